@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/aggregator"
+	"github.com/alipay/container-observability-service/pkg/featuregates"
+	"github.com/alipay/container-observability-service/pkg/replayer"
+	"github.com/alipay/container-observability-service/pkg/spans"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+type backfillOptions struct {
+	From    string
+	To      string
+	Step    time.Duration
+	Version string
+}
+
+// newBackfillCmd recomputes the SLOs of a time range, the flags of aggregator such as --cluster and --es-* are shared.
+func newBackfillCmd(options *aggregator.AggregatorOptions) *cobra.Command {
+	backfillOpts := &backfillOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Recompute SLOs of a time range",
+		Long: `Re-read the audit events of [--from, --to) in elasticsearch and recompute the create/delete/upgrade SLOs,
+for example after the SLO config or a reason analyzer is changed. The stored SLO records are overwritten,
+or written to "<index>_<version>" with --version. Nothing else of the running aggregator is touched.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// SLO processors wait for span analysis in the processing DAG, spans are not exported
+			if err := featuregates.Parse(spans.SpanAnalysisFeature + "=true"); err != nil {
+				return err
+			}
+
+			if options.Cluster == "" {
+				return fmt.Errorf("need --cluster commandline arguments")
+			}
+			if strings.Contains(options.Cluster, ",") {
+				return fmt.Errorf("backfill one cluster at a time")
+			}
+			if options.ElasticSearchEndpoint == "" {
+				return fmt.Errorf("need --es-endpoint commandline arguments")
+			}
+
+			from, err := time.Parse(time.RFC3339, backfillOpts.From)
+			if err != nil {
+				return fmt.Errorf("invalid --from: %s", err.Error())
+			}
+			to, err := time.Parse(time.RFC3339, backfillOpts.To)
+			if err != nil {
+				return fmt.Errorf("invalid --to: %s", err.Error())
+			}
+
+			esConf := options.AuditElasticSearchConf()
+			writer, err := xsearch.NewSLODocWriter(esConf, backfillOpts.Version)
+			if err != nil {
+				return err
+			}
+			xsearch.SetDocWriter(writer)
+			defer writer.Close()
+
+			if err := spans.InitKubeSpanWatcher([]string{options.Cluster}, ""); err != nil {
+				return err
+			}
+
+			processed, err := replayer.Backfill(&replayer.BackfillOptions{
+				Cluster: options.Cluster,
+				ESConf:  esConf,
+				From:    from,
+				To:      to,
+				Step:    backfillOpts.Step,
+			}, false, stopCh)
+			if err != nil {
+				return err
+			}
+			klog.Infof("backfill finished, %d audit events processed", processed)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(
+		&backfillOpts.From, "from", "",
+		"",
+		"Recompute SLOs of audit events since this time, in RFC3339")
+	cmd.Flags().StringVarP(
+		&backfillOpts.To, "to", "",
+		"",
+		"Recompute SLOs of audit events before this time, in RFC3339. Pods still in delivery at this time get no result")
+	cmd.Flags().DurationVarP(
+		&backfillOpts.Step, "step", "",
+		30*time.Second,
+		"Time range of audit events scrolled at a time")
+	cmd.Flags().StringVarP(
+		&backfillOpts.Version, "version", "",
+		"",
+		"Write the SLO records to <index>_<version> and keep the former ones, overwrite them if not set")
+
+	return cmd
+}
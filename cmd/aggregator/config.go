@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alipay/container-observability-service/pkg/common"
+	"github.com/alipay/container-observability-service/pkg/config"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// loadConfiguration applies --config and the LUNETTES_* environment variables to the flags of cmd,
+// with --print-config the effective configuration is printed and the command exits.
+func loadConfiguration(cmd *cobra.Command, printConfig bool) error {
+	cfg, err := common.LoadConfiguration(cmd.Flags())
+	if err != nil {
+		return err
+	}
+	dump, err := cfg.Dump()
+	if err != nil {
+		return err
+	}
+	if printConfig {
+		fmt.Print(dump)
+		os.Exit(0)
+	}
+	klog.Infof("effective config:\n%s", dump)
+
+	if !cfg.SLO.IsZero() {
+		config.SetDefaultLunettesConfig(&config.LunettesConfig{
+			UserOnlineConfigMap:         cfg.SLO.UserOnlineConfigMap,
+			UserAppConfigMap:            cfg.SLO.UserAppConfigMap,
+			UserSLAConfigMap:            cfg.SLO.UserSLAConfigMap,
+			IgnoredNamespaceForAudit:    cfg.SLO.IgnoredNamespaceForAudit,
+			PostStartHookTimeout:        cfg.SLO.PostStartHookTimeout,
+			ShouldIgnoreSinglePod:       cfg.SLO.ShouldIgnoreSinglePod,
+			ShouldRetainOldMetrics:      cfg.SLO.ShouldRetainOldMetrics,
+			IgnoreDeleteReasonNamespace: cfg.SLO.IgnoreDeleteReasonNamespace,
+		})
+	}
+	return nil
+}
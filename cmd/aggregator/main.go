@@ -16,8 +16,14 @@ import (
 
 	"github.com/alipay/container-observability-service/pkg/aggregator"
 	apiserver "github.com/alipay/container-observability-service/pkg/api"
+	"github.com/alipay/container-observability-service/pkg/common"
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/alipay/container-observability-service/pkg/deadletter"
 	"github.com/alipay/container-observability-service/pkg/featuregates"
 	"github.com/alipay/container-observability-service/pkg/kube"
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/alipay/container-observability-service/pkg/replayer"
+	"github.com/alipay/container-observability-service/pkg/slopolicy"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -41,21 +47,53 @@ var (
 
 func newRootCmd() *cobra.Command {
 	options := &aggregator.AggregatorOptions{}
+	var configFile string
+	var printConfig bool
+	var lunettesConfigFile string
 
 	cmd := &cobra.Command{
 		Use:   "aggregator",
 		Short: "This is aggregator command",
 		Long:  `This is aggregator comand for lenettes`,
+		// also for the subcommands sharing the flags
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadConfiguration(cmd, printConfig); err != nil {
+				return err
+			}
+			if options.MetricsClusterLabel {
+				if err := metrics.EnableClusterLabel(); err != nil {
+					return err
+				}
+			}
+			if lunettesConfigFile != "" {
+				if err := config.UseLunettesConfigFile(lunettesConfigFile, stopCh); err != nil {
+					return err
+				}
+			}
+			if options.AuditFilterPolicyFile != "" {
+				policy, err := replayer.LoadAuditFilterPolicy(options.AuditFilterPolicyFile)
+				if err != nil {
+					return err
+				}
+				replayer.SetAuditFilterPolicy(policy)
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// flag.Parse()
+			// not the default mux, which is served on all interfaces with pprof
+			metricsMux := http.NewServeMux()
 			go func() {
 				// Expose the registered metrics via HTTP.
-				http.Handle("/metrics", promhttp.Handler())
-				klog.Fatal(http.ListenAndServe(options.MetricsAddr, nil))
+				metricsMux.Handle("/metrics", promhttp.Handler())
+				klog.Fatal(http.ListenAndServe(options.MetricsAddr, metricsMux))
 			}()
 
 			// parse feature gates
-			featuregates.Parse(options.FeatureGates)
+			if err := featuregates.Parse(options.FeatureGates); err != nil {
+				klog.Errorf("invalid feature gates: %s", err.Error())
+				os.Exit(-1)
+			}
 
 			if options.JaegerCollector == "" && featuregates.IsEnabled(spans.JaegerFeature) {
 				klog.Error("need --jaeger-collector commandline arguments")
@@ -67,8 +105,14 @@ func newRootCmd() *cobra.Command {
 				os.Exit(-1)
 			}
 
-			if options.Cluster == "" {
-				klog.Error("--clusters commandline arguments")
+			clusters, err := options.ClusterNames()
+			if err != nil {
+				klog.Errorf("invalid clusters: %s", err.Error())
+				os.Exit(-1)
+			}
+
+			if err := options.Shard.Validate(); err != nil {
+				klog.Errorf("invalid shard: %s", err.Error())
 				os.Exit(-1)
 			}
 
@@ -95,26 +139,63 @@ func newRootCmd() *cobra.Command {
 
 			//init kube
 			kube.InitKube(options.KubeConfigFile)
+			if options.WatchSLOPolicies {
+				if err := slopolicy.Watch(stopCh); err != nil {
+					return err
+				}
+			}
 			//init api module
 			apiserver.InitApi(options.ElasticSearchEndpoint, options.ElasticSearchUser, options.ElasticSearchPassword)
 			//init esClient
 			xsearch.InitZsearch(
-				options.ElasticSearchEndpoint, options.ElasticSearchUser, options.ElasticSearchPassword, options.Cluster)
+				options.ElasticSearchEndpoint, options.ElasticSearchUser, options.ElasticSearchPassword, clusters)
+			// failed events are kept until they are replayed after a fix
+			deadletter.SetStore(deadletter.NewESStore(stopCh))
 
 			agg, err := aggregator.NewAggregator(options)
 			if err != nil {
 				return err
 			}
+			// served with /metrics
+			metricsMux.Handle("/healthz", agg.HealthzHandler())
+			metricsMux.Handle("/featuregates", featuregates.Handler())
+			// re-injecting events changes the results, not exposed with /metrics
+			go func() {
+				mux := http.NewServeMux()
+				mux.Handle("/deadletters", deadletter.ListHandler())
+				mux.Handle("/deadletters/replay", deadletter.ReplayHandler())
+				klog.Fatal(http.ListenAndServe(options.AdminAddr, mux))
+			}()
 
 			agg.Run(stopCh)
 			return nil
 		},
 	}
 
+	cmd.PersistentFlags().StringVarP(
+		&configFile, common.ConfigFlag, "",
+		"",
+		"Path of the config file, the flags set on the command line and the LUNETTES_<FLAG_NAME> environment variables take precedence over it")
+	cmd.PersistentFlags().BoolVarP(
+		&printConfig, "print-config", "",
+		false,
+		"Print the effective config and exit")
+	cmd.PersistentFlags().StringVarP(
+		&lunettesConfigFile, "lunettes-config-file", "",
+		"",
+		"Path of a JSON file read instead of the lunettes-config ConfigMap, it is reloaded when changed")
 	cmd.PersistentFlags().StringVarP(
 		&options.MetricsAddr, "metrics-addr", "",
 		":9091",
 		"metrics listen address (default :9091)")
+	cmd.PersistentFlags().StringVarP(
+		&options.AdminAddr, "admin-addr", "",
+		"127.0.0.1:9092",
+		"Listen address of the dead-letter endpoints, which re-inject events. Keep it on localhost unless the network is trusted")
+	cmd.PersistentFlags().BoolVarP(
+		&options.MetricsClusterLabel, "metrics-cluster-label", "",
+		false,
+		"Add the cluster label to trace_processing_latency_seconds and slo_pod_delete_latency_quantiles_in_seconds. It changes their label sets, so the dashboards and recording rules using them need to be updated")
 	cmd.PersistentFlags().StringVarP(
 		&options.JaegerCollector, "jaeger-collector", "",
 		"",
@@ -134,7 +215,15 @@ func newRootCmd() *cobra.Command {
 	cmd.PersistentFlags().StringVarP(
 		&options.Cluster, "cluster", "",
 		"",
-		"Default cluster name")
+		"Cluster name, or comma separated names of clusters sharing the audit source flags")
+	cmd.PersistentFlags().StringVarP(
+		&options.ClustersConfigFile, "clusters-config", "",
+		"",
+		"YAML file listing the clusters and their audit sources, overrides --cluster")
+	cmd.PersistentFlags().BoolVarP(
+		&options.WatchSLOPolicies, "watch-slo-policies", "",
+		false,
+		"Apply the DeliverySLOPolicy custom resources of the cluster to the SLO times of the pods, and update their status")
 
 	cmd.PersistentFlags().IntVarP(
 		&options.Burst, "burst", "",
@@ -162,6 +251,18 @@ func newRootCmd() *cobra.Command {
 		&options.ElasticSearchIndexName, "es-index", "",
 		"audit",
 		"ElasticSearch index where audit logs are stored")
+	cmd.PersistentFlags().StringVarP(
+		&options.ElasticSearchIndexPattern, "es-index-pattern", "",
+		replayer.DefaultAuditIndexPattern,
+		"Daily indexes, data streams or aliases of audit logs, {index}, {cluster}, {date} and {date:<go time layout>} are replaced. --es-index is read directly if empty")
+	cmd.PersistentFlags().StringVarP(
+		&options.ElasticSearchTimestampField, "es-timestamp-field", "",
+		replayer.DefaultAuditTimestampField,
+		"Time field of audit logs")
+	cmd.PersistentFlags().StringVarP(
+		&options.ElasticSearchClusterField, "es-cluster-field", "",
+		replayer.DefaultAuditClusterField,
+		"Keyword field of audit logs telling the cluster, audit logs are not filtered by cluster if empty")
 	cmd.PersistentFlags().DurationVarP(
 		&options.ElasticSearchBufferDuration, "es-buffer-duration", "",
 		10*time.Second,
@@ -174,7 +275,7 @@ func newRootCmd() *cobra.Command {
 	cmd.PersistentFlags().StringVarP(
 		&options.FeatureGates, "feature-gates", "",
 		"",
-		"Enabled features split by comma")
+		"Feature gates like SpanAnalysisFeature=true,TraceFeature=false, a gate alone means it is enabled. See /featuregates on --metrics-addr for the known ones")
 
 	// for apiserver
 	cmd.PersistentFlags().BoolVarP(
@@ -190,6 +291,101 @@ func newRootCmd() *cobra.Command {
 		true,
 		"If close the trace feature, default false")
 
+	// for audit source
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditSource, "audit-source", "",
+		replayer.AuditSourceElasticSearch,
+		"Where to read audit events from, elasticsearch, file or webhook")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditLogPath, "audit-log-path", "",
+		"",
+		"Path of the audit log file written by apiserver, used when --audit-source=file")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditCheckpointPath, "audit-checkpoint-path", "",
+		"",
+		"Path to save the read offset of the audit log file (default <audit-log-path>.checkpoint)")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditWebhookListenAddr, "audit-webhook-addr", "",
+		":9099",
+		"Listen address of audit webhook, used when --audit-source=webhook")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditWebhookTLSCertFile, "audit-webhook-tls-cert-file", "",
+		"",
+		"TLS certificate of audit webhook, serve plain http if not set")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditWebhookTLSKeyFile, "audit-webhook-tls-key-file", "",
+		"",
+		"TLS private key of audit webhook")
+	cmd.PersistentFlags().DurationVarP(
+		&options.AuditDedupWindow, "audit-dedup-window", "",
+		2*time.Minute,
+		"Drop audit events whose AuditID has been processed in this window, 0 to disable")
+	cmd.PersistentFlags().IntVarP(
+		&options.AuditDedupMaxSize, "audit-dedup-max-size", "",
+		100000,
+		"The maximum number of AuditIDs kept in the dedupe window")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditDedupPath, "audit-dedup-path", "",
+		"",
+		"Path to save the dedupe window (default <audit-log-path>.dedup for file source, elasticsearch for elasticsearch source, memory only for webhook source)")
+	cmd.PersistentFlags().StringVarP(
+		&options.AuditFilterPolicyFile, "audit-filter-policy", "",
+		"",
+		"YAML file of the policy telling which audit events are processed and traced, all events by default")
+	cmd.PersistentFlags().StringVarP(
+		&options.QueueSpillDir, "queue-spill-dir", "",
+		"",
+		"Directory where the podyaml, podphase, nodeyaml and trace queues spill audit events when they are full, so a slow consumer never blocks the others. Disabled if empty")
+	cmd.PersistentFlags().Int64VarP(
+		&options.QueueSpillMaxBytes, "queue-spill-max-bytes", "",
+		10<<30,
+		"The maximum bytes spilled by each queue, 0 for no limit")
+	cmd.PersistentFlags().StringVarP(
+		&options.WatchHistoryDir, "watch-history-dir", "",
+		"",
+		"Directory to persist the messages kept for /api/v1/watch, so watchers resume across restarts. Memory only if empty")
+	cmd.PersistentFlags().IntVarP(
+		&options.WatchHistorySize, "watch-history-size", "",
+		1000,
+		"The number of the latest messages of each watch type kept for watchers to resume from")
+
+	cmd.PersistentFlags().BoolVarP(
+		&options.LeaderElection.Enabled, "leader-elect", "",
+		false,
+		"Run as active/standby replicas, only the holder of the lease processes audit events")
+	cmd.PersistentFlags().StringVarP(
+		&options.LeaderElection.Namespace, "leader-elect-namespace", "",
+		"lunettes",
+		"Namespace of the leader election lease")
+	cmd.PersistentFlags().StringVarP(
+		&options.LeaderElection.Name, "leader-elect-name", "",
+		"lunettes-aggregator",
+		"Name of the leader election lease")
+	cmd.PersistentFlags().DurationVarP(
+		&options.LeaderElection.LeaseDuration, "leader-elect-lease-duration", "",
+		15*time.Second,
+		"How long a standby waits before taking over the lease not renewed")
+	cmd.PersistentFlags().DurationVarP(
+		&options.LeaderElection.RenewDeadline, "leader-elect-renew-deadline", "",
+		10*time.Second,
+		"How long the leader retries renewing the lease before giving up the leadership")
+	cmd.PersistentFlags().DurationVarP(
+		&options.LeaderElection.RetryPeriod, "leader-elect-retry-period", "",
+		2*time.Second,
+		"How long to wait between tries of acquiring or renewing the lease")
+
+	cmd.PersistentFlags().IntVarP(
+		&options.Shard.Count, "shard-count", "",
+		1,
+		"Number of aggregators splitting the audit events by pod uid")
+	cmd.PersistentFlags().IntVarP(
+		&options.Shard.Index, "shard-index", "",
+		0,
+		"Index of this aggregator in [0, shard-count), it processes the pods whose uid hash falls in its range, shard 0 also the other objects, nodes and workloads")
+
+	cmd.AddCommand(newReplayCmd(options))
+	cmd.AddCommand(newBackfillCmd(options))
+
 	return cmd
 }
 
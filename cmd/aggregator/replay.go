@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/aggregator"
+	"github.com/alipay/container-observability-service/pkg/featuregates"
+	"github.com/alipay/container-observability-service/pkg/replayer"
+	"github.com/alipay/container-observability-service/pkg/spans"
+	"github.com/alipay/container-observability-service/pkg/trace"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	_ "github.com/alipay/container-observability-service/pkg/shares/base_processor"
+	_ "github.com/alipay/container-observability-service/pkg/shares/extractor"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+const (
+	replayOutputJSON          = "json"
+	replayOutputElasticSearch = "elasticsearch"
+	// the dal storage read by grafanadi, it has no writer
+	replayOutputMySQL = "mysql"
+)
+
+type replayOptions struct {
+	AuditFiles []string
+	From       string
+	To         string
+	Output     string
+	OutputDir  string
+}
+
+// newReplayCmd replays captured audit events offline, the flags of aggregator such as --cluster and --es-* are shared.
+func newReplayCmd(options *aggregator.AggregatorOptions) *cobra.Command {
+	replayOpts := &replayOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Replay audit events offline",
+		Long: `Replay audit log files (or audit events of a time range in elasticsearch) through all processors of aggregator,
+and write the SLO/trace/diagnosis results to local json files or elasticsearch.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// SLO processors wait for span analysis in the processing DAG, so it is always enabled in replay
+			if err := featuregates.Parse(options.FeatureGates + "," + spans.SpanAnalysisFeature + "=true"); err != nil {
+				return err
+			}
+
+			if options.Cluster == "" {
+				return fmt.Errorf("need --cluster commandline arguments")
+			}
+			if strings.Contains(options.Cluster, ",") {
+				return fmt.Errorf("replay one cluster at a time")
+			}
+
+			from, err := parseReplayTime(replayOpts.From)
+			if err != nil {
+				return fmt.Errorf("invalid --from: %s", err.Error())
+			}
+			to, err := parseReplayTime(replayOpts.To)
+			if err != nil {
+				return fmt.Errorf("invalid --to: %s", err.Error())
+			}
+
+			esConf := options.AuditElasticSearchConf()
+			if len(replayOpts.AuditFiles) == 0 && esConf.Endpoint == "" {
+				return fmt.Errorf("need --audit-file or --es-endpoint commandline arguments")
+			}
+
+			switch replayOpts.Output {
+			case replayOutputJSON:
+				writer, err := xsearch.NewJSONDocWriter(replayOpts.OutputDir)
+				if err != nil {
+					return err
+				}
+				xsearch.SetDocWriter(writer)
+			case replayOutputElasticSearch:
+				xsearch.InitZsearch(esConf.Endpoint, esConf.User, esConf.Password, options.Cluster)
+			case replayOutputMySQL, "dal":
+				return fmt.Errorf("output %s is not supported, the results can be written to json or elasticsearch only", replayOpts.Output)
+			default:
+				return fmt.Errorf("unknown output %s", replayOpts.Output)
+			}
+
+			if featuregates.IsEnabled(spans.SpanAnalysisFeature) {
+				if err := spans.InitKubeSpanWatcher([]string{options.Cluster}, options.JaegerCollector); err != nil {
+					return err
+				}
+			}
+			if featuregates.IsEnabled(trace.TraceFeature) {
+				if err := trace.InitKubeTraceWatcher([]string{options.Cluster}, options.OTLPCollector); err != nil {
+					return err
+				}
+			}
+
+			replayerOptions := &replayer.ReplayOptions{
+				Cluster:    options.Cluster,
+				AuditFiles: replayOpts.AuditFiles,
+				From:       from,
+				To:         to,
+			}
+			if len(replayOpts.AuditFiles) == 0 {
+				replayerOptions.ESConf = esConf
+			}
+			processed, err := replayer.Replay(replayerOptions, options.EnableTrace, stopCh)
+			if err != nil {
+				return err
+			}
+
+			if writer := xsearch.GetDocWriter(); writer != nil {
+				if err := writer.Close(); err != nil {
+					return err
+				}
+			}
+			klog.Infof("replay finished, %d audit events processed", processed)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(
+		&replayOpts.AuditFiles, "audit-file", "",
+		nil,
+		"Audit log files to replay, read from elasticsearch by --es-* if not set")
+	cmd.Flags().StringVarP(
+		&replayOpts.From, "from", "",
+		"",
+		"Replay audit events since this time, in RFC3339")
+	cmd.Flags().StringVarP(
+		&replayOpts.To, "to", "",
+		"",
+		"Replay audit events before this time, in RFC3339")
+	cmd.Flags().StringVarP(
+		&replayOpts.Output, "output", "",
+		replayOutputJSON,
+		"Where to write the results, json or elasticsearch. The mysql storage driver of grafanadi is read only and not supported")
+	cmd.Flags().StringVarP(
+		&replayOpts.OutputDir, "output-dir", "",
+		"replay",
+		"Directory of json results, one file per index")
+
+	return cmd
+}
+
+func parseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	tkpReqProvider "github.com/alipay/container-observability-service/pkg/tkp_provider"
 	"os"
 	"os/signal"
@@ -27,14 +28,37 @@ var (
 func newRootCmd() *cobra.Command {
 	config := &server.ServerConfig{}
 	var cfgFile, kubeConfigFile, tkpRefCfgFile string
+	var lunettesCfgFile string
+	var printConfig bool
+	var lunettesCfg *common.Configuration
 
 	cmd := &cobra.Command{
 		Use:   "grafanadi",
 		Short: "This is grafanadi command",
 		Long:  `This is grafanadi comand for lunettes`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			options, err := common.InitConfig(cfgFile)
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if lunettesCfg, err = common.LoadConfiguration(cmd.Flags()); err != nil {
+				return err
+			}
+			dump, err := lunettesCfg.Dump()
 			if err != nil {
+				return err
+			}
+			if printConfig {
+				fmt.Print(dump)
+				os.Exit(0)
+			}
+			klog.Infof("effective config:\n%s", dump)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var options *common.DBOptions
+			var err error
+			if lunettesCfgFile != "" {
+				// storage of the shared config file
+				options = lunettesCfg.DBOptions()
+			} else if options, err = common.InitConfig(cfgFile); err != nil {
 				klog.Errorf("failed to get init config [%s], err:%s", cfgFile, err.Error())
 				panic(err.Error())
 			}
@@ -70,6 +94,9 @@ func newRootCmd() *cobra.Command {
 		},
 	}
 
+	cmd.PersistentFlags().StringVarP(&lunettesCfgFile, common.ConfigFlag, "", "", "Path of the config file shared with aggregator, its storage takes place of --config-file. The flags set on the command line and the LUNETTES_<FLAG_NAME> environment variables take precedence over it")
+	cmd.PersistentFlags().BoolVarP(&printConfig, "print-config", "", false, "Print the effective config and exit")
+
 	// for server listen port
 	cmd.PersistentFlags().StringVarP(&config.MetricsAddr, "metrics-addr", "", ":9091", "metrics listen address (default :9091)")
 	cmd.PersistentFlags().StringVarP(&config.ListenAddr, "listen-addr", "", ":8080", "api server listen address (default :8080)")
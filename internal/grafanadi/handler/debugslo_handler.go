@@ -62,6 +62,8 @@ func (handler *DebuggingPodsHandler) QueryDebuggingWithType(debugparams model.Sl
 		httpStatus, slodatas, err = handler.queryDeleteSloByResult(handler.requestParams)
 	} else if strings.Contains(handler.requestParams.Type, "Upgrade") {
 		httpStatus, slodatas, err = handler.queryUpgradeSloByResult(handler.requestParams)
+	} else if model.IsJobSlo(handler.requestParams.Type) {
+		httpStatus, slodatas, err = queryJobSloByResult(handler.storage, handler.requestParams)
 	} else {
 		httpStatus, slodatas, err = handler.querySloByResult(handler.requestParams)
 	}
@@ -177,6 +179,41 @@ func (handler *DebuggingPodsHandler) queryUpgradeSloByResult(requestParams *mode
 
 	return http.StatusOK, returnResult, nil
 }
+
+// queryJobSloByResult queries the completions of jobs, shared by the pod list
+func queryJobSloByResult(storage data_access.StorageInterface, requestParams *model.SloOptions) (int, []*model.Slodata, error) {
+	res := make([]*model.SloTraceData, 0)
+	returnResult := make([]*model.Slodata, 0)
+
+	if requestParams == nil || requestParams.Result == "" {
+		return http.StatusOK, returnResult, customerrors.Error(customerrors.ErrParams, customerrors.NoDeliveryResult)
+	}
+
+	begin := time.Now()
+	defer func() {
+		cost := utils.TimeSinceInMilliSeconds(begin)
+		metrics.QueryMethodDurationMilliSeconds.WithLabelValues("QueryJobSloWithResult").Observe(cost)
+	}()
+
+	// the type is stored in lower case
+	jobParams := *requestParams
+	jobParams.Type = model.SloTypeJob
+	err := storage.QueryJobSloWithResult(&res, &jobParams)
+	if err != nil {
+		return http.StatusBadRequest, returnResult, fmt.Errorf("QueryJobSloWithResult error, error is %s", err)
+	}
+	for _, v := range res {
+		slo := &model.Slodata{}
+		by, err := json.Marshal(v)
+		if err == nil {
+			if er := json.Unmarshal(by, slo); er == nil {
+				returnResult = append(returnResult, slo)
+			}
+		}
+	}
+
+	return http.StatusOK, returnResult, nil
+}
 func (handler *DebuggingPodsHandler) querySloByResult(requestParams *model.SloOptions) (int, []*model.Slodata, error) {
 	// return http.StatusOK, result, nil
 	returnResult := make([]*model.Slodata, 0)
@@ -297,6 +334,19 @@ func transSlodata(slo *model.Slodata, env string) map[string]string {
 		}
 	}
 
+	if slo.JobResult != "" {
+		result["JobName"] = slo.JobName
+		result["JobResult"] = slo.JobResult
+		result["JobDuration"] = slo.Duration.String()
+		result["JobRetries"] = strconv.Itoa(int(slo.Failed))
+		if slo.CronJobName != "" {
+			result["CronJobName"] = slo.CronJobName
+		}
+		if slo.JobFailedReason != "" {
+			result["JobFailedReason"] = slo.JobFailedReason
+		}
+	}
+
 	return result
 }
 func getTime(str string) string {
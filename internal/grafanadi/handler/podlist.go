@@ -60,6 +60,8 @@ func (handler *PodListHandler) QueryDebuggingWithType(debugparams model.SloOptio
 		httpStatus, slodatas, err = handler.queryDeleteSloByResult(handler.requestParams)
 	} else if strings.Contains(handler.requestParams.Type, "Upgrade") {
 		httpStatus, slodatas, err = handler.queryUpgradeSloByResult(handler.requestParams)
+	} else if model.IsJobSlo(handler.requestParams.Type) {
+		httpStatus, slodatas, err = queryJobSloByResult(handler.storage, handler.requestParams)
 	} else {
 		httpStatus, slodatas, err = handler.querySloByResult(handler.requestParams)
 	}
@@ -1,9 +1,11 @@
 package aggregator
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/alipay/container-observability-service/pkg/featuregates"
+	"github.com/alipay/container-observability-service/pkg/metas"
 	"github.com/alipay/container-observability-service/pkg/spans"
 	"github.com/alipay/container-observability-service/pkg/trace"
 
@@ -11,6 +13,7 @@ import (
 	"k8s.io/klog/v2"
 
 	"github.com/alipay/container-observability-service/pkg/replayer"
+	"github.com/alipay/container-observability-service/pkg/slo"
 	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 
@@ -22,6 +25,7 @@ import (
 	corelisters "k8s.io/client-go/listers/core/v1"
 	restclient "k8s.io/client-go/rest"
 	k8scache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
 )
 
 var (
@@ -36,6 +40,8 @@ var (
 
 type AggregatorOptions struct {
 	MetricsAddr                 string
+	AdminAddr                   string
+	MetricsClusterLabel         bool
 	Workers                     int
 	QPS                         float32
 	Burst                       int
@@ -48,6 +54,9 @@ type AggregatorOptions struct {
 	ElasticSearchUser           string
 	ElasticSearchPassword       string
 	ElasticSearchIndexName      string
+	ElasticSearchIndexPattern   string
+	ElasticSearchTimestampField string
+	ElasticSearchClusterField   string
 	ElasticSearchBufferDuration time.Duration
 	ElasticSearchFetchInterval  time.Duration
 	FeatureGates                string
@@ -55,6 +64,37 @@ type AggregatorOptions struct {
 	APIServerListenAddr         string
 	APIServerTraceStatsIndex    string
 	EnableTrace                 bool
+	AuditSource                 string
+	AuditLogPath                string
+	AuditCheckpointPath         string
+	AuditWebhookListenAddr      string
+	AuditWebhookTLSCertFile     string
+	AuditWebhookTLSKeyFile      string
+	AuditDedupWindow            time.Duration
+	AuditDedupMaxSize           int
+	AuditDedupPath              string
+	AuditFilterPolicyFile       string
+	QueueSpillDir               string
+	QueueSpillMaxBytes          int64
+	WatchHistoryDir             string
+	WatchHistorySize            int
+	ClustersConfigFile          string
+	WatchSLOPolicies            bool
+	LeaderElection              LeaderElectionOptions
+	Shard                       utils.Shard
+}
+
+// AuditElasticSearchConf returns where and how to read audit events in elasticsearch
+func (options *AggregatorOptions) AuditElasticSearchConf() *xsearch.ElasticSearchConf {
+	return &xsearch.ElasticSearchConf{
+		Endpoint:       options.ElasticSearchEndpoint,
+		User:           options.ElasticSearchUser,
+		Password:       options.ElasticSearchPassword,
+		Index:          options.ElasticSearchIndexName,
+		IndexPattern:   options.ElasticSearchIndexPattern,
+		TimestampField: options.ElasticSearchTimestampField,
+		ClusterField:   options.ElasticSearchClusterField,
+	}
 }
 
 type auditReplayer interface {
@@ -73,13 +113,19 @@ type Aggregator struct {
 	kubeConfig *restclient.Config
 	esConfig   *xsearch.ElasticSearchConf
 
-	replayer auditReplayer
+	// one replayer per cluster
+	replayers []auditReplayer
+	leader    *leaderState
 }
 
 func NewAggregator(options *AggregatorOptions) (*Aggregator, error) {
 
 	aggregator := &Aggregator{
 		options: options,
+		leader:  &leaderState{},
+	}
+	if options.LeaderElection.Enabled {
+		aggregator.leader.healthz = leaderelection.NewLeaderHealthzAdaptor(options.LeaderElection.RenewDeadline)
 	}
 
 	var kubeClient clientset.Interface
@@ -96,54 +142,155 @@ func NewAggregator(options *AggregatorOptions) (*Aggregator, error) {
 	aggregator.podLister = podInformer.Lister()
 	aggregator.podListerSynced = podInformer.Informer().HasSynced
 
-	esConf := &xsearch.ElasticSearchConf{
-		Endpoint: options.ElasticSearchEndpoint,
-		User:     options.ElasticSearchUser,
-		Password: options.ElasticSearchPassword,
-		Index:    options.ElasticSearchIndexName,
-	}
+	esConf := options.AuditElasticSearchConf()
 	aggregator.esConfig = esConf
 
-	auditProcessor, err := replayer.NewAuditProcessor(
-		esConf,
-		options.ElasticSearchBufferDuration, options.ElasticSearchFetchInterval,
-		options.TraceTimeout, options.Cluster, options.EnableTrace)
+	clusters, err := options.ClusterList()
 	if err != nil {
 		return nil, err
 	}
-	aggregator.replayer = auditProcessor
+	clusterNames := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		auditProcessor, err := newAuditProcessor(options, cluster, esConf)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		aggregator.replayers = append(aggregator.replayers, auditProcessor)
+		clusterNames = append(clusterNames, cluster.Name)
+	}
 
 	if featuregates.IsEnabled(spans.SpanAnalysisFeature) {
-		err = spans.InitKubeSpanWatcher(options.Cluster, options.JaegerCollector)
+		err = spans.InitKubeSpanWatcher(clusterNames, options.JaegerCollector)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if featuregates.IsEnabled(trace.TraceFeature) {
-		err = trace.InitKubeTraceWatcher(options.Cluster, options.OTLPCollector)
+		err = trace.InitKubeTraceWatcher(clusterNames, options.OTLPCollector)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// after the watchers creating their queues
+	if options.QueueSpillDir != "" {
+		if err := enableQueueSpill(options.QueueSpillDir, options.QueueSpillMaxBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	// watchers of /api/v1/watch resume from the kept messages
+	if err := metas.EnableWatchHistory(options.WatchHistoryDir, options.WatchHistorySize); err != nil {
+		return nil, err
+	}
+
 	return aggregator, err
 }
 
+// newAuditProcessor creates the processor reading audit events of cluster
+func newAuditProcessor(options *AggregatorOptions, cluster *ClusterOptions,
+	esConf *xsearch.ElasticSearchConf) (*replayer.AuditProcessor, error) {
+	if options.Shard.Enabled() {
+		cluster = shardClusterOptions(cluster, options.Shard)
+	}
+
+	var newSource replayer.AuditSourceFactory
+	switch cluster.AuditSource {
+	case replayer.AuditSourceFile:
+		newSource = replayer.NewFileSource(cluster.AuditLogPath, cluster.AuditCheckpointPath, cluster.Name)
+	case replayer.AuditSourceWebhook:
+		newSource = replayer.NewWebhookSource(&replayer.WebhookConfig{
+			ListenAddr:  cluster.AuditWebhookListenAddr,
+			TLSCertFile: cluster.AuditWebhookTLSCertFile,
+			TLSKeyFile:  cluster.AuditWebhookTLSKeyFile,
+		}, cluster.Name)
+	case replayer.AuditSourceElasticSearch, "":
+		newSource = replayer.NewElasticSearchSource(esConf,
+			options.ElasticSearchBufferDuration, options.ElasticSearchFetchInterval, cluster.Name, options.Shard)
+	default:
+		return nil, fmt.Errorf("unknown audit source %s", cluster.AuditSource)
+	}
+
+	auditProcessor, err := replayer.NewAuditProcessor(newSource, cluster.Name, options.EnableTrace)
+	if err != nil {
+		return nil, err
+	}
+	auditProcessor.SetShard(options.Shard)
+	slo.SetShard(options.Shard)
+
+	if options.AuditDedupWindow > 0 {
+		store, err := newAuditIDStore(cluster, esConf, options.Shard)
+		if err != nil {
+			return nil, err
+		}
+		auditProcessor.EnableDedup(options.AuditDedupWindow, options.AuditDedupMaxSize, store)
+	}
+	return auditProcessor, nil
+}
+
+// shardClusterOptions returns the options of cluster whose checkpoint and dedupe window are kept for each shard
+func shardClusterOptions(cluster *ClusterOptions, shard utils.Shard) *ClusterOptions {
+	sharded := *cluster
+	if sharded.AuditSource == replayer.AuditSourceFile {
+		if sharded.AuditCheckpointPath == "" {
+			sharded.AuditCheckpointPath = sharded.AuditLogPath + ".checkpoint"
+		}
+		sharded.AuditCheckpointPath = shard.Name(sharded.AuditCheckpointPath)
+		if sharded.AuditDedupPath == "" {
+			sharded.AuditDedupPath = sharded.AuditLogPath + ".dedup"
+		}
+	}
+	if sharded.AuditDedupPath != "" {
+		sharded.AuditDedupPath = shard.Name(sharded.AuditDedupPath)
+	}
+	return &sharded
+}
+
+// newAuditIDStore returns where to persist the AuditID dedupe window, nil if it is kept in memory only
+func newAuditIDStore(cluster *ClusterOptions, esConf *xsearch.ElasticSearchConf, shard utils.Shard) (replayer.AuditIDStore, error) {
+	if cluster.AuditDedupPath != "" {
+		return replayer.NewFileAuditIDStore(cluster.AuditDedupPath), nil
+	}
+	switch cluster.AuditSource {
+	case replayer.AuditSourceFile:
+		return replayer.NewFileAuditIDStore(cluster.AuditLogPath + ".dedup"), nil
+	case replayer.AuditSourceElasticSearch, "":
+		return replayer.NewESAuditIDStore(esConf, shard.Name(cluster.Name))
+	}
+	return nil, nil
+}
+
 func (a *Aggregator) Run(stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 
-	a.replayer.Start(stopCh)
-	klog.Infof("replayer has started")
-	<-stopCh
-
-	// close processing queue
-	a.replayer.Stop()
+	if a.options.LeaderElection.Enabled {
+		if err := a.runWithLeaderElection(stopCh); err != nil {
+			return err
+		}
+	} else {
+		a.leader.setLeading(true)
+		a.runReplayers(stopCh)
+	}
 
 	klog.Warning("aggregator is existing.")
 	return nil
 }
 
+// runReplayers starts the replayers, and stops them after stopCh is closed
+func (a *Aggregator) runReplayers(stopCh <-chan struct{}) {
+	for _, r := range a.replayers {
+		r.Start(stopCh)
+	}
+	klog.Infof("%d replayers have started", len(a.replayers))
+	<-stopCh
+
+	// close processing queue
+	for _, r := range a.replayers {
+		r.Stop()
+	}
+}
+
 func init() {
 	prometheus.MustRegister(OngoingTrace)
 }
@@ -0,0 +1,149 @@
+package aggregator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alipay/container-observability-service/pkg/replayer"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ClusterOptions tells where to read the audit events of a cluster, empty fields default to the commandline flags.
+type ClusterOptions struct {
+	Name                    string `json:"name"`
+	AuditSource             string `json:"auditSource,omitempty"`
+	AuditLogPath            string `json:"auditLogPath,omitempty"`
+	AuditCheckpointPath     string `json:"auditCheckpointPath,omitempty"`
+	AuditWebhookListenAddr  string `json:"auditWebhookListenAddr,omitempty"`
+	AuditWebhookTLSCertFile string `json:"auditWebhookTLSCertFile,omitempty"`
+	AuditWebhookTLSKeyFile  string `json:"auditWebhookTLSKeyFile,omitempty"`
+	AuditDedupPath          string `json:"auditDedupPath,omitempty"`
+}
+
+type clustersConfig struct {
+	Clusters []*ClusterOptions `json:"clusters"`
+}
+
+// ClusterList returns all clusters served by the aggregator, which are read from --clusters-config,
+// or else the comma separated --cluster sharing the same audit source flags.
+func (options *AggregatorOptions) ClusterList() ([]*ClusterOptions, error) {
+	clusters := make([]*ClusterOptions, 0)
+	if options.ClustersConfigFile != "" {
+		data, err := os.ReadFile(options.ClustersConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		config := &clustersConfig{}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", options.ClustersConfigFile, err.Error())
+		}
+		clusters = config.Clusters
+	} else {
+		for _, name := range strings.Split(options.Cluster, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				clusters = append(clusters, &ClusterOptions{Name: name})
+			}
+		}
+	}
+
+	for _, cluster := range clusters {
+		options.setClusterDefaults(cluster)
+	}
+	if err := validateClusters(clusters); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// ClusterNames returns the names of ClusterList
+func (options *AggregatorOptions) ClusterNames() ([]string, error) {
+	clusters, err := options.ClusterList()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+	}
+	return names, nil
+}
+
+func (options *AggregatorOptions) setClusterDefaults(cluster *ClusterOptions) {
+	if cluster.AuditSource == "" {
+		cluster.AuditSource = options.AuditSource
+	}
+	if cluster.AuditLogPath == "" {
+		cluster.AuditLogPath = options.AuditLogPath
+	}
+	if cluster.AuditCheckpointPath == "" {
+		cluster.AuditCheckpointPath = options.AuditCheckpointPath
+	}
+	if cluster.AuditWebhookListenAddr == "" {
+		cluster.AuditWebhookListenAddr = options.AuditWebhookListenAddr
+	}
+	if cluster.AuditWebhookTLSCertFile == "" {
+		cluster.AuditWebhookTLSCertFile = options.AuditWebhookTLSCertFile
+	}
+	if cluster.AuditWebhookTLSKeyFile == "" {
+		cluster.AuditWebhookTLSKeyFile = options.AuditWebhookTLSKeyFile
+	}
+	if cluster.AuditDedupPath == "" {
+		cluster.AuditDedupPath = options.AuditDedupPath
+	}
+}
+
+// validateClusters makes sure the clusters do not share the files or webhook address
+func validateClusters(clusters []*ClusterOptions) error {
+	if len(clusters) == 0 {
+		return fmt.Errorf("no cluster is given by --cluster or --clusters-config")
+	}
+
+	// "<what> <value>" -> cluster
+	used := make(map[string]string)
+	useOnce := func(cluster, what, value string) error {
+		if value == "" {
+			return nil
+		}
+		key := what + " " + value
+		if other, ok := used[key]; ok {
+			return fmt.Errorf("cluster %s and %s use the same %s", other, cluster, key)
+		}
+		used[key] = cluster
+		return nil
+	}
+
+	for _, cluster := range clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("cluster name is empty")
+		}
+		if err := useOnce(cluster.Name, "name", cluster.Name); err != nil {
+			return err
+		}
+		if err := useOnce(cluster.Name, "dedupe file", cluster.AuditDedupPath); err != nil {
+			return err
+		}
+
+		switch cluster.AuditSource {
+		case replayer.AuditSourceFile:
+			if cluster.AuditLogPath == "" {
+				return fmt.Errorf("audit log path of cluster %s is empty", cluster.Name)
+			}
+			if err := useOnce(cluster.Name, "audit log", cluster.AuditLogPath); err != nil {
+				return err
+			}
+			if err := useOnce(cluster.Name, "checkpoint file", cluster.AuditCheckpointPath); err != nil {
+				return err
+			}
+		case replayer.AuditSourceWebhook:
+			if err := useOnce(cluster.Name, "webhook address", cluster.AuditWebhookListenAddr); err != nil {
+				return err
+			}
+		case replayer.AuditSourceElasticSearch, "":
+		default:
+			return fmt.Errorf("unknown audit source %s of cluster %s", cluster.AuditSource, cluster.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package aggregator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alipay/container-observability-service/pkg/replayer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterList(t *testing.T) {
+	options := &AggregatorOptions{
+		Cluster:                "a, b,",
+		AuditSource:            replayer.AuditSourceElasticSearch,
+		AuditWebhookListenAddr: ":9099",
+	}
+	clusters, err := options.ClusterList()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(clusters))
+	assert.Equal(t, "a", clusters[0].Name)
+	assert.Equal(t, "b", clusters[1].Name)
+	assert.Equal(t, replayer.AuditSourceElasticSearch, clusters[1].AuditSource)
+
+	// clusters can not share the webhook address
+	options.AuditSource = replayer.AuditSourceWebhook
+	_, err = options.ClusterList()
+	assert.NotNil(t, err)
+
+	options.Cluster = ""
+	_, err = options.ClusterList()
+	assert.NotNil(t, err)
+}
+
+func TestClusterListFromFile(t *testing.T) {
+	config := `
+clusters:
+- name: a
+  auditSource: file
+  auditLogPath: /var/log/a/audit.log
+- name: b
+  auditSource: webhook
+  auditWebhookListenAddr: ":9100"
+- name: c
+`
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(config), 0644))
+
+	options := &AggregatorOptions{
+		Cluster:                "ignored",
+		ClustersConfigFile:     path,
+		AuditSource:            replayer.AuditSourceElasticSearch,
+		AuditWebhookListenAddr: ":9099",
+	}
+	names, err := options.ClusterNames()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+
+	clusters, err := options.ClusterList()
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/log/a/audit.log", clusters[0].AuditLogPath)
+	assert.Equal(t, ":9100", clusters[1].AuditWebhookListenAddr)
+	assert.Equal(t, replayer.AuditSourceElasticSearch, clusters[2].AuditSource)
+
+	// two clusters reading the same file
+	config += `
+- name: d
+  auditSource: file
+  auditLogPath: /var/log/a/audit.log
+`
+	assert.Nil(t, os.WriteFile(path, []byte(config), 0644))
+	_, err = options.ClusterList()
+	assert.NotNil(t, err)
+}
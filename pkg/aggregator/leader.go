@@ -0,0 +1,193 @@
+package aggregator
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+var (
+	IsLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "lunettes_aggregator_is_leader",
+			Help: "1 if this aggregator is the leader processing audit events, 0 if it is standby",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(IsLeader)
+}
+
+// LeaderElectionOptions configures the lease shared by the aggregator replicas
+type LeaderElectionOptions struct {
+	Enabled       bool
+	Namespace     string
+	Name          string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// leaderState is reported by the health endpoint
+type leaderState struct {
+	leading int32
+	healthz *leaderelection.HealthzAdaptor
+}
+
+func (s *leaderState) setLeading(leading bool) {
+	if leading {
+		atomic.StoreInt32(&s.leading, 1)
+		IsLeader.Set(1)
+	} else {
+		atomic.StoreInt32(&s.leading, 0)
+		IsLeader.Set(0)
+	}
+}
+
+func (s *leaderState) isLeading() bool {
+	return atomic.LoadInt32(&s.leading) == 1
+}
+
+// HealthzHandler returns 500 if the leader failed to renew the lease in time,
+// otherwise 200 with the body "leader" or "standby".
+func (a *Aggregator) HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.leader.healthz != nil {
+			if err := a.leader.healthz.Check(r); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if a.leader.isLeading() {
+			w.Write([]byte("leader"))
+		} else {
+			w.Write([]byte("standby"))
+		}
+	}
+}
+
+// runWithLeaderElection blocks until stopCh is closed, the replayers run only while holding the lease.
+// The new leader resumes from the checkpoint in lunettes_meta, and restores PodDeleteMileStoneMap saved by the old one.
+func (a *Aggregator) runWithLeaderElection(stopCh <-chan struct{}) error {
+	options := a.options.LeaderElection
+	// every shard has its own leader
+	options.Name = a.options.Shard.Name(options.Name)
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	identity := hostname + "_" + string(uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: options.Namespace,
+			Name:      options.Name,
+		},
+		Client: a.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	// closed when the replayers are stopped and the checkpoints are saved
+	replayersDone := make(chan struct{})
+	var (
+		mutex    sync.Mutex
+		running  bool
+		stopping bool
+	)
+
+	// the lease is released on cancel, which must wait for the checkpoints so the next leader resumes from them
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		mutex.Lock()
+		stopping = true
+		wait := running
+		mutex.Unlock()
+		if wait {
+			<-replayersDone
+		}
+		cancel()
+	}()
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   options.LeaseDuration,
+		RenewDeadline:   options.RenewDeadline,
+		RetryPeriod:     options.RetryPeriod,
+		ReleaseOnCancel: true,
+		WatchDog:        a.leader.healthz,
+		Name:            options.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				defer close(replayersDone)
+				mutex.Lock()
+				if stopping {
+					mutex.Unlock()
+					return
+				}
+				running = true
+				mutex.Unlock()
+
+				klog.Infof("%s becomes the leader", identity)
+				a.leader.setLeading(true)
+				// stopped on shutdown while still holding the lease, or when the lease is lost
+				replayersStop := make(chan struct{})
+				go func() {
+					select {
+					case <-stopCh:
+					case <-ctx.Done():
+					}
+					close(replayersStop)
+				}()
+				a.runReplayers(replayersStop)
+			},
+			OnStoppedLeading: func() {
+				if !a.leader.isLeading() {
+					// standby is shutting down
+					return
+				}
+				a.leader.setLeading(false)
+				select {
+				case <-stopCh:
+					// shutting down, wait for the checkpoints to be saved
+					<-replayersDone
+					return
+				default:
+				}
+
+				// the milestones in memory can not be handed over, exit and restart as standby
+				<-replayersDone
+				xsearch.XSearchClear.DoClear()
+				klog.Fatalf("%s lost the leadership, exiting", identity)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					klog.Infof("the leader is %s, %s is standby", current, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	a.leader.healthz.SetLeaderElection(elector)
+
+	klog.Infof("%s is waiting for the lease %s/%s", identity, options.Namespace, options.Name)
+	elector.Run(ctx)
+	return nil
+}
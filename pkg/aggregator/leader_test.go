@@ -0,0 +1,93 @@
+package aggregator
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+type fakeReplayer struct {
+	started int32
+	stopped int32
+	onStop  func()
+}
+
+func (r *fakeReplayer) Start(stopCh <-chan struct{}) { atomic.StoreInt32(&r.started, 1) }
+func (r *fakeReplayer) Stop() {
+	if r.onStop != nil {
+		r.onStop()
+	}
+	atomic.StoreInt32(&r.stopped, 1)
+}
+
+func healthz(a *Aggregator) string {
+	w := httptest.NewRecorder()
+	a.HealthzHandler()(w, httptest.NewRequest("GET", "/healthz", nil))
+	return w.Body.String()
+}
+
+func leaseHolder(t *testing.T, client *fake.Clientset) string {
+	lease, err := client.CoordinationV1().Leases("lunettes").Get(context.Background(), "lunettes-aggregator", metav1.GetOptions{})
+	assert.Nil(t, err)
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	// the lease is held until the checkpoints are saved by Stop
+	holderOnStop := ""
+	replayer := &fakeReplayer{onStop: func() {
+		holderOnStop = leaseHolder(t, client)
+	}}
+	a := &Aggregator{
+		options: &AggregatorOptions{
+			LeaderElection: LeaderElectionOptions{
+				Enabled:       true,
+				Namespace:     "lunettes",
+				Name:          "lunettes-aggregator",
+				LeaseDuration: 15 * time.Second,
+				RenewDeadline: 10 * time.Second,
+				RetryPeriod:   100 * time.Millisecond,
+			},
+		},
+		kubeClient: client,
+		replayers:  []auditReplayer{replayer},
+		leader: &leaderState{
+			healthz: leaderelection.NewLeaderHealthzAdaptor(10 * time.Second),
+		},
+	}
+	assert.Equal(t, "standby", healthz(a))
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		assert.Nil(t, a.Run(stopCh))
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&replayer.started) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "leader", healthz(a))
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("aggregator is not stopped")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&replayer.stopped))
+	assert.NotEmpty(t, holderOnStop)
+	assert.Empty(t, leaseHolder(t, client))
+	assert.Equal(t, "standby", healthz(a))
+}
@@ -0,0 +1,36 @@
+package aggregator
+
+import (
+	"fmt"
+
+	"github.com/alipay/container-observability-service/pkg/nodeyaml"
+	"github.com/alipay/container-observability-service/pkg/podphase"
+	"github.com/alipay/container-observability-service/pkg/podyaml"
+	"github.com/alipay/container-observability-service/pkg/queue"
+	"github.com/alipay/container-observability-service/pkg/shares"
+	"github.com/alipay/container-observability-service/pkg/trace"
+)
+
+// enableQueueSpill lets the queues fed by the audit processor spill to dir, so that the reader is not blocked
+// by a slow consumer. A spilled event is decoded as a new one, so only the queues of independent nodes in the
+// processing DAG could spill, the slo and spans queues never do.
+func enableQueueSpill(dir string, maxBytes int64) error {
+	for node, q := range map[string]*queue.BoundedQueue{
+		podyaml.DAGNode:  podyaml.Queue,
+		podphase.DAGNode: podphase.WatcherQueue,
+		nodeyaml.DAGNode: nodeyaml.Queue,
+		trace.DAGNode:    trace.WatcherQueue,
+	} {
+		// the trace queue is created only if the feature is enabled
+		if q == nil {
+			continue
+		}
+		if !shares.IsIndependentDAGNode(node) {
+			return fmt.Errorf("queue of %s could not spill, it depends on or is depended on by other nodes", node)
+		}
+		if err := q.EnableSpill(dir, maxBytes, shares.AuditEventCodec{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
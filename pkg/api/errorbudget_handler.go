@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/alipay/container-observability-service/pkg/slo"
+	"github.com/alipay/container-observability-service/pkg/utils"
+)
+
+type errorBudgetHandler struct {
+	server        *Server
+	request       *http.Request
+	writer        http.ResponseWriter
+	requestParams *errorBudgetReq
+}
+
+type errorBudgetReq struct {
+	Objective string // 为空时返回所有 SLO 目标
+	Cluster   string
+	Namespace string
+	OwnerRef  string
+}
+
+func (handler *errorBudgetHandler) RequestParams() interface{} {
+	return handler.requestParams
+}
+
+func (handler *errorBudgetHandler) ParseRequest() error {
+	r := handler.request
+	req := errorBudgetReq{}
+	if r.Method == http.MethodGet {
+		setSP(r.URL.Query(), "objective", &req.Objective)
+		setSP(r.URL.Query(), "cluster", &req.Cluster)
+		setSP(r.URL.Query(), "namespace", &req.Namespace)
+		setSP(r.URL.Query(), "ownerref", &req.OwnerRef)
+	}
+	handler.requestParams = &req
+	return nil
+}
+
+func (handler *errorBudgetHandler) ValidRequest() error {
+	return nil
+}
+
+func (handler *errorBudgetHandler) Process() (int, interface{}, error) {
+	defer utils.IgnorePanic("errorBudgetHandler.Process")
+
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("QueryErrorBudget", begin)
+	}()
+
+	debugApiCalledCounter("errorBudgetHandler", handler.request)
+
+	req := handler.requestParams
+	result := make([]*slo.ErrorBudget, 0)
+	for _, budget := range slo.ErrorBudgets(req.Objective) {
+		if (req.Cluster != "" && budget.Cluster != req.Cluster) ||
+			(req.Namespace != "" && budget.Namespace != req.Namespace) ||
+			(req.OwnerRef != "" && budget.OwnerRef != req.OwnerRef) {
+			continue
+		}
+		result = append(result, budget)
+	}
+
+	return http.StatusOK, result, nil
+}
+
+func errorBudgetFactory(s *Server, w http.ResponseWriter, r *http.Request) handler {
+	return &errorBudgetHandler{
+		server:  s,
+		request: r,
+		writer:  w,
+	}
+}
@@ -157,6 +157,7 @@ func (s *Server) StartServer(stopCh chan struct{}) {
 		http.HandleFunc("/api/v1/debugpod", handlerWrapper(s, debugPodFactory))
 		http.HandleFunc("/api/v1/debugslo", handlerWrapper(s, sloFactory))
 		http.HandleFunc("/api/v1/rawdata", handlerWrapper(s, rawDataFactory))
+		http.HandleFunc("/api/v1/errorbudget", handlerWrapper(s, errorBudgetFactory))
 		http.HandleFunc("/fake", handlerWrapper(s, fakeFactory))
 		//watch delivery info
 		http.HandleFunc("/api/v1/watch", watch)
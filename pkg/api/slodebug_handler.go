@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/alipay/container-observability-service/pkg/dal/storage-client/model"
 	"github.com/alipay/container-observability-service/pkg/metrics"
 	"github.com/alipay/container-observability-service/pkg/utils"
 )
@@ -21,7 +22,7 @@ type sloReq struct {
 	Result         string
 	Cluster        string
 	Count          string
-	Type           string    // create 或者 delete, 默认是 create
+	Type           string    // create, delete, upgrade 或者 job, 默认是 create
 	DeliveryStatus string    // FAIL/KILL/ALL/SUCCESS
 	SloTime        string    // 20s/30m0s/10m0s
 	Env            string    // prod, test
@@ -76,6 +77,8 @@ func (handler *sloHandler) Process() (int, interface{}, error) {
 		slodatas = handler.server.queryDeleteSloByResult(handler.requestParams)
 	} else if strings.Contains(handler.requestParams.Type, "upgrade") {
 		slodatas = handler.server.queryUpgradeSloByResult(handler.requestParams)
+	} else if model.IsJobSlo(handler.requestParams.Type) {
+		slodatas = handler.server.queryJobSloByResult(handler.requestParams)
 	} else {
 		slodatas = handler.server.querySloByResult(handler.requestParams)
 	}
@@ -1,17 +1,26 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alipay/container-observability-service/pkg/metas"
+	"github.com/alipay/container-observability-service/pkg/pubsub"
 	"github.com/gorilla/websocket"
 	"k8s.io/klog/v2"
 )
 
 type WatchParam struct {
 	deliveryType string
+	// resume after the version, the messages published from now on if empty
+	resourceVersion string
+	// select the SLO records sent, see metas.WatchFilter
+	labelSelector string
+	fieldSelector string
 }
 
 var upgrader = websocket.Upgrader{} // use default options
@@ -19,19 +28,36 @@ var upgrader = websocket.Upgrader{} // use default options
 func parseParam(request *http.Request) *WatchParam {
 	watchParam := &WatchParam{}
 	setSP(request.URL.Query(), "type", &watchParam.deliveryType)
+	setSP(request.URL.Query(), "resourceVersion", &watchParam.resourceVersion)
+	setSP(request.URL.Query(), "labelSelector", &watchParam.labelSelector)
+	setSP(request.URL.Query(), "fieldSelector", &watchParam.fieldSelector)
 
 	return watchParam
 }
 
 func validateParam(param *WatchParam) error {
-	if param == nil || param.deliveryType != metas.PodCreateSLO && param.deliveryType != metas.PodDeleteSLO {
-		err := fmt.Errorf("watch param can not be nil, must be one of [%s, %s]", metas.PodCreateSLO, metas.PodDeleteSLO)
+	if param == nil || !isDeliveryType(param.deliveryType) {
+		err := fmt.Errorf("watch param can not be nil, must be one of [%s]", strings.Join(metas.DeliveryTypes, ", "))
 		return err
 	}
+	if param.resourceVersion != "" {
+		if _, err := strconv.ParseUint(param.resourceVersion, 10, 64); err != nil {
+			return fmt.Errorf("invalid resourceVersion %s", param.resourceVersion)
+		}
+	}
 
 	return nil
 }
 
+func isDeliveryType(deliveryType string) bool {
+	for _, t := range metas.DeliveryTypes {
+		if t == deliveryType {
+			return true
+		}
+	}
+	return false
+}
+
 func watch(w http.ResponseWriter, r *http.Request) {
 	param := parseParam(r)
 	if err := validateParam(param); err != nil {
@@ -40,6 +66,12 @@ func watch(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(msg))
 		return
 	}
+	filter, err := metas.ParseWatchFilter(param.labelSelector, param.fieldSelector)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
 	infoType := param.deliveryType
 
@@ -52,8 +84,18 @@ func watch(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(msg))
 		return
 	}
-	watcher := metas.NewWatcher(fmt.Sprintf("%s_%s", infoType, time.Now().Format(time.RFC3339Nano)), w, r)
-	err := publisher.Subscribe(watcher)
+	watcher := metas.NewWatcher(fmt.Sprintf("%s_%s", infoType, time.Now().Format(time.RFC3339Nano)), filter, w, r)
+	if watcher == nil {
+		return
+	}
+	resourceVersion, _ := strconv.ParseUint(param.resourceVersion, 10, 64)
+	err = publisher.SubscribeFrom(watcher, resourceVersion)
+	if errors.Is(err, pubsub.ErrResourceVersionExpired) {
+		// like kubernetes, the client should list again and watch from now on
+		watcher.SendError(http.StatusGone, "Expired", err)
+		watcher.Stop()
+		return
+	}
 	if err != nil {
 		msg := fmt.Sprintf("failed to new watcher, err: %v", err)
 		klog.Errorf(msg)
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/alipay/container-observability-service/pkg/dal/storage-client/model"
 	"github.com/alipay/container-observability-service/pkg/metrics"
 	"github.com/alipay/container-observability-service/pkg/spans"
 
@@ -134,6 +135,12 @@ type slodata struct {
 	DeliveryStatus            string
 	DeliveryStatusOrig        string
 	SloHint                   string // why current slo class
+	JobName                   string
+	CronJobName               string
+	JobResult                 string
+	JobFailedReason           string
+	Failed                    int32
+	Duration                  time.Duration
 }
 
 //func transSlodatas(slos []*slodata) []map[string]string {
@@ -215,6 +222,19 @@ func transSlodata(slo *slodata, env string) map[string]string {
 		}
 	}
 
+	if slo.JobResult != "" {
+		result["JobName"] = slo.JobName
+		result["JobResult"] = slo.JobResult
+		result["JobDuration"] = slo.Duration.String()
+		result["JobRetries"] = strconv.Itoa(int(slo.Failed))
+		if slo.CronJobName != "" {
+			result["CronJobName"] = slo.CronJobName
+		}
+		if slo.JobFailedReason != "" {
+			result["JobFailedReason"] = slo.JobFailedReason
+		}
+	}
+
 	return result
 }
 
@@ -426,6 +446,66 @@ func (s *Server) queryUpgradeSloByResult(requestParams *sloReq) []*slodata {
 	return returnResult
 }
 
+// 查询 slo_trace_data_daily 索引中 Job 的完成记录，用于 debugSLO 这个 URL
+func (s *Server) queryJobSloByResult(requestParams *sloReq) []*slodata {
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("QueryJobSloByResult", begin)
+	}()
+
+	if requestParams == nil || requestParams.Result == "" {
+		return make([]*slodata, 0)
+	}
+
+	resultQuery := elastic.NewQueryStringQuery(fmt.Sprintf("JobResult: \"%s\"", requestParams.Result))
+	typeQuery := elastic.NewQueryStringQuery(fmt.Sprintf("Type: \"%s\"", model.SloTypeJob))
+	query := elastic.NewBoolQuery().Must(resultQuery, typeQuery)
+
+	if requestParams.Cluster != "" {
+		query = query.Must(elastic.NewTermQuery("Cluster.keyword", requestParams.Cluster))
+	}
+
+	// add range query
+	if !requestParams.From.IsZero() || !requestParams.To.IsZero() {
+		rangeQuery := elastic.NewRangeQuery("CreatedTime").TimeZone("UTC")
+		if !requestParams.From.IsZero() {
+			rangeQuery = rangeQuery.From(requestParams.From)
+		}
+		if !requestParams.To.IsZero() {
+			rangeQuery = rangeQuery.To(requestParams.To)
+		}
+		query = query.Must(rangeQuery)
+	}
+
+	querySize := 300
+	if requestParams.Count != "" {
+		count, err := strconv.Atoi(requestParams.Count)
+		if err == nil {
+			querySize = count
+		}
+	}
+	if querySize > 5000 {
+		querySize = 5000
+	}
+
+	returnResult := make([]*slodata, 0)
+	searchResult, err := s.ESClient.Search().Index(sloTraceDataIndexName).Type(sloDataTypeName).Query(query).Size(querySize).
+		Sort("CreatedTime", false).Do(context.Background())
+	if err != nil {
+		klog.Error(err)
+		return returnResult
+	}
+
+	for _, hit := range searchResult.Hits.Hits {
+		slo := &slodata{}
+		if er := json.Unmarshal(hit.Source, slo); er == nil {
+			returnResult = append(returnResult, slo)
+		}
+	}
+
+	return returnResult
+}
+
 func (s *Server) queryPodPhaseWithPodUIDOrName(podUID string, podName string) []*podPhase {
 	stringQuery := elastic.NewQueryStringQuery(fmt.Sprintf("podName: \"%s\"", podName))
 	if podUID != "" {
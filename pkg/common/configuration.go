@@ -0,0 +1,424 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	ConfigurationAPIVersion = "lunettes.alipay.com/v1alpha1"
+	ConfigurationKind       = "LunettesConfiguration"
+
+	// ConfigFlag is the flag of the config file path
+	ConfigFlag = "config"
+	// EnvPrefix prefixes the environment variables overriding flags, e.g. LUNETTES_ES_ENDPOINT for --es-endpoint
+	EnvPrefix = "LUNETTES_"
+
+	maskedSecret = "******"
+)
+
+// Configuration is the config file shared by aggregator and grafanadi. The fields tagged with flag are the
+// defaults of the flags, the order of precedence is flag, environment variable, config file, then flag default.
+// A binary ignores the fields whose flags it does not have.
+type Configuration struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+
+	Kubernetes     KubernetesConfig     `yaml:"kubernetes"`
+	Ingest         IngestConfig         `yaml:"ingest"`
+	Storage        StorageConfig        `yaml:"storage"`
+	Exporters      ExportersConfig      `yaml:"exporters"`
+	Queues         QueuesConfig         `yaml:"queues"`
+	Server         ServerConfig         `yaml:"server"`
+	LeaderElection LeaderElectionConfig `yaml:"leaderElection"`
+	// the defaults of the lunettes-config ConfigMap
+	SLO SLOConfig `yaml:"slo"`
+	// a JSON file replacing the lunettes-config ConfigMap, for running out of cluster
+	LunettesConfigFile string          `yaml:"lunettesConfigFile" flag:"lunettes-config-file"`
+	FeatureGates       map[string]bool `yaml:"featureGates" flag:"feature-gates"`
+}
+
+type KubernetesConfig struct {
+	KubeConfig     string  `yaml:"kubeconfig" flag:"kubeconfig"`
+	QPS            float32 `yaml:"qps" flag:"qps"`
+	Burst          int     `yaml:"burst" flag:"burst"`
+	Cluster        string  `yaml:"cluster" flag:"cluster"`
+	ClustersConfig string  `yaml:"clustersConfig" flag:"clusters-config"`
+	// apply the DeliverySLOPolicy custom resources of the cluster
+	WatchSLOPolicies bool `yaml:"watchSLOPolicies" flag:"watch-slo-policies"`
+}
+
+type IngestConfig struct {
+	// elasticsearch, file or webhook
+	Source         string        `yaml:"source" flag:"audit-source"`
+	LogPath        string        `yaml:"logPath" flag:"audit-log-path"`
+	CheckpointPath string        `yaml:"checkpointPath" flag:"audit-checkpoint-path"`
+	Webhook        WebhookConfig `yaml:"webhook"`
+	Dedup          DedupConfig   `yaml:"dedup"`
+	Shard          ShardConfig   `yaml:"shard"`
+	// a YAML file of replayer.AuditFilterPolicy
+	FilterPolicy string `yaml:"filterPolicy" flag:"audit-filter-policy"`
+}
+
+type WebhookConfig struct {
+	ListenAddr  string `yaml:"listenAddr" flag:"audit-webhook-addr"`
+	TLSCertFile string `yaml:"tlsCertFile" flag:"audit-webhook-tls-cert-file"`
+	TLSKeyFile  string `yaml:"tlsKeyFile" flag:"audit-webhook-tls-key-file"`
+}
+
+type DedupConfig struct {
+	Window  time.Duration `yaml:"window" flag:"audit-dedup-window"`
+	MaxSize int           `yaml:"maxSize" flag:"audit-dedup-max-size"`
+	Path    string        `yaml:"path" flag:"audit-dedup-path"`
+}
+
+type ShardConfig struct {
+	Count int `yaml:"count" flag:"shard-count"`
+	Index int `yaml:"index" flag:"shard-index"`
+}
+
+type StorageConfig struct {
+	// elasticsearch or mysql, used by grafanadi
+	Driver        string                     `yaml:"driver"`
+	ElasticSearch ElasticSearchStorageConfig `yaml:"elasticsearch"`
+	MySQL         *MysqlOptions              `yaml:"mysql"`
+}
+
+type ElasticSearchStorageConfig struct {
+	Endpoint       string        `yaml:"endpoint" flag:"es-endpoint"`
+	Username       string        `yaml:"username" flag:"es-user"`
+	Password       string        `yaml:"password" flag:"es-password" secret:"true"`
+	Index          string        `yaml:"index" flag:"es-index"`
+	IndexPattern   string        `yaml:"indexPattern" flag:"es-index-pattern"`
+	TimestampField string        `yaml:"timestampField" flag:"es-timestamp-field"`
+	ClusterField   string        `yaml:"clusterField" flag:"es-cluster-field"`
+	BufferDuration time.Duration `yaml:"bufferDuration" flag:"es-buffer-duration"`
+	FetchInterval  time.Duration `yaml:"fetchInterval" flag:"es-fetch-interval"`
+}
+
+type ExportersConfig struct {
+	JaegerCollector string        `yaml:"jaegerCollector" flag:"jaeger-collector"`
+	OTLPCollector   string        `yaml:"otlpCollector" flag:"otlp-collector"`
+	TraceEnabled    bool          `yaml:"traceEnabled" flag:"trace-enable"`
+	TraceTimeout    time.Duration `yaml:"traceTimeout" flag:"trace-timeout"`
+}
+
+type QueuesConfig struct {
+	SpillDir      string `yaml:"spillDir" flag:"queue-spill-dir"`
+	SpillMaxBytes int64  `yaml:"spillMaxBytes" flag:"queue-spill-max-bytes"`
+}
+
+type ServerConfig struct {
+	MetricsAddr string `yaml:"metricsAddr" flag:"metrics-addr"`
+	// dead letters are listed and replayed here, only from localhost by default
+	AdminAddr string `yaml:"adminAddr" flag:"admin-addr"`
+	// adds the cluster label to the metrics which did not have it, their label sets change
+	MetricsClusterLabel bool `yaml:"metricsClusterLabel" flag:"metrics-cluster-label"`
+	// api server of aggregator
+	APIServerEnabled bool   `yaml:"apiServerEnabled" flag:"apiserver-enabled"`
+	APIServerAddr    string `yaml:"apiServerAddr" flag:"apiserver-addr"`
+	// api server of grafanadi
+	ListenAddr       string `yaml:"listenAddr" flag:"listen-addr"`
+	GrafanaURL       string `yaml:"grafanaURL" flag:"grafana-url"`
+	TKPReqConfigFile string `yaml:"tkpReqConfigFile" flag:"tkp-req-config-file"`
+
+	WatchHistoryDir  string `yaml:"watchHistoryDir" flag:"watch-history-dir"`
+	WatchHistorySize int    `yaml:"watchHistorySize" flag:"watch-history-size"`
+}
+
+type LeaderElectionConfig struct {
+	Enabled       bool          `yaml:"enabled" flag:"leader-elect"`
+	Namespace     string        `yaml:"namespace" flag:"leader-elect-namespace"`
+	Name          string        `yaml:"name" flag:"leader-elect-name"`
+	LeaseDuration time.Duration `yaml:"leaseDuration" flag:"leader-elect-lease-duration"`
+	RenewDeadline time.Duration `yaml:"renewDeadline" flag:"leader-elect-renew-deadline"`
+	RetryPeriod   time.Duration `yaml:"retryPeriod" flag:"leader-elect-retry-period"`
+}
+
+// SLOConfig is used until the lunettes-config ConfigMap is read, see config.LunettesConfig
+type SLOConfig struct {
+	UserOnlineConfigMap         map[string]string `yaml:"userOnlineConfigMap,omitempty"`
+	UserAppConfigMap            map[string]string `yaml:"userAppConfigMap,omitempty"`
+	UserSLAConfigMap            map[string]string `yaml:"userSLAConfigMap,omitempty"`
+	IgnoredNamespaceForAudit    []string          `yaml:"ignoredNamespaceForAudit,omitempty"`
+	PostStartHookTimeout        string            `yaml:"postStartHookTimeout,omitempty"`
+	ShouldIgnoreSinglePod       bool              `yaml:"shouldIgnoreSinglePod,omitempty"`
+	ShouldRetainOldMetrics      bool              `yaml:"shouldRetainOldMetrics,omitempty"`
+	IgnoreDeleteReasonNamespace []string          `yaml:"ignoreDeleteReasonNamespace,omitempty"`
+}
+
+// IsZero tells whether nothing of the SLO defaults is set
+func (c *SLOConfig) IsZero() bool {
+	return reflect.ValueOf(*c).IsZero()
+}
+
+// DBOptions returns the storage options of grafanadi
+func (c *Configuration) DBOptions() *DBOptions {
+	options := NewDefaultOptions()
+	if c.Storage.Driver != "" {
+		options.Driver = c.Storage.Driver
+	}
+	if c.Storage.MySQL != nil {
+		options.MysqlOptions = c.Storage.MySQL
+	}
+	es := c.Storage.ElasticSearch
+	if es.Endpoint != "" {
+		options.ESOptions = &ESOptions{EndPoint: es.Endpoint, Username: es.Username, Password: es.Password}
+	}
+	return options
+}
+
+// Validate checks the values not checked by the flags
+func (c *Configuration) Validate() error {
+	errs := make([]error, 0)
+	if c.APIVersion != ConfigurationAPIVersion {
+		errs = append(errs, fmt.Errorf("apiVersion must be %s", ConfigurationAPIVersion))
+	}
+	if c.Kind != ConfigurationKind {
+		errs = append(errs, fmt.Errorf("kind must be %s", ConfigurationKind))
+	}
+	switch c.Ingest.Source {
+	// replayer.AuditSource*
+	case "", "elasticsearch", "file", "webhook":
+	default:
+		errs = append(errs, fmt.Errorf("ingest.source must be one of elasticsearch, file or webhook"))
+	}
+	switch c.Storage.Driver {
+	case "", "elasticsearch", "mysql":
+	default:
+		errs = append(errs, fmt.Errorf("storage.driver must be one of elasticsearch or mysql"))
+	}
+	if c.Ingest.Shard.Count > 0 && (c.Ingest.Shard.Index < 0 || c.Ingest.Shard.Index >= c.Ingest.Shard.Count) {
+		errs = append(errs, fmt.Errorf("ingest.shard.index must be in [0, %d)", c.Ingest.Shard.Count))
+	}
+	if c.Queues.SpillMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("queues.spillMaxBytes must not be negative"))
+	}
+	if c.Server.WatchHistorySize < 0 {
+		errs = append(errs, fmt.Errorf("server.watchHistorySize must not be negative"))
+	}
+	if c.SLO.PostStartHookTimeout != "" {
+		if _, err := time.ParseDuration(c.SLO.PostStartHookTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("slo.postStartHookTimeout: %w", err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// EnvName returns the environment variable overriding flag name
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadConfiguration applies the environment variables and the file of --config to the flags not set
+// on the command line, and returns the effective configuration.
+func LoadConfiguration(flags *pflag.FlagSet) (*Configuration, error) {
+	errs := make([]error, 0)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if flags.Changed(f.Name) {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err := flags.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", EnvName(f.Name), err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+
+	cfg := &Configuration{APIVersion: ConfigurationAPIVersion, Kind: ConfigurationKind}
+	if err := cfg.readFlags(flags); err != nil {
+		return nil, err
+	}
+	path := ""
+	if f := flags.Lookup(ConfigFlag); f != nil {
+		path = f.Value.String()
+	}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.APIVersion, cfg.Kind = "", ""
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	walkFlagFields(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, name string) {
+		if flags.Lookup(name) == nil || flags.Changed(name) {
+			return
+		}
+		if err := flags.Set(name, formatFlagValue(field)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s of config file: %w", name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, utilerrors.NewAggregate(errs))
+	}
+	// the flags set by environment variables
+	return cfg, cfg.readFlags(flags)
+}
+
+// readFlags sets the fields to the values of their flags
+func (c *Configuration) readFlags(flags *pflag.FlagSet) error {
+	errs := make([]error, 0)
+	walkFlagFields(reflect.ValueOf(c).Elem(), func(field reflect.Value, name string) {
+		if f := flags.Lookup(name); f != nil {
+			if err := parseFlagValue(field, f.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("flag %s: %w", name, err))
+			}
+		}
+	})
+	return utilerrors.NewAggregate(errs)
+}
+
+// Dump returns the configuration as yaml with secrets masked
+func (c *Configuration) Dump() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	masked := &Configuration{}
+	if err := yaml.Unmarshal(data, masked); err != nil {
+		return "", err
+	}
+	maskSecrets(reflect.ValueOf(masked).Elem())
+	if masked.Storage.MySQL != nil && masked.Storage.MySQL.Password != "" {
+		masked.Storage.MySQL.Password = maskedSecret
+	}
+	data, err = yaml.Marshal(masked)
+	return string(data), err
+}
+
+func maskSecrets(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			maskSecrets(field)
+			continue
+		}
+		if v.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(maskedSecret)
+		}
+	}
+}
+
+// walkFlagFields calls fn with the fields tagged with flag
+func walkFlagFields(v reflect.Value, fn func(field reflect.Value, name string)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if name := v.Type().Field(i).Tag.Get("flag"); name != "" {
+			fn(field, name)
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			walkFlagFields(field, fn)
+		}
+	}
+}
+
+// formatFlagValue formats the field as the value of a flag
+func formatFlagValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case []string:
+		return strings.Join(value, ",")
+	case map[string]bool:
+		// feature gates
+		gates := make([]string, 0, len(value))
+		for name, enabled := range value {
+			gates = append(gates, fmt.Sprintf("%s=%t", name, enabled))
+		}
+		sort.Strings(gates)
+		return strings.Join(gates, ",")
+	case float32:
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// parseFlagValue sets the field to the value of a flag
+func parseFlagValue(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case []string:
+		s = strings.Trim(s, "[]")
+		values := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item != "" {
+				values = append(values, item)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+		return nil
+	case map[string]bool:
+		gates := make(map[string]bool)
+		for _, item := range strings.Split(s, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			name, value := item, "true"
+			if i := strings.Index(item, "="); i >= 0 {
+				name, value = item[:i], item[i+1:]
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			gates[name] = enabled
+		}
+		v.Set(reflect.ValueOf(gates))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Float32:
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
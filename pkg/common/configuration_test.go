@@ -0,0 +1,122 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFlags struct {
+	config        string
+	qps           float32
+	cluster       string
+	source        string
+	webhookAddr   string
+	dedupWindow   time.Duration
+	esEndpoint    string
+	esPassword    string
+	spillDir      string
+	spillMaxBytes int64
+	apiEnabled    bool
+	featureGates  string
+}
+
+func newTestFlags() (*pflag.FlagSet, *testFlags) {
+	f := &testFlags{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVar(&f.config, ConfigFlag, "", "")
+	flags.Float32Var(&f.qps, "qps", 1024, "")
+	flags.StringVar(&f.cluster, "cluster", "", "")
+	flags.StringVar(&f.source, "audit-source", "elasticsearch", "")
+	flags.StringVar(&f.webhookAddr, "audit-webhook-addr", ":9443", "")
+	flags.DurationVar(&f.dedupWindow, "audit-dedup-window", time.Hour, "")
+	flags.StringVar(&f.esEndpoint, "es-endpoint", "", "")
+	flags.StringVar(&f.esPassword, "es-password", "", "")
+	flags.StringVar(&f.spillDir, "queue-spill-dir", "", "")
+	flags.Int64Var(&f.spillMaxBytes, "queue-spill-max-bytes", 10<<30, "")
+	flags.BoolVar(&f.apiEnabled, "apiserver-enabled", false, "")
+	flags.StringVar(&f.featureGates, "feature-gates", "", "")
+	return flags, f
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	flags, f := newTestFlags()
+	assert.Nil(t, flags.Parse([]string{"--config", "./testdata/lunettes-config.yaml", "--audit-source", "file"}))
+	t.Setenv(EnvName("es-endpoint"), "http://es-from-env:9200")
+	// the command line wins
+	t.Setenv(EnvName("audit-source"), "elasticsearch")
+
+	cfg, err := LoadConfiguration(flags)
+	assert.Nil(t, err)
+
+	assert.Equal(t, float32(512.5), f.qps)
+	assert.Equal(t, "c1,c2", f.cluster)
+	assert.Equal(t, "file", f.source)
+	assert.Equal(t, ":8443", f.webhookAddr)
+	assert.Equal(t, 15*time.Minute, f.dedupWindow)
+	assert.Equal(t, "http://es-from-env:9200", f.esEndpoint)
+	assert.Equal(t, "secret", f.esPassword)
+	assert.Equal(t, "/data/spill", f.spillDir)
+	// zero in the file is kept
+	assert.Equal(t, int64(0), f.spillMaxBytes)
+	assert.True(t, f.apiEnabled)
+	assert.Equal(t, "SpanAnalysisFeature=true,TraceFeature=false", f.featureGates)
+
+	// effective
+	assert.Equal(t, "file", cfg.Ingest.Source)
+	assert.Equal(t, "http://es-from-env:9200", cfg.Storage.ElasticSearch.Endpoint)
+	assert.Equal(t, "5m", cfg.SLO.PostStartHookTimeout)
+	assert.Equal(t, []string{"kube-system"}, cfg.SLO.IgnoredNamespaceForAudit)
+
+	options := cfg.DBOptions()
+	assert.Equal(t, "mysql", options.Driver)
+	assert.Equal(t, "db", options.MysqlOptions.Host)
+	assert.Equal(t, "http://es-from-env:9200", options.ESOptions.EndPoint)
+
+	dump, err := cfg.Dump()
+	assert.Nil(t, err)
+	assert.NotContains(t, dump, "secret")
+	assert.Contains(t, dump, "password: '******'")
+	assert.Contains(t, dump, "window: 15m0s")
+}
+
+func TestLoadConfigurationWithoutFile(t *testing.T) {
+	flags, f := newTestFlags()
+	assert.Nil(t, flags.Parse([]string{"--feature-gates", "SpanAnalysisFeature"}))
+
+	cfg, err := LoadConfiguration(flags)
+	assert.Nil(t, err)
+	assert.Equal(t, "elasticsearch", f.source)
+	assert.Equal(t, time.Hour, cfg.Ingest.Dedup.Window)
+	assert.Equal(t, map[string]bool{"SpanAnalysisFeature": true}, cfg.FeatureGates)
+	assert.Equal(t, ConfigurationAPIVersion, cfg.APIVersion)
+}
+
+func TestLoadInvalidConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"unknown field": "apiVersion: lunettes.alipay.com/v1alpha1\nkind: LunettesConfiguration\ningest:\n  sorce: file\n",
+		"apiVersion":    "apiVersion: v0\nkind: LunettesConfiguration\n",
+		"source":        "apiVersion: lunettes.alipay.com/v1alpha1\nkind: LunettesConfiguration\ningest:\n  source: kafka\n",
+		"duration":      "apiVersion: lunettes.alipay.com/v1alpha1\nkind: LunettesConfiguration\nslo:\n  postStartHookTimeout: 5 minutes\n",
+	}
+	for name, content := range cases {
+		path := filepath.Join(dir, name+".yaml")
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+		flags, _ := newTestFlags()
+		assert.Nil(t, flags.Parse([]string{"--config", path}))
+		_, err := LoadConfiguration(flags)
+		assert.NotNil(t, err, name)
+	}
+
+	flags, _ := newTestFlags()
+	assert.Nil(t, flags.Parse(nil))
+	t.Setenv(EnvName("qps"), "fast")
+	_, err := LoadConfiguration(flags)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "LUNETTES_QPS")
+}
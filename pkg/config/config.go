@@ -1,15 +1,17 @@
 package config
 
 import (
-	"context"
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
-	clientset "k8s.io/client-go/kubernetes"
-	restclient "k8s.io/client-go/rest"
+	"github.com/alipay/container-observability-service/pkg/featuregates"
+
+	"github.com/prometheus/client_golang/prometheus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
 
@@ -21,6 +23,7 @@ import (
 // IgnoredNamespaceForAudit: 用于指定 lunettes 可以忽略的 ns，通常由 测试开发 来配置
 // PostStartHookTimeout:     用于指定 PostStartHookTimeout 超时时间
 // ShouldIgnoreSinglePod:    用于指定 "资源交付SLO" 场景下，是否把一个单独的 Pod 给忽略掉
+// SLOObjectives:            用于定义交付 SLO 目标，计算错误预算和消耗速率，见 SLOObjective
 type LunettesConfig struct {
 	UserOnlineConfigMap         map[string]string `json:"UserOnlineConfigMap,omitempty"`
 	UserAppConfigMap            map[string]string `json:"UserAppConfigMap,omitempty"`
@@ -30,6 +33,9 @@ type LunettesConfig struct {
 	ShouldIgnoreSinglePod       bool              `json:"ShouldIgnoreSinglePod,string,omitempty"`
 	ShouldRetainOldMetrics      bool              `json:"ShouldRetainOldMetrics,string,omitempty"`
 	IgnoreDeleteReasonNamespace []string          `json:"IgnoreDeleteReasonNamespace,omitempty"`
+	// 动态开关的 feature gates，格式同 --feature-gates，例如 "NewReasonFeature=true"
+	FeatureGates  string         `json:"FeatureGates,omitempty"`
+	SLOObjectives []SLOObjective `json:"SLOObjectives,omitempty"`
 }
 
 const (
@@ -39,6 +45,12 @@ const (
 	kubeConfigPath        = "/etc/kubernetes/kubeconfig/admin.kubeconfig"
 )
 
+// where a LunettesConfig comes from
+const (
+	SourceConfigMap = "configmap"
+	SourceFile      = "file"
+)
+
 func GlobalLunettesConfig() *LunettesConfig {
 	val := configValue.Load()
 	if ptr, ok := val.(*LunettesConfig); ok && ptr != nil {
@@ -50,58 +62,149 @@ func GlobalLunettesConfig() *LunettesConfig {
 var (
 	configValue          atomic.Value
 	globalLunettesConfig LunettesConfig
+	// configmap 是否已读取，读取前使用配置文件中的默认值
+	configMapLoaded atomic.Value
+
+	handlersLock sync.Mutex
+	handlers     []namedHandler
+
+	rejectedConfigCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lunettes_config_rejected_count",
+			Help: "lunettes config rejected by validation, the last valid one is kept",
+		},
+		// configmap or file
+		[]string{"source"},
+	)
+	configReloadTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lunettes_config_last_reload_timestamp_seconds",
+			Help: "time of the last valid lunettes config applied",
+		},
+		[]string{"source"},
+	)
 )
 
+type namedHandler struct {
+	name string
+	fn   func(old, new *LunettesConfig)
+}
+
 func init() {
+	prometheus.MustRegister(rejectedConfigCount)
+	prometheus.MustRegister(configReloadTime)
+
 	configValue.Store(&globalLunettesConfig)
-	stop := make(<-chan struct{})
-	refreshLunettesConfigFromConfigMap(stop)
+	watchLunettesConfigMap()
 }
 
-// 更新 lunettes configmap
-func refreshLunettesConfigFromConfigMap(stop <-chan struct{}) {
-	if EnableStressMode {
+// SetDefaultLunettesConfig 设置 configmap 读取前使用的配置
+func SetDefaultLunettesConfig(c *LunettesConfig) {
+	if loaded, _ := configMapLoaded.Load().(bool); loaded {
 		return
 	}
+	setLunettesConfig(c)
+}
 
-	var cfg *restclient.Config
-	cfg, err := restclient.InClusterConfig()
-	if err != nil {
-		klog.Errorf("failed to build config, err is %v", err)
-		return
+// OnLunettesConfigChange registers fn to be called with the old and the new config each time
+// a valid config is applied. fn is called at once with the current config, old being nil.
+func OnLunettesConfigChange(name string, fn func(old, new *LunettesConfig)) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers = append(handlers, namedHandler{name: name, fn: fn})
+	callHandler(handlers[len(handlers)-1], nil, GlobalLunettesConfig())
+}
+
+func callHandler(h namedHandler, old, new *LunettesConfig) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("lunettes config handler %s panics: %v", h.name, r)
+		}
+	}()
+	h.fn(old, new)
+}
+
+// setLunettesConfig stores c and notifies the handlers in registration order
+func setLunettesConfig(c *LunettesConfig) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	old := GlobalLunettesConfig()
+	configValue.Store(c)
+	for _, h := range handlers {
+		callHandler(h, old, c)
 	}
+}
 
-	cfg.UserAgent = "lunettes"
-	cs, err := clientset.NewForConfig(cfg)
-	if err != nil {
-		klog.Errorf("failed to create clientset: %v", err)
-		return
+// ParseLunettesConfig decodes the JSON of the lunettes-config ConfigMap, unknown fields are rejected
+func ParseLunettesConfig(data []byte) (*LunettesConfig, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("lunettes config is empty")
 	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	c := &LunettesConfig{}
+	if err := decoder.Decode(c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-	refreshConfigMap := func() {
-		lunettesConfigMap, err := cs.CoreV1().ConfigMaps(lunettesNs).Get(context.TODO(), lunettesConfigMapName, metav1.GetOptions{})
+// Validate checks the durations of the config
+func (c *LunettesConfig) Validate() error {
+	errs := make([]error, 0)
+	checkDuration := func(field, value string) {
+		d, err := time.ParseDuration(value)
 		if err != nil {
-			klog.Errorf("failed to get lunettes configmap: %v", err)
-			return
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", field, value))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: duration %q must be positive", field, value))
 		}
-
-		lunettesStr := lunettesConfigMap.Data["lunettes-config"]
-		if lunettesStr == "" {
-			klog.Errorf("lunettes configmap data is empty")
-			return
+	}
+	for field, m := range map[string]map[string]string{
+		"UserOnlineConfigMap": c.UserOnlineConfigMap,
+		"UserAppConfigMap":    c.UserAppConfigMap,
+		"UserSLAConfigMap":    c.UserSLAConfigMap,
+	} {
+		for key, value := range m {
+			checkDuration(fmt.Sprintf("%s[%s]", field, key), value)
 		}
-		var tmpConfig LunettesConfig
-		err = json.Unmarshal([]byte(lunettesStr), &tmpConfig)
-		if err != nil {
-			klog.Errorf("failed to unmarshal lunettes configmap: %v", err)
-			return
+	}
+	if c.PostStartHookTimeout != "" {
+		checkDuration("PostStartHookTimeout", c.PostStartHookTimeout)
+	}
+	names := make(map[string]bool, len(c.SLOObjectives))
+	for i := range c.SLOObjectives {
+		o := &c.SLOObjectives[i]
+		if o.Name == "" {
+			errs = append(errs, fmt.Errorf("SLOObjectives[%d]: name needed", i))
+		} else if names[o.Name] {
+			errs = append(errs, fmt.Errorf("SLOObjectives[%s]: duplicated name", o.Name))
 		}
+		names[o.Name] = true
+		errs = append(errs, o.validate()...)
+	}
+	return utilerrors.NewAggregate(errs)
+}
 
-		klog.Infof("configmap is %v", tmpConfig)
-		configValue.Store(&tmpConfig)
+// applyLunettesConfig validates and applies the config read from source,
+// an invalid config is counted and the last valid one is kept
+func applyLunettesConfig(source string, data []byte) error {
+	c, err := ParseLunettesConfig(data)
+	if err != nil {
+		rejectedConfigCount.WithLabelValues(source).Inc()
+		klog.Errorf("lunettes config from %s is rejected, the last valid one is kept: %v", source, err)
+		return err
 	}
 
-	refreshConfigMap()
-	go wait.JitterUntil(refreshConfigMap, 15*time.Second, 0.0, true, stop)
-	return
+	klog.Infof("lunettes config from %s is %+v", source, *c)
+	configMapLoaded.Store(true)
+	setLunettesConfig(c)
+	configReloadTime.WithLabelValues(source).SetToCurrentTime()
+	if err := featuregates.SetRuntime(c.FeatureGates); err != nil {
+		klog.Errorf("invalid feature gates of lunettes config: %v", err)
+	}
+	return nil
 }
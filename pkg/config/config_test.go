@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLunettesConfig(t *testing.T) {
+	c, err := ParseLunettesConfig([]byte(`{
+		"UserOnlineConfigMap": {"test-ns": "1m30s"},
+		"IgnoredNamespaceForAudit": ["cluster-loader"],
+		"PostStartHookTimeout": "5m",
+		"ShouldIgnoreSinglePod": "true"
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "1m30s", c.UserOnlineConfigMap["test-ns"])
+	assert.True(t, c.ShouldIgnoreSinglePod)
+
+	_, err = ParseLunettesConfig([]byte(" "))
+	assert.NotNil(t, err)
+	_, err = ParseLunettesConfig([]byte(`{"UserOnlineConfigMap": {"test-ns": "1m30s"},}`))
+	assert.NotNil(t, err)
+	_, err = ParseLunettesConfig([]byte(`{"IgnoredNamespaceForAduit": ["cluster-loader"]}`))
+	assert.NotNil(t, err)
+
+	_, err = ParseLunettesConfig([]byte(`{"UserSLAConfigMap": {"app": "10 min"}, "PostStartHookTimeout": "-1s"}`))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `UserSLAConfigMap[app]: invalid duration "10 min"`)
+	assert.Contains(t, err.Error(), `PostStartHookTimeout: duration "-1s" must be positive`)
+}
+
+func TestParseSLOObjectives(t *testing.T) {
+	c, err := ParseLunettesConfig([]byte(`{"SLOObjectives": [
+		{"Name": "create", "Target": 99.5, "GroupBy": ["namespace", "ownerref"]},
+		{"Name": "delete", "Type": "pod_delete", "Target": 99.9, "Window": "7d"}
+	]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, SLOObjectivePodCreate, c.SLOObjectives[0].ObjectiveType())
+	assert.Equal(t, DefaultSLOWindow, c.SLOObjectives[0].WindowDuration())
+	assert.Equal(t, 7*24*time.Hour, c.SLOObjectives[1].WindowDuration())
+
+	_, err = ParseLunettesConfig([]byte(`{"SLOObjectives": [
+		{"Name": "create", "Target": 100, "Window": "10m"},
+		{"Name": "create", "Type": "pod_upgrade", "Target": 99, "Window": "1w", "GroupBy": ["node"]}
+	]}`))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `SLOObjectives[create]: target 100 must be between 0 and 100`)
+	assert.Contains(t, err.Error(), `SLOObjectives[create]: window "10m" must be at least 1h`)
+	assert.Contains(t, err.Error(), `SLOObjectives[create]: duplicated name`)
+	assert.Contains(t, err.Error(), `SLOObjectives[create]: unknown type "pod_upgrade"`)
+	assert.Contains(t, err.Error(), `SLOObjectives[create]: invalid window "1w"`)
+	assert.Contains(t, err.Error(), `SLOObjectives[create]: unknown group by "node"`)
+}
+
+func TestApplyLunettesConfig(t *testing.T) {
+	saved := GlobalLunettesConfig()
+	t.Cleanup(func() {
+		handlers = nil
+		configValue.Store(saved)
+	})
+
+	changes := make([]string, 0)
+	OnLunettesConfigChange("test", func(old, new *LunettesConfig) {
+		if old == nil {
+			changes = append(changes, "registered")
+			return
+		}
+		changes = append(changes, old.PostStartHookTimeout+"->"+new.PostStartHookTimeout)
+	})
+	OnLunettesConfigChange("panics", func(old, new *LunettesConfig) {
+		panic("not stopping the others")
+	})
+
+	rejected := testutil.ToFloat64(rejectedConfigCount.WithLabelValues("test"))
+	assert.Nil(t, applyLunettesConfig("test", []byte(`{"PostStartHookTimeout": "5m"}`)))
+	assert.NotNil(t, applyLunettesConfig("test", []byte(`{"PostStartHookTimeout": "5"}`)))
+	assert.Nil(t, applyLunettesConfig("test", []byte(`{"PostStartHookTimeout": "3m"}`)))
+
+	assert.Equal(t, rejected+1, testutil.ToFloat64(rejectedConfigCount.WithLabelValues("test")))
+	assert.Equal(t, "3m", GlobalLunettesConfig().PostStartHookTimeout)
+	assert.Equal(t, []string{"registered", saved.PostStartHookTimeout + "->5m", "5m->3m"}, changes)
+}
+
+func TestUseLunettesConfigFile(t *testing.T) {
+	saved := GlobalLunettesConfig()
+	t.Cleanup(func() {
+		configValue.Store(saved)
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+
+	path := filepath.Join(t.TempDir(), "lunettes-config.json")
+	assert.NotNil(t, UseLunettesConfigFile(path, stop))
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"PostStartHookTimeout": "5m"}`), 0644))
+	assert.Nil(t, UseLunettesConfigFile(path, stop))
+	assert.Equal(t, "5m", GlobalLunettesConfig().PostStartHookTimeout)
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"PostStartHookTimeout": "3m"}`), 0644))
+	assert.Eventually(t, func() bool {
+		return GlobalLunettesConfig().PostStartHookTimeout == "3m"
+	}, 3*fileCheckInterval, 100*time.Millisecond)
+}
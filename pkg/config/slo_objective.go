@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// the deliveries an SLO objective is computed on
+const (
+	// pods delivered within their PodSLO
+	SLOObjectivePodCreate = "pod_create"
+	// pods deleted before the delete timeout
+	SLOObjectivePodDelete = "pod_delete"
+)
+
+// the labels the deliveries of an SLO objective are grouped by
+const (
+	SLOGroupByCluster   = "cluster"
+	SLOGroupByNamespace = "namespace"
+	SLOGroupByOwnerRef  = "ownerref"
+)
+
+// DefaultSLOWindow is the window of an SLO objective without Window
+const DefaultSLOWindow = 28 * 24 * time.Hour
+
+// SLOObjective 定义一个交付 SLO 目标，例如 28 天内 99.5% 的 Pod 在 PodSLO 内交付，
+// 其错误预算和消耗速率由 aggregator 计算
+type SLOObjective struct {
+	Name string `json:"Name"`
+	// pod_create or pod_delete, pod_create if empty
+	Type string `json:"Type,omitempty"`
+	// percentage of the good deliveries, e.g. 99.5
+	Target float64 `json:"Target"`
+	// e.g. 28d or 12h, 28d if empty
+	Window string `json:"Window,omitempty"`
+	// only the deliveries of the namespaces are counted, all if empty
+	Namespaces []string `json:"Namespaces,omitempty"`
+	// cluster, namespace or ownerref, one error budget for all the deliveries if empty
+	GroupBy []string `json:"GroupBy,omitempty"`
+	// only the deliveries of the pods matched by the DeliverySLOPolicy are counted, set by the policy
+	Policy string `json:"-"`
+}
+
+// ObjectiveType returns the type of the deliveries counted
+func (o *SLOObjective) ObjectiveType() string {
+	if o.Type == "" {
+		return SLOObjectivePodCreate
+	}
+	return o.Type
+}
+
+// WindowDuration returns the window of the objective, the config is validated
+func (o *SLOObjective) WindowDuration() time.Duration {
+	if o.Window == "" {
+		return DefaultSLOWindow
+	}
+	d, _ := ParseSLOWindow(o.Window)
+	return d
+}
+
+// ParseSLOWindow parses a duration which can also be in days, e.g. 28d
+func ParseSLOWindow(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+// Validate checks the objective except its name
+func (o *SLOObjective) Validate() error {
+	return utilerrors.NewAggregate(o.validate())
+}
+
+func (o *SLOObjective) validate() []error {
+	errs := make([]error, 0)
+	field := fmt.Sprintf("SLOObjectives[%s]", o.Name)
+	switch o.ObjectiveType() {
+	case SLOObjectivePodCreate, SLOObjectivePodDelete:
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown type %q", field, o.Type))
+	}
+	if o.Target <= 0 || o.Target >= 100 {
+		errs = append(errs, fmt.Errorf("%s: target %v must be between 0 and 100", field, o.Target))
+	}
+	if o.Window != "" {
+		if d, err := ParseSLOWindow(o.Window); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+		} else if d < time.Hour {
+			errs = append(errs, fmt.Errorf("%s: window %q must be at least 1h", field, o.Window))
+		}
+	}
+	for _, label := range o.GroupBy {
+		switch label {
+		case SLOGroupByCluster, SLOGroupByNamespace, SLOGroupByOwnerRef:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown group by %q", field, label))
+		}
+	}
+	return errs
+}
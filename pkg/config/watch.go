@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the ConfigMap is listed again to repair a missed event
+	configMapResync = 10 * time.Minute
+	// how often the config file is checked
+	fileCheckInterval = 5 * time.Second
+)
+
+var (
+	sourceLock sync.Mutex
+	// closed to stop watching the ConfigMap when a config file is used
+	configMapStop chan struct{}
+)
+
+// watchLunettesConfigMap 监听 lunettes configmap，配置变化时立即生效
+func watchLunettesConfigMap() {
+	if EnableStressMode {
+		return
+	}
+
+	var cfg *restclient.Config
+	cfg, err := restclient.InClusterConfig()
+	if err != nil {
+		klog.Errorf("failed to build config, err is %v", err)
+		return
+	}
+
+	cfg.UserAgent = "lunettes"
+	cs, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		klog.Errorf("failed to create clientset: %v", err)
+		return
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cs.CoreV1().Events(lunettesNs)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "lunettes"})
+
+	factory := informers.NewSharedInformerFactoryWithOptions(cs, configMapResync,
+		informers.WithNamespace(lunettesNs),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", lunettesConfigMapName).String()
+		}))
+
+	// resync delivers the same version again
+	var lastVersion string
+	onConfigMap := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.ResourceVersion == lastVersion {
+			return
+		}
+		lastVersion = cm.ResourceVersion
+		if err := applyLunettesConfig(SourceConfigMap, []byte(cm.Data[lunettesConfigMapName])); err != nil {
+			recorder.Eventf(cm, corev1.EventTypeWarning, "InvalidLunettesConfig",
+				"lunettes config is rejected, the last valid one is kept: %v", err)
+		}
+	}
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onConfigMap,
+		UpdateFunc: func(_, obj interface{}) {
+			onConfigMap(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.Errorf("lunettes configmap is deleted, the last config is kept")
+		},
+	})
+
+	sourceLock.Lock()
+	configMapStop = make(chan struct{})
+	stop := configMapStop
+	sourceLock.Unlock()
+
+	factory.Start(stop)
+	go func() {
+		factory.WaitForCacheSync(stop)
+		<-stop
+		broadcaster.Shutdown()
+	}()
+}
+
+// UseLunettesConfigFile reads the LunettesConfig from a JSON file instead of the lunettes-config ConfigMap,
+// for the aggregator running out of cluster and tests. The file is checked again every few seconds.
+func UseLunettesConfigFile(path string, stop <-chan struct{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := applyLunettesConfig(SourceFile, data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	sourceLock.Lock()
+	if configMapStop != nil {
+		close(configMapStop)
+		configMapStop = nil
+	}
+	sourceLock.Unlock()
+
+	last := data
+	go wait.Until(func() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Errorf("failed to read lunettes config file %s: %v", path, err)
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		last = data
+		_ = applyLunettesConfig(SourceFile, data)
+	}, fileCheckInterval, stop)
+	return nil
+}
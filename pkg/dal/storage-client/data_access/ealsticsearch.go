@@ -1066,6 +1066,69 @@ func (s *StorageEsImpl) QueryDeleteSloWithResult(data interface{}, requestParams
 
 	return nil
 }
+func (s *StorageEsImpl) QueryJobSloWithResult(data interface{}, requestParams *model.SloOptions) error {
+	if requestParams == nil || requestParams.Result == "" {
+		return customerrors.Error(customerrors.ErrParams, customerrors.NoDeliveryResult)
+	}
+	_, esTableName, esType, err := utils.GetMetaName(data)
+	if err != nil {
+		return err
+	}
+
+	resultQuery := elastic.NewQueryStringQuery(fmt.Sprintf("JobResult: \"%s\"", requestParams.Result))
+	query := elastic.NewBoolQuery().Must(resultQuery)
+
+	if requestParams.Cluster != "" {
+		query = query.Must(elastic.NewTermQuery("Cluster.keyword", requestParams.Cluster))
+	}
+
+	if requestParams.Type != "" {
+		typeQuery := elastic.NewQueryStringQuery(fmt.Sprintf("Type: \"%s\"", requestParams.Type))
+		query = query.Must(typeQuery)
+	}
+
+	// add range query
+	if !requestParams.From.IsZero() || !requestParams.To.IsZero() {
+		rangeQuery := elastic.NewRangeQuery("CreatedTime").TimeZone("UTC")
+		if !requestParams.From.IsZero() {
+			rangeQuery = rangeQuery.From(requestParams.From)
+		}
+		if !requestParams.To.IsZero() {
+			rangeQuery = rangeQuery.To(requestParams.To)
+		}
+		query = query.Must(rangeQuery)
+	} else {
+		rangeQuery := elastic.NewRangeQuery("CreatedTime").TimeZone("UTC").Gte("now-24h")
+		query = query.Must(rangeQuery)
+	}
+
+	querySize := 300
+	if requestParams.Count != "" {
+		count, err := strconv.Atoi(requestParams.Count)
+		if err == nil {
+			querySize = count
+		}
+	}
+	if querySize > 500 {
+		querySize = 500
+	}
+
+	searchResult, err := s.DB.Search().Index(esTableName).Type(esType).Query(query).Size(querySize).
+		Sort("CreatedTime", false).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error%v", err)
+	}
+	var hits []*json.RawMessage
+	for _, hit := range searchResult.Hits.Hits {
+		hits = append(hits, &hit.Source)
+	}
+	hitsStr, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(hitsStr, data)
+}
 func (s *StorageEsImpl) QueryNodeYamlWithParams(data interface{}, debugparams *model.NodeParams) error {
 
 	if debugparams == nil {
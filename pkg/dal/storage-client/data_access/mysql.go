@@ -622,6 +622,45 @@ func (s *StorageSqlImpl) QueryDeleteSloWithResult(data interface{}, requestParam
 	}
 	return nil
 }
+func (s *StorageSqlImpl) QueryJobSloWithResult(data interface{}, requestParams *model.SloOptions) error {
+	if requestParams == nil || requestParams.Result == "" {
+		return customerrors.Error(customerrors.ErrParams, customerrors.NoDeliveryResult)
+	}
+	tx := s.DB.Where("job_result =?", requestParams.Result)
+	if requestParams.Cluster != "" {
+		tx = tx.Where("cluster =?", requestParams.Cluster)
+	}
+
+	if requestParams.Type != "" {
+		tx = tx.Where("type =?", requestParams.Type)
+	}
+
+	// add range query
+	if !requestParams.From.IsZero() {
+		tx = tx.Where("created_time >=?", requestParams.From)
+	}
+	if !requestParams.To.IsZero() {
+		tx = tx.Where("created_time <?", requestParams.To)
+	}
+
+	querySize := 300
+	if requestParams.Count != "" {
+		count, err := strconv.Atoi(requestParams.Count)
+		if err == nil {
+			querySize = count
+		}
+	}
+	if querySize > 5000 {
+		querySize = 5000
+	}
+
+	tx = tx.Limit(querySize).Order("created_time desc").Find(data)
+	if tx.Error != nil {
+		klog.Error(tx.Error)
+		return fmt.Errorf("error%v", tx.Error)
+	}
+	return nil
+}
 func (s *StorageSqlImpl) QueryNodeYamlWithParams(data interface{}, debugparams *model.NodeParams) error {
 	var resultOB *gorm.DB
 
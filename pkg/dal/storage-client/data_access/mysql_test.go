@@ -1294,6 +1294,39 @@ func TestSqlQueryDeleteSloWithResult(t *testing.T) {
 		}
 	}
 }
+func TestSqlQueryJobSloWithResult(t *testing.T) {
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		panic(err)
+	}
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	rows := sqlmock.NewRows([]string{"doc_id", "cluster", "namespace", "job_name", "job_result", "failed"}).
+		AddRow("dsdsa", "abc", "ns", "job-1", "BackoffLimitExceeded", 6)
+	mock.ExpectQuery("SELECT * FROM `slo_trace_data_daily` WHERE job_result =? AND cluster =? AND type =? ORDER BY created_time desc LIMIT 300").
+		WithArgs("BackoffLimitExceeded", "abc", "job").WillReturnRows(rows)
+
+	fClient := &StorageSqlImpl{
+		DB: gormDB,
+	}
+	res := make([]*model.SloTraceData, 0)
+	err = fClient.QueryJobSloWithResult(&res, &model.SloOptions{Cluster: "abc", Result: "BackoffLimitExceeded", Type: "job"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res))
+	assert.Equal(t, "job-1", res[0].JobName)
+	assert.Equal(t, int32(6), res[0].Failed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	err = fClient.QueryJobSloWithResult(&res, &model.SloOptions{Type: "job"})
+	assert.NotNil(t, err)
+}
 func TestSqlDebuggingNodeUidParams(t *testing.T) {
 
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
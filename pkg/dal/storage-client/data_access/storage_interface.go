@@ -45,6 +45,7 @@ type SloTraceDataInterface interface {
 	QueryUpgradeSloWithResult(data interface{}, opts *model.SloOptions) error
 	QuerySloTraceDataWithOwnerId(data interface{}, ownerid string, opts ...model.OptionFunc) error
 	QueryCreateSloWithResult(data interface{}, opts *model.SloOptions) error
+	QueryJobSloWithResult(data interface{}, opts *model.SloOptions) error
 }
 
 type PodLifePhaseInterface interface {
@@ -500,6 +500,30 @@ func (s *FakeStorage) QueryUpgradeSloWithResult(data interface{}, requestParams
 
 	return nil
 }
+func (s *FakeStorage) QueryJobSloWithResult(data interface{}, requestParams *model.SloOptions) error {
+	if requestParams.BizName == "12345" || model.IsJobSlo(requestParams.Type) {
+		res := make([]*model.Slodata, 0)
+		res = append(res, &model.Slodata{
+			JobName:   "12345",
+			JobResult: requestParams.Result,
+			Cluster:   "cluster",
+		})
+		resStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(resStr, data)
+		if err != nil {
+			return err
+		}
+
+	} else if requestParams.BizName == "fake" {
+		return errors.New("fake uid")
+	}
+
+	return nil
+}
 func (s *FakeStorage) QueryDeleteSloWithResult(data interface{}, requestParams *model.SloOptions) error {
 	if requestParams.BizName == "12345" {
 		res := make([]*model.Slodata, 0)
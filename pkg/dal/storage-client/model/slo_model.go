@@ -76,6 +76,12 @@ type SloTraceData struct {
 	PVCUID                        string             `gorm:"column:pvc_uid"`
 	CreateResult                  string             `gorm:"column:create_result"`
 	TimeoutTime                   time.Time          `gorm:"column:timeout_time"`
+	JobName                       string             `gorm:"column:job_name"`
+	CronJobName                   string             `gorm:"column:cron_job_name"`
+	JobResult                     string             `gorm:"column:job_result"`
+	JobFailedReason               string             `gorm:"column:job_failed_reason"`
+	Failed                        int32              `gorm:"column:failed"`
+	Duration                      time.Duration      `gorm:"column:duration"`
 	PullTimeoutImageName          string             `gorm:"-"`
 	SLOResult                     []string           `json:"sloResult,omitempty" gorm:"-"`
 	SLOType                       string             `json:"sloType,omitempty" gorm:"-"`
@@ -140,6 +146,12 @@ type Slodata struct {
 	UpgradeTimeoutTime            time.Time
 	UpgradeResult                 string
 	DeleteResult                  string
+	JobName                       string        `gorm:"column:job_name" json:"jobName,omitempty"`
+	CronJobName                   string        `gorm:"column:cron_job_name" json:"cronJobName,omitempty"`
+	JobResult                     string        `gorm:"column:job_result" json:"jobResult,omitempty"`
+	JobFailedReason               string        `gorm:"column:job_failed_reason" json:"jobFailedReason,omitempty"`
+	Failed                        int32         `gorm:"column:failed" json:"failed,omitempty"`
+	Duration                      time.Duration `gorm:"column:duration" json:"duration,omitempty"`
 }
 
 func (s *SloTraceData) TableName() string {
@@ -1,13 +1,24 @@
 package model
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// SloTypeJob is the Type of the job completions in SloTraceData
+const SloTypeJob = "job"
+
+// IsJobSlo tells if the Type of SloOptions asks for the job completions, in any case
+func IsJobSlo(sloType string) bool {
+	return strings.EqualFold(sloType, SloTypeJob)
+}
 
 type SloOptions struct {
 	Result         string
 	Cluster        string
 	BizName        string
 	Count          string
-	Type           string    // create 或者 delete, 默认是 create
+	Type           string    // create, delete, upgrade 或者 job, 默认是 create
 	DeliveryStatus string    // FAIL/KILL/ALL/SUCCESS
 	SloTime        string    // 20s/30m0s/10m0s
 	Env            string    // prod, test
@@ -0,0 +1,190 @@
+package deadletter
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the audit event read could not be decoded
+	StageDecode = "decode"
+	// the audit event received failed to be dispatched to the queues
+	StageDispatch = "dispatch"
+)
+
+var (
+	deadLetterCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lunettes_dead_letter_events_count",
+			Help: "audit events failed to be processed and kept in the dead-letter store",
+		},
+		// where the event failed
+		[]string{"stage"},
+	)
+
+	storeMutex sync.RWMutex
+	store      Store = NewMemoryStore(10000)
+
+	// cluster -> the pipeline to re-inject events
+	replayersMutex sync.RWMutex
+	replayers      = make(map[string]ReplayFunc)
+
+	// one replay at a time, so a dead letter is never re-injected twice
+	replayMutex sync.Mutex
+)
+
+// ReplayFunc re-injects the event failed in stage into the stage only, the stages before the queues are
+// replayed to all of them
+type ReplayFunc func(event *k8s_audit.Event, stage string)
+
+func init() {
+	prometheus.MustRegister(deadLetterCount)
+}
+
+// SetStore sets where dead letters are kept, in memory by default
+func SetStore(s Store) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	store = s
+}
+
+func getStore() Store {
+	storeMutex.RLock()
+	defer storeMutex.RUnlock()
+	return store
+}
+
+// Capture keeps the audit event failed in stage
+func Capture(stage string, event *k8s_audit.Event, err error) {
+	if event == nil {
+		return
+	}
+	data, mErr := json.Marshal(event)
+	if mErr != nil {
+		klog.Errorf("failed to marshal dead letter %s of %s: %s", stage, event.AuditID, mErr.Error())
+		return
+	}
+	save(&xsearch.DeadLetter{
+		ID:      stage + "/" + string(event.AuditID),
+		Stage:   stage,
+		Cluster: event.Annotations["cluster"],
+		AuditID: string(event.AuditID),
+		Error:   err.Error(),
+		Time:    time.Now(),
+		Event:   data,
+	})
+}
+
+// CaptureRaw keeps the raw audit event of cluster which could not be decoded
+func CaptureRaw(stage, cluster string, raw []byte, err error) {
+	if !json.Valid(raw) {
+		// the event is stored as json
+		data, _ := json.Marshal(string(raw))
+		raw = data
+	}
+	save(&xsearch.DeadLetter{
+		ID:      fmt.Sprintf("%s/%x", stage, md5.Sum(raw)),
+		Stage:   stage,
+		Cluster: cluster,
+		Error:   err.Error(),
+		Time:    time.Now(),
+		Event:   raw,
+	})
+}
+
+func save(letter *xsearch.DeadLetter) {
+	deadLetterCount.WithLabelValues(letter.Stage).Inc()
+	if err := getStore().Save(letter); err != nil {
+		klog.Errorf("failed to save dead letter %s: %s", letter.ID, err.Error())
+	}
+}
+
+// RegisterReplayer sets the pipeline where the dead letters of cluster are re-injected
+func RegisterReplayer(cluster string, replay ReplayFunc) {
+	replayersMutex.Lock()
+	defer replayersMutex.Unlock()
+	replayers[cluster] = replay
+}
+
+// UnregisterReplayer stops re-injecting the dead letters of cluster
+func UnregisterReplayer(cluster string) {
+	replayersMutex.Lock()
+	defer replayersMutex.Unlock()
+	delete(replayers, cluster)
+}
+
+func getReplayer(cluster string) ReplayFunc {
+	replayersMutex.RLock()
+	defer replayersMutex.RUnlock()
+	return replayers[cluster]
+}
+
+// List returns the dead letters matching filter
+func List(filter *Filter) ([]*xsearch.DeadLetter, error) {
+	return getStore().List(filter)
+}
+
+// Replay re-injects the dead letters matching filter into the stages they failed in and removes them,
+// the ones of clusters not processed here or still not decodable are kept. An event failed more than once
+// in a stage is re-injected once. It returns the number re-injected.
+func Replay(filter *Filter) (int, error) {
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+
+	letters, err := getStore().List(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	seen := make(map[string]bool, len(letters))
+	for _, letter := range letters {
+		// the undecodable ones have no audit id, their ids are the hash of the event
+		key := letter.ID
+		if letter.AuditID != "" {
+			key = letter.Stage + "/" + letter.AuditID
+		}
+		if seen[key] {
+			if err := getStore().Delete([]string{letter.ID}); err != nil {
+				return replayed, err
+			}
+			continue
+		}
+
+		replay := getReplayer(letter.Cluster)
+		if replay == nil {
+			klog.Warningf("no pipeline of cluster %s to replay dead letter %s", letter.Cluster, letter.ID)
+			continue
+		}
+		event := &k8s_audit.Event{}
+		if err := json.Unmarshal(letter.Event, event); err != nil {
+			klog.Warningf("dead letter %s is still not decodable: %s", letter.ID, err.Error())
+			continue
+		}
+		if event.Annotations == nil {
+			event.Annotations = map[string]string{}
+		}
+		if event.Annotations["cluster"] == "" {
+			event.Annotations["cluster"] = letter.Cluster
+		}
+
+		// removed before replay, it is captured again if it fails again
+		if err := getStore().Delete([]string{letter.ID}); err != nil {
+			return replayed, err
+		}
+		seen[key] = true
+		replay(event, letter.Stage)
+		replayed++
+	}
+	klog.Infof("replayed %d of %d dead letters", replayed, len(letters))
+	return replayed, nil
+}
@@ -0,0 +1,119 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/stretchr/testify/assert"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	SetStore(NewMemoryStore(10))
+
+	event := &k8s_audit.Event{
+		AuditID:     "audit-1",
+		Annotations: map[string]string{"cluster": "c1"},
+		ObjectRef:   &k8s_audit.ObjectReference{Resource: "pods", Name: "p1"},
+	}
+	Capture("podphase", event, fmt.Errorf("boom"))
+	// the same failure is kept once
+	Capture("podphase", event, fmt.Errorf("boom"))
+	Capture("slo_create", event, fmt.Errorf("boom"))
+	CaptureRaw(StageDecode, "c2", []byte(`{"auditID": 1}`), fmt.Errorf("cannot unmarshal"))
+
+	letters, err := List(&Filter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(letters))
+	letters, _ = List(&Filter{Stage: "podphase"})
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "podphase/audit-1", letters[0].ID)
+	assert.Equal(t, "c1", letters[0].Cluster)
+	assert.Equal(t, "boom", letters[0].Error)
+
+	replayed := make([]*k8s_audit.Event, 0)
+	stages := make([]string, 0)
+	RegisterReplayer("c1", func(event *k8s_audit.Event, stage string) {
+		replayed = append(replayed, event)
+		stages = append(stages, stage)
+	})
+	defer UnregisterReplayer("c1")
+
+	// c2 has no pipeline here, the event failed in two stages is replayed to each of them once
+	n, err := Replay(&Filter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 2, len(replayed))
+	assert.Equal(t, "p1", replayed[0].ObjectRef.Name)
+	assert.ElementsMatch(t, []string{"podphase", "slo_create"}, stages)
+
+	letters, _ = List(&Filter{})
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, StageDecode, letters[0].Stage)
+}
+
+func TestMemoryStoreCapacity(t *testing.T) {
+	store := NewMemoryStore(2)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		_ = store.Save(&xsearch.DeadLetter{ID: fmt.Sprintf("s/%d", i), Time: now.Add(time.Duration(i) * time.Second)})
+	}
+	// the oldest is dropped, the latest comes first
+	letters, _ := store.List(&Filter{})
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, "s/2", letters[0].ID)
+	assert.Equal(t, "s/1", letters[1].ID)
+}
+
+func TestHandlers(t *testing.T) {
+	SetStore(NewMemoryStore(10))
+	Capture("podyaml", &k8s_audit.Event{AuditID: "a1", Annotations: map[string]string{"cluster": "c1"},
+		ObjectRef: &k8s_audit.ObjectReference{Resource: "pods"}}, fmt.Errorf("boom"))
+
+	w := httptest.NewRecorder()
+	ListHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deadletters?stage=podyaml", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	letters := make([]*xsearch.DeadLetter, 0)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &letters))
+	assert.Equal(t, 1, len(letters))
+
+	RegisterReplayer("c1", func(event *k8s_audit.Event, stage string) {})
+	defer UnregisterReplayer("c1")
+	w = httptest.NewRecorder()
+	ReplayHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/deadletters/replay?id=podyaml/a1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"replayed": 1}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ReplayHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deadletters/replay", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestReplayDedupe(t *testing.T) {
+	SetStore(NewMemoryStore(10))
+	data, _ := json.Marshal(&k8s_audit.Event{AuditID: "a2", ObjectRef: &k8s_audit.ObjectReference{Resource: "pods"}})
+	now := time.Now()
+	// kept twice, e.g. by an older version with other ids
+	for _, id := range []string{"podphase/a2", "podphase/a2-retry"} {
+		assert.Nil(t, getStore().Save(&xsearch.DeadLetter{ID: id, Stage: "podphase", Cluster: "c1", AuditID: "a2", Time: now, Event: data}))
+	}
+
+	replayed := 0
+	RegisterReplayer("c1", func(event *k8s_audit.Event, stage string) {
+		replayed++
+	})
+	defer UnregisterReplayer("c1")
+
+	n, err := Replay(&Filter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, replayed)
+	letters, _ := List(&Filter{})
+	assert.Empty(t, letters)
+}
@@ -0,0 +1,67 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// filterFromRequest reads the filter from ?stage=&cluster=&id=a,b&limit=
+func filterFromRequest(r *http.Request) *Filter {
+	query := r.URL.Query()
+	filter := &Filter{
+		Stage:   query.Get("stage"),
+		Cluster: query.Get("cluster"),
+	}
+	for _, id := range query["id"] {
+		for _, s := range strings.Split(id, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				filter.IDs = append(filter.IDs, s)
+			}
+		}
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	return filter
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.Errorf("failed to write response: %s", err.Error())
+	}
+}
+
+// ListHandler serves GET /deadletters
+func ListHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		letters, err := List(filterFromRequest(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, letters)
+	})
+}
+
+// ReplayHandler serves POST /deadletters/replay, which re-injects the matched dead letters
+func ReplayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		replayed, err := Replay(filterFromRequest(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]int{"replayed": replayed})
+	})
+}
@@ -0,0 +1,166 @@
+package deadletter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const defaultListLimit = 100
+
+// Filter selects dead letters, empty fields match all
+type Filter struct {
+	Stage   string
+	Cluster string
+	IDs     []string
+	// 100 if not positive
+	Limit int
+}
+
+func (f *Filter) limit() int {
+	if f.Limit <= 0 {
+		return defaultListLimit
+	}
+	return f.Limit
+}
+
+func (f *Filter) match(letter *xsearch.DeadLetter) bool {
+	if f.Stage != "" && f.Stage != letter.Stage {
+		return false
+	}
+	if f.Cluster != "" && f.Cluster != letter.Cluster {
+		return false
+	}
+	if len(f.IDs) == 0 {
+		return true
+	}
+	for _, id := range f.IDs {
+		if id == letter.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps the dead letters
+type Store interface {
+	Save(letter *xsearch.DeadLetter) error
+	// List returns the latest letters first
+	List(filter *Filter) ([]*xsearch.DeadLetter, error)
+	Delete(ids []string) error
+}
+
+// MemoryStore keeps the latest dead letters in memory
+type MemoryStore struct {
+	capacity int
+	mutex    sync.Mutex
+	letters  map[string]*xsearch.DeadLetter
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		letters:  make(map[string]*xsearch.DeadLetter),
+	}
+}
+
+func (s *MemoryStore) Save(letter *xsearch.DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.letters[letter.ID] = letter
+	if len(s.letters) > s.capacity {
+		// drop the oldest one
+		var oldest *xsearch.DeadLetter
+		for _, l := range s.letters {
+			if oldest == nil || l.Time.Before(oldest.Time) {
+				oldest = l
+			}
+		}
+		delete(s.letters, oldest.ID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) List(filter *Filter) ([]*xsearch.DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make([]*xsearch.DeadLetter, 0)
+	for _, letter := range s.letters {
+		if filter.match(letter) {
+			result = append(result, letter)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.After(result[j].Time)
+	})
+	if len(result) > filter.limit() {
+		result = result[:filter.limit()]
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) Delete(ids []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		delete(s.letters, id)
+	}
+	return nil
+}
+
+// ESStore keeps the dead letters in elasticsearch, so they survive the restart bringing the fix.
+// Letters are written in bulk periodically.
+type ESStore struct {
+	mutex   sync.Mutex
+	pending map[string]*xsearch.DeadLetter
+}
+
+// NewESStore returns the store flushing letters until stopCh is closed, the rest are flushed on exit
+func NewESStore(stopCh <-chan struct{}) *ESStore {
+	s := &ESStore{pending: make(map[string]*xsearch.DeadLetter)}
+	go wait.Until(s.flush, 5*time.Second, stopCh)
+	xsearch.XSearchClear.AddCleanWork(s.flush)
+	return s
+}
+
+func (s *ESStore) flush() {
+	s.mutex.Lock()
+	letters := make([]*xsearch.DeadLetter, 0, len(s.pending))
+	for _, letter := range s.pending {
+		letters = append(letters, letter)
+	}
+	s.pending = make(map[string]*xsearch.DeadLetter)
+	s.mutex.Unlock()
+
+	if err := xsearch.SaveDeadLetters(letters); err != nil {
+		klog.Errorf("failed to save %d dead letters: %s", len(letters), err.Error())
+	}
+}
+
+func (s *ESStore) Save(letter *xsearch.DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending[letter.ID] = letter
+	return nil
+}
+
+func (s *ESStore) List(filter *Filter) ([]*xsearch.DeadLetter, error) {
+	s.flush()
+	return xsearch.GetDeadLetters(filter.Stage, filter.Cluster, filter.IDs, filter.limit())
+}
+
+func (s *ESStore) Delete(ids []string) error {
+	s.mutex.Lock()
+	for _, id := range ids {
+		delete(s.pending, id)
+	}
+	s.mutex.Unlock()
+	return xsearch.DeleteDeadLetters(ids)
+}
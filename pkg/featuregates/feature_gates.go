@@ -1,30 +1,209 @@
 package featuregates
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
 
+// Stage is the maturity of a feature
+type Stage string
+
+const (
+	Alpha      Stage = "ALPHA"
+	Beta       Stage = "BETA"
+	GA         Stage = "GA"
+	Deprecated Stage = "DEPRECATED"
+)
+
+// where the value of a gate comes from
+const (
+	sourceDefault   = "default"
+	sourceFlag      = "flag"
+	sourceConfigMap = "configmap"
+)
+
+// FeatureSpec describes a known feature gate
+type FeatureSpec struct {
+	Default    bool
+	PreRelease Stage
+	// the gate could be flipped by the lunettes-config ConfigMap without restart,
+	// false for the gates read once at startup
+	Dynamic bool
+}
+
 var (
-	fg = FeatureGates{}
+	fg = FeatureGates{
+		known:   make(map[string]FeatureSpec),
+		flags:   make(map[string]bool),
+		runtime: make(map[string]bool),
+	}
 )
 
 type FeatureGates struct {
-	gates    string
-	features map[string]bool
+	lock  sync.RWMutex
+	gates string
+	known map[string]FeatureSpec
+	// set by --feature-gates
+	flags map[string]bool
+	// set by the lunettes-config ConfigMap, only for dynamic gates
+	runtime map[string]bool
+	// the ConfigMap could be read before all gates are registered, it is applied again by Parse
+	runtimeGates string
+	parsed       bool
 }
 
-func Parse(gates string) {
+// Register adds a known feature gate, it is called in init of the package owning the feature
+func Register(name string, spec FeatureSpec) {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+	if _, ok := fg.known[name]; ok {
+		panic(fmt.Sprintf("feature gate %s is registered twice", name))
+	}
+	fg.known[name] = spec
+}
+
+// parseGates parses "a=true,b=false" of known gates, "a" alone means "a=true"
+func parseGates(gates string, known map[string]FeatureSpec) (map[string]bool, error) {
+	features := make(map[string]bool)
+	errs := make([]error, 0)
+	for _, s := range strings.Split(gates, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		name, value := s, "true"
+		if i := strings.Index(s, "="); i >= 0 {
+			name, value = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		}
+		if _, ok := known[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown feature gate %s", name))
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q of feature gate %s", value, name))
+			continue
+		}
+		features[name] = enabled
+	}
+	return features, utilerrors.NewAggregate(errs)
+}
+
+// Parse sets the gates of --feature-gates, like "SpanAnalysisFeature=true,TraceFeature=false"
+func Parse(gates string) error {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+	features, err := parseGates(gates, fg.known)
+	if err != nil {
+		return err
+	}
 	fg.gates = gates
-	fg.features = make(map[string]bool)
-	ss := strings.Split(gates, ",")
-	for _, s := range ss {
-		fg.features[s] = true
+	fg.flags = features
+	fg.parsed = true
+	klog.Infof("feature gates: %s", gates)
+	if err := fg.setRuntime(fg.runtimeGates); err != nil {
+		klog.Errorf("invalid feature gates of configmap: %s", err.Error())
+	}
+	return nil
+}
+
+// SetRuntime sets the gates of the lunettes-config ConfigMap, replacing the ones set before.
+// The gates not dynamic are rejected, the rest are still applied.
+func SetRuntime(gates string) error {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+	fg.runtimeGates = gates
+	if !fg.parsed {
+		return nil
+	}
+	return fg.setRuntime(gates)
+}
+
+func (g *FeatureGates) setRuntime(gates string) error {
+	features, err := parseGates(gates, g.known)
+	errs := []error{err}
+	for name, enabled := range features {
+		if !g.known[name].Dynamic {
+			errs = append(errs, fmt.Errorf("feature gate %s can not be changed without restart", name))
+			delete(features, name)
+			continue
+		}
+		if old, ok := g.runtime[name]; !ok || old != enabled {
+			klog.Infof("feature gate %s is set to %t by configmap", name, enabled)
+		}
 	}
-	klog.Infof("enabled feature: %s", gates)
+	g.runtime = features
+	return utilerrors.NewAggregate(errs)
 }
 
 func IsEnabled(f string) bool {
-	return fg.features[f]
+	fg.lock.RLock()
+	defer fg.lock.RUnlock()
+	enabled, _ := fg.lookup(f)
+	return enabled
+}
+
+func (g *FeatureGates) lookup(f string) (bool, string) {
+	if enabled, ok := g.runtime[f]; ok {
+		return enabled, sourceConfigMap
+	}
+	if enabled, ok := g.flags[f]; ok {
+		return enabled, sourceFlag
+	}
+	return g.known[f].Default, sourceDefault
+}
+
+// FeatureStatus is a gate served by /featuregates
+type FeatureStatus struct {
+	Name       string `json:"name"`
+	Enabled    bool   `json:"enabled"`
+	Default    bool   `json:"default"`
+	PreRelease Stage  `json:"preRelease"`
+	Dynamic    bool   `json:"dynamic"`
+	// default, flag or configmap
+	Source string `json:"source"`
+}
+
+// Status returns the known gates sorted by name
+func Status() []*FeatureStatus {
+	fg.lock.RLock()
+	defer fg.lock.RUnlock()
+	result := make([]*FeatureStatus, 0, len(fg.known))
+	for name, spec := range fg.known {
+		enabled, source := fg.lookup(name)
+		result = append(result, &FeatureStatus{
+			Name:       name,
+			Enabled:    enabled,
+			Default:    spec.Default,
+			PreRelease: spec.PreRelease,
+			Dynamic:    spec.Dynamic,
+			Source:     source,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Handler serves GET /featuregates
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(Status()); err != nil {
+			klog.Errorf("failed to write feature gates: %s", err.Error())
+		}
+	})
 }
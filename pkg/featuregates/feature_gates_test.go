@@ -0,0 +1,89 @@
+package featuregates
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetFeatureGates(t *testing.T) {
+	saved := fg.known
+	fg.known = make(map[string]FeatureSpec)
+	fg.flags = make(map[string]bool)
+	fg.runtime = make(map[string]bool)
+	fg.runtimeGates = ""
+	fg.parsed = false
+	t.Cleanup(func() {
+		fg.known = saved
+		fg.flags = make(map[string]bool)
+		fg.runtime = make(map[string]bool)
+	})
+
+	Register("Static", FeatureSpec{Default: false, PreRelease: Beta})
+	Register("OnByDefault", FeatureSpec{Default: true, PreRelease: GA})
+	Register("Dynamic", FeatureSpec{Default: false, PreRelease: Alpha, Dynamic: true})
+}
+
+func TestParse(t *testing.T) {
+	resetFeatureGates(t)
+
+	assert.Nil(t, Parse(""))
+	assert.False(t, IsEnabled("Static"))
+	assert.True(t, IsEnabled("OnByDefault"))
+	assert.False(t, IsEnabled("Unknown"))
+
+	assert.Nil(t, Parse("Static, OnByDefault=false,Dynamic=true"))
+	assert.True(t, IsEnabled("Static"))
+	assert.False(t, IsEnabled("OnByDefault"))
+	assert.True(t, IsEnabled("Dynamic"))
+
+	err := Parse("Statc,Dynamic=yes")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown feature gate Statc")
+	assert.Contains(t, err.Error(), `invalid value "yes" of feature gate Dynamic`)
+	// not changed by the invalid gates
+	assert.True(t, IsEnabled("Static"))
+}
+
+func TestSetRuntime(t *testing.T) {
+	resetFeatureGates(t)
+
+	// read before the flags, applied by Parse
+	assert.Nil(t, SetRuntime("Dynamic=true"))
+	assert.False(t, IsEnabled("Dynamic"))
+	assert.Nil(t, Parse("Static=true"))
+	assert.True(t, IsEnabled("Dynamic"))
+
+	err := SetRuntime("Static=false,Dynamic=false")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "feature gate Static can not be changed without restart")
+	assert.True(t, IsEnabled("Static"))
+	assert.False(t, IsEnabled("Dynamic"))
+
+	// back to the flag when removed from the configmap
+	assert.Nil(t, Parse("Dynamic=true"))
+	assert.Nil(t, SetRuntime("Dynamic=false"))
+	assert.False(t, IsEnabled("Dynamic"))
+	assert.Nil(t, SetRuntime(""))
+	assert.True(t, IsEnabled("Dynamic"))
+}
+
+func TestHandler(t *testing.T) {
+	resetFeatureGates(t)
+	assert.Nil(t, Parse("Static"))
+	assert.Nil(t, SetRuntime("Dynamic=true"))
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/featuregates", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := make([]*FeatureStatus, 0)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, []*FeatureStatus{
+		{Name: "Dynamic", Enabled: true, PreRelease: Alpha, Dynamic: true, Source: sourceConfigMap},
+		{Name: "OnByDefault", Enabled: true, Default: true, PreRelease: GA, Source: sourceDefault},
+		{Name: "Static", Enabled: true, PreRelease: Beta, Source: sourceFlag},
+	}, status)
+}
@@ -41,6 +41,15 @@ func IsTypicalPodNew(pod *v1.Pod) string {
 	return "TYPICAL"
 }
 
+// GetPodSLO returns the PodCreate SLO set by a DeliverySLOPolicy in sloSpec, adjusted is true then,
+// or the SLO of the delivery path of the pod
+func GetPodSLO(pod *v1.Pod, sloSpec SloSpec) (time.Duration, bool) {
+	if sloTime, ok := sloSpec.SloTime(SloSpecPodCreate); ok && sloSpec[SloSpecPodCreate].Policy != "" {
+		return sloTime, true
+	}
+	return GetPodSLOByDeliveryPath(pod)
+}
+
 func GetPodSLOByDeliveryPath(pod *v1.Pod) (time.Duration, bool) {
 	result := IsTypicalPodNew(pod)
 	sloSpec := 0 * time.Second
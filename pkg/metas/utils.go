@@ -12,6 +12,7 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	corev1 "k8s.io/api/core/v1"
@@ -454,14 +455,52 @@ func strFirstToUpper(str string) string {
 	return strings.ToUpper(str[0:1]) + str[1:]
 }
 
+// the deliveries of a SloSpec
+const (
+	SloSpecPodCreate  = "PodCreate"
+	SloSpecPodUpgrade = "PodUpgrade"
+	SloSpecPodDelete  = "PodDelete"
+)
+
+// SloSpecAnnotation 记录用户为 Pod 指定的 SloSpec (JSON)
+const SloSpecAnnotation = "lunettes.alipay.com/slo-spec"
+
 type SloSpecItem struct {
 	SloTime string
+	// the DeliverySLOPolicy setting it, not read from the annotation
+	Policy string `json:"-"`
 }
 
 type SloSpec map[string]*SloSpecItem
 
+// SloTime returns the SLO time of the delivery, false if it is not set or invalid
+func (s SloSpec) SloTime(delivery string) (time.Duration, bool) {
+	item := s[delivery]
+	if item == nil {
+		return 0, false
+	}
+	d, err := time.ParseDuration(item.SloTime)
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
+
+// FetchSloSpec reads the SloSpec from the annotation of obj, empty if it has none
 func FetchSloSpec(obj runtime.Object) SloSpec {
 	sloSpec := make(map[string]*SloSpecItem, 0)
+	if obj == nil {
+		return sloSpec
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return sloSpec
+	}
+	if data := accessor.GetAnnotations()[SloSpecAnnotation]; data != "" {
+		if err := json.Unmarshal([]byte(data), &sloSpec); err != nil {
+			klog.V(6).Infof("invalid slo spec of %s/%s: %v", accessor.GetNamespace(), accessor.GetName(), err)
+		}
+	}
 
 	return sloSpec
 }
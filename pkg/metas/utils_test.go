@@ -3,6 +3,7 @@ package metas
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -447,3 +448,29 @@ func TestGeneratePodFromEvent(t *testing.T) {
 	}
 
 }
+
+func TestFetchSloSpec(t *testing.T) {
+	pod := &corev1.Pod{}
+	assert.Empty(t, FetchSloSpec(pod))
+	sloTime, adjusted := GetPodSLO(pod, FetchSloSpec(pod))
+	assert.Equal(t, time.Duration(0), sloTime)
+	assert.False(t, adjusted)
+
+	pod.Annotations = map[string]string{
+		SloSpecAnnotation: `{"PodCreate": {"SloTime": "2m0s", "Policy": "web"}, "PodDelete": {"SloTime": "5m"}, "PodUpgrade": {"SloTime": "soon"}}`,
+	}
+	sloSpec := FetchSloSpec(pod)
+	sloTime, ok := sloSpec.SloTime(SloSpecPodDelete)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Minute, sloTime)
+	_, ok = sloSpec.SloTime(SloSpecPodUpgrade)
+	assert.False(t, ok)
+	// the policy is not taken from the annotation
+	sloTime, adjusted = GetPodSLO(pod, sloSpec)
+	assert.Equal(t, time.Duration(0), sloTime)
+	assert.False(t, adjusted)
+	sloSpec[SloSpecPodCreate].Policy = "web"
+	sloTime, adjusted = GetPodSLO(pod, sloSpec)
+	assert.Equal(t, 2*time.Minute, sloTime)
+	assert.True(t, adjusted)
+}
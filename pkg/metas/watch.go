@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 
 	"github.com/alipay/container-observability-service/pkg/pubsub"
@@ -13,12 +16,47 @@ import (
 )
 
 const (
-	PodCreateSLO = "pod_create_slo"
-	PodDeleteSLO = "pod_delete_slo"
+	PodCreateSLO  = "pod_create_slo"
+	PodDeleteSLO  = "pod_delete_slo"
+	PodUpgradeSLO = "pod_upgrade_slo"
+	PVCCreateSLO  = "pvc_create_slo"
+	// rollouts of deployments and statefulsets
+	WorkloadRolloutSLO = "workload_rollout_slo"
+	// completions of jobs
+	JobSLO = "job_slo"
 )
 
+// DeliveryTypes are the types could be watched
+var DeliveryTypes = []string{PodCreateSLO, PodDeleteSLO, PodUpgradeSLO, PVCCreateSLO, WorkloadRolloutSLO, JobSLO}
+
+// types of WatchEvent
+const (
+	WatchEventAdded = "ADDED"
+	WatchEventError = "ERROR"
+)
+
+// WatchEvent is what a watcher receives, like the watch events of kubernetes
+type WatchEvent struct {
+	Type string `json:"type"`
+	// the watcher resumes from it after reconnecting
+	ResourceVersion string      `json:"resourceVersion,omitempty"`
+	Object          interface{} `json:"object"`
+}
+
+// WatchStatus is the object of an ERROR WatchEvent, 410 tells the resource version is too old and the watcher should list again
+type WatchStatus struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
 var DeliveryWatchers *utils.SafeMap = utils.NewSafeMap()
 
+var (
+	publisherNamesLock sync.Mutex
+	publisherNames     []string
+)
+
 func RegisterPublisher(name string) {
 	if _, ok := DeliveryWatchers.Get(name); !ok {
 		pb, err := pubsub.New(1000)
@@ -26,9 +64,40 @@ func RegisterPublisher(name string) {
 			klog.Errorf("cant not register publisher %s, err: %s", name, err.Error())
 		}
 		DeliveryWatchers.Set(name, pb)
+		publisherNamesLock.Lock()
+		publisherNames = append(publisherNames, name)
+		publisherNamesLock.Unlock()
 	}
 }
 
+// EnableWatchHistory keeps the latest size messages of each publisher for watchers to resume from,
+// they are persisted in dir if it is not empty.
+func EnableWatchHistory(dir string, size int) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	publisherNamesLock.Lock()
+	defer publisherNamesLock.Unlock()
+	for _, name := range publisherNames {
+		pb := GetPubLister(name)
+		if pb == nil {
+			continue
+		}
+		if err := pb.SetHistorySize(size); err != nil {
+			return err
+		}
+		if dir == "" || size == 0 {
+			continue
+		}
+		if err := pb.EnableHistoryFile(filepath.Join(dir, name+".history")); err != nil {
+			return fmt.Errorf("failed to persist history of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 func GetPubLister(name string) *pubsub.PubSub {
 	if pb, ok := DeliveryWatchers.Get(name); ok {
 		pubSub, ok := pb.(*pubsub.PubSub)
@@ -45,9 +114,12 @@ type WatchSubscriber struct {
 	connection *websocket.Conn
 	errorChan  chan error
 	conLock    sync.Mutex
+	// nil if all the messages are sent
+	filter *WatchFilter
 }
 
-func NewWatcher(name string, w http.ResponseWriter, r *http.Request) *WatchSubscriber {
+// NewWatcher upgrades the request to a websocket receiving the messages selected by filter
+func NewWatcher(name string, filter *WatchFilter, w http.ResponseWriter, r *http.Request) *WatchSubscriber {
 	up := &websocket.Upgrader{} // use default options
 	c, err := up.Upgrade(w, r, nil)
 	if err != nil {
@@ -61,6 +133,7 @@ func NewWatcher(name string, w http.ResponseWriter, r *http.Request) *WatchSubsc
 		connection: c,
 		errorChan:  make(chan error, 100),
 		conLock:    sync.Mutex{},
+		filter:     filter,
 	}
 
 	return watcher
@@ -70,11 +143,42 @@ func (c *WatchSubscriber) SubscriberName() string {
 	return c.name
 }
 
+// OnVersionedMessage sends the message selected by the filter as a WatchEvent
+func (c *WatchSubscriber) OnVersionedMessage(m *pubsub.Message) {
+	data, err := json.Marshal(m.Object)
+	if err != nil {
+		klog.Errorf("watch publish error when json marshal, err: %v", err)
+		c.reportError(err)
+		return
+	}
+	if !c.filter.Matches(data) {
+		return
+	}
+	c.OnMessage(&WatchEvent{
+		Type:            WatchEventAdded,
+		ResourceVersion: strconv.FormatUint(m.ResourceVersion, 10),
+		Object:          json.RawMessage(data),
+	})
+}
+
+// OnClosed stops the watch, the client resumes from the last resource version received
+func (c *WatchSubscriber) OnClosed(err error) {
+	c.reportError(err)
+}
+
+// SendError sends an ERROR WatchEvent
+func (c *WatchSubscriber) SendError(code int, reason string, err error) {
+	c.OnMessage(&WatchEvent{
+		Type:   WatchEventError,
+		Object: &WatchStatus{Code: code, Reason: reason, Message: err.Error()},
+	})
+}
+
 func (c *WatchSubscriber) OnMessage(v interface{}) {
 	b, err := json.Marshal(v)
 	if err != nil {
 		klog.Errorf("watch publish error when json marshal, err: %v", err)
-		c.errorChan <- err
+		c.reportError(err)
 	}
 
 	c.conLock.Lock()
@@ -83,7 +187,15 @@ func (c *WatchSubscriber) OnMessage(v interface{}) {
 
 	if err != nil {
 		klog.Errorf("watch publish write error: %v", err)
-		c.errorChan <- err
+		c.reportError(err)
+	}
+}
+
+// reportError does not block, the messages left after the watch stops fail to write with nobody reading the errors
+func (c *WatchSubscriber) reportError(err error) {
+	select {
+	case c.errorChan <- err:
+	default:
 	}
 }
 
@@ -0,0 +1,79 @@
+package metas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// WatchFilter selects the delivery results sent to a watcher by the fields of the SLO record,
+// e.g. labelSelector "Namespace in (a,b),!SLOViolationReason" and fieldSelector "DeliveryStatus!=success".
+// Nested fields are named by their paths like "Spec.Name", empty fields are taken as absent.
+type WatchFilter struct {
+	labelSelector labels.Selector
+	fieldSelector fields.Selector
+}
+
+// ParseWatchFilter returns nil if both selectors are empty
+func ParseWatchFilter(labelSelector, fieldSelector string) (*WatchFilter, error) {
+	if labelSelector == "" && fieldSelector == "" {
+		return nil, nil
+	}
+	filter := &WatchFilter{
+		labelSelector: labels.Everything(),
+		fieldSelector: fields.Everything(),
+	}
+	var err error
+	if labelSelector != "" {
+		if filter.labelSelector, err = labels.Parse(labelSelector); err != nil {
+			return nil, fmt.Errorf("invalid labelSelector: %w", err)
+		}
+	}
+	if fieldSelector != "" {
+		if filter.fieldSelector, err = fields.ParseSelector(fieldSelector); err != nil {
+			return nil, fmt.Errorf("invalid fieldSelector: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// Matches tells whether the record marshaled as data is selected, a nil filter selects all
+func (f *WatchFilter) Matches(data []byte) bool {
+	if f == nil {
+		return true
+	}
+	var record interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return false
+	}
+	set := make(map[string]string)
+	flattenRecord("", record, set)
+	return f.labelSelector.Matches(labels.Set(set)) && f.fieldSelector.Matches(fields.Set(set))
+}
+
+// flattenRecord keeps the scalar fields of v in set by their paths
+func flattenRecord(path string, v interface{}, set map[string]string) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			if path != "" {
+				k = path + "." + k
+			}
+			flattenRecord(k, child, set)
+		}
+	case string:
+		if value != "" {
+			set[path] = value
+		}
+	case json.Number:
+		set[path] = value.String()
+	case bool:
+		set[path] = strconv.FormatBool(value)
+	}
+}
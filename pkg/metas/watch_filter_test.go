@@ -0,0 +1,44 @@
+package metas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchFilter(t *testing.T) {
+	violated := []byte(`{"Namespace": "ns1", "OwnerRefStr": "ReplicaSet/web-1", "DeliveryStatus": "timeout",
+		"SLOViolationReason": "ImagePullBackOff", "PodSLO": 120, "IsJob": false, "Spec": {"Zone": "z1"}}`)
+	delivered := []byte(`{"Namespace": "ns2", "OwnerRefStr": "", "DeliveryStatus": "success",
+		"SLOViolationReason": "", "PodSLO": 60, "IsJob": true}`)
+
+	filter, err := ParseWatchFilter("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+	assert.True(t, filter.Matches(violated))
+
+	cases := []struct {
+		labelSelector string
+		fieldSelector string
+		matches       []bool
+	}{
+		{"Namespace in (ns1,ns3)", "", []bool{true, false}},
+		{"SLOViolationReason", "", []bool{true, false}},
+		{"!SLOViolationReason", "", []bool{false, true}},
+		{"", "OwnerRefStr=ReplicaSet/web-1", []bool{true, false}},
+		{"", "DeliveryStatus!=success,IsJob=true", []bool{false, false}},
+		{"Namespace=ns2", "IsJob=true,PodSLO=60", []bool{false, true}},
+		{"Spec.Zone=z1", "", []bool{true, false}},
+	}
+	for _, c := range cases {
+		filter, err := ParseWatchFilter(c.labelSelector, c.fieldSelector)
+		assert.Nil(t, err)
+		assert.Equal(t, c.matches[0], filter.Matches(violated), "%s %s", c.labelSelector, c.fieldSelector)
+		assert.Equal(t, c.matches[1], filter.Matches(delivered), "%s %s", c.labelSelector, c.fieldSelector)
+	}
+
+	_, err = ParseWatchFilter("Namespace in (", "")
+	assert.NotNil(t, err)
+	_, err = ParseWatchFilter("", "Namespace")
+	assert.NotNil(t, err)
+}
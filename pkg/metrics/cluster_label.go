@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// clusterLabelEnabled tells whether the metrics which had no cluster label before multiple clusters were served
+	// have it. It changes their label sets, so their dashboards and recording rules need to be updated.
+	clusterLabelEnabled bool
+
+	// the label set of a metric can not be changed once registered, so they are registered on the first observation
+	clusterLabelMetricsRegistered bool
+	clusterLabelMetricsOnce       sync.Once
+)
+
+// EnableClusterLabel adds the cluster label to trace_processing_latency_seconds and
+// slo_pod_delete_latency_quantiles_in_seconds, it should be called before anything is observed.
+func EnableClusterLabel() error {
+	if clusterLabelEnabled {
+		return nil
+	}
+	if clusterLabelMetricsRegistered {
+		return fmt.Errorf("the cluster label must be enabled before the metrics are observed")
+	}
+	clusterLabelEnabled = true
+	TraceProcessingLatency = newTraceProcessingLatency(true)
+	PodDeleteLatencyQuantiles = newPodDeleteLatencyQuantiles(true)
+	return nil
+}
+
+func registerClusterLabelMetrics() {
+	clusterLabelMetricsOnce.Do(func() {
+		clusterLabelMetricsRegistered = true
+		prometheus.MustRegister(TraceProcessingLatency)
+		prometheus.MustRegister(PodDeleteLatencyQuantiles)
+	})
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnableClusterLabel(t *testing.T) {
+	assert.Nil(t, EnableClusterLabel())
+	ObserveTraceProcessingLatency("c1", "delete", 1)
+	ObserveTraceProcessingLatency("c2", "delete", 1)
+	ObservePodDeleteLatencyQuantiles("c1", "normal", 1)
+	assert.Equal(t, 2, testutil.CollectAndCount(TraceProcessingLatency))
+	assert.Equal(t, 1, testutil.CollectAndCount(PodDeleteLatencyQuantiles))
+	assert.Nil(t, EnableClusterLabel())
+}
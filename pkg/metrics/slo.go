@@ -160,15 +160,7 @@ var (
 		[]string{"cluster", "namespace", "phase"},
 	)
 
-	PodDeleteLatencyQuantiles = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Name:       "slo_pod_delete_latency_quantiles_in_seconds",
-			Help:       "pod delete latency in seconds with quantiles",
-			MaxAge:     time.Hour,
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
-		},
-		[]string{"pod_type"},
-	)
+	PodDeleteLatencyQuantiles = newPodDeleteLatencyQuantiles(false)
 
 	//PodUpgradeResultCounter update
 	PodUpgradeResultCounter = prometheus.NewCounterVec(
@@ -189,6 +181,32 @@ var (
 	)
 )
 
+func newPodDeleteLatencyQuantiles(clusterLabel bool) *prometheus.SummaryVec {
+	labels := []string{"pod_type"}
+	if clusterLabel {
+		labels = []string{"cluster", "pod_type"}
+	}
+	return prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "slo_pod_delete_latency_quantiles_in_seconds",
+			Help:       "pod delete latency in seconds with quantiles",
+			MaxAge:     time.Hour,
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		labels,
+	)
+}
+
+// ObservePodDeleteLatencyQuantiles observes the delete latency of a pod of podType in cluster
+func ObservePodDeleteLatencyQuantiles(cluster, podType string, seconds float64) {
+	registerClusterLabelMetrics()
+	if clusterLabelEnabled {
+		PodDeleteLatencyQuantiles.WithLabelValues(cluster, podType).Observe(seconds)
+		return
+	}
+	PodDeleteLatencyQuantiles.WithLabelValues(podType).Observe(seconds)
+}
+
 func init() {
 	// create
 	prometheus.MustRegister(PodStartupLatencyExcludingShceduling)
@@ -210,7 +228,6 @@ func init() {
 	prometheus.MustRegister(PodDeleteResultInWeek)
 	prometheus.MustRegister(PodDeleteApiCode)
 	prometheus.MustRegister(PodDeleteLatency)
-	prometheus.MustRegister(PodDeleteLatencyQuantiles)
 
 	// update
 	prometheus.MustRegister(PodUpgradeResultCounter)
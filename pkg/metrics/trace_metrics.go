@@ -7,18 +7,31 @@ var (
 		480, 600, 900, 1200, 1800, 2700, 3600, 7200, 14400, 43200, 86400}
 
 	// 每个 trace 交付的时间延迟
-	TraceProcessingLatency = prometheus.NewHistogramVec(
+	TraceProcessingLatency = newTraceProcessingLatency(false)
+)
+
+func newTraceProcessingLatency(clusterLabel bool) *prometheus.HistogramVec {
+	// trace type: 类型
+	labels := []string{"type"}
+	if clusterLabel {
+		labels = []string{"cluster", "type"}
+	}
+	return prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "trace_processing_latency_seconds",
 			Help:    "time used to process one trace",
 			Buckets: TraceProcessingLatencyBuckets,
 		},
-
-		// trace type: 类型
-		[]string{"type"},
+		labels,
 	)
-)
+}
 
-func init() {
-	prometheus.MustRegister(TraceProcessingLatency)
+// ObserveTraceProcessingLatency observes the time used to process a trace of traceType in cluster
+func ObserveTraceProcessingLatency(cluster, traceType string, seconds float64) {
+	registerClusterLabelMetrics()
+	if clusterLabelEnabled {
+		TraceProcessingLatency.WithLabelValues(cluster, traceType).Observe(seconds)
+		return
+	}
+	TraceProcessingLatency.WithLabelValues(traceType).Observe(seconds)
 }
@@ -6,11 +6,13 @@ import (
 	"github.com/alipay/container-observability-service/pkg/shares"
 
 	"github.com/alipay/container-observability-service/pkg/queue"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// DAGNode is the node of the node yaml watcher in the processing DAG
+const DAGNode = "NodeYamlNode"
+
 var (
 	Queue *queue.BoundedQueue
 )
@@ -23,6 +25,8 @@ type nodeOpStruct struct {
 }
 
 func init() {
+	shares.RegisterDAGNode(DAGNode)
+
 	Queue = queue.NewBoundedQueue("nodeyaml-watcher", 100000, nil)
 	Queue.StartLengthReporting(10 * time.Second)
 	Queue.StartConsumers(1, processAuditEvent)
@@ -37,11 +41,8 @@ func init() {
 			return true
 		}
 
-		if auditEvent.ObjectRef.Resource != "nodes" {
-			return true
-		}
-
-		if auditEvent.ResponseStatus.Code >= 300 {
+		if auditEvent.ObjectRef.Resource != "nodes" || auditEvent.ResponseStatus.Code >= 300 {
+			auditEvent.FinishProcess(DAGNode)
 			return true
 		}
 
@@ -50,8 +51,6 @@ func init() {
 }
 
 func processAuditEvent(v interface{}) {
-	defer utils.IgnorePanic("StartConsumers")
-
 	if v == nil {
 		return
 	}
@@ -60,7 +59,9 @@ func processAuditEvent(v interface{}) {
 	if !ok || auditEvent == nil {
 		return
 	}
-	auditEvent.Wait()
+	defer auditEvent.FinishProcess(DAGNode)
+	defer auditEvent.IgnorePanic("nodeyaml", "processAuditEvent")
+	auditEvent.CanProcess(DAGNode)
 
 	if auditEvent.ObjectRef.Resource != "nodes" {
 		return
@@ -3,13 +3,12 @@ package podphase
 import (
 	"github.com/alipay/container-observability-service/pkg/shares"
 
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	v1 "k8s.io/api/core/v1"
 )
 
 func processPodBinding(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodBinding")
+	defer auditEvent.IgnorePanic("podphase", "processPodBinding")
 
 	clusterName := auditEvent.Annotations["cluster"]
 	extraInfo := make(map[string]interface{})
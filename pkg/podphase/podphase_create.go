@@ -10,14 +10,13 @@ import (
 	"github.com/alipay/container-observability-service/pkg/kube"
 	"github.com/alipay/container-observability-service/pkg/metrics"
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	"k8s.io/klog/v2"
 )
 
 // 用于在 tracing API 中添加一个 apicreate 的 phase
 func processPodCreation(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodCreation")
+	defer auditEvent.IgnorePanic("podphase", "processPodCreation")
 
 	pod := auditEvent.TryGetPodFromEvent()
 	if pod == nil {
@@ -67,5 +66,5 @@ func processPodCreation(auditEvent *shares.AuditEvent) {
 	}
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("phase_create").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "phase_create", traceProcessingTime)
 }
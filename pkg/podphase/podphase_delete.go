@@ -11,13 +11,12 @@ import (
 	"github.com/alipay/container-observability-service/pkg/shares"
 
 	"github.com/alipay/container-observability-service/pkg/kube"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	v1 "k8s.io/api/core/v1"
 )
 
 func processPodDeletion(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodDeletion")
+	defer auditEvent.IgnorePanic("podphase", "processPodDeletion")
 
 	/*responsePod := &v1.Pod{}
 	if err := json.Unmarshal(auditEvent.ResponseObject.Raw, responsePod); err != nil {
@@ -69,5 +68,5 @@ func processPodDeletion(auditEvent *shares.AuditEvent) {
 	}
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("delete").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "delete", traceProcessingTime)
 }
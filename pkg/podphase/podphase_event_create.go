@@ -6,13 +6,12 @@ import (
 
 	"github.com/alipay/container-observability-service/pkg/shares"
 
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	v1 "k8s.io/api/core/v1"
 )
 
 func processPodEventCreation(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodEventCreation")
+	defer auditEvent.IgnorePanic("podphase", "processPodEventCreation")
 
 	if auditEvent == nil || auditEvent.RequestObject == nil || auditEvent.ResponseStatus.Code >= 300 {
 		return
@@ -3,13 +3,12 @@ package podphase
 import (
 	"github.com/alipay/container-observability-service/pkg/shares"
 
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	v1 "k8s.io/api/core/v1"
 )
 
 func processPodEventPatch(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodEventPatch")
+	defer auditEvent.IgnorePanic("podphase", "processPodEventPatch")
 
 	if auditEvent == nil || auditEvent.RequestObject == nil {
 		return
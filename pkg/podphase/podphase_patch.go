@@ -10,7 +10,6 @@ import (
 
 	"github.com/oliveagle/jsonpath"
 
-	"github.com/alipay/container-observability-service/pkg/utils"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
@@ -18,7 +17,7 @@ import (
 
 // 处理 Pod 的 Patch
 func processPodPatch(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodPatch")
+	defer auditEvent.IgnorePanic("podphase", "processPodPatch")
 
 	if auditEvent.ResponseStatus.Code >= 300 {
 		return
@@ -36,7 +35,7 @@ func processPodPatch(auditEvent *shares.AuditEvent) {
 // 处理非 Status 的字段
 // 包括 finalizer，labels，annotation，spec.SchedulerName 等
 func processPatchNoSubresource(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPatchNoSubresource ")
+	defer auditEvent.IgnorePanic("podphase", "processPatchNoSubresource")
 
 	// response pod
 	/*responsePod, err := metas.GeneratePodFromEvent(auditEvent)
@@ -91,12 +90,12 @@ func processPatchNoSubresource(auditEvent *shares.AuditEvent) {
 	_ = xsearch.SavePodInfoToZSearch(auditEvent.Annotations["cluster"], responsePod, "进行中", auditEvent.StageTimestamp.Time, "", "调度阶段", true)
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("patch").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "patch", traceProcessingTime)
 }
 
 // 处理 Status 的字段的更新
 func processPatchSubResourceStatus(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPatchSubResourceStatus")
+	defer auditEvent.IgnorePanic("podphase", "processPatchSubResourceStatus")
 
 	klog.V(6).Infof("this is patch for subresource status for pod _1: %s", auditEvent.ObjectRef.Name)
 	ok := false
@@ -181,7 +180,7 @@ func processPatchSubResourceStatus(auditEvent *shares.AuditEvent) {
 	}
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("patchSubResource").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "patchSubResource", traceProcessingTime)
 }
 
 func formatContainerStatus(con v1.ContainerStatus) string {
@@ -12,11 +12,16 @@ import (
 	"github.com/alipay/container-observability-service/pkg/queue"
 )
 
+// DAGNode is the node of the pod phase watcher in the processing DAG
+const DAGNode = "PodPhaseNode"
+
 var (
 	WatcherQueue *queue.BoundedQueue
 )
 
 func init() {
+	shares.RegisterDAGNode(DAGNode)
+
 	WatcherQueue = queue.NewBoundedQueue("podphase-watcher", 100000, nil)
 	WatcherQueue.StartLengthReporting(10 * time.Second)
 	WatcherQueue.IsDropEventOnFull = false
@@ -29,7 +34,8 @@ func init() {
 		if !ok || auditEvent == nil {
 			return
 		}
-		auditEvent.Wait()
+		auditEvent.CanProcess(DAGNode)
+		defer auditEvent.FinishProcess(DAGNode)
 
 		// pods资源
 		if auditEvent.ObjectRef.Resource == "pods" {
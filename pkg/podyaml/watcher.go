@@ -13,6 +13,9 @@ import (
 )
 
 const (
+	// DAGNode is the node of the pod yaml watcher in the processing DAG
+	DAGNode = "PodYamlNode"
+
 	deleteMark           = "deleted"
 	workerQueueCap       = 2000
 	numberOfWorkerQueues = 1000
@@ -35,6 +38,8 @@ type podOpStruct struct {
 }
 
 func init() {
+	shares.RegisterDAGNode(DAGNode)
+
 	Queue = queue.NewBoundedQueue("podyaml", 50000, nil)
 	Queue.StartLengthReporting(10 * time.Second)
 	Queue.IsDropEventOnFull = false
@@ -49,10 +54,9 @@ func init() {
 			return true
 		}
 
-		if auditEvent.ObjectRef == nil || auditEvent.ObjectRef.Resource != "pods" {
-			return true
-		}
-		if auditEvent.ResponseStatus.Code >= 300 {
+		if auditEvent.ObjectRef == nil || auditEvent.ObjectRef.Resource != "pods" ||
+			auditEvent.ResponseStatus.Code >= 300 {
+			auditEvent.FinishProcess(DAGNode)
 			return true
 		}
 		return false
@@ -65,7 +69,8 @@ func init() {
 		if !ok || auditEvent == nil {
 			return
 		}
-		auditEvent.Wait()
+		auditEvent.CanProcess(DAGNode)
+		defer auditEvent.FinishProcess(DAGNode)
 
 		consumePodOpStruct(auditEvent)
 	})
@@ -86,7 +91,7 @@ func init() {
 }
 
 func consumePodOpStruct(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("StartConsumers")
+	defer auditEvent.IgnorePanic("podyaml", "StartConsumers")
 
 	if auditEvent.ResponseRuntimeObj == nil {
 		return
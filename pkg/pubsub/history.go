@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// the largest message line read from a history file
+const maxHistoryLineBytes = 16 << 20
+
+// persistedMessage is a line of the history file, the object is kept as it was marshaled
+type persistedMessage struct {
+	ResourceVersion uint64          `json:"resourceVersion"`
+	Time            time.Time       `json:"time"`
+	Object          json.RawMessage `json:"object"`
+}
+
+// historyFile keeps the messages as json lines, it is rewritten with the kept messages
+// when it grows to twice the history size
+type historyFile struct {
+	path     string
+	file     *os.File
+	lines    int
+	maxLines int
+}
+
+// EnableHistoryFile persists the kept messages to path, the messages kept there by the last run are loaded,
+// so subscribers resume across restarts.
+func (ps *PubSub) EnableHistoryFile(path string) error {
+	ps.locker.Lock()
+	defer ps.locker.Unlock()
+	if ps.historySize == 0 {
+		return fmt.Errorf("no message is kept to persist")
+	}
+
+	loaded, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+	if len(loaded) > 0 {
+		// versions are not reused even if the tail of the file was lost
+		if last := loaded[len(loaded)-1].ResourceVersion; last >= ps.nextVersion {
+			ps.nextVersion = last + 1
+		}
+		// the loaded ones are published before
+		ps.history = append(loaded, ps.history...)
+		if len(ps.history) > ps.historySize {
+			ps.history = ps.history[len(ps.history)-ps.historySize:]
+		}
+	}
+
+	hf := &historyFile{path: path, maxLines: 2 * ps.historySize}
+	if err := hf.rewrite(ps.history); err != nil {
+		return err
+	}
+	if ps.historyFile != nil {
+		ps.historyFile.close()
+	}
+	ps.historyFile = hf
+	klog.Infof("loaded %d messages from %s", len(loaded), path)
+	return nil
+}
+
+func loadHistory(path string) ([]*Message, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	messages := make([]*Message, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLineBytes)
+	for scanner.Scan() {
+		pm := &persistedMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), pm); err != nil {
+			// the last line could be cut by a crash
+			klog.Warningf("skip broken message in %s: %s", path, err.Error())
+			continue
+		}
+		if len(messages) > 0 && pm.ResourceVersion <= messages[len(messages)-1].ResourceVersion {
+			continue
+		}
+		messages = append(messages, &Message{ResourceVersion: pm.ResourceVersion, Time: pm.Time, Object: pm.Object})
+	}
+	return messages, scanner.Err()
+}
+
+func (h *historyFile) append(m *Message, history []*Message) error {
+	if h.lines >= h.maxLines {
+		return h.rewrite(history)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := h.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	h.lines++
+	return nil
+}
+
+// rewrite replaces the file with the messages
+func (h *historyFile) rewrite(messages []*Message) error {
+	tmp := h.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, m := range messages {
+		data, err := json.Marshal(m)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+
+	h.close()
+	if h.file, err = os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return err
+	}
+	h.lines = len(messages)
+	return nil
+}
+
+func (h *historyFile) close() {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
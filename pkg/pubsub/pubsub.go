@@ -1,12 +1,22 @@
 package pubsub
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/alipay/container-observability-service/pkg/metrics"
 	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// ErrResourceVersionExpired tells the messages after the version are no longer kept, the subscriber should list again
+	ErrResourceVersionExpired = errors.New("resource version is too old")
+	// ErrSubscriberTooSlow tells the subscriber was dropped for falling behind the publisher
+	ErrSubscriberTooSlow = errors.New("subscriber falls behind")
 )
 
 // Subscriber is interface that who want to consume message
@@ -17,31 +27,97 @@ type Subscriber interface {
 	OnMessage(v interface{})
 }
 
+// VersionedSubscriber receives the messages with their resource versions instead of OnMessage
+type VersionedSubscriber interface {
+	Subscriber
+	OnVersionedMessage(m *Message)
+	// OnClosed is called when the subscriber is dropped, it could subscribe again from the last version received
+	OnClosed(err error)
+}
+
+// Message is a published message, the resource versions of the messages of a PubSub increase
+type Message struct {
+	ResourceVersion uint64      `json:"resourceVersion"`
+	Time            time.Time   `json:"time"`
+	Object          interface{} `json:"object"`
+}
+
+// subscription delivers messages to a subscriber in order
+type subscription struct {
+	subscriber Subscriber
+	messages   chan *Message
+	// why the subscription is closed by the publisher, set before messages is closed
+	err error
+}
+
+func (s *subscription) run(backlog []*Message) {
+	for _, m := range backlog {
+		s.deliver(m)
+	}
+	for m := range s.messages {
+		s.deliver(m)
+	}
+	if s.err == nil {
+		return
+	}
+	if vs, ok := s.subscriber.(VersionedSubscriber); ok {
+		vs.OnClosed(s.err)
+	} else {
+		klog.Warningf("subscriber %s is dropped: %s", s.subscriber.SubscriberName(), s.err.Error())
+	}
+}
+
+func (s *subscription) deliver(m *Message) {
+	if vs, ok := s.subscriber.(VersionedSubscriber); ok {
+		vs.OnVersionedMessage(m)
+		return
+	}
+	s.subscriber.OnMessage(m.Object)
+}
+
 // PubSub contains channel and subscribers.
 type PubSub struct {
 	messageChan chan interface{}
-	subscribers map[string]Subscriber
+	buffer      int
+	subscribers map[string]*subscription
 	locker      sync.Mutex
 	e           chan error
 	closed      bool
+
+	// the latest messages kept for subscribers resuming from a version
+	history     []*Message
+	historySize int
+	nextVersion uint64
+	// nil if the history is kept in memory only
+	historyFile *historyFile
 }
 
-// New return new PubSub intreface.
+// New return new PubSub intreface keeping the latest buffer messages.
 func New(buffer int) (*PubSub, error) {
+	return NewWithHistory(buffer, buffer)
+}
+
+// NewWithHistory return new PubSub keeping the latest historySize messages for subscribers to resume from.
+func NewWithHistory(buffer, historySize int) (*PubSub, error) {
 
 	if buffer < 1 {
 		return nil, fmt.Errorf("message(%d) buffer should be greater than 0", buffer)
 	}
+	if historySize < 0 {
+		return nil, fmt.Errorf("history size %d should not be negative", historySize)
+	}
 	ps := new(PubSub)
 	ps.messageChan = make(chan interface{}, buffer)
-	ps.subscribers = make(map[string]Subscriber)
+	ps.buffer = buffer
+	ps.subscribers = make(map[string]*subscription)
+	ps.historySize = historySize
+	// versions keep increasing after restart, so versions of the last run are told expired
+	ps.nextVersion = uint64(time.Now().UnixNano())
 	go func() {
 		for v := range ps.messageChan {
 			ps.locker.Lock()
 			t := time.Now()
-			for _, subscriber := range ps.subscribers {
-				go subscriber.OnMessage(v)
-			}
+			ps.dispatch(&Message{ResourceVersion: ps.nextVersion, Time: t, Object: v})
 			ps.locker.Unlock()
 			metrics.DebugMethodDurationMilliSeconds.WithLabelValues("pubsub.publish").Observe(utils.TimeSinceInMilliSeconds(t))
 		}
@@ -49,14 +125,108 @@ func New(buffer int) (*PubSub, error) {
 	return ps, nil
 }
 
+// dispatch keeps m and sends it to the subscribers, the ones falling behind are dropped
+func (ps *PubSub) dispatch(m *Message) {
+	ps.nextVersion = m.ResourceVersion + 1
+	ps.keep(m)
+	for name, sub := range ps.subscribers {
+		select {
+		case sub.messages <- m:
+		default:
+			sub.err = ErrSubscriberTooSlow
+			close(sub.messages)
+			delete(ps.subscribers, name)
+		}
+	}
+}
+
+func (ps *PubSub) keep(m *Message) {
+	if ps.historySize == 0 {
+		return
+	}
+	ps.history = append(ps.history, m)
+	if len(ps.history) > ps.historySize {
+		ps.history = ps.history[len(ps.history)-ps.historySize:]
+	}
+	if ps.historyFile != nil {
+		if err := ps.historyFile.append(m, ps.history); err != nil {
+			klog.Errorf("failed to persist message %d: %s", m.ResourceVersion, err.Error())
+		}
+	}
+}
+
+// SetHistorySize keeps the latest size messages for subscribers to resume from
+func (ps *PubSub) SetHistorySize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("history size %d should not be negative", size)
+	}
+	ps.locker.Lock()
+	defer ps.locker.Unlock()
+	ps.historySize = size
+	if len(ps.history) > size {
+		ps.history = ps.history[len(ps.history)-size:]
+	}
+	return nil
+}
+
+// backlog returns the messages after resourceVersion
+func (ps *PubSub) backlog(resourceVersion uint64) ([]*Message, error) {
+	latest := ps.nextVersion - 1
+	oldest := ps.nextVersion
+	if len(ps.history) > 0 {
+		oldest = ps.history[0].ResourceVersion
+	}
+	if resourceVersion > latest || resourceVersion+1 < oldest {
+		return nil, ErrResourceVersionExpired
+	}
+
+	backlog := make([]*Message, 0)
+	for _, m := range ps.history {
+		if m.ResourceVersion > resourceVersion {
+			backlog = append(backlog, m)
+		}
+	}
+	return backlog, nil
+}
+
+// ResourceVersion returns the version of the latest message
+func (ps *PubSub) ResourceVersion() uint64 {
+	ps.locker.Lock()
+	defer ps.locker.Unlock()
+	return ps.nextVersion - 1
+}
+
 // Subscribe subscribe to the PubSub.
 func (ps *PubSub) Subscribe(s Subscriber) error {
+	return ps.SubscribeFrom(s, 0)
+}
+
+// SubscribeFrom subscribe to the PubSub, the kept messages after resourceVersion are received first.
+// 0 means receiving the messages published from now on. ErrResourceVersionExpired is returned if the
+// messages after resourceVersion are no longer kept.
+func (ps *PubSub) SubscribeFrom(s Subscriber, resourceVersion uint64) error {
 	ps.locker.Lock()
 	defer ps.locker.Unlock()
+	if ps.closed {
+		return fmt.Errorf("PubSub has already closed")
+	}
 	if _, found := ps.subscribers[s.SubscriberName()]; found {
 		return fmt.Errorf("subscriber %s already exists", s.SubscriberName())
 	}
-	ps.subscribers[s.SubscriberName()] = s
+
+	var backlog []*Message
+	if resourceVersion > 0 {
+		var err error
+		if backlog, err = ps.backlog(resourceVersion); err != nil {
+			return err
+		}
+	}
+	sub := &subscription{
+		subscriber: s,
+		messages:   make(chan *Message, ps.buffer),
+	}
+	ps.subscribers[s.SubscriberName()] = sub
+	go sub.run(backlog)
 	return nil
 }
 
@@ -64,7 +234,10 @@ func (ps *PubSub) Subscribe(s Subscriber) error {
 func (ps *PubSub) UnSubscribe(s Subscriber) {
 	ps.locker.Lock()
 	defer ps.locker.Unlock()
-	delete(ps.subscribers, s.SubscriberName())
+	if sub, found := ps.subscribers[s.SubscriberName()]; found {
+		close(sub.messages)
+		delete(ps.subscribers, s.SubscriberName())
+	}
 }
 
 // Publish will publish message to subscribers
@@ -85,6 +258,13 @@ func (ps *PubSub) Close() error {
 	}
 	ps.closed = true
 	close(ps.messageChan)
+	for _, sub := range ps.subscribers {
+		close(sub.messages)
+	}
 	ps.subscribers = nil
+	if ps.historyFile != nil {
+		ps.historyFile.close()
+		ps.historyFile = nil
+	}
 	return nil
 }
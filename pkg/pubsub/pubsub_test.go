@@ -1,6 +1,9 @@
 package pubsub
 
 import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -84,3 +87,133 @@ func TestPubSub(t *testing.T) {
 	err = ps.Close()
 	assert.NotNil(t, err, "error should not be nil for close PubSub server second time")
 }
+
+type versionedSubscriber struct {
+	name     string
+	lock     sync.Mutex
+	messages []*Message
+	closed   chan error
+}
+
+func newVersionedSubscriber(name string) *versionedSubscriber {
+	return &versionedSubscriber{name: name, closed: make(chan error, 1)}
+}
+
+func (s *versionedSubscriber) SubscriberName() string {
+	return s.name
+}
+
+func (s *versionedSubscriber) OnMessage(v interface{}) {}
+
+func (s *versionedSubscriber) OnVersionedMessage(m *Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, m)
+}
+
+func (s *versionedSubscriber) OnClosed(err error) {
+	s.closed <- err
+}
+
+func (s *versionedSubscriber) received() []*Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Message{}, s.messages...)
+}
+
+func (s *versionedSubscriber) objects() []interface{} {
+	objects := make([]interface{}, 0)
+	for _, m := range s.received() {
+		objects = append(objects, m.Object)
+	}
+	return objects
+}
+
+func publishAndWait(t *testing.T, ps *PubSub, values ...int) {
+	for _, v := range values {
+		assert.Nil(t, ps.Publish(v))
+	}
+	assert.Eventually(t, func() bool {
+		ps.locker.Lock()
+		defer ps.locker.Unlock()
+		return len(ps.messageChan) == 0 && len(ps.history) > 0 && ps.history[len(ps.history)-1].Object == values[len(values)-1]
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPubSubResume(t *testing.T) {
+	ps, err := NewWithHistory(100, 3)
+	assert.Nil(t, err)
+	defer ps.Close()
+
+	s1 := newVersionedSubscriber("s1")
+	assert.Nil(t, ps.Subscribe(s1))
+	publishAndWait(t, ps, 1, 2, 3, 4)
+	assert.Eventually(t, func() bool { return len(s1.objects()) == 4 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []interface{}{1, 2, 3, 4}, s1.objects())
+	received := s1.received()
+	for i := 1; i < len(received); i++ {
+		assert.Equal(t, received[i-1].ResourceVersion+1, received[i].ResourceVersion)
+	}
+	assert.Equal(t, received[3].ResourceVersion, ps.ResourceVersion())
+
+	// resumes after the second message
+	s2 := newVersionedSubscriber("s2")
+	assert.Nil(t, ps.SubscribeFrom(s2, received[1].ResourceVersion))
+	publishAndWait(t, ps, 5)
+	assert.Eventually(t, func() bool { return len(s2.objects()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []interface{}{3, 4, 5}, s2.objects())
+
+	// the first two are no longer kept
+	assert.Equal(t, ErrResourceVersionExpired, ps.SubscribeFrom(newVersionedSubscriber("s3"), received[0].ResourceVersion))
+	// a version of the future, e.g. from the last run
+	assert.Equal(t, ErrResourceVersionExpired, ps.SubscribeFrom(newVersionedSubscriber("s4"), ps.ResourceVersion()+10))
+	// up to date
+	assert.Nil(t, ps.SubscribeFrom(newVersionedSubscriber("s5"), ps.ResourceVersion()))
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	ps, err := New(1)
+	assert.Nil(t, err)
+	defer ps.Close()
+
+	slow := newVersionedSubscriber("slow")
+	ps.locker.Lock()
+	// its messages are not consumed
+	ps.subscribers[slow.name] = &subscription{subscriber: slow, messages: make(chan *Message, 1)}
+	ps.locker.Unlock()
+
+	publishAndWait(t, ps, 1)
+	publishAndWait(t, ps, 2)
+	ps.locker.Lock()
+	sub, found := ps.subscribers[slow.name]
+	ps.locker.Unlock()
+	assert.False(t, found)
+	assert.Nil(t, sub)
+}
+
+func TestPubSubHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topic.history")
+
+	ps, err := NewWithHistory(100, 3)
+	assert.Nil(t, err)
+	assert.Nil(t, ps.EnableHistoryFile(path))
+	publishAndWait(t, ps, 1, 2, 3, 4, 5, 6, 7)
+	last := ps.ResourceVersion()
+	assert.Nil(t, ps.Close())
+
+	// restarted
+	ps, err = NewWithHistory(100, 3)
+	assert.Nil(t, err)
+	defer ps.Close()
+	assert.Nil(t, ps.EnableHistoryFile(path))
+	assert.True(t, ps.ResourceVersion() >= last)
+
+	s := newVersionedSubscriber("s")
+	assert.Nil(t, ps.SubscribeFrom(s, last-2))
+	assert.Eventually(t, func() bool { return len(s.objects()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []interface{}{json.RawMessage("6"), json.RawMessage("7")}, s.objects())
+
+	publishAndWait(t, ps, 8)
+	assert.Eventually(t, func() bool { return len(s.objects()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.True(t, s.received()[2].ResourceVersion > last)
+}
@@ -1,11 +1,12 @@
 package queue
 
 import (
-	"github.com/prometheus/client_golang/prometheus"
 	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -24,12 +25,47 @@ var (
 		// queue name
 		[]string{"name"},
 	)
+	spilledEventsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lunettes_queue_spilled_events_count",
+			Help: "total events spilled to disk when the queue is full",
+		},
+		[]string{"name"},
+	)
+	spilledBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lunettes_queue_spilled_bytes",
+			Help: "bytes of spilled events not consumed yet",
+		},
+		[]string{"name"},
+	)
+	spillAgeSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lunettes_queue_spill_age_seconds",
+			Help: "how long the oldest spilled event not consumed yet has been spilled",
+		},
+		[]string{"name"},
+	)
+)
+
+// all started queues, used to wait for every event in memory to be consumed
+var (
+	queuesMutex sync.Mutex
+	queues      = make(map[*BoundedQueue]struct{})
 )
 
+// Tracked is an item which is told when it is put into a queue and when a consumer has finished it,
+// e.g. to know whether the items of a source are all consumed
+type Tracked interface {
+	Produced()
+	Consumed()
+}
+
 type BoundedQueue struct {
 	name                string
 	capacity            int
 	size                int32
+	consuming           int32
 	onDroppedItem       func(item interface{})
 	filterItemOnProduce func(item interface{}) bool // true: item will be filtered
 	itemsCh             chan interface{}
@@ -38,6 +74,8 @@ type BoundedQueue struct {
 	stopped             int32
 	IsLockOSThread      bool
 	IsDropEventOnFull   bool
+	// overflowed items are kept on disk if set
+	spill *spill
 }
 
 // NewBoundedQueue constructs the new queue of specified capacity, and with an optional
@@ -57,6 +95,10 @@ func NewBoundedQueue(name string, capacity int, onDroppedItem func(item interfac
 // StartConsumers starts a given number of goroutines consuming items from the queue
 // and passing them into the consumer callback.
 func (q *BoundedQueue) StartConsumers(num int, consumer func(item interface{})) {
+	queuesMutex.Lock()
+	queues[q] = struct{}{}
+	queuesMutex.Unlock()
+
 	var startWG sync.WaitGroup
 	for i := 0; i < num; i++ {
 		q.stopWG.Add(1)
@@ -71,8 +113,13 @@ func (q *BoundedQueue) StartConsumers(num int, consumer func(item interface{}))
 			for {
 				select {
 				case item := <-q.itemsCh:
+					atomic.AddInt32(&q.consuming, 1)
 					atomic.AddInt32(&q.size, -1)
 					consumer(item)
+					if tracked, ok := item.(Tracked); ok {
+						tracked.Consumed()
+					}
+					atomic.AddInt32(&q.consuming, -1)
 				case <-q.stopCh:
 					return
 				}
@@ -85,10 +132,7 @@ func (q *BoundedQueue) StartConsumers(num int, consumer func(item interface{}))
 // Produce is used by the producer to submit new item to the queue. Returns false in case of queue overflow.
 func (q *BoundedQueue) Produce(item interface{}) bool {
 	if atomic.LoadInt32(&q.stopped) != 0 {
-		TotalEventsDroppedCount.WithLabelValues(q.name).Inc()
-		if q.onDroppedItem != nil {
-			q.onDroppedItem(item)
-		}
+		q.drop(item)
 		return false
 	}
 
@@ -96,16 +140,30 @@ func (q *BoundedQueue) Produce(item interface{}) bool {
 		return true
 	}
 
+	// before it could be consumed, and undone if it is dropped
+	tracked, isTracked := item.(Tracked)
+	if isTracked {
+		tracked.Produced()
+	}
+	produced := q.produce(item)
+	if isTracked && !produced {
+		tracked.Consumed()
+	}
+	return produced
+}
+
+func (q *BoundedQueue) produce(item interface{}) bool {
+	if q.spill != nil {
+		return q.produceWithSpill(item)
+	}
+
 	if q.IsDropEventOnFull {
 		select {
 		case q.itemsCh <- item:
 			atomic.AddInt32(&q.size, 1)
 			return true
 		default:
-			TotalEventsDroppedCount.WithLabelValues(q.name).Inc()
-			if q.onDroppedItem != nil {
-				q.onDroppedItem(item)
-			}
+			q.drop(item)
 			return false
 		}
 	} else {
@@ -117,12 +175,30 @@ func (q *BoundedQueue) Produce(item interface{}) bool {
 	}
 }
 
+func (q *BoundedQueue) drop(item interface{}) {
+	TotalEventsDroppedCount.WithLabelValues(q.name).Inc()
+	if q.onDroppedItem != nil {
+		q.onDroppedItem(item)
+	}
+}
+
 // Stop stops all consumers, as well as the length reporter if started,
 // and releases the items channel. It blocks until all consumers have stopped.
 func (q *BoundedQueue) Stop() {
+	queuesMutex.Lock()
+	delete(queues, q)
+	queuesMutex.Unlock()
+
 	atomic.StoreInt32(&q.stopped, 1) // disable producer
 	close(q.stopCh)
+	if q.spill != nil {
+		// wake up the spill drainer and the producers waiting for disk space
+		q.spill.mutex.Lock()
+		q.spill.cond.Broadcast()
+		q.spill.mutex.Unlock()
+	}
 	q.stopWG.Wait()
+	q.closeSpill()
 	close(q.itemsCh)
 }
 
@@ -131,6 +207,40 @@ func (q *BoundedQueue) Size() int {
 	return int(atomic.LoadInt32(&q.size))
 }
 
+// IsIdle returns true if the queue is empty and no item is being consumed
+func (q *BoundedQueue) IsIdle() bool {
+	return atomic.LoadInt32(&q.size) == 0 && atomic.LoadInt32(&q.consuming) == 0
+}
+
+// WaitAllIdle blocks until all started queues are idle in rounds continuous checks.
+// Consumers may hand items over to queues or goroutines later, so a single check is not enough.
+func WaitAllIdle(interval time.Duration, rounds int) {
+	WaitAllIdleTimeout(interval, rounds, 0)
+}
+
+// WaitAllIdleTimeout is WaitAllIdle giving up after timeout, it returns false if the queues are still busy.
+// A zero timeout waits forever.
+func WaitAllIdleTimeout(interval time.Duration, rounds int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for idle := 0; idle < rounds; {
+		if timeout > 0 && time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(interval)
+
+		idle++
+		queuesMutex.Lock()
+		for q := range queues {
+			if !q.IsIdle() {
+				idle = 0
+				break
+			}
+		}
+		queuesMutex.Unlock()
+	}
+	return true
+}
+
 // Capacity returns capacity of the queue
 func (q *BoundedQueue) Capacity() int {
 	return q.capacity
@@ -147,6 +257,7 @@ func (q *BoundedQueue) StartLengthReporting(reportPeriod time.Duration) {
 			case <-ticker.C:
 				size := q.Size()
 				inputQueueSize.WithLabelValues(q.name).Set(float64(size))
+				q.reportSpill()
 			case <-q.stopCh:
 				return
 			}
@@ -161,4 +272,7 @@ func (q *BoundedQueue) SetFilterItemFunc(filter func(item interface{}) bool) {
 func init() {
 	prometheus.MustRegister(inputQueueSize)
 	prometheus.MustRegister(TotalEventsDroppedCount)
+	prometheus.MustRegister(spilledEventsCount)
+	prometheus.MustRegister(spilledBytes)
+	prometheus.MustRegister(spillAgeSeconds)
 }
@@ -28,3 +28,44 @@ func TestNewBoundedQueue(t *testing.T) {
 	queue.Stop()
 	assert.Equal(t, 10, receivedCount)
 }
+
+func TestWaitAllIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	queue := NewBoundedQueue("test-idle-queue", 10, nil)
+	queue.StartConsumers(1, func(item interface{}) {
+		<-release
+	})
+	defer queue.Stop()
+
+	queue.Produce(1)
+	assert.False(t, WaitAllIdleTimeout(10*time.Millisecond, 3, 100*time.Millisecond))
+	close(release)
+	assert.True(t, WaitAllIdleTimeout(10*time.Millisecond, 3, time.Second))
+}
+
+type trackedItem struct {
+	produced int
+	consumed int
+}
+
+func (i *trackedItem) Produced() { i.produced++ }
+func (i *trackedItem) Consumed() { i.consumed++ }
+
+func TestTrackedItem(t *testing.T) {
+	queue := NewBoundedQueue("test-tracked-queue", 1, nil)
+	consumed := make(chan struct{})
+	item, dropped := &trackedItem{}, &trackedItem{}
+	assert.True(t, queue.Produce(item))
+	assert.False(t, queue.Produce(dropped))
+	assert.Equal(t, 1, dropped.produced)
+	assert.Equal(t, 1, dropped.consumed)
+	assert.Equal(t, 0, item.consumed)
+
+	queue.StartConsumers(1, func(v interface{}) {
+		close(consumed)
+	})
+	<-consumed
+	queue.Stop()
+	assert.Equal(t, 1, item.produced)
+	assert.Equal(t, 1, item.consumed)
+}
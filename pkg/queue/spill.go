@@ -0,0 +1,409 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	spillSegmentBytes = 64 << 20
+	// unix nano of spill time and length of data
+	spillHeaderBytes = 12
+	// sequence of the segment being read and the offset of the first record not consumed
+	spillHeadBytes = 16
+)
+
+// SpillCodec encodes the items spilled to disk and decodes them back
+type SpillCodec interface {
+	Encode(item interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// spill keeps the items overflowing the channel in segment files, which are drained back to the channel in order.
+type spill struct {
+	dir      string
+	name     string
+	maxBytes int64
+	codec    SpillCodec
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// oldest first, the last one is being written
+	segments    []string
+	nextSeq     int64
+	writer      *os.File
+	bufWriter   *bufio.Writer
+	writerBytes int64
+	reader      *os.File
+	bufReader   *bufio.Reader
+	// position after the last record read, saved to head once the record is handed over
+	readSeq    int64
+	readOffset int64
+	head       *os.File
+	// items spilled but not handed over to consumers and their bytes
+	pending  int64
+	bytes    int64
+	headTime time.Time
+}
+
+// EnableSpill makes the queue spill items to segment files in dir instead of dropping or blocking when it is full,
+// the spilled items are consumed in order before newer ones. Segments left by the former process are consumed first.
+// At most maxBytes are spilled if it is positive, then the queue drops or blocks as before.
+// It must be called before any item is produced.
+func (q *BoundedQueue) EnableSpill(dir string, maxBytes int64, codec SpillCodec) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s := &spill{
+		dir:      dir,
+		name:     q.name,
+		maxBytes: maxBytes,
+		codec:    codec,
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	head, err := os.OpenFile(filepath.Join(dir, q.name+".head"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	s.head = head
+	if err := s.recover(); err != nil {
+		return err
+	}
+	if err := s.rotate(); err != nil {
+		return err
+	}
+	if s.pending > 0 {
+		klog.Infof("queue %s recovered %d spilled items of %d bytes", q.name, s.pending, s.bytes)
+		atomic.AddInt32(&q.size, int32(s.pending))
+	}
+
+	q.spill = s
+	q.reportSpill()
+	q.stopWG.Add(1)
+	go q.drainSpill()
+	return nil
+}
+
+func (q *BoundedQueue) produceWithSpill(item interface{}) bool {
+	s := q.spill
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// items go to disk as long as some are there to keep the order
+	if s.pending == 0 {
+		select {
+		case q.itemsCh <- item:
+			atomic.AddInt32(&q.size, 1)
+			return true
+		default:
+		}
+	}
+
+	data, err := s.codec.Encode(item)
+	if err != nil {
+		klog.Errorf("queue %s failed to encode item: %s", q.name, err.Error())
+		q.drop(item)
+		return false
+	}
+	for s.maxBytes > 0 && s.bytes+int64(spillHeaderBytes+len(data)) > s.maxBytes {
+		if q.IsDropEventOnFull || atomic.LoadInt32(&q.stopped) != 0 {
+			q.drop(item)
+			return false
+		}
+		s.cond.Wait()
+	}
+	if err := s.write(data); err != nil {
+		klog.Errorf("queue %s failed to spill item: %s", q.name, err.Error())
+		q.drop(item)
+		return false
+	}
+	atomic.AddInt32(&q.size, 1)
+	// the item on disk survives restart, and is read back as a new item
+	if tracked, ok := item.(Tracked); ok {
+		tracked.Consumed()
+	}
+	spilledEventsCount.WithLabelValues(q.name).Inc()
+	spilledBytes.WithLabelValues(q.name).Set(float64(s.bytes))
+	s.cond.Broadcast()
+	return true
+}
+
+// drainSpill moves the spilled items to the channel one by one, the head is advanced only after
+// an item is handed over, so the item read when the queue is stopped is read again after restart.
+func (q *BoundedQueue) drainSpill() {
+	defer q.stopWG.Done()
+	s := q.spill
+	for {
+		s.mutex.Lock()
+		for s.pending == 0 && atomic.LoadInt32(&q.stopped) == 0 {
+			s.cond.Wait()
+		}
+		if atomic.LoadInt32(&q.stopped) != 0 {
+			s.mutex.Unlock()
+			return
+		}
+		data, err := s.read()
+		s.mutex.Unlock()
+
+		var item interface{}
+		if err == nil {
+			item, err = s.codec.Decode(data)
+		}
+		if err != nil {
+			klog.Errorf("queue %s failed to read spilled item: %s", q.name, err.Error())
+			atomic.AddInt32(&q.size, -1)
+			TotalEventsDroppedCount.WithLabelValues(q.name).Inc()
+		} else {
+			select {
+			case q.itemsCh <- item:
+			case <-q.stopCh:
+				return
+			}
+		}
+
+		s.mutex.Lock()
+		s.pending--
+		s.bytes -= int64(spillHeaderBytes + len(data))
+		if err := s.saveHead(); err != nil {
+			klog.Errorf("queue %s failed to save spill head: %s", q.name, err.Error())
+		}
+		s.cond.Broadcast()
+		s.mutex.Unlock()
+		q.reportSpill()
+	}
+}
+
+// reportSpill sets the bytes spilled and the age of the oldest one
+func (q *BoundedQueue) reportSpill() {
+	s := q.spill
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	spilledBytes.WithLabelValues(q.name).Set(float64(s.bytes))
+	age := time.Duration(0)
+	if s.pending > 0 && !s.headTime.IsZero() {
+		age = time.Since(s.headTime)
+	}
+	spillAgeSeconds.WithLabelValues(q.name).Set(age.Seconds())
+}
+
+func (q *BoundedQueue) closeSpill() {
+	s := q.spill
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.bufWriter != nil {
+		_ = s.bufWriter.Flush()
+		_ = s.writer.Close()
+	}
+	if s.reader != nil {
+		_ = s.reader.Close()
+	}
+	_ = s.head.Close()
+}
+
+func (s *spill) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%020d.seg", s.name, seq))
+}
+
+func (s *spill) segmentSeq(path string) (int64, bool) {
+	var seq int64
+	if _, err := fmt.Sscanf(filepath.Base(path), s.name+"-%d.seg", &seq); err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// recover counts the items not consumed in the segments left, the consumed segments are removed
+func (s *spill) recover() error {
+	headSeq, headOffset := s.loadHead()
+	paths, err := filepath.Glob(filepath.Join(s.dir, s.name+"-*.seg"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		seq, ok := s.segmentSeq(path)
+		if !ok {
+			continue
+		}
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+		if seq < headSeq {
+			if err := os.Remove(path); err != nil {
+				klog.Errorf("failed to remove spill segment %s: %s", path, err.Error())
+			}
+			continue
+		}
+		offset := int64(0)
+		if seq == headSeq {
+			offset = headOffset
+		}
+		count, bytes, err := countRecords(path, offset)
+		if err != nil {
+			return err
+		}
+		if len(s.segments) == 0 {
+			s.readSeq, s.readOffset = seq, offset
+		}
+		s.segments = append(s.segments, path)
+		s.pending += count
+		s.bytes += bytes
+	}
+	return nil
+}
+
+// loadHead returns the position saved by saveHead, or zero if there is none
+func (s *spill) loadHead() (int64, int64) {
+	buf := make([]byte, spillHeadBytes)
+	if n, _ := s.head.ReadAt(buf, 0); n != spillHeadBytes {
+		return 0, 0
+	}
+	return int64(binary.BigEndian.Uint64(buf)), int64(binary.BigEndian.Uint64(buf[8:]))
+}
+
+// saveHead saves the position after the last record read, the records before are not recovered
+func (s *spill) saveHead() error {
+	buf := make([]byte, spillHeadBytes)
+	binary.BigEndian.PutUint64(buf, uint64(s.readSeq))
+	binary.BigEndian.PutUint64(buf[8:], uint64(s.readOffset))
+	_, err := s.head.WriteAt(buf, 0)
+	return err
+}
+
+// countRecords returns the number of whole records in the segment after offset, a record partly written is ignored
+func countRecords(path string, offset int64) (int64, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, spillHeaderBytes)
+	count, bytes := int64(0), int64(0)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return count, bytes, nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[8:]))
+		if n, err := reader.Discard(int(length)); err != nil || int64(n) != length {
+			return count, bytes, nil
+		}
+		count++
+		bytes += spillHeaderBytes + length
+	}
+}
+
+// rotate starts a new segment to write
+func (s *spill) rotate() error {
+	if s.bufWriter != nil {
+		if err := s.bufWriter.Flush(); err != nil {
+			return err
+		}
+		_ = s.writer.Close()
+	}
+	path := s.segmentPath(s.nextSeq)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if len(s.segments) == 0 {
+		s.readSeq, s.readOffset = s.nextSeq, 0
+	}
+	s.nextSeq++
+	s.segments = append(s.segments, path)
+	s.writer = file
+	s.bufWriter = bufio.NewWriter(file)
+	s.writerBytes = 0
+	return nil
+}
+
+func (s *spill) write(data []byte) error {
+	if s.writerBytes >= spillSegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	header := make([]byte, spillHeaderBytes)
+	binary.BigEndian.PutUint64(header, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(data)))
+	if _, err := s.bufWriter.Write(header); err != nil {
+		return err
+	}
+	if _, err := s.bufWriter.Write(data); err != nil {
+		return err
+	}
+	s.writerBytes += int64(spillHeaderBytes + len(data))
+	s.pending++
+	s.bytes += int64(spillHeaderBytes + len(data))
+	return nil
+}
+
+// read returns the oldest record not read, which must exist. Drained segments are removed.
+func (s *spill) read() ([]byte, error) {
+	for {
+		if s.reader == nil {
+			file, err := os.Open(s.segments[0])
+			if err != nil {
+				return nil, err
+			}
+			if _, err := file.Seek(s.readOffset, io.SeekStart); err != nil {
+				_ = file.Close()
+				return nil, err
+			}
+			s.reader = file
+			s.bufReader = bufio.NewReader(file)
+		}
+		// records of the segment being written may be buffered
+		if len(s.segments) == 1 {
+			if err := s.bufWriter.Flush(); err != nil {
+				return nil, err
+			}
+		}
+
+		header := make([]byte, spillHeaderBytes)
+		_, err := io.ReadFull(s.bufReader, header)
+		if err == nil {
+			data := make([]byte, binary.BigEndian.Uint32(header[8:]))
+			if _, err = io.ReadFull(s.bufReader, data); err == nil {
+				s.headTime = time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+				s.readOffset += int64(spillHeaderBytes + len(data))
+				return data, nil
+			}
+		}
+		if len(s.segments) == 1 {
+			return nil, err
+		}
+
+		// the segment is drained, a record partly written by the former process is skipped as well
+		_ = s.reader.Close()
+		s.reader = nil
+		if err := os.Remove(s.segments[0]); err != nil {
+			klog.Errorf("failed to remove spill segment %s: %s", s.segments[0], err.Error())
+		}
+		s.segments = s.segments[1:]
+		s.readSeq, _ = s.segmentSeq(s.segments[0])
+		s.readOffset = 0
+	}
+}
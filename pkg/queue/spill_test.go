@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type intCodec struct{}
+
+func (intCodec) Encode(item interface{}) ([]byte, error) {
+	return []byte(strconv.Itoa(item.(int))), nil
+}
+
+func (intCodec) Decode(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	queue := NewBoundedQueue("test-spill", 10, nil)
+	queue.IsDropEventOnFull = false
+	assert.Nil(t, queue.EnableSpill(dir, 0, intCodec{}))
+
+	// the producer is never blocked
+	for i := 0; i < 1000; i++ {
+		assert.True(t, queue.Produce(i))
+	}
+	assert.Equal(t, 1000, queue.Size())
+
+	var mutex sync.Mutex
+	received := make([]int, 0)
+	queue.StartConsumers(1, func(item interface{}) {
+		mutex.Lock()
+		received = append(received, item.(int))
+		mutex.Unlock()
+	})
+	for i := 1000; i < 1100; i++ {
+		assert.True(t, queue.Produce(i))
+	}
+	WaitAllIdle(10*time.Millisecond, 3)
+	queue.Stop()
+
+	assert.Equal(t, 1100, len(received))
+	for i, item := range received {
+		assert.Equal(t, i, item)
+	}
+}
+
+func TestSpillRecover(t *testing.T) {
+	dir := t.TempDir()
+	queue := NewBoundedQueue("test-spill-recover", 1, nil)
+	assert.Nil(t, queue.EnableSpill(dir, 0, intCodec{}))
+	for i := 0; i < 5; i++ {
+		assert.True(t, queue.Produce(i))
+	}
+	// item 0 in memory is lost
+	queue.Stop()
+
+	queue = NewBoundedQueue("test-spill-recover", 1, nil)
+	assert.Nil(t, queue.EnableSpill(dir, 0, intCodec{}))
+	assert.Equal(t, 4, queue.Size())
+
+	var mutex sync.Mutex
+	received := make([]int, 0)
+	queue.StartConsumers(1, func(item interface{}) {
+		mutex.Lock()
+		received = append(received, item.(int))
+		mutex.Unlock()
+	})
+	assert.True(t, queue.Produce(5))
+	WaitAllIdle(10*time.Millisecond, 3)
+	queue.Stop()
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, received)
+}
+
+func TestSpillRecoverConsumed(t *testing.T) {
+	dir := t.TempDir()
+	queue := NewBoundedQueue("test-spill-consumed", 1, nil)
+	assert.Nil(t, queue.EnableSpill(dir, 0, intCodec{}))
+	for i := 0; i < 5; i++ {
+		assert.True(t, queue.Produce(i))
+	}
+	var mutex sync.Mutex
+	received := make([]int, 0)
+	queue.StartConsumers(1, func(item interface{}) {
+		mutex.Lock()
+		received = append(received, item.(int))
+		mutex.Unlock()
+	})
+	WaitAllIdle(10*time.Millisecond, 3)
+	queue.Stop()
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, received)
+
+	// the consumed items are not recovered
+	queue = NewBoundedQueue("test-spill-consumed", 1, nil)
+	assert.Nil(t, queue.EnableSpill(dir, 0, intCodec{}))
+	assert.Equal(t, 0, queue.Size())
+	// 5 in memory is lost, 6 is read by the drainer when stop fires
+	assert.True(t, queue.Produce(5))
+	assert.True(t, queue.Produce(6))
+	time.Sleep(50 * time.Millisecond)
+	queue.Stop()
+
+	queue = NewBoundedQueue("test-spill-consumed", 1, nil)
+	assert.Nil(t, queue.EnableSpill(dir, 0, intCodec{}))
+	assert.Equal(t, 1, queue.Size())
+	received = received[:0]
+	queue.StartConsumers(1, func(item interface{}) {
+		mutex.Lock()
+		received = append(received, item.(int))
+		mutex.Unlock()
+	})
+	WaitAllIdle(10*time.Millisecond, 3)
+	queue.Stop()
+	assert.Equal(t, []int{6}, received)
+}
+
+func TestSpillMaxBytes(t *testing.T) {
+	dropped := 0
+	queue := NewBoundedQueue("test-spill-max", 1, func(item interface{}) {
+		dropped++
+	})
+	// room for 2 records of one digit
+	assert.Nil(t, queue.EnableSpill(t.TempDir(), 2*(spillHeaderBytes+1), intCodec{}))
+	for i := 0; i < 5; i++ {
+		queue.Produce(i)
+	}
+	assert.Equal(t, 2, dropped)
+	assert.Equal(t, 3, queue.Size())
+	queue.Stop()
+}
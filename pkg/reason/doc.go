@@ -6,4 +6,11 @@ Package podphase 记录一个Pod上所有的操作
 */
 package reason
 
+import "github.com/alipay/container-observability-service/pkg/featuregates"
+
 const NewReasonFeature = "NewReasonFeature"
+
+func init() {
+	// checked on each analysis
+	featuregates.Register(NewReasonFeature, featuregates.FeatureSpec{Default: false, PreRelease: featuregates.Alpha, Dynamic: true})
+}
@@ -0,0 +1,260 @@
+package replayer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	"k8s.io/klog/v2"
+)
+
+const (
+	dedupIndexName = "lunettes_audit_dedup"
+	dedupMapping   = `
+	{
+		"mappings": {
+			"enabled": false
+		}
+	}`
+)
+
+var (
+	duplicatedEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricNamePrefix + "audit_duplicated_events",
+			Help: "number of audit events dropped because they have been processed",
+		},
+		[]string{"cluster"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(duplicatedEvents)
+}
+
+type dedupEntry struct {
+	Key  string
+	Time int64
+}
+
+// auditDedupWindow remembers the AuditID of recently processed events, which is bounded by
+// the time behind the latest event and by the number of events.
+type auditDedupWindow struct {
+	window  time.Duration
+	maxSize int
+
+	mutex   sync.Mutex
+	keys    map[string]struct{}
+	entries []dedupEntry // in the order of being processed
+	latest  int64
+	changed bool
+}
+
+func newAuditDedupWindow(window time.Duration, maxSize int) *auditDedupWindow {
+	return &auditDedupWindow{
+		window:  window,
+		maxSize: maxSize,
+		keys:    make(map[string]struct{}),
+		entries: make([]dedupEntry, 0),
+	}
+}
+
+// Seen returns true if the event has been processed, otherwise the event is recorded.
+// Every stage of a request is an event with the same AuditID, so stage is part of the key.
+func (w *auditDedupWindow) Seen(event *k8s_audit.Event) bool {
+	if event.AuditID == "" {
+		return false
+	}
+	key := string(event.AuditID) + "/" + string(event.Stage)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.keys[key]; ok {
+		return true
+	}
+	w.add(dedupEntry{Key: key, Time: event.StageTimestamp.UnixNano()})
+	w.changed = true
+	return false
+}
+
+func (w *auditDedupWindow) add(entry dedupEntry) {
+	w.keys[entry.Key] = struct{}{}
+	w.entries = append(w.entries, entry)
+	if entry.Time > w.latest {
+		w.latest = entry.Time
+	}
+
+	expired := w.latest - w.window.Nanoseconds()
+	for len(w.entries) > 0 && (len(w.entries) > w.maxSize || w.entries[0].Time < expired) {
+		delete(w.keys, w.entries[0].Key)
+		w.entries = w.entries[1:]
+	}
+}
+
+// marshal returns nil if nothing is changed since last marshal
+func (w *auditDedupWindow) marshal() ([]byte, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.changed {
+		return nil, nil
+	}
+	data, err := json.Marshal(w.entries)
+	if err == nil {
+		w.changed = false
+	}
+	return data, err
+}
+
+func (w *auditDedupWindow) unmarshal(data []byte) error {
+	entries := make([]dedupEntry, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, entry := range entries {
+		w.add(entry)
+	}
+	return nil
+}
+
+// AuditIDStore persists the dedupe window, so that events re-read after restart are not processed twice.
+type AuditIDStore interface {
+	Load() ([]byte, error)
+	Save(data []byte) error
+}
+
+type esAuditIDStore struct {
+	client *elastic.Client
+	docID  string
+}
+
+// NewESAuditIDStore saves the dedupe window of cluster in elasticsearch
+func NewESAuditIDStore(conf *xsearch.ElasticSearchConf, cluster string) (AuditIDStore, error) {
+	client, err := elastic.NewClient(elastic.SetURL(conf.Endpoint),
+		elastic.SetBasicAuth(conf.User, conf.Password),
+		elastic.SetSniff(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := xsearch.EnsureIndex(client, dedupIndexName, dedupMapping); err != nil {
+		return nil, err
+	}
+	return &esAuditIDStore{client: client, docID: cluster}, nil
+}
+
+type dedupDoc struct {
+	Entries string
+}
+
+func (s *esAuditIDStore) Load() ([]byte, error) {
+	result, err := s.client.Get().Index(dedupIndexName).Id(s.docID).Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &dedupDoc{}
+	if err := json.Unmarshal(result.Source, doc); err != nil {
+		return nil, err
+	}
+	return []byte(doc.Entries), nil
+}
+
+func (s *esAuditIDStore) Save(data []byte) error {
+	_, err := s.client.Index().Index(dedupIndexName).Id(s.docID).
+		BodyJson(&dedupDoc{Entries: string(data)}).
+		Do(context.Background())
+	return err
+}
+
+type fileAuditIDStore struct {
+	path string
+}
+
+// NewFileAuditIDStore saves the dedupe window to a local file
+func NewFileAuditIDStore(path string) AuditIDStore {
+	return &fileAuditIDStore{path: path}
+}
+
+func (s *fileAuditIDStore) Load() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *fileAuditIDStore) Save(data []byte) error {
+	return writeFileAtomic(s.path, data)
+}
+
+// loadDedupWindow restores the window saved in store
+func loadDedupWindow(window *auditDedupWindow, store AuditIDStore) {
+	data, err := store.Load()
+	if err != nil {
+		klog.Errorf("failed to load audit dedupe window: %s", err.Error())
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	if err := window.unmarshal(data); err != nil {
+		klog.Errorf("failed unmarshal audit dedupe window: %s", err.Error())
+		return
+	}
+	klog.Infof("audit dedupe window restored, %d events", len(window.entries))
+}
+
+// dedupSnapshot is the dedupe window at a read checkpoint
+type dedupSnapshot struct {
+	window *auditDedupWindow
+	store  AuditIDStore
+	data   []byte
+}
+
+// snapshotDedupWindow returns the window to save, nil if it is not changed since the last snapshot
+func snapshotDedupWindow(window *auditDedupWindow, store AuditIDStore) *dedupSnapshot {
+	data, err := window.marshal()
+	if err != nil {
+		klog.Errorf("failed marshal audit dedupe window: %s", err.Error())
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	return &dedupSnapshot{window: window, store: store, data: data}
+}
+
+// save persists the window once the read checkpoint is saved. A window older than the checkpoint lets a few
+// events re-read after restart through, a newer one would drop the events after the checkpoint.
+func (s *dedupSnapshot) save() {
+	if s == nil {
+		return
+	}
+	if err := s.store.Save(s.data); err != nil {
+		klog.Errorf("failed to save audit dedupe window: %s", err.Error())
+		s.discard()
+	}
+}
+
+// discard keeps the window changed, so the next checkpoint saves it
+func (s *dedupSnapshot) discard() {
+	if s == nil {
+		return
+	}
+	s.window.mutex.Lock()
+	s.window.changed = true
+	s.window.mutex.Unlock()
+}
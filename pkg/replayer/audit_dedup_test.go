@@ -0,0 +1,136 @@
+package replayer
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func dedupEvent(id string, stage k8s_audit.Stage, t time.Time) *k8s_audit.Event {
+	return &k8s_audit.Event{
+		AuditID:        types.UID(id),
+		Stage:          stage,
+		StageTimestamp: metav1.NewMicroTime(t),
+	}
+}
+
+func TestAuditDedupWindow(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := newAuditDedupWindow(time.Minute, 3)
+
+	assert.False(t, window.Seen(dedupEvent("1", k8s_audit.StageResponseComplete, base)))
+	assert.True(t, window.Seen(dedupEvent("1", k8s_audit.StageResponseComplete, base)))
+	// another stage of the same request
+	assert.False(t, window.Seen(dedupEvent("1", k8s_audit.StageRequestReceived, base)))
+	// events without AuditID are never dropped
+	assert.False(t, window.Seen(dedupEvent("", k8s_audit.StageResponseComplete, base)))
+	assert.False(t, window.Seen(dedupEvent("", k8s_audit.StageResponseComplete, base)))
+
+	// evicted by time
+	assert.False(t, window.Seen(dedupEvent("2", k8s_audit.StageResponseComplete, base.Add(2*time.Minute))))
+	assert.False(t, window.Seen(dedupEvent("1", k8s_audit.StageResponseComplete, base)))
+
+	// evicted by size
+	window = newAuditDedupWindow(time.Hour, 3)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		assert.False(t, window.Seen(dedupEvent(id, k8s_audit.StageResponseComplete, base)))
+	}
+	assert.False(t, window.Seen(dedupEvent("1", k8s_audit.StageResponseComplete, base)))
+	assert.True(t, window.Seen(dedupEvent("4", k8s_audit.StageResponseComplete, base)))
+}
+
+func TestAuditDedupWindowRestore(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewFileAuditIDStore(filepath.Join(t.TempDir(), "audit.dedup"))
+
+	// nothing saved yet
+	window := newAuditDedupWindow(time.Minute, 100)
+	loadDedupWindow(window, store)
+	assert.Equal(t, 0, len(window.entries))
+
+	window.Seen(dedupEvent("1", k8s_audit.StageResponseComplete, base))
+	window.Seen(dedupEvent("2", k8s_audit.StageResponseComplete, base.Add(time.Second)))
+	snapshotDedupWindow(window, store).save()
+	data, err := window.marshal()
+	assert.Nil(t, err)
+	assert.Nil(t, data, "nothing changed after save")
+
+	// restarted
+	window = newAuditDedupWindow(time.Minute, 100)
+	loadDedupWindow(window, store)
+	assert.True(t, window.Seen(dedupEvent("1", k8s_audit.StageResponseComplete, base)))
+	assert.True(t, window.Seen(dedupEvent("2", k8s_audit.StageResponseComplete, base.Add(time.Second))))
+	assert.False(t, window.Seen(dedupEvent("3", k8s_audit.StageResponseComplete, base.Add(time.Second))))
+}
+
+func configMapLine(t *testing.T, auditID string) string {
+	event := &k8s_audit.Event{
+		AuditID:        types.UID(auditID),
+		Stage:          k8s_audit.StageResponseComplete,
+		Verb:           "update",
+		StageTimestamp: metav1.NewMicroTime(time.Now()),
+		ResponseStatus: &metav1.Status{Code: 200},
+		ObjectRef: &k8s_audit.ObjectReference{
+			Resource:  "configmaps",
+			Namespace: "default",
+			Name:      "cm-" + auditID,
+		},
+		RequestURI: "/api/v1/namespaces/default/configmaps/" + strings.Repeat("x", fingerprintSize),
+	}
+	data, err := json.Marshal(event)
+	assert.Nil(t, err)
+	return string(data) + "\n"
+}
+
+func TestAuditDedupWindowCrashRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	cluster := "test-dedup-crash"
+	start := func() (*AuditProcessor, *fileReader) {
+		var reader *fileReader
+		processor, err := NewAuditProcessor(func(handler AuditEventHandler) (AuditSource, error) {
+			reader = newFileReader(handler, path, "", cluster)
+			return reader, nil
+		}, cluster, false)
+		assert.Nil(t, err)
+		processor.EnableDedup(time.Hour, 100, NewFileAuditIDStore(filepath.Join(dir, "audit.dedup")))
+		loadDedupWindow(processor.dedup, processor.dedupStore)
+		assert.Nil(t, reader.open(reader.loadCheckpoint()))
+		return processor, reader
+	}
+	duplicated := func() float64 {
+		return testutil.ToFloat64(duplicatedEvents.WithLabelValues(cluster))
+	}
+
+	appendFile(t, path, configMapLine(t, "1")+configMapLine(t, "2"))
+	processor, reader := start()
+	reader.readAvailable()
+	assert.Nil(t, reader.saveCheckpoint(10*time.Second))
+
+	// dispatched after the checkpoint, then killed without Stop
+	appendFile(t, path, configMapLine(t, "3")+configMapLine(t, "4"))
+	reader.readAvailable()
+	assert.True(t, waitEventsConsumed(cluster, 10*time.Second))
+	assert.Equal(t, float64(0), duplicated())
+
+	// the events after the checkpoint are read and processed again
+	processor, reader = start()
+	reader.readAvailable()
+	assert.Equal(t, float64(0), duplicated())
+	assert.Equal(t, int64(len(configMapLine(t, "1"))*4), reader.readOffset())
+
+	// the ones before it are still dropped
+	event := &k8s_audit.Event{}
+	assert.Nil(t, json.Unmarshal([]byte(configMapLine(t, "1")), event))
+	processor.ProcessEvent(event)
+	assert.Equal(t, float64(1), duplicated())
+	reader.Stop()
+}
@@ -0,0 +1,334 @@
+package replayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	"sigs.k8s.io/yaml"
+)
+
+// reasons of the filtered events
+const (
+	filterReasonIgnoredNamespace = "ignored_namespace"
+	filterReasonExcluded         = "excluded"
+	filterReasonNotIncluded      = "not_included"
+)
+
+// fields of the audit index, see the audit mapping in xsearch
+const (
+	auditNamespaceField   = "objectRef.namespace"
+	auditResourceField    = "objectRef.resource"
+	auditSubresourceField = "objectRef.subresource"
+	auditVerbField        = "verb"
+)
+
+var (
+	filteredEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lunettes_audit_filtered_events_count",
+			Help: "audit events dropped by IgnoredNamespaceForAudit or the audit filter policy after they are read",
+		},
+		[]string{"cluster", "reason"},
+	)
+
+	filterPolicy atomic.Value
+)
+
+func init() {
+	prometheus.MustRegister(filteredEvents)
+}
+
+// AuditFilterRule matches an audit event if all of its non-empty fields match, a list matches if any item matches
+type AuditFilterRule struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+	// "pods" matches pods and all of the subresources, "pods/status" only the subresource
+	Resources []string `json:"resources,omitempty"`
+	Verbs     []string `json:"verbs,omitempty"`
+	Users     []string `json:"users,omitempty"`
+	// prefixes of the user agent, e.g. "kube-controller-manager/"
+	UserAgents []string `json:"userAgents,omitempty"`
+	// selectors on the labels and annotations of the object in the event,
+	// events without the object, e.g. most deletions, never match them
+	LabelSelector      string `json:"labelSelector,omitempty"`
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+
+	labels      labels.Selector
+	annotations labels.Selector
+}
+
+// AuditFilterPolicy decides which audit events are processed, an event is dropped if it matches any rule of Exclude,
+// or Include is not empty and it matches none of them. If Trace is not empty, only the events matching
+// one of its rules are sent to span analysis and tracing.
+type AuditFilterPolicy struct {
+	Include []*AuditFilterRule `json:"include,omitempty"`
+	Exclude []*AuditFilterRule `json:"exclude,omitempty"`
+	Trace   []*AuditFilterRule `json:"trace,omitempty"`
+}
+
+// LoadAuditFilterPolicy reads the policy of --audit-filter-policy
+func LoadAuditFilterPolicy(path string) (*AuditFilterPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &AuditFilterPolicy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return policy, nil
+}
+
+// SetAuditFilterPolicy applies the policy to the events read after, nil to process all events
+func SetAuditFilterPolicy(policy *AuditFilterPolicy) {
+	filterPolicy.Store(policy)
+}
+
+func currentAuditFilterPolicy() *AuditFilterPolicy {
+	policy, _ := filterPolicy.Load().(*AuditFilterPolicy)
+	return policy
+}
+
+func (policy *AuditFilterPolicy) compile() error {
+	compile := func(what string, rules []*AuditFilterRule) error {
+		for i, rule := range rules {
+			if err := rule.compile(); err != nil {
+				return fmt.Errorf("%s rule %d: %s", what, i, err.Error())
+			}
+		}
+		return nil
+	}
+	if err := compile("include", policy.Include); err != nil {
+		return err
+	}
+	if err := compile("exclude", policy.Exclude); err != nil {
+		return err
+	}
+	return compile("trace", policy.Trace)
+}
+
+func (rule *AuditFilterRule) compile() error {
+	if len(rule.Namespaces) == 0 && len(rule.Resources) == 0 && len(rule.Verbs) == 0 && len(rule.Users) == 0 &&
+		len(rule.UserAgents) == 0 && rule.LabelSelector == "" && rule.AnnotationSelector == "" {
+		return fmt.Errorf("empty rule matches all events")
+	}
+	var err error
+	if rule.LabelSelector != "" {
+		if rule.labels, err = labels.Parse(rule.LabelSelector); err != nil {
+			return fmt.Errorf("invalid labelSelector: %s", err.Error())
+		}
+	}
+	if rule.AnnotationSelector != "" {
+		if rule.annotations, err = labels.Parse(rule.AnnotationSelector); err != nil {
+			return fmt.Errorf("invalid annotationSelector: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Allows tells whether the event is processed, and the reason if not
+func (policy *AuditFilterPolicy) Allows(event *k8s_audit.Event) (bool, string) {
+	if policy == nil {
+		return true, ""
+	}
+	// decoded once for all rules
+	meta := &lazyObjectMeta{event: event}
+	for _, rule := range policy.Exclude {
+		if rule.matches(event, meta) {
+			return false, filterReasonExcluded
+		}
+	}
+	if len(policy.Include) == 0 {
+		return true, ""
+	}
+	for _, rule := range policy.Include {
+		if rule.matches(event, meta) {
+			return true, ""
+		}
+	}
+	return false, filterReasonNotIncluded
+}
+
+// Traces tells whether the event is sent to span analysis and tracing
+func (policy *AuditFilterPolicy) Traces(event *k8s_audit.Event) bool {
+	if policy == nil || len(policy.Trace) == 0 {
+		return true
+	}
+	meta := &lazyObjectMeta{event: event}
+	for _, rule := range policy.Trace {
+		if rule.matches(event, meta) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *AuditFilterRule) matches(event *k8s_audit.Event, meta *lazyObjectMeta) bool {
+	if event.ObjectRef == nil {
+		return false
+	}
+	if len(rule.Namespaces) > 0 && !utils.SliceContainsString(rule.Namespaces, event.ObjectRef.Namespace) {
+		return false
+	}
+	if len(rule.Resources) > 0 && !rule.matchesResource(event.ObjectRef) {
+		return false
+	}
+	if len(rule.Verbs) > 0 && !utils.SliceContainsString(rule.Verbs, event.Verb) {
+		return false
+	}
+	if len(rule.Users) > 0 && !utils.SliceContainsString(rule.Users, event.User.Username) {
+		return false
+	}
+	if len(rule.UserAgents) > 0 && !hasAnyPrefix(event.UserAgent, rule.UserAgents) {
+		return false
+	}
+	if rule.labels != nil || rule.annotations != nil {
+		m := meta.get()
+		if m == nil {
+			return false
+		}
+		if rule.labels != nil && !rule.labels.Matches(labels.Set(m.Labels)) {
+			return false
+		}
+		if rule.annotations != nil && !rule.annotations.Matches(labels.Set(m.Annotations)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule *AuditFilterRule) matchesResource(ref *k8s_audit.ObjectReference) bool {
+	for _, r := range rule.Resources {
+		resource, subresource := splitResource(r)
+		if resource == ref.Resource && (subresource == "" || subresource == ref.Subresource) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitResource(r string) (string, string) {
+	if i := strings.Index(r, "/"); i >= 0 {
+		return r[:i], r[i+1:]
+	}
+	return r, ""
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type objectMeta struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// lazyObjectMeta decodes only the metadata of the object in the event, the first time it is needed
+type lazyObjectMeta struct {
+	event   *k8s_audit.Event
+	decoded bool
+	meta    *objectMeta
+}
+
+func (l *lazyObjectMeta) get() *objectMeta {
+	if l.decoded {
+		return l.meta
+	}
+	l.decoded = true
+	for _, obj := range []*runtime.Unknown{l.event.ResponseObject, l.event.RequestObject} {
+		if obj == nil || len(obj.Raw) == 0 {
+			continue
+		}
+		object := &struct {
+			Metadata *objectMeta `json:"metadata"`
+		}{}
+		if err := json.Unmarshal(obj.Raw, object); err == nil && object.Metadata != nil {
+			l.meta = object.Metadata
+			return l.meta
+		}
+	}
+	return nil
+}
+
+// pushDown adds the conditions of the policy that elasticsearch can evaluate to the audit query,
+// so less events are fetched. The events are still checked by Allows.
+func (policy *AuditFilterPolicy) pushDown(query *elastic.BoolQuery) *elastic.BoolQuery {
+	if policy == nil {
+		return query
+	}
+	for _, rule := range policy.Exclude {
+		// dropping only part of the conditions would exclude more than the rule
+		if rule.onlyIndexedFields() {
+			query = query.MustNot(rule.esQuery())
+		}
+	}
+	if len(policy.Include) > 0 {
+		include := elastic.NewBoolQuery()
+		for _, rule := range policy.Include {
+			// the conditions not indexed are dropped, which matches more than the rule
+			if !rule.hasIndexedFields() {
+				return query
+			}
+			include = include.Should(rule.esQuery())
+		}
+		query = query.Must(include)
+	}
+	return query
+}
+
+func (rule *AuditFilterRule) hasIndexedFields() bool {
+	return len(rule.Namespaces) > 0 || len(rule.Resources) > 0 || len(rule.Verbs) > 0
+}
+
+func (rule *AuditFilterRule) onlyIndexedFields() bool {
+	return len(rule.Users) == 0 && len(rule.UserAgents) == 0 && rule.labels == nil && rule.annotations == nil
+}
+
+// esQuery returns the query of the indexed fields of the rule
+func (rule *AuditFilterRule) esQuery() *elastic.BoolQuery {
+	query := elastic.NewBoolQuery()
+	if len(rule.Namespaces) > 0 {
+		query = query.Must(elastic.NewTermsQuery(auditNamespaceField, stringsToInterfaces(rule.Namespaces)...))
+	}
+	if len(rule.Verbs) > 0 {
+		query = query.Must(elastic.NewTermsQuery(auditVerbField, stringsToInterfaces(rule.Verbs)...))
+	}
+	if len(rule.Resources) > 0 {
+		resources := elastic.NewBoolQuery()
+		for _, r := range rule.Resources {
+			resource, subresource := splitResource(r)
+			q := elastic.NewBoolQuery().Must(elastic.NewTermQuery(auditResourceField, resource))
+			if subresource != "" {
+				q = q.Must(elastic.NewTermQuery(auditSubresourceField, subresource))
+			}
+			resources = resources.Should(q)
+		}
+		query = query.Must(resources)
+	}
+	return query
+}
+
+func stringsToInterfaces(s []string) []interface{} {
+	result := make([]interface{}, 0, len(s))
+	for _, v := range s {
+		result = append(result, v)
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package replayer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/stretchr/testify/assert"
+	authnv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+const testFilterPolicy = `
+exclude:
+- namespaces: [cluster-loader]
+- userAgents: [kube-controller-manager/]
+  verbs: [update, patch]
+include:
+- resources: [pods, nodes/status]
+- labelSelector: tenant in (a,b)
+trace:
+- annotationSelector: lunettes.io/trace=true
+`
+
+func loadTestFilterPolicy(t *testing.T, policy string) (*AuditFilterPolicy, error) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(policy), 0644))
+	return LoadAuditFilterPolicy(path)
+}
+
+func newFilterTestEvent(namespace, resource, subresource, verb, userAgent, object string) *k8s_audit.Event {
+	event := &k8s_audit.Event{
+		Verb:      verb,
+		User:      authnv1.UserInfo{Username: "system:admin"},
+		UserAgent: userAgent,
+		ObjectRef: &k8s_audit.ObjectReference{Namespace: namespace, Resource: resource, Subresource: subresource},
+	}
+	if object != "" {
+		event.ResponseObject = &runtime.Unknown{Raw: []byte(object)}
+	}
+	return event
+}
+
+func TestAuditFilterPolicy(t *testing.T) {
+	policy, err := loadTestFilterPolicy(t, testFilterPolicy)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		event   *k8s_audit.Event
+		allowed bool
+		reason  string
+	}{
+		{newFilterTestEvent("ns", "pods", "", "create", "kubectl", ""), true, ""},
+		{newFilterTestEvent("cluster-loader", "pods", "", "create", "kubectl", ""), false, filterReasonExcluded},
+		{newFilterTestEvent("ns", "pods", "", "update", "kube-controller-manager/v1.18", ""), false, filterReasonExcluded},
+		{newFilterTestEvent("ns", "pods", "binding", "create", "kube-controller-manager/v1.18", ""), true, ""},
+		{newFilterTestEvent("", "nodes", "status", "patch", "kubelet", ""), true, ""},
+		{newFilterTestEvent("", "nodes", "", "patch", "kubelet", ""), false, filterReasonNotIncluded},
+		{newFilterTestEvent("ns", "deployments", "", "create", "kubectl", `{"metadata":{"labels":{"tenant":"a"}}}`), true, ""},
+		{newFilterTestEvent("ns", "deployments", "", "create", "kubectl", `{"metadata":{"labels":{"tenant":"c"}}}`), false, filterReasonNotIncluded},
+		// no object to match the labels
+		{newFilterTestEvent("ns", "deployments", "", "delete", "kubectl", ""), false, filterReasonNotIncluded},
+	}
+	for i, c := range cases {
+		allowed, reason := policy.Allows(c.event)
+		assert.Equal(t, c.allowed, allowed, "case %d", i)
+		assert.Equal(t, c.reason, reason, "case %d", i)
+	}
+
+	assert.True(t, policy.Traces(newFilterTestEvent("ns", "pods", "", "create", "kubectl",
+		`{"metadata":{"annotations":{"lunettes.io/trace":"true"}}}`)))
+	assert.False(t, policy.Traces(newFilterTestEvent("ns", "pods", "", "create", "kubectl", "")))
+
+	// all events by default
+	var none *AuditFilterPolicy
+	allowed, _ := none.Allows(cases[1].event)
+	assert.True(t, allowed)
+	assert.True(t, none.Traces(cases[1].event))
+}
+
+func TestLoadInvalidAuditFilterPolicy(t *testing.T) {
+	_, err := loadTestFilterPolicy(t, "exclude:\n- namespace: [a]\n")
+	assert.NotNil(t, err)
+	_, err = loadTestFilterPolicy(t, "include:\n- {}\n")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "include rule 0: empty rule")
+	_, err = loadTestFilterPolicy(t, "trace:\n- labelSelector: 'a in b'\n")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "trace rule 0: invalid labelSelector")
+}
+
+func TestAuditFilterPushDown(t *testing.T) {
+	policy, err := loadTestFilterPolicy(t, testFilterPolicy)
+	assert.Nil(t, err)
+	SetAuditFilterPolicy(policy)
+	defer SetAuditFilterPolicy(nil)
+
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	source, err := auditQuery(&xsearch.ElasticSearchConf{}, "c1", from, from.Add(time.Minute)).Source()
+	assert.Nil(t, err)
+	query := source.(map[string]interface{})["bool"].(map[string]interface{})
+	// the exclude rule with the user agent is not pushed down
+	mustNot := query["must_not"].(map[string]interface{})["bool"].(map[string]interface{})["must"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"cluster-loader"}, mustNot["terms"].(map[string]interface{})[auditNamespaceField])
+	// the include rule with the label selector matches all events in elasticsearch
+	assert.Len(t, query["must"], 1)
+
+	policy, err = loadTestFilterPolicy(t, "include:\n- resources: [pods]\n  verbs: [create]\n")
+	assert.Nil(t, err)
+	SetAuditFilterPolicy(policy)
+	source, err = auditQuery(&xsearch.ElasticSearchConf{}, "c1", from, from.Add(time.Minute)).Source()
+	assert.Nil(t, err)
+	query = source.(map[string]interface{})["bool"].(map[string]interface{})
+	assert.Len(t, query["must"], 2)
+	assert.Nil(t, query["must_not"])
+}
@@ -0,0 +1,141 @@
+package replayer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/olivere/elastic/v7"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultAuditIndexPattern   = "append_only.{index}.{date}"
+	DefaultAuditTimestampField = "stageTimestamp"
+	DefaultAuditClusterField   = "annotations.cluster.keyword"
+
+	defaultIndexDateLayout = "2006-01-02"
+	// an index not created yet is checked again after this duration
+	missingIndexRecheck = time.Minute
+)
+
+// auditIndexNames returns the names given by pattern for every day in [from, to], in the local time zone.
+func auditIndexNames(pattern, index, cluster string, from, to time.Time) []string {
+	name := strings.ReplaceAll(pattern, "{index}", index)
+	name = strings.ReplaceAll(name, "{cluster}", cluster)
+
+	start := strings.Index(name, "{date")
+	if start < 0 {
+		return []string{name}
+	}
+	end := strings.Index(name[start:], "}")
+	if end < 0 {
+		return []string{name}
+	}
+	end += start
+	layout := defaultIndexDateLayout
+	if strings.HasPrefix(name[start:end], "{date:") {
+		layout = name[start+len("{date:") : end]
+	}
+
+	names := make([]string, 0)
+	from, to = from.Local(), to.Local()
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	for !day.After(to) {
+		n := name[:start] + day.Format(layout) + name[end+1:]
+		if len(names) == 0 || names[len(names)-1] != n {
+			names = append(names, n)
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return names
+}
+
+type indexState struct {
+	exists    bool
+	checkedAt time.Time
+}
+
+// auditIndexResolver tells which indexes to read the audit events of a time range from
+type auditIndexResolver struct {
+	client  *elastic.Client
+	conf    *xsearch.ElasticSearchConf
+	cluster string
+
+	mutex   sync.Mutex
+	indexes map[string]*indexState
+}
+
+func newAuditIndexResolver(client *elastic.Client, conf *xsearch.ElasticSearchConf, cluster string) *auditIndexResolver {
+	return &auditIndexResolver{
+		client:  client,
+		conf:    conf,
+		cluster: cluster,
+		indexes: make(map[string]*indexState),
+	}
+}
+
+// resolve returns the comma separated indexes holding the events in [from, to),
+// which are more than one if the range crosses a day boundary.
+func (r *auditIndexResolver) resolve(from, to time.Time) string {
+	if r.conf.IndexPattern == "" {
+		return r.conf.Index
+	}
+
+	existing := make([]string, 0)
+	for _, name := range auditIndexNames(r.conf.IndexPattern, r.conf.Index, r.cluster, from, to) {
+		if r.exists(name) {
+			existing = append(existing, name)
+		}
+	}
+	if len(existing) == 0 {
+		return r.conf.Index
+	}
+	return strings.Join(existing, ",")
+}
+
+func (r *auditIndexResolver) exists(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state, ok := r.indexes[name]
+	if ok && (state.exists || time.Since(state.checkedAt) < missingIndexRecheck) {
+		return state.exists
+	}
+
+	exists, err := r.client.IndexExists(name).Do(context.Background())
+	if err != nil {
+		klog.Errorf("failed to check index %s: %s", name, err.Error())
+		return false
+	}
+	if exists && (!ok || !state.exists) {
+		klog.Infof("[%s]audit index %s is found", r.cluster, name)
+	}
+	r.indexes[name] = &indexState{exists: exists, checkedAt: time.Now()}
+	return exists
+}
+
+// auditQuery returns the query of audit events of cluster in [from, to)
+func auditQuery(conf *xsearch.ElasticSearchConf, cluster string, from, to time.Time) *elastic.BoolQuery {
+	query := elastic.NewBoolQuery().Must(auditTimeRangeQuery(conf, from, to))
+	if conf.ClusterField != "" {
+		query = query.Must(elastic.NewTermQuery(conf.ClusterField, cluster))
+	}
+	// dropped before they are fetched
+	if namespaces := config.GlobalLunettesConfig().IgnoredNamespaceForAudit; len(namespaces) > 0 {
+		query = query.MustNot(elastic.NewTermsQuery(auditNamespaceField, stringsToInterfaces(namespaces)...))
+	}
+	return currentAuditFilterPolicy().pushDown(query)
+}
+
+func auditTimeRangeQuery(conf *xsearch.ElasticSearchConf, from, to time.Time) *elastic.RangeQuery {
+	return elastic.NewRangeQuery(conf.AuditTimestampField()).
+		From(from).To(to).
+		IncludeLower(true).
+		IncludeUpper(false).
+		TimeZone("UTC")
+}
@@ -0,0 +1,86 @@
+package replayer
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/shares"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAuditIndexNames(t *testing.T) {
+	day := time.Date(2023, 1, 1, 23, 59, 50, 0, time.Local)
+
+	assert.Equal(t, []string{"append_only.audit.2023-01-01"},
+		auditIndexNames(DefaultAuditIndexPattern, "audit", "c1", day, day.Add(5*time.Second)))
+	// across a day boundary
+	assert.Equal(t, []string{"append_only.audit.2023-01-01", "append_only.audit.2023-01-02"},
+		auditIndexNames(DefaultAuditIndexPattern, "audit", "c1", day, day.Add(30*time.Second)))
+	assert.Equal(t, []string{"audit-c1-2023.01.01", "audit-c1-2023.01.02"},
+		auditIndexNames("{index}-{cluster}-{date:2006.01.02}", "audit", "c1", day, day.Add(30*time.Second)))
+	// data streams or aliases
+	assert.Equal(t, []string{"logs-audit-c1"},
+		auditIndexNames("logs-audit-{cluster}", "audit", "c1", day, day.Add(30*time.Second)))
+	// monthly indexes
+	assert.Equal(t, []string{"audit-2022.12", "audit-2023.01"},
+		auditIndexNames("audit-{date:2006.01}", "audit", "c1", day.AddDate(0, 0, -3), day.AddDate(0, 0, 10)))
+}
+
+func TestAuditQuery(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	conf := &xsearch.ElasticSearchConf{ClusterField: DefaultAuditClusterField}
+
+	source, err := auditQuery(conf, "c1", from, from.Add(time.Minute)).Source()
+	assert.Nil(t, err)
+	must := source.(map[string]interface{})["bool"].(map[string]interface{})["must"].([]interface{})
+	assert.Equal(t, 2, len(must))
+	assert.Contains(t, must[0].(map[string]interface{})["range"], DefaultAuditTimestampField)
+	assert.Equal(t, "c1", must[1].(map[string]interface{})["term"].(map[string]interface{})[DefaultAuditClusterField])
+
+	conf = &xsearch.ElasticSearchConf{TimestampField: "@timestamp"}
+	source, err = auditQuery(conf, "c1", from, from.Add(time.Minute)).Source()
+	assert.Nil(t, err)
+	// not filtered by cluster
+	only := source.(map[string]interface{})["bool"].(map[string]interface{})["must"].(map[string]interface{})
+	assert.Contains(t, only["range"], "@timestamp")
+}
+
+func TestReadCursors(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	hit := func(auditID string, staged, ingested time.Time, field string) *shares.HitEvent {
+		source := map[string]interface{}{
+			"auditID":        auditID,
+			"stageTimestamp": staged.Format(metav1.RFC3339Micro),
+			"@timestamp":     ingested.Format(time.RFC3339Nano),
+			"ingest":         map[string]interface{}{"time": ingested.UnixNano() / int64(time.Millisecond)},
+		}
+		data, err := json.Marshal(source)
+		assert.Nil(t, err)
+		raw := json.RawMessage(data)
+		h := shares.NewHitEvent()
+		h.UnmarshalToEvent(&raw, field)
+		h.Wait()
+		return h
+	}
+
+	// ordered by stageTimestamp, ingested out of order
+	for _, field := range []string{"@timestamp", "ingest.time"} {
+		hits := []*shares.HitEvent{
+			hit("1", base, base.Add(3*time.Second), field),
+			hit("2", base.Add(time.Second), base.Add(2*time.Second), field),
+			hit("3", base.Add(2*time.Second), base.Add(4*time.Second), field),
+		}
+		cursors := readCursors(hits)
+		assert.True(t, cursors[0].Equal(base.Add(2*time.Second)), field)
+		assert.True(t, cursors[1].Equal(base.Add(2*time.Second)), field)
+		assert.True(t, cursors[2].Equal(base.Add(4*time.Second)), field)
+	}
+
+	// by stageTimestamp if not configured
+	hits := []*shares.HitEvent{hit("1", base, base.Add(3*time.Second), DefaultAuditTimestampField)}
+	assert.True(t, readCursors(hits)[0].Equal(base))
+}
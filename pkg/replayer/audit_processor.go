@@ -1,59 +1,246 @@
 package replayer
 
 import (
+	"sync/atomic"
 	"time"
 
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/featuregates"
+	"github.com/alipay/container-observability-service/pkg/nodeyaml"
+	"github.com/alipay/container-observability-service/pkg/podphase"
+	"github.com/alipay/container-observability-service/pkg/podyaml"
+	"github.com/alipay/container-observability-service/pkg/queue"
+	"github.com/alipay/container-observability-service/pkg/shares"
 	"github.com/alipay/container-observability-service/pkg/slo"
+	"github.com/alipay/container-observability-service/pkg/spans"
+	"github.com/alipay/container-observability-service/pkg/trace"
 	"github.com/alipay/container-observability-service/pkg/utils"
 
-	"github.com/alipay/container-observability-service/pkg/xsearch"
 	corev1 "k8s.io/api/core/v1"
 	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
 	"k8s.io/klog/v2"
 )
 
+// namespaces of IgnoredNamespaceForAudit, updated when the lunettes config changes
+var ignoredNamespaces atomic.Value
+
+func init() {
+	config.OnLunettesConfigChange("replayer", func(_, c *config.LunettesConfig) {
+		namespaces := make(map[string]struct{}, len(c.IgnoredNamespaceForAudit))
+		for _, ns := range c.IgnoredNamespaceForAudit {
+			namespaces[ns] = struct{}{}
+		}
+		ignoredNamespaces.Store(namespaces)
+	})
+}
+
+func isIgnoredNamespace(ns string) bool {
+	namespaces, _ := ignoredNamespaces.Load().(map[string]struct{})
+	_, ok := namespaces[ns]
+	return ok
+}
+
 type AuditEvent struct {
 	Pod   *corev1.Pod
 	Event *k8s_audit.Event
 }
 
 type AuditProcessor struct {
-	// event input queue
-	reader *logReader
-	// TODO not used yet.
+	// event input source
+	source AuditSource
+	// cluster of the events
 	cluster     string
 	enableTrace bool
+
+	// drops the events processed already, nil if deduplication is disabled
+	dedup      *auditDedupWindow
+	dedupStore AuditIDStore
+
+	// only the objects of the shard are processed
+	shard utils.Shard
+
+	// counts the events in the consumer queues, which the read checkpoint waits for
+	state *checkpointState
 }
 
-// NewAuditProcessor create new audit log processor
-func NewAuditProcessor(
-	esConf *xsearch.ElasticSearchConf,
-	buffer, interval, traceTimeout time.Duration,
-	cluster string, enableTrace bool) (*AuditProcessor, error) {
+// NewAuditProcessor create new audit log processor reading events from the source created by newSource
+func NewAuditProcessor(newSource AuditSourceFactory, cluster string, enableTrace bool) (*AuditProcessor, error) {
+	// consumers not fed are not waited for
+	shares.SetDAGNodeEnabled(shares.SpanProcessNode, featuregates.IsEnabled(spans.SpanAnalysisFeature))
+	shares.SetDAGNodeEnabled(trace.DAGNode, featuregates.IsEnabled(trace.TraceFeature))
+	if err := shares.ValidateDAG(); err != nil {
+		return nil, err
+	}
+	if err := shares.ValidateProcessors(); err != nil {
+		return nil, err
+	}
+
 	auditProcessor := &AuditProcessor{
 		cluster:     cluster,
 		enableTrace: enableTrace,
+		state:       checkpointStateOf(cluster),
 	}
-	slo.ClusterName = cluster
-	xsearch.EsConfig = esConf
 
-	reader, err := newLogReader(auditProcessor,
-		esConf, buffer, interval,
-		auditProcessor.cluster)
+	source, err := newSource(auditProcessor.ProcessEvent)
 
-	auditProcessor.reader = reader
+	auditProcessor.source = source
 	return auditProcessor, err
 }
 
+// EnableDedup drops the events whose AuditID has been processed in the last window,
+// the window is saved with the read checkpoint of the source and restored from store on Start,
+// so events before the checkpoint re-read after restart are not processed twice.
+// store could be nil, the window is then kept in memory only.
+func (auditProcessor *AuditProcessor) EnableDedup(window time.Duration, maxSize int, store AuditIDStore) {
+	auditProcessor.dedup = newAuditDedupWindow(window, maxSize)
+	auditProcessor.dedupStore = store
+	if store != nil {
+		// saved with the read checkpoint of the source, so it covers the same events as the milestones
+		auditProcessor.state.setDedup(auditProcessor.dedup, store)
+	}
+}
+
+// SetShard skips the events whose pods belong to other shards, so the SLOs of a pod are always kept by the same
+// aggregator. The cluster shard follows the other objects, and the pods of other shards for the nodes and workloads
+// only. The elasticsearch source does not fetch most of the events skipped.
+func (auditProcessor *AuditProcessor) SetShard(shard utils.Shard) {
+	auditProcessor.shard = shard
+}
+
 // Start satrt processing traces
 func (auditProcessor *AuditProcessor) Start(stopCh <-chan struct{}) {
 	start := time.Now()
 	defer func() {
 		klog.Infof("time for processing: %f minutes", utils.TimeSinceInMinutes(start))
 	}()
-	auditProcessor.reader.Run(stopCh)
+
+	if auditProcessor.dedup != nil && auditProcessor.dedupStore != nil {
+		loadDedupWindow(auditProcessor.dedup, auditProcessor.dedupStore)
+	}
+	// dead letters are re-injected only while events are processed here
+	deadletter.RegisterReplayer(auditProcessor.cluster, auditProcessor.ReprocessEvent)
+	auditProcessor.source.Run(stopCh)
 }
 
 func (auditProcessor *AuditProcessor) Stop() {
-	auditProcessor.reader.Stop()
+	deadletter.UnregisterReplayer(auditProcessor.cluster)
+	auditProcessor.source.Stop()
+}
+
+// ProcessEvent decodes one audit event and dispatches it to all consumer queues
+func (auditProcessor *AuditProcessor) ProcessEvent(event *k8s_audit.Event) {
+	klog.V(8).Infof("got event %s", utils.DumpsEventKeyInfo(event))
+
+	// 此处为忽略某些 namespace 的流量，可以在 configmap 中进行配置
+	// 例如：忽略压测流量 event.ObjectRef.Namespace == "cluster-loader-v3"
+	if event.ObjectRef == nil {
+		return
+	}
+	if isIgnoredNamespace(event.ObjectRef.Namespace) {
+		filteredEvents.WithLabelValues(auditProcessor.cluster, filterReasonIgnoredNamespace).Inc()
+		return
+	}
+	// before the event is decoded
+	if allowed, reason := currentAuditFilterPolicy().Allows(event); !allowed {
+		filteredEvents.WithLabelValues(auditProcessor.cluster, reason).Inc()
+		return
+	}
+
+	route := routeAuditEvent(auditProcessor.shard, event)
+	if route == routeOther {
+		eventsOfOtherShards.WithLabelValues(auditProcessor.cluster).Inc()
+		return
+	}
+
+	if auditProcessor.dedup != nil && auditProcessor.dedup.Seen(event) {
+		klog.V(6).Infof("drop duplicated event %s", utils.DumpsEventKeyInfo(event))
+		duplicatedEvents.WithLabelValues(auditProcessor.cluster).Inc()
+		return
+	}
+
+	if route == routeObserved {
+		eventsObserved.WithLabelValues(auditProcessor.cluster).Inc()
+		auditProcessor.observeEvent(event)
+		return
+	}
+	auditProcessor.dispatchEvent(event)
+}
+
+// ReprocessEvent dispatches the event failed in stage again, e.g. a dead letter after a fix, the dedupe window is skipped.
+// It goes to the queue of stage only, the other consumers have processed it already. An event failed before
+// the queues, in decoding or the processors and extractors, goes to all of them.
+func (auditProcessor *AuditProcessor) ReprocessEvent(event *k8s_audit.Event, stage string) {
+	klog.V(6).Infof("reprocess event %s of %s", utils.DumpsEventKeyInfo(event), stage)
+	if event.ObjectRef == nil {
+		return
+	}
+	q := stageQueue(stage)
+	if q == nil {
+		auditProcessor.dispatchEvent(event)
+		return
+	}
+	shareEvent := auditProcessor.newShareEvent(event)
+	// nothing else is fed with it to wait for
+	shareEvent.FinishAllProcess()
+	q.Produce(shareEvent)
+}
+
+// stageQueue returns the queue of the stage named in dead letters, nil for the stages before the queues
+func stageQueue(stage string) *queue.BoundedQueue {
+	switch stage {
+	case "podphase":
+		return podphase.WatcherQueue
+	case "podyaml":
+		return podyaml.Queue
+	case "nodeyaml":
+		return nodeyaml.Queue
+	case "spans":
+		return spans.WatcherQueue
+	case "trace":
+		return trace.WatcherQueue
+	}
+	return slo.StageQueue(stage)
+}
+
+// newShareEvent wraps the event, the pods, events and nodes are decoded by the processors
+func (auditProcessor *AuditProcessor) newShareEvent(event *k8s_audit.Event) *shares.AuditEvent {
+	shareEvent := shares.NewAuditEvent(event)
+	shareEvent.Track(auditProcessor.state)
+	if event.ObjectRef.Resource == "pods" || event.ObjectRef.Resource == "events" || event.ObjectRef.Resource == "nodes" {
+		shareEvent.Process()
+	}
+	return shareEvent
+}
+
+// observeEvent hands the event of a pod of another shard to the trackers of the nodes and workloads only
+func (auditProcessor *AuditProcessor) observeEvent(event *k8s_audit.Event) {
+	shareEvent := auditProcessor.newShareEvent(event)
+	shareEvent.Observed = true
+	// nothing else is fed with it to wait for
+	shareEvent.FinishAllProcess()
+	slo.Queue.Produce(shareEvent)
+}
+
+// dispatchEvent decodes the event and puts it into all consumer queues
+func (auditProcessor *AuditProcessor) dispatchEvent(event *k8s_audit.Event) {
+	shareEvent := auditProcessor.newShareEvent(event)
+
+	// 将 event 放入 queue 中
+	slo.Queue.Produce(shareEvent)             // 这个队列是用于 SLO 的
+	podphase.WatcherQueue.Produce(shareEvent) // 这个队列是用于 pod phase 的
+	podyaml.Queue.Produce(shareEvent)         // pod yaml
+	nodeyaml.Queue.Produce(shareEvent)        // node yaml
+	// only the tenants selected by the filter policy are traced, the consumers waiting for tracing are released
+	traced := currentAuditFilterPolicy().Traces(event)
+	if !traced {
+		shareEvent.FinishProcess(shares.SpanProcessNode)
+		shareEvent.FinishProcess(trace.DAGNode)
+	}
+	if traced && featuregates.IsEnabled(spans.SpanAnalysisFeature) {
+		spans.WatcherQueue.Produce(shareEvent)
+	}
+	if traced && featuregates.IsEnabled(trace.TraceFeature) {
+		trace.WatcherQueue.Produce(shareEvent)
+	}
 }
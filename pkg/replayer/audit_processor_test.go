@@ -0,0 +1,31 @@
+package replayer
+
+import (
+	"testing"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/podphase"
+	"github.com/alipay/container-observability-service/pkg/slo"
+	"github.com/alipay/container-observability-service/pkg/spans"
+	"github.com/alipay/container-observability-service/pkg/trace"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageQueue(t *testing.T) {
+	assert.Equal(t, podphase.WatcherQueue, stageQueue("podphase"))
+	assert.NotNil(t, stageQueue("slo_create"))
+	assert.Equal(t, slo.StageQueue("slo_delete"), stageQueue("slo_delete"))
+	assert.NotEqual(t, stageQueue("slo_create"), stageQueue("slo_upgrade"))
+	// the trackers only, not the SLOs of the pods
+	assert.NotNil(t, stageQueue("slo_rollout"))
+	assert.NotEqual(t, slo.Queue, stageQueue("slo_rollout"))
+	assert.NotEqual(t, stageQueue("slo_rollout"), stageQueue("slo_job"))
+	assert.NotNil(t, stageQueue("slo_node"))
+	assert.Equal(t, spans.WatcherQueue, stageQueue("spans"))
+	assert.Equal(t, trace.WatcherQueue, stageQueue("trace"))
+	// failed before the queues
+	for _, stage := range []string{deadletter.StageDecode, "processor", "extractor"} {
+		assert.Nil(t, stageQueue(stage))
+	}
+}
@@ -6,15 +6,9 @@ import (
 	"flag"
 	"fmt"
 
-	"github.com/alipay/container-observability-service/pkg/config"
-	"github.com/alipay/container-observability-service/pkg/featuregates"
-	"github.com/alipay/container-observability-service/pkg/nodeyaml"
-	"github.com/alipay/container-observability-service/pkg/podphase"
-	"github.com/alipay/container-observability-service/pkg/podyaml"
+	"github.com/alipay/container-observability-service/pkg/deadletter"
 	"github.com/alipay/container-observability-service/pkg/shares"
 	"github.com/alipay/container-observability-service/pkg/slo"
-	"github.com/alipay/container-observability-service/pkg/spans"
-	"github.com/alipay/container-observability-service/pkg/trace"
 
 	"io"
 	"runtime"
@@ -35,8 +29,17 @@ import (
 
 type metaConf struct {
 	LastReadTime string
+	// generation of the milestone snapshots saved with LastReadTime
+	MilestoneGeneration string
 }
 
+const (
+	// the read checkpoint is saved at most every checkpointInterval, after the consumers caught up in checkpointTimeout
+	checkpointInterval    = 20 * time.Second
+	checkpointTimeout     = 10 * time.Second
+	stopCheckpointTimeout = 30 * time.Second
+)
+
 const (
 	metricNamePrefix = "lunettes_"
 	metaIndexName    = "lunettes_meta"
@@ -47,6 +50,9 @@ const (
 			"properties": {
 				"LastReadTime": {
 					"type": "long"
+				},
+				"MilestoneGeneration": {
+					"type": "keyword"
 				}
 			}
 		}
@@ -54,32 +60,35 @@ const (
 )
 
 var (
-	xsearchFetedEventDurationMilliSeconds = prometheus.NewGauge(
+	xsearchFetedEventDurationMilliSeconds = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: metricNamePrefix + "xsearch_scroll_duration_milliseconds",
 			Help: "how long an xsearch scroll operation to completed",
 		},
+		[]string{"cluster"},
 	)
 	xsearchSLOProDurationInMilliSeconds = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: metricNamePrefix + "xsearch_process_duration_milliseconds",
 			Help: "time lag since the last fetch operation",
 		},
-		[]string{"phase"},
+		[]string{"cluster", "phase"},
 	)
 
-	xsearchFetchedEventCountOneScroll = prometheus.NewGauge(
+	xsearchFetchedEventCountOneScroll = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: metricNamePrefix + "xsearch_fetched_events_one_scroll",
 			Help: "how many events fetch at one scroll operation",
 		},
+		[]string{"cluster"},
 	)
 
-	xsearchFetchLagInMilliSeconds = prometheus.NewGauge(
+	xsearchFetchLagInMilliSeconds = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: metricNamePrefix + "xsearch_fetch_lag_milliseconds",
 			Help: "time lag since the last fetch operation",
 		},
+		[]string{"cluster"},
 	)
 
 	xsearchFetchLagInMilliSecondsSum = prometheus.NewHistogramVec(
@@ -88,23 +97,21 @@ var (
 			Help:    "time lag since the last fetch operation",
 			Buckets: []float64{0.5, 1, 2, 4, 6, 8, 10, 14, 18, 22, 26, 30, 35, 40, 45, 50, 55, 60, 65, 70, 75, 80, 90, 100, 110, 120, 130, 140, 150, 200, 250, 300, 400, 500, 600, 900, 1200},
 		},
-		[]string{"type"},
+		[]string{"cluster", "type"},
 	)
 
-	xsearchQueryErrors = prometheus.NewCounter(
+	xsearchQueryErrors = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: metricNamePrefix + "xsearch_query_errors",
 			Help: "service operation errors",
 		},
+		[]string{"cluster"},
 	)
 )
 
 var (
-	numSlices        = 4
-	queryDocNum      = 80
-	lastNoEventError = false
-
-	currentIndex = atomic.Value{}
+	numSlices   = 4
+	queryDocNum = 80
 )
 
 func init() {
@@ -117,28 +124,50 @@ func init() {
 
 	flag.IntVar(&numSlices, "num_scroll_slice", 4, "number of scroll slice")
 	flag.IntVar(&queryDocNum, "doc_num_peer_query", 80, "enable biz process")
-
-	currentIndex.Store("")
 }
 
 type logReader struct {
 	esConf                *xsearch.ElasticSearchConf
 	lastReadTime          time.Time
-	auditProcessor        *AuditProcessor
+	handler               AuditEventHandler
 	bufferDuration        time.Duration
 	fetchIntervalDuration time.Duration
 	cluster               string
+	shard                 utils.Shard
 	esClient              *elastic.Client
 	lastReadTimeChan      chan time.Time
+	indexes               *auditIndexResolver
+	lastNoEventError      bool
+
+	// held while fetching, so the checkpoint saved by Stop is of a point between two fetches,
+	// guards lastReadTime, stopped and the checkpoint
+	readMutex          sync.Mutex
+	stopped            bool
+	lastCheckpointTime time.Time
+}
+
+// NewElasticSearchSource returns the factory of audit source which scrolls audit events from elasticsearch,
+// the read time is checkpointed for each shard.
+func NewElasticSearchSource(esConf *xsearch.ElasticSearchConf,
+	buffer, interval time.Duration, cluster string, shard utils.Shard) AuditSourceFactory {
+	return func(handler AuditEventHandler) (AuditSource, error) {
+		xsearch.EsConfig = esConf
+		lr, err := newLogReader(handler, esConf, buffer, interval, cluster)
+		if err != nil {
+			return nil, err
+		}
+		lr.shard = shard
+		return lr, nil
+	}
 }
 
 // todo
-func newLogReader(processor *AuditProcessor,
+func newLogReader(handler AuditEventHandler,
 	conf *xsearch.ElasticSearchConf,
 	buffer, interval time.Duration, cluster string) (*logReader, error) {
 	lr := &logReader{
 		esConf:                conf,
-		auditProcessor:        processor,
+		handler:               handler,
 		bufferDuration:        buffer,
 		fetchIntervalDuration: interval,
 		cluster:               cluster,
@@ -156,13 +185,20 @@ func newLogReader(processor *AuditProcessor,
 	}
 
 	lr.esClient = client
-	lr.refreshIndex()
+	lr.indexes = newAuditIndexResolver(client, conf, cluster)
 	return lr, nil
 }
 
 func (lr *logReader) Stop() {
 	klog.Info("Stop log reader")
-	lr.updateLastReadTime()
+	// waits for the events being fetched
+	lr.readMutex.Lock()
+	lr.stopped = true
+	lr.readMutex.Unlock()
+
+	if err := lr.checkpoint(stopCheckpointTimeout); err != nil {
+		klog.Errorf("[%s]failed to save checkpoint: %s", lr.cluster, err.Error())
+	}
 	klog.Info("Stop log reader completed")
 }
 
@@ -178,12 +214,13 @@ func (lr *logReader) Run(stopCh <-chan struct{}) {
 	}
 
 	// get last read time
-	lr.lastReadTime = lr.getLastReadTime()
-	klog.Infof("last read time from xsearch %+v", lr.lastReadTime)
+	var generation string
+	lr.lastReadTime, generation = lr.getLastReadTime()
+	klog.Infof("last read time from xsearch %+v, milestone generation %s", lr.lastReadTime, generation)
 
 	// only get data older than this duration, wait for not stored new event to be inserted
 	fetchedUntil := time.Now().Add(-lr.bufferDuration)
-	_, err := lr.recovery(fetchedUntil, stopCh)
+	_, err := lr.recovery(fetchedUntil, generation, stopCh)
 
 	maxStepDuration := time.Second * 60
 	if lr.fetchIntervalDuration > maxStepDuration {
@@ -205,8 +242,8 @@ func (lr *logReader) Run(stopCh <-chan struct{}) {
 		//isLastErr := false
 		wait.Until(func() {
 			startTime = time.Now()
-			xsearchFetchLagInMilliSeconds.Set(utils.TimeDiffInMilliSeconds(lr.lastReadTime, startTime))
-			xsearchFetchLagInMilliSecondsSum.WithLabelValues("audit_read").Observe(utils.TimeDiffInSeconds(lr.lastReadTime, startTime))
+			xsearchFetchLagInMilliSeconds.WithLabelValues(lr.cluster).Set(utils.TimeDiffInMilliSeconds(lr.lastReadTime, startTime))
+			xsearchFetchLagInMilliSecondsSum.WithLabelValues(lr.cluster, "audit_read").Observe(utils.TimeDiffInSeconds(lr.lastReadTime, startTime))
 
 			nextEndTime := startTime.Add(-lr.bufferDuration)
 			if lr.lastReadTime.After(nextEndTime) {
@@ -218,7 +255,7 @@ func (lr *logReader) Run(stopCh <-chan struct{}) {
 			fetchTimeRange := nextEndTime.Sub(lr.lastReadTime).Seconds()
 			controlledRange := time.Duration(flowController.DecideFlow(fetchTimeRange)) * time.Second
 
-			fetchedEvent, err := lr.fetchEvents(controlledRange)
+			fetchedEvent, err := lr.fetch(controlledRange)
 			if err != nil {
 				klog.Errorf("failed to fetch events: %s", err.Error())
 				//	isLastErr = true
@@ -227,25 +264,83 @@ func (lr *logReader) Run(stopCh <-chan struct{}) {
 				//	isLastErr = false
 				flowController.RecordFlow(true)
 			}
-			xsearchFetedEventDurationMilliSeconds.Set(utils.TimeDiffInMilliSeconds(startTime, time.Now()))
-			xsearchFetchedEventCountOneScroll.Set(float64(fetchedEvent))
+			xsearchFetedEventDurationMilliSeconds.WithLabelValues(lr.cluster).Set(utils.TimeDiffInMilliSeconds(startTime, time.Now()))
+			xsearchFetchedEventCountOneScroll.WithLabelValues(lr.cluster).Set(float64(fetchedEvent))
 
+			// at most behind 20 seconds if app is killed without grace shutdown.
+			if err := lr.checkpointAfter(checkpointInterval); err != nil {
+				klog.Errorf("[%s]failed to save checkpoint: %s", lr.cluster, err.Error())
+			}
 		}, lr.fetchIntervalDuration, stopCh)
 	}()
+}
+
+// fetch fetches the events of timeDuration after the last read time, nothing is fetched after Stop
+func (lr *logReader) fetch(timeDuration time.Duration) (int64, error) {
+	lr.readMutex.Lock()
+	defer lr.readMutex.Unlock()
+	if lr.stopped {
+		return 0, nil
+	}
+	return lr.fetchEvents(timeDuration)
+}
+
+// checkpointAfter saves the checkpoint if the last one is older than interval
+func (lr *logReader) checkpointAfter(interval time.Duration) error {
+	lr.readMutex.Lock()
+	due := time.Since(lr.lastCheckpointTime) > interval
+	lr.readMutex.Unlock()
+	if !due {
+		return nil
+	}
+	return lr.checkpoint(checkpointTimeout)
+}
+
+// checkpoint saves the read time together with the in-flight create/upgrade/pvc milestones, which recovery restores,
+// and the dedupe window.
+// Fetching is paused and the events read are consumed first, otherwise the milestones would miss the events before
+// the read time. The snapshots are saved as a new generation before the read time refers to it, and the old
+// generations are removed after, so the checkpoint always refers to the milestones of the same point.
+func (lr *logReader) checkpoint(timeout time.Duration) error {
+	lr.readMutex.Lock()
+	defer lr.readMutex.Unlock()
+
+	lastReadTime := lr.lastReadTime
+	if !waitEventsConsumed(lr.cluster, timeout) {
+		return fmt.Errorf("events read until %v are not consumed in %v, the last checkpoint is kept", lastReadTime, timeout)
+	}
 
-	// at most behind 10 minutues if app is killed without grace shutdown.
-	go wait.Until(func() {
-		lr.updateLastReadTime()
-	}, 20*time.Second, stopCh)
+	owner := lr.shard.Name(lr.cluster)
+	generation := strconv.FormatInt(time.Now().UnixNano(), 10)
+	milestones := slo.SnapshotMilestones(lr.cluster)
+	dedup := checkpointStateOf(lr.cluster).snapshotDedup()
+	err := xsearch.SaveMilestoneSnapshots(owner, generation, milestones)
+	if err == nil {
+		err = lr.updateLastReadTime(lastReadTime, generation)
+	}
+	if err != nil {
+		dedup.discard()
+		return err
+	}
+	dedup.save()
+	lr.lastCheckpointTime = time.Now()
+	return xsearch.DeleteMilestoneSnapshots(owner, generation)
 }
 
-func (lr *logReader) updateLastReadTime() error {
+// waitEventsConsumed returns true once the events of cluster dispatched are consumed by all queues, false after timeout.
+// The events of other clusters and sources are not waited for.
+func waitEventsConsumed(cluster string, timeout time.Duration) bool {
+	return checkpointStateOf(cluster).waitConsumed(timeout)
+}
+
+func (lr *logReader) updateLastReadTime(lastReadTime time.Time, generation string) error {
 	conf := metaConf{
-		LastReadTime: strconv.FormatInt(lr.lastReadTime.UnixNano(), 10),
+		LastReadTime:        strconv.FormatInt(lastReadTime.UnixNano(), 10),
+		MilestoneGeneration: generation,
 	}
 	var err error
 
-	docID := lr.cluster
+	docID := lr.shard.Name(lr.cluster)
 	x := utils.Dumps(conf)
 	_, err = lr.esClient.Index().
 		Index(metaIndexName).
@@ -253,21 +348,31 @@ func (lr *logReader) updateLastReadTime() error {
 		BodyString(x).
 		Do(context.Background())
 	if err == nil {
-		klog.V(5).Infof("[%s]last read time updated to %s/%v", lr.cluster, conf.LastReadTime, lr.lastReadTime)
+		klog.V(5).Infof("[%s]last read time updated to %s/%v", lr.cluster, conf.LastReadTime, lastReadTime)
 	}
 	return err
 }
 
-func (lr *logReader) getLastReadTime() time.Time {
+// getLastReadTime returns the checkpoint, and the generation of the milestone snapshots saved with it
+func (lr *logReader) getLastReadTime() (time.Time, string) {
 	now := time.Now()
 
+	docID := lr.shard.Name(lr.cluster)
 	result, err := lr.esClient.Get().
 		Index(metaIndexName).
-		Id(lr.cluster). // use first cluster is ok. all cluster will have the same time
+		Id(docID).
 		Do(context.Background())
+	if elastic.IsNotFound(err) && docID != lr.cluster {
+		// the first run after sharding, resume from the checkpoint of the cluster
+		klog.Infof("no checkpoint of %s, read from the one of %s", docID, lr.cluster)
+		result, err = lr.esClient.Get().
+			Index(metaIndexName).
+			Id(lr.cluster).
+			Do(context.Background())
+	}
 	if err != nil {
 		klog.Errorf("[may be not exists] faild get last time for %s: %s", lr.cluster, err.Error())
-		return now
+		return now, ""
 	}
 
 	if result.Found {
@@ -275,23 +380,23 @@ func (lr *logReader) getLastReadTime() time.Time {
 		err := json.Unmarshal(result.Source, conf)
 		if err != nil {
 			klog.Errorf("failed unmarshal %s: %s", string(result.Source), err.Error())
-			return now
+			return now, ""
 		}
 
 		lastReadTime, err := strconv.ParseInt(conf.LastReadTime, 10, 64)
 		if lastReadTime > 0 {
 			t := time.Unix(0, lastReadTime)
 			klog.Infof("got last read time %+v", t)
-			return t
+			return t, conf.MilestoneGeneration
 		}
 
 		klog.Errorf("got invalid last read time %+v", conf.LastReadTime)
 	}
 
-	return now
+	return now, ""
 }
 
-func (lr *logReader) recovery(end time.Time, stopCh <-chan struct{}) (int64, error) {
+func (lr *logReader) recovery(end time.Time, generation string, stopCh <-chan struct{}) (int64, error) {
 	var (
 		totalProcessed int64
 	)
@@ -303,13 +408,33 @@ func (lr *logReader) recovery(end time.Time, stopCh <-chan struct{}) (int64, err
 		klog.Errorf("Recovering PodDeleleteMilestone from xsearch failed, exiting... err is %v", err)
 		// os.Exit(1)
 	}
-	slo.PodDeleteMileStoneMap = podDeleteMap
-	klog.Infof("Finished getting all cached data from PodDeleteMileStoneMap, size is %d", slo.PodDeleteMileStoneMap.Size())
+	// milestones of other clusters are kept, and the ones of other shards are left to them
+	var mutex sync.Mutex
+	keys := make([]string, 0)
+	podDeleteMap.IterateWithFunc(func(v interface{}) {
+		if milestone, ok := v.(*xsearch.PodDeleteMileStone); ok && lr.shard.Owns(milestone.PodUID) {
+			slo.PodDeleteMileStoneMap.Set(milestone.Key, milestone)
+			mutex.Lock()
+			keys = append(keys, milestone.Key)
+			mutex.Unlock()
+		}
+	})
+	klog.Infof("Finished getting all cached data from PodDeleteMileStoneMap, size is %d", len(keys))
 
 	klog.Infof("Deleting all cached data from PodDeleteMileStoneMap")
-	xsearch.DeleteAllPodDeleteMilestone(cluster)
+	if lr.shard.Enabled() {
+		xsearch.DeletePodDeleteMilestones(cluster, keys)
+	} else {
+		xsearch.DeleteAllPodDeleteMilestone(cluster)
+	}
 	klog.Infof("Finished deleting all cached data from PodDeleteMileStoneMap")
 
+	snapshots, err := xsearch.GetMilestoneSnapshots(lr.shard.Name(cluster), generation)
+	if err != nil {
+		klog.Errorf("failed to get milestone snapshots of %s: %s", cluster, err.Error())
+	}
+	klog.Infof("restored %d of %d create/upgrade/pvc milestones", slo.RestoreMilestones(snapshots), len(snapshots))
+
 	for lr.lastReadTime.Before(end) {
 		select {
 		case <-stopCh:
@@ -325,7 +450,7 @@ func (lr *logReader) recovery(end time.Time, stopCh <-chan struct{}) (int64, err
 
 		processed := int64(0)
 		err = utils.ReTry(func() error {
-			processed, err = lr.fetchEvents(timeRange)
+			processed, err = lr.fetch(timeRange)
 			return err
 		}, 2*time.Second, 3)
 
@@ -334,23 +459,49 @@ func (lr *logReader) recovery(end time.Time, stopCh <-chan struct{}) (int64, err
 			return totalProcessed, err
 		}
 		totalProcessed += processed
-		lr.updateLastReadTime()
-		xsearchFetchedEventCountOneScroll.Set(float64(processed))
+		if err := lr.checkpoint(checkpointTimeout); err != nil {
+			klog.Errorf("[%s]failed to save checkpoint: %s", lr.cluster, err.Error())
+		}
+		xsearchFetchedEventCountOneScroll.WithLabelValues(lr.cluster).Set(float64(processed))
 		time.Sleep(200 * time.Millisecond)
 	}
 	klog.Infof("fetch %d events at recovery", totalProcessed)
 	return totalProcessed, nil
 }
 
+// auditQuery returns the query of the audit events of the shard in [from, to)
+func (lr *logReader) auditQuery(from, to time.Time) *elastic.BoolQuery {
+	query := auditQuery(lr.esConf, lr.cluster, from, to)
+	if lr.shard.Enabled() {
+		if shard := shardQuery(lr.shard); shard != nil {
+			query = query.Filter(shard)
+		}
+	}
+	return query
+}
+
+// readCursors returns the time to resume from before each hit is handled, the earliest time of the hits not handled
+// yet in the field the events are queried by. The hits are ordered by stageTimestamp, which the field may not follow.
+func readCursors(hits []*shares.HitEvent) []time.Time {
+	cursors := make([]time.Time, len(hits))
+	var earliest time.Time
+	for i := len(hits) - 1; i >= 0; i-- {
+		ts := hits[i].Timestamp
+		if !hits[i].HasError && !ts.IsZero() && (earliest.IsZero() || ts.Before(earliest)) {
+			earliest = ts
+		}
+		cursors[i] = earliest
+	}
+	return cursors
+}
+
 // 获取审计日志
 func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 	endTime := lr.lastReadTime.Add(timeDuration)
 	startTime := lr.lastReadTime
-	//daily索引生成每天凌晨00:30分
-	/*m, _ := time.ParseDuration("-30m")
-	indexDaily := fmt.Sprintf("append_only.%s.%s", lr.esConf.Index, startTime.Add(m).Format("2006-01-02"))*/
-	indexDaily := currentIndex.Load().(string)
-	if lastNoEventError {
+	// 跨天时同时查询前后两天的索引
+	indexDaily := lr.indexes.resolve(startTime, endTime)
+	if lr.lastNoEventError {
 		indexDaily = lr.esConf.Index
 	}
 
@@ -358,16 +509,7 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 	var indexSliceDuration float64 = 0
 	var indexSliceStart time.Time = time.Now()
 
-	rangeQuery := elastic.NewRangeQuery("stageTimestamp").
-		From(lr.lastReadTime).To(endTime).
-		IncludeLower(true).
-		IncludeUpper(false).
-		TimeZone("UTC")
-	query := elastic.NewBoolQuery().Must(rangeQuery)
-
-	cluster := lr.cluster
-	clusterQuery := elastic.NewTermQuery("annotations.cluster.keyword", cluster)
-	query = query.Must(clusterQuery)
+	query := lr.auditQuery(startTime, endTime)
 
 	s, _ := query.Source()
 	klog.V(7).Infof("query: %s", utils.Dumps(s))
@@ -383,7 +525,8 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 	count := int64(0)
 	stableCount := 0
 	for {
-		curCount, err := lr.esClient.Count(indexDaily).Query(query).Do(ctx)
+		curCount, err := lr.esClient.Count(indexDaily).Query(query).
+			IgnoreUnavailable(true).AllowNoIndices(true).Do(ctx)
 		if err != nil {
 			return 0, err
 		}
@@ -407,14 +550,14 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 	if count == 0 {
 		klog.Errorf("NoEventError")
 		// 应对日志rotate场景
-		if lastNoEventError {
+		if lr.lastNoEventError {
 			// 如果上次查询是NoEventError，则此次 index已经切换到全局（非daily模式），此次仍然没数据则大概率此时间段没数据，则查询时间需要继续向前更新
 			lr.lastReadTime = endTime
 		}
-		lastNoEventError = true
+		lr.lastNoEventError = true
 		return 0, nil
 	}
-	lastNoEventError = false
+	lr.lastNoEventError = false
 
 	var globalErr error
 	var sloScrollDuration float64 = 0
@@ -436,19 +579,13 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 			scrollWg.Add(1)
 			var curScrollDuration float64 = 0
 
-			curQuery := elastic.NewBoolQuery().Must(clusterQuery)
 			from := startTime.Add(time.Duration(stepDuration * int64(j)))
 			to := startTime.Add(time.Duration(stepDuration * int64(j+1)))
 			if j == int(numPeer)-1 {
 				to = endTime
 			}
 
-			rangeQuery := elastic.NewRangeQuery("stageTimestamp").
-				From(from).To(to).
-				IncludeLower(true).
-				IncludeUpper(false).
-				TimeZone("UTC")
-			curQuery = curQuery.Must(rangeQuery)
+			curQuery := lr.auditQuery(from, to)
 
 			go func(sliceQuery *elastic.SliceQuery, query *elastic.BoolQuery) error {
 				defer func() {
@@ -458,7 +595,8 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 					scrollWg.Done()
 				}()
 
-				scroll := lr.esClient.Scroll(indexDaily).Query(query).Size(pageSize).Slice(sliceQuery)
+				scroll := lr.esClient.Scroll(indexDaily).Query(query).Size(pageSize).Slice(sliceQuery).
+					IgnoreUnavailable(true).AllowNoIndices(true)
 
 				for {
 					scrollStart := time.Now()
@@ -493,7 +631,7 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 					//hitEvents := shares.NewHitEventSlice(len(results.Hits.Hits))
 					for _, hit := range results.Hits.Hits {
 						hitEvent := shares.NewHitEvent()
-						hitEvent.UnmarshalToEvent(&hit.Source)
+						hitEvent.UnmarshalToEvent(&hit.Source, lr.esConf.AuditTimestampField())
 						//hitEvents.Append(hitEvent)
 						hitEventSlice.Append(hitEvent)
 					}
@@ -529,6 +667,7 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 		hitEventSlice.SortByTimeStamp(true)
 
 		sloUnmarshalDuration = utils.TimeDiffInMilliSeconds(waitStart, time.Now())
+		cursors := readCursors(hitEventSlice.Hits())
 
 		for idx, _ := range hitEventSlice.Hits() {
 			atomic.AddInt64(&totalProcessed, 1)
@@ -536,40 +675,26 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 
 			if hitEvent.HasError {
 				klog.Errorf("hitEvent HasError, continue")
+				deadletter.CaptureRaw(deadletter.StageDecode, lr.cluster, hitEvent.Raw, hitEvent.Err)
 				continue
 			}
 
-			event := *hitEvent.Event
-
-			klog.V(8).Infof("got event %s", utils.DumpsEventKeyInfo(&event))
-			//更新最近的event，防止出错断点重连
-			lr.lastReadTime = event.StageTimestamp.Time
-
-			// 此处为忽略某些 namespace 的流量，可以在 configmap 中进行配置
-			// 例如：忽略压测流量 event.ObjectRef.Namespace == "cluster-loader-v3"
-			if event.ObjectRef != nil &&
-				utils.SliceContainsString(config.GlobalLunettesConfig().IgnoredNamespaceForAudit, event.ObjectRef.Namespace) {
-				continue
+			event := hitEvent.Event
+			// indexes of one cluster may have no cluster annotation
+			if event.Annotations == nil {
+				event.Annotations = map[string]string{}
 			}
-
-			shareEvent := shares.NewAuditEvent(&event)
-			if event.ObjectRef.Resource == "pods" || event.ObjectRef.Resource == "events" || event.ObjectRef.Resource == "nodes" {
-				shareEvent.Process()
+			if event.Annotations["cluster"] == "" {
+				event.Annotations["cluster"] = lr.cluster
 			}
 
-			// 将 event 放入 queue 中
-			sloStart := time.Now()
-			slo.Queue.Produce(shareEvent)             // 这个队列是用于 SLO 的
-			podphase.WatcherQueue.Produce(shareEvent) // 这个队列是用于 pod phase 的
-			podyaml.Queue.Produce(shareEvent)         // pod yaml
-			nodeyaml.Queue.Produce(shareEvent)        // node yaml
-			if featuregates.IsEnabled(spans.SpanAnalysisFeature) {
-				spans.WatcherQueue.Produce(shareEvent)
-			}
-			if featuregates.IsEnabled(trace.TraceFeature) {
-				trace.WatcherQueue.Produce(shareEvent)
+			//更新最近的event，防止出错断点重连
+			if !cursors[idx].IsZero() {
+				lr.lastReadTime = cursors[idx]
 			}
 
+			sloStart := time.Now()
+			lr.handler(event)
 			sloProDuration += utils.TimeDiffInMilliSeconds(sloStart, time.Now())
 
 			// Terminate early?
@@ -589,43 +714,36 @@ func (lr *logReader) fetchEvents(timeDuration time.Duration) (int64, error) {
 	}
 
 	if globalErr != nil {
-		xsearchQueryErrors.Inc()
+		xsearchQueryErrors.WithLabelValues(lr.cluster).Inc()
 		// notify tracer to check if some traces have timeout
-		go func() {
-			lr.lastReadTimeChan <- lr.lastReadTime
-		}()
+		lr.notifyLastReadTime()
 
 		return totalProcessed, globalErr
 	}
 
-	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"phase": "slo_scroll"}).Set(sloScrollDuration)
-	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"phase": "slo_unmarshal"}).Set(sloUnmarshalDuration)
-	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"phase": "slo_delivery"}).Set(sloProDuration)
-	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"phase": "produce_func"}).Set(produceFuncDuration)
-	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"phase": "index_slice"}).Set(indexSliceDuration)
+	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"cluster": lr.cluster, "phase": "slo_scroll"}).Set(sloScrollDuration)
+	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"cluster": lr.cluster, "phase": "slo_unmarshal"}).Set(sloUnmarshalDuration)
+	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"cluster": lr.cluster, "phase": "slo_delivery"}).Set(sloProDuration)
+	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"cluster": lr.cluster, "phase": "produce_func"}).Set(produceFuncDuration)
+	xsearchSLOProDurationInMilliSeconds.With(map[string]string{"cluster": lr.cluster, "phase": "index_slice"}).Set(indexSliceDuration)
 
 	// update last read time only when no errors found.
 	// but this may leads this filed not updated if error returned continually
 	lr.lastReadTime = endTime
 
 	// notify tracer to check if some traces have timeout
-	go func() {
-		lr.lastReadTimeChan <- lr.lastReadTime
-	}()
+	lr.notifyLastReadTime()
 
 	klog.V(5).Infof("fetch from %s, range %v, got %d audit log", startTime.Format(time.RFC3339), timeDuration, totalProcessed)
 	return totalProcessed, nil
 }
 
-func (lr *logReader) refreshIndex() {
-	if currentIndex.Load().(string) == "" {
-		currentIndex.Store(lr.esConf.Index)
+func (lr *logReader) notifyLastReadTime() {
+	// nobody listens if not set
+	if lr.lastReadTimeChan == nil {
+		return
 	}
-
-	go wait.Until(func() {
-		indexDaily := fmt.Sprintf("append_only.%s.%s", lr.esConf.Index, time.Now().Format("2006-01-02"))
-		if exist, _ := lr.esClient.IndexExists(indexDaily).Do(context.TODO()); exist {
-			currentIndex.Store(indexDaily)
-		}
-	}, 5*time.Minute, make(chan struct{}))
+	go func(t time.Time) {
+		lr.lastReadTimeChan <- t
+	}(lr.lastReadTime)
 }
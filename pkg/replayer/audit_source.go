@@ -0,0 +1,29 @@
+package replayer
+
+import (
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+const (
+	// AuditSourceElasticSearch reads audit events by scrolling the elasticsearch audit index
+	AuditSourceElasticSearch = "elasticsearch"
+	// AuditSourceFile tails the JSONL file written by apiserver's --audit-log-path
+	AuditSourceFile = "file"
+	// AuditSourceWebhook receives audit events posted by apiserver's --audit-webhook-config-file backend
+	AuditSourceWebhook = "webhook"
+)
+
+// AuditEventHandler is called by an AuditSource for every audit event it reads, in order.
+type AuditEventHandler func(event *k8s_audit.Event)
+
+// AuditSource is where the aggregator reads audit events from.
+type AuditSource interface {
+	// Run starts reading audit events in background and passes them to the handler
+	// until stopCh is closed.
+	Run(stopCh <-chan struct{})
+	// Stop persists the read checkpoint of this source.
+	Stop()
+}
+
+// AuditSourceFactory creates an AuditSource which feeds events to handler.
+type AuditSourceFactory func(handler AuditEventHandler) (AuditSource, error)
@@ -0,0 +1,116 @@
+package replayer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/queue"
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	"k8s.io/klog/v2"
+)
+
+// BackfillOptions tells the time range of audit events to recompute SLOs for
+type BackfillOptions struct {
+	Cluster string
+	ESConf  *xsearch.ElasticSearchConf
+	// events in [From, To) are re-read
+	From time.Time
+	To   time.Time
+	// time range fetched by each scroll
+	Step time.Duration
+}
+
+// backfillSource scrolls the events of the time range window by window with the slice scroll of logReader,
+// the clock is set to the time of each event before it is handled.
+type backfillSource struct {
+	reader  *logReader
+	options *BackfillOptions
+	clock   *clock.FakePassiveClock
+
+	processed int64
+	err       error
+}
+
+// Run reads all events of the time range before return
+func (s *backfillSource) Run(stopCh <-chan struct{}) {
+	lr := s.reader
+	lr.lastReadTime = s.options.From
+	for lr.lastReadTime.Before(s.options.To) {
+		select {
+		case <-stopCh:
+			s.err = fmt.Errorf("backfill is interrupted at %s", lr.lastReadTime.Format(time.RFC3339))
+			return
+		default:
+		}
+
+		step := s.options.Step
+		if lr.lastReadTime.Add(step).After(s.options.To) {
+			step = s.options.To.Sub(lr.lastReadTime)
+		}
+		klog.V(4).Infof("backfill from %s with range %v", lr.lastReadTime.Format(time.RFC3339), step)
+
+		processed := int64(0)
+		err := utils.ReTry(func() error {
+			var err error
+			processed, err = lr.fetchEvents(step)
+			return err
+		}, 2*time.Second, 3)
+		s.processed += processed
+		if err != nil {
+			s.err = fmt.Errorf("backfill stopped at %s: %w", lr.lastReadTime.Format(time.RFC3339), err)
+			return
+		}
+	}
+}
+
+func (s *backfillSource) Stop() {}
+
+// Backfill re-reads the audit events of [From, To) from elasticsearch and recomputes the SLOs with the clock following
+// event time, one event at a time. It is isolated from the live aggregator: the read checkpoint, the milestone caches and the dedupe window
+// are neither read nor written, where the results go is decided by the doc writer of xsearch.
+func Backfill(options *BackfillOptions, enableTrace bool, stopCh <-chan struct{}) (int64, error) {
+	if options.From.IsZero() || options.To.IsZero() || !options.From.Before(options.To) {
+		return 0, fmt.Errorf("invalid time range [%s, %s)", options.From, options.To)
+	}
+	if options.Step <= 0 {
+		return 0, fmt.Errorf("invalid step %v", options.Step)
+	}
+
+	var source *backfillSource
+	processor, err := NewAuditProcessor(func(handler AuditEventHandler) (AuditSource, error) {
+		source = &backfillSource{
+			options: options,
+			clock:   clock.NewFakePassiveClock(options.From),
+		}
+		state := checkpointStateOf(options.Cluster)
+		lr, err := newLogReader(func(event *k8s_audit.Event) {
+			handleInEventTime(source.clock, state, handler, event)
+		}, options.ESConf, 0, 0, options.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		source.reader = lr
+		return source, nil
+	}, options.Cluster, enableTrace)
+	if err != nil {
+		return 0, err
+	}
+
+	wallClock := utils.Clock
+	utils.Clock = source.clock
+	defer func() {
+		utils.Clock = wallClock
+	}()
+
+	processor.Start(stopCh)
+	// the results computed so far are flushed anyway
+	queue.WaitAllIdle(100*time.Millisecond, 10)
+	xsearch.XSearchClear.DoClear()
+	klog.Infof("%d audit events backfilled", source.processed)
+
+	return source.processed, source.err
+}
@@ -0,0 +1,213 @@
+package replayer
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+// fakeAuditES answers the count and scroll requests of fetchEvents with the events in the queried time range
+type fakeAuditES struct {
+	events []*k8s_audit.Event
+	// time of an event in the stored doc
+	timestamp func(event *k8s_audit.Event) time.Time
+}
+
+// findRange returns the bounds of the first range query in the request body
+func findRange(v interface{}) (time.Time, time.Time, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if r, ok := v["range"].(map[string]interface{}); ok {
+			for _, bounds := range r {
+				b := bounds.(map[string]interface{})
+				from, _ := time.Parse(time.RFC3339Nano, b["from"].(string))
+				to, _ := time.Parse(time.RFC3339Nano, b["to"].(string))
+				return from, to, true
+			}
+		}
+		for _, child := range v {
+			if from, to, ok := findRange(child); ok {
+				return from, to, true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if from, to, ok := findRange(child); ok {
+				return from, to, true
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+func (es *fakeAuditES) eventsInRange(t *testing.T, r *http.Request) []*k8s_audit.Event {
+	var body interface{}
+	data, err := io.ReadAll(r.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, &body))
+	from, to, ok := findRange(body)
+	assert.True(t, ok)
+
+	result := make([]*k8s_audit.Event, 0)
+	for _, event := range es.events {
+		ts := es.timestamp(event)
+		if !ts.Before(from) && ts.Before(to) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+func (es *fakeAuditES) serve(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_count"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"count": len(es.eventsInRange(t, r))})
+		case strings.HasPrefix(r.URL.Path, "/_search/scroll"):
+			// a single page
+			w.Write([]byte(`{"_scroll_id": "scroll", "hits": {"total": {"value": 0}, "hits": []}}`))
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			hits := make([]map[string]interface{}, 0)
+			for _, event := range es.eventsInRange(t, r) {
+				hits = append(hits, map[string]interface{}{"_id": string(event.AuditID), "_source": event})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"_scroll_id": "scroll",
+				"hits":       map[string]interface{}{"total": map[string]interface{}{"value": len(hits)}, "hits": hits},
+			})
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+}
+
+func newFakeAuditEvent(auditID string, ts time.Time) *k8s_audit.Event {
+	return &k8s_audit.Event{
+		AuditID:        types.UID(auditID),
+		Verb:           "update",
+		Stage:          k8s_audit.StageResponseComplete,
+		StageTimestamp: metav1.NewMicroTime(ts),
+		ResponseStatus: &metav1.Status{Code: 200},
+		ObjectRef: &k8s_audit.ObjectReference{
+			Resource:  "configmaps",
+			Namespace: "default",
+			Name:      "cm-" + auditID,
+		},
+	}
+}
+
+// withSingleScroll makes fetchEvents scroll the whole range at once, the fake serves no slices
+func withSingleScroll(t *testing.T) {
+	slices, docNum := numSlices, queryDocNum
+	numSlices, queryDocNum = 1, 10000
+	t.Cleanup(func() {
+		numSlices, queryDocNum = slices, docNum
+	})
+}
+
+func TestBackfillOptions(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	stopCh := make(chan struct{})
+
+	for _, options := range []*BackfillOptions{
+		{Cluster: "test-cluster", To: base, Step: time.Minute},
+		{Cluster: "test-cluster", From: base, To: base, Step: time.Minute},
+		{Cluster: "test-cluster", From: base.Add(time.Hour), To: base, Step: time.Minute},
+		{Cluster: "test-cluster", From: base, To: base.Add(time.Hour)},
+	} {
+		_, err := Backfill(options, false, stopCh)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestBackfillSource(t *testing.T) {
+	withSingleScroll(t)
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	es := &fakeAuditES{
+		events: []*k8s_audit.Event{
+			newFakeAuditEvent("before", base.Add(-time.Second)),
+			newFakeAuditEvent("1", base.Add(10*time.Second)),
+			newFakeAuditEvent("2", base.Add(70*time.Second)),
+			newFakeAuditEvent("3", base.Add(150*time.Second)),
+			newFakeAuditEvent("after", base.Add(3*time.Minute)),
+		},
+		timestamp: func(event *k8s_audit.Event) time.Time { return event.StageTimestamp.Time },
+	}
+	server := es.serve(t)
+	defer server.Close()
+
+	options := &BackfillOptions{
+		Cluster: "test-cluster",
+		ESConf:  &xsearch.ElasticSearchConf{Endpoint: server.URL, Index: "audit"},
+		From:    base,
+		To:      base.Add(3 * time.Minute),
+		Step:    time.Minute,
+	}
+	source := &backfillSource{options: options, clock: clock.NewFakePassiveClock(base)}
+	got := make([]string, 0)
+	clockTimes := make([]time.Time, 0)
+	lr, err := newLogReader(func(event *k8s_audit.Event) {
+		source.clock.SetTime(event.StageTimestamp.Time)
+		got = append(got, string(event.AuditID))
+		clockTimes = append(clockTimes, source.clock.Now())
+	}, options.ESConf, 0, 0, options.Cluster)
+	assert.Nil(t, err)
+	source.reader = lr
+
+	source.Run(make(chan struct{}))
+	assert.Nil(t, source.err)
+	// only the events of [From, To), window by window
+	assert.Equal(t, []string{"1", "2", "3"}, got)
+	assert.Equal(t, int64(3), source.processed)
+	assert.True(t, clockTimes[1].Equal(base.Add(70*time.Second)))
+	assert.True(t, lr.lastReadTime.Equal(options.To))
+
+	// interrupted before the range is read
+	stopCh := make(chan struct{})
+	close(stopCh)
+	source = &backfillSource{options: options, reader: lr, clock: clock.NewFakePassiveClock(base)}
+	source.Run(stopCh)
+	assert.NotNil(t, source.err)
+	assert.Equal(t, int64(0), source.processed)
+}
+
+func TestBackfill(t *testing.T) {
+	withSingleScroll(t)
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	es := &fakeAuditES{
+		events: []*k8s_audit.Event{
+			newFakeAuditEvent("1", base.Add(10*time.Second)),
+			newFakeAuditEvent("2", base.Add(20*time.Second)),
+		},
+		timestamp: func(event *k8s_audit.Event) time.Time { return event.StageTimestamp.Time },
+	}
+	server := es.serve(t)
+	defer server.Close()
+
+	wallClock := utils.Clock
+	processed, err := Backfill(&BackfillOptions{
+		Cluster: "test-cluster",
+		ESConf:  &xsearch.ElasticSearchConf{Endpoint: server.URL, Index: "audit"},
+		From:    base,
+		To:      base.Add(time.Minute),
+		Step:    30 * time.Second,
+	}, false, make(chan struct{}))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), processed)
+	// the live clock is given back
+	assert.Equal(t, wallClock, utils.Clock)
+}
@@ -0,0 +1,93 @@
+package replayer
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkpointState is what the read checkpoint of a cluster covers besides the read position: the events of the
+// cluster dispatched to the consumer queues and not consumed yet are waited for, and the dedupe window is saved.
+type checkpointState struct {
+	// events put into and consumed from the queues, an event handed over by a consumer to another queue
+	// is put into it before the consumer finishes, so the two are equal only once it is consumed everywhere
+	produced int64
+	consumed int64
+
+	// guards dedup and dedupStore
+	mutex sync.Mutex
+	// the dedupe window of the processor, nil if it is not persisted
+	dedup      *auditDedupWindow
+	dedupStore AuditIDStore
+}
+
+var (
+	checkpointStatesMutex sync.Mutex
+	checkpointStates      = make(map[string]*checkpointState)
+)
+
+// checkpointStateOf returns the state of cluster shared by its processor and source, created on first use
+func checkpointStateOf(cluster string) *checkpointState {
+	checkpointStatesMutex.Lock()
+	defer checkpointStatesMutex.Unlock()
+	state, ok := checkpointStates[cluster]
+	if !ok {
+		state = &checkpointState{}
+		checkpointStates[cluster] = state
+	}
+	return state
+}
+
+// Produced implements queue.Tracked
+func (s *checkpointState) Produced() {
+	atomic.AddInt64(&s.produced, 1)
+}
+
+// Consumed implements queue.Tracked
+func (s *checkpointState) Consumed() {
+	atomic.AddInt64(&s.consumed, 1)
+}
+
+// inflight returns the number of events in the queues
+func (s *checkpointState) inflight() int64 {
+	return atomic.LoadInt64(&s.produced) - atomic.LoadInt64(&s.consumed)
+}
+
+// waitConsumed returns true once the events dispatched are consumed by all queues, false after timeout.
+// The source must not dispatch events meanwhile. It yields for a while before sleeping, as a replay waits
+// for every event, which is mostly consumed at once.
+func (s *checkpointState) waitConsumed(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for i := 0; s.inflight() > 0; i++ {
+		if time.Now().After(deadline) {
+			return false
+		}
+		if i < 1000 {
+			runtime.Gosched()
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return true
+}
+
+// setDedup tells the checkpoints to save the dedupe window to store
+func (s *checkpointState) setDedup(window *auditDedupWindow, store AuditIDStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dedup = window
+	s.dedupStore = store
+}
+
+// snapshotDedup returns the dedupe window at the checkpoint, which must be taken while the source dispatches nothing,
+// after the events dispatched are consumed. It is nil if the window is not persisted or not changed.
+func (s *checkpointState) snapshotDedup() *dedupSnapshot {
+	s.mutex.Lock()
+	window, store := s.dedup, s.dedupStore
+	s.mutex.Unlock()
+	if window == nil || store == nil {
+		return nil
+	}
+	return snapshotDedupWindow(window, store)
+}
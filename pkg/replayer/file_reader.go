@@ -0,0 +1,366 @@
+package replayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/slo"
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// bytes at the head of the audit log used to tell whether the file was rotated while we were down.
+	// a single audit event is always longer than this, so the head is stable once the first event is written.
+	fingerprintSize  = 256
+	filePollInterval = time.Second
+)
+
+// statFile is replaced by tests to rotate the file between the drain and the check
+var statFile = os.Stat
+
+// fileCheckpoint is persisted to checkpointPath so that a restarted reader resumes from the last byte read,
+// with the in-flight create/upgrade/pvc milestones of the events before Offset.
+type fileCheckpoint struct {
+	Path        string
+	Offset      int64
+	Fingerprint uint32
+	Milestones  []*xsearch.MilestoneSnapshot `json:",omitempty"`
+}
+
+// fileReader tails the JSONL file written by apiserver's --audit-log-path
+type fileReader struct {
+	path           string
+	checkpointPath string
+	cluster        string
+	handler        AuditEventHandler
+	pollInterval   time.Duration
+
+	// held while reading, guards file, reader, pending and stopped
+	readMutex sync.Mutex
+	file      *os.File
+	reader    *bufio.Reader
+	pending   []byte // incomplete last line, waiting for apiserver to finish writing it
+	stopped   bool
+
+	// guards the checkpoint, which is saved while reading
+	mutex       sync.Mutex
+	offset      int64 // offset right after the last complete line
+	fingerprint uint32
+}
+
+// NewFileSource returns the factory of audit source which tails the audit log file at path.
+// The read offset is checkpointed to checkpointPath, which defaults to "<path>.checkpoint".
+func NewFileSource(path, checkpointPath, cluster string) AuditSourceFactory {
+	return func(handler AuditEventHandler) (AuditSource, error) {
+		return newFileReader(handler, path, checkpointPath, cluster), nil
+	}
+}
+
+func newFileReader(handler AuditEventHandler, path, checkpointPath, cluster string) *fileReader {
+	if checkpointPath == "" {
+		checkpointPath = path + ".checkpoint"
+	}
+	return &fileReader{
+		path:           path,
+		checkpointPath: checkpointPath,
+		cluster:        cluster,
+		handler:        handler,
+		pollInterval:   filePollInterval,
+	}
+}
+
+func (r *fileReader) Run(stopCh <-chan struct{}) {
+	klog.Infof("tailing audit log file %s, checkpoint %s", r.path, r.checkpointPath)
+
+	checkpoint := r.loadCheckpoint()
+	r.readMutex.Lock()
+	if err := r.open(checkpoint); err != nil {
+		// the file may not be created yet, readAvailable will retry
+		klog.Errorf("failed to open audit log file %s: %s", r.path, err.Error())
+	}
+	r.readMutex.Unlock()
+	if checkpoint != nil {
+		klog.Infof("restored %d of %d create/upgrade/pvc milestones", slo.RestoreMilestones(checkpoint.Milestones), len(checkpoint.Milestones))
+	}
+
+	go wait.Until(r.readAvailable, r.pollInterval, stopCh)
+
+	// at most behind 20 seconds if app is killed without grace shutdown.
+	go wait.Until(func() {
+		if err := r.saveCheckpoint(checkpointTimeout); err != nil {
+			klog.Errorf("failed to save checkpoint %s: %s", r.checkpointPath, err.Error())
+		}
+	}, checkpointInterval, stopCh)
+}
+
+func (r *fileReader) Stop() {
+	klog.Info("Stop file reader")
+
+	// waits for the lines being read
+	r.readMutex.Lock()
+	r.stopped = true
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	r.readMutex.Unlock()
+
+	if err := r.saveCheckpoint(stopCheckpointTimeout); err != nil {
+		klog.Errorf("failed to save checkpoint %s: %s", r.checkpointPath, err.Error())
+	}
+	klog.Info("Stop file reader completed")
+}
+
+// open opens the audit log file, and seeks to the checkpointed offset if it still belongs to the same file.
+// readMutex must be held.
+func (r *fileReader) open(checkpoint *fileCheckpoint) error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	fingerprint := fileFingerprint(file)
+	offset := int64(0)
+	if checkpoint != nil && checkpoint.Offset <= info.Size() &&
+		(checkpoint.Fingerprint == 0 || checkpoint.Fingerprint == fingerprint) {
+		offset = checkpoint.Offset
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	klog.Infof("audit log file %s opened at offset %d", r.path, offset)
+
+	r.file = file
+	r.reader = bufio.NewReaderSize(file, 1024*1024)
+	r.pending = nil
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.offset = offset
+	r.fingerprint = fingerprint
+	return nil
+}
+
+// readAvailable reads all complete lines appended since last call, then reopens the file if it was rotated.
+func (r *fileReader) readAvailable() {
+	defer utils.IgnorePanic("fileReader.readAvailable")
+
+	r.readMutex.Lock()
+	defer r.readMutex.Unlock()
+	if r.stopped {
+		return
+	}
+	if r.file == nil {
+		if err := r.open(r.loadCheckpoint()); err != nil {
+			klog.V(5).Infof("audit log file %s is not ready: %s", r.path, err.Error())
+			return
+		}
+	}
+
+	r.drain()
+
+	// the file has been drained, now check whether apiserver has rotated or truncated it
+	pathInfo, err := statFile(r.path)
+	if err != nil {
+		klog.V(5).Infof("audit log file %s is not ready: %s", r.path, err.Error())
+		return
+	}
+	fileInfo, err := r.file.Stat()
+	if err != nil {
+		klog.Errorf("failed to stat audit log file %s: %s", r.path, err.Error())
+		return
+	}
+
+	if !os.SameFile(pathInfo, fileInfo) {
+		klog.Infof("audit log file %s is rotated, reopen it", r.path)
+		// lines may have been appended to the rotated file after it was drained
+		r.drain()
+		r.reopen()
+	} else if fileInfo.Size() < r.readOffset() {
+		klog.Infof("audit log file %s is truncated, read from beginning", r.path)
+		r.reopen()
+	} else if r.fingerprint == 0 {
+		// the file was too short to be fingerprinted when opened
+		fingerprint := fileFingerprint(r.file)
+		r.mutex.Lock()
+		r.fingerprint = fingerprint
+		r.mutex.Unlock()
+	}
+}
+
+// reopen reads the file at path from the beginning, readMutex must be held
+func (r *fileReader) reopen() {
+	r.file.Close()
+	r.file = nil
+
+	if err := r.open(nil); err != nil {
+		klog.Errorf("failed to reopen audit log file %s: %s", r.path, err.Error())
+		return
+	}
+	r.drain()
+}
+
+func (r *fileReader) readOffset() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.offset
+}
+
+// drain passes every complete line until EOF to the handler, readMutex must be held
+func (r *fileReader) drain() {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			r.pending = append(r.pending, line...)
+		}
+		if err != nil {
+			if err != io.EOF {
+				klog.Errorf("failed to read audit log file %s: %s", r.path, err.Error())
+			}
+			return
+		}
+
+		r.processLine(r.pending)
+
+		r.mutex.Lock()
+		r.offset += int64(len(r.pending))
+		r.mutex.Unlock()
+		r.pending = nil
+	}
+}
+
+func (r *fileReader) processLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	event := &k8s_audit.Event{}
+	if err := json.Unmarshal(line, event); err != nil {
+		klog.Errorf("failed unmarshal %s, data %s", err.Error(), string(line))
+		deadletter.CaptureRaw(deadletter.StageDecode, r.cluster, line, err)
+		return
+	}
+
+	// filebeat adds cluster to annotations when shipping to elasticsearch, do the same here
+	if event.Annotations == nil {
+		event.Annotations = make(map[string]string)
+	}
+	if event.Annotations["cluster"] == "" {
+		event.Annotations["cluster"] = r.cluster
+	}
+
+	xsearchFetchLagInMilliSecondsSum.WithLabelValues(r.cluster, "audit_file_read").Observe(utils.TimeSinceInSeconds(event.StageTimestamp.Time))
+	r.handler(event)
+}
+
+func (r *fileReader) loadCheckpoint() *fileCheckpoint {
+	data, err := os.ReadFile(r.checkpointPath)
+	if os.IsNotExist(err) {
+		klog.V(5).Infof("checkpoint %s not exists", r.checkpointPath)
+		return nil
+	}
+	if err != nil {
+		klog.Errorf("failed to read checkpoint %s: %s", r.checkpointPath, err.Error())
+		return nil
+	}
+
+	checkpoint := &fileCheckpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		klog.Errorf("failed unmarshal checkpoint %s: %s", string(data), err.Error())
+		return nil
+	}
+	if checkpoint.Path != r.path {
+		klog.Warningf("checkpoint is for %s, not %s, ignore it", checkpoint.Path, r.path)
+		return nil
+	}
+	klog.Infof("got checkpoint of %s at offset %d, fingerprint %d", checkpoint.Path, checkpoint.Offset, checkpoint.Fingerprint)
+	return checkpoint
+}
+
+// saveCheckpoint saves the offset with the milestones and the dedupe window once the events read are consumed,
+// reading is paused meanwhile so all of them are of the same point.
+func (r *fileReader) saveCheckpoint(timeout time.Duration) error {
+	r.readMutex.Lock()
+	defer r.readMutex.Unlock()
+
+	r.mutex.Lock()
+	checkpoint := fileCheckpoint{
+		Path:        r.path,
+		Offset:      r.offset,
+		Fingerprint: r.fingerprint,
+	}
+	r.mutex.Unlock()
+	if !waitEventsConsumed(r.cluster, timeout) {
+		return fmt.Errorf("events read until offset %d are not consumed in %v, the last checkpoint is kept", checkpoint.Offset, timeout)
+	}
+	checkpoint.Milestones = slo.SnapshotMilestones(r.cluster)
+	dedup := checkpointStateOf(r.cluster).snapshotDedup()
+
+	data, err := json.Marshal(checkpoint)
+	if err == nil {
+		err = writeFileAtomic(r.checkpointPath, data)
+	}
+	if err != nil {
+		dedup.discard()
+		return err
+	}
+	dedup.save()
+	klog.V(5).Infof("checkpoint of %s updated to %d", r.path, checkpoint.Offset)
+	return nil
+}
+
+// fileFingerprint returns crc32 of the file head, or 0 if the file is too short to be fingerprinted
+func fileFingerprint(file *os.File) uint32 {
+	head := make([]byte, fingerprintSize)
+	n, err := file.ReadAt(head, 0)
+	if n < fingerprintSize {
+		if err != nil && err != io.EOF {
+			klog.Errorf("failed to read head of %s: %s", file.Name(), err.Error())
+		}
+		return 0
+	}
+	return crc32.ChecksumIEEE(head)
+}
+
+// writeFileAtomic writes to a temp file and rename, so the file is never half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package replayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/queue"
+	"github.com/alipay/container-observability-service/pkg/shares"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func auditLine(t *testing.T, auditID string) string {
+	event := &k8s_audit.Event{
+		AuditID:        types.UID(auditID),
+		Verb:           "create",
+		StageTimestamp: metav1.NewMicroTime(time.Now()),
+		ObjectRef: &k8s_audit.ObjectReference{
+			Resource:  "pods",
+			Namespace: "default",
+			Name:      "pod-" + auditID,
+		},
+		// makes every line longer than fingerprintSize
+		RequestURI: "/api/v1/namespaces/default/pods/" + strings.Repeat("x", fingerprintSize),
+	}
+	data, err := json.Marshal(event)
+	assert.Nil(t, err)
+	return string(data) + "\n"
+}
+
+func appendFile(t *testing.T, path, content string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(content)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func newTestFileReader(path string) (*fileReader, *[]string) {
+	got := make([]string, 0)
+	reader := newFileReader(func(event *k8s_audit.Event) {
+		got = append(got, string(event.AuditID))
+	}, path, "", "test-cluster")
+	return reader, &got
+}
+
+func TestFileReaderPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, got := newTestFileReader(path)
+
+	line1, line2 := auditLine(t, "1"), auditLine(t, "2")
+	appendFile(t, path, line1+line2[:10])
+	reader.readAvailable()
+	assert.Equal(t, []string{"1"}, *got)
+	assert.Equal(t, int64(len(line1)), reader.offset)
+
+	appendFile(t, path, line2[10:])
+	reader.readAvailable()
+	assert.Equal(t, []string{"1", "2"}, *got)
+	assert.Equal(t, int64(len(line1)+len(line2)), reader.offset)
+}
+
+func TestFileReaderDecodeFailure(t *testing.T) {
+	deadletter.SetStore(deadletter.NewMemoryStore(10))
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, got := newTestFileReader(path)
+
+	appendFile(t, path, "{\"auditID\":\n"+auditLine(t, "2"))
+	reader.readAvailable()
+	assert.Equal(t, []string{"2"}, *got)
+
+	letters, err := deadletter.List(&deadletter.Filter{Stage: deadletter.StageDecode})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "test-cluster", letters[0].Cluster)
+}
+
+func TestFileReaderRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, got := newTestFileReader(path)
+
+	appendFile(t, path, auditLine(t, "1"))
+	reader.readAvailable()
+
+	// apiserver renames the full file and writes a new one
+	appendFile(t, path, auditLine(t, "2"))
+	assert.Nil(t, os.Rename(path, path+".1"))
+	appendFile(t, path, auditLine(t, "3"))
+	reader.readAvailable()
+	assert.Equal(t, []string{"1", "2", "3"}, *got)
+
+	appendFile(t, path, auditLine(t, "4"))
+	reader.readAvailable()
+	assert.Equal(t, []string{"1", "2", "3", "4"}, *got)
+}
+
+func TestFileReaderRotationAfterDrain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, got := newTestFileReader(path)
+	defer func() { statFile = os.Stat }()
+
+	appendFile(t, path, auditLine(t, "1"))
+	reader.readAvailable()
+
+	// apiserver writes the old file and rotates it once it has been drained
+	statFile = func(name string) (os.FileInfo, error) {
+		statFile = os.Stat
+		appendFile(t, path, auditLine(t, "2"))
+		assert.Nil(t, os.Rename(path, path+".1"))
+		appendFile(t, path, auditLine(t, "3"))
+		return os.Stat(name)
+	}
+	reader.readAvailable()
+	assert.Equal(t, []string{"1", "2", "3"}, *got)
+}
+
+func TestFileReaderCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, got := newTestFileReader(path)
+
+	for i := 0; i < 3; i++ {
+		appendFile(t, path, auditLine(t, fmt.Sprint(i)))
+	}
+	reader.readAvailable()
+	assert.Equal(t, 3, len(*got))
+	reader.Stop()
+
+	// restarted reader resumes after the checkpoint
+	appendFile(t, path, auditLine(t, "3"))
+	reader, got = newTestFileReader(path)
+	assert.Nil(t, reader.open(reader.loadCheckpoint()))
+	reader.readAvailable()
+	assert.Equal(t, []string{"3"}, *got)
+	reader.Stop()
+
+	// checkpoint of a rotated file is ignored
+	assert.Nil(t, os.Remove(path))
+	appendFile(t, path, auditLine(t, "4")+auditLine(t, "5")+auditLine(t, "6")+auditLine(t, "7")+auditLine(t, "8"))
+	reader, got = newTestFileReader(path)
+	assert.Nil(t, reader.open(reader.loadCheckpoint()))
+	reader.readAvailable()
+	assert.Equal(t, []string{"4", "5", "6", "7", "8"}, *got)
+	reader.Stop()
+}
+
+func TestFileReaderCheckpointWaitsForConsumers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, _ := newTestFileReader(path)
+	appendFile(t, path, auditLine(t, "1"))
+	reader.readAvailable()
+	assert.Nil(t, reader.saveCheckpoint(time.Second))
+	assert.NotNil(t, reader.loadCheckpoint())
+
+	release := make(chan struct{})
+	busy := queue.NewBoundedQueue("test-busy-consumer", 10, nil)
+	busy.StartConsumers(1, func(item interface{}) {
+		<-release
+	})
+	defer busy.Stop()
+
+	// events of other clusters are not waited for
+	other := shares.NewAuditEvent(&k8s_audit.Event{})
+	other.Track(checkpointStateOf("other-cluster"))
+	busy.Produce(other)
+	assert.Nil(t, reader.saveCheckpoint(time.Second))
+
+	// the milestones of events still in queues are not snapshotted yet
+	event := shares.NewAuditEvent(&k8s_audit.Event{})
+	event.Track(checkpointStateOf("test-cluster"))
+	busy.Produce(event)
+
+	appendFile(t, path, auditLine(t, "2"))
+	reader.readAvailable()
+	assert.NotNil(t, reader.saveCheckpoint(200*time.Millisecond))
+	checkpoint := reader.loadCheckpoint()
+	assert.Less(t, checkpoint.Offset, reader.readOffset())
+
+	close(release)
+	assert.Nil(t, reader.saveCheckpoint(time.Second))
+	assert.Equal(t, reader.readOffset(), reader.loadCheckpoint().Offset)
+}
+
+func TestFileReaderStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	reader, got := newTestFileReader(path)
+
+	appendFile(t, path, auditLine(t, "1"))
+	reader.readAvailable()
+	reader.Stop()
+
+	// a poll scheduled before stop does not reopen the file
+	appendFile(t, path, auditLine(t, "2"))
+	reader.readAvailable()
+	assert.Equal(t, []string{"1"}, *got)
+	assert.Nil(t, reader.file)
+}
@@ -0,0 +1,217 @@
+package replayer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/queue"
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/olivere/elastic/v7"
+	"k8s.io/apimachinery/pkg/util/clock"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	"k8s.io/klog/v2"
+)
+
+// ReplayOptions tells where to read the audit events of a replay from
+type ReplayOptions struct {
+	Cluster string
+	// audit log files written by apiserver, in JSONL
+	AuditFiles []string
+	// read audit events from elasticsearch if no file is given
+	ESConf *xsearch.ElasticSearchConf
+	// only events in [From, To) are replayed, zero means unbounded
+	From time.Time
+	To   time.Time
+}
+
+// replayEventTimeout is how long a replay waits for an event to be consumed before it goes on with the next one
+const replayEventTimeout = 10 * time.Second
+
+// replaySource passes the loaded events to the handler in StageTimestamp order,
+// the clock is set to the time of each event before it is handled.
+type replaySource struct {
+	events  []*k8s_audit.Event
+	cluster string
+	handler AuditEventHandler
+	clock   *clock.FakePassiveClock
+	// counts the events of the cluster in the queues
+	state *checkpointState
+	// number of replayed events
+	processed int
+}
+
+func newReplaySource(events []*k8s_audit.Event, cluster string, handler AuditEventHandler) *replaySource {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].StageTimestamp.Before(&events[j].StageTimestamp)
+	})
+
+	start := time.Now()
+	if len(events) > 0 {
+		start = events[0].StageTimestamp.Time
+	}
+	return &replaySource{
+		events:  events,
+		cluster: cluster,
+		handler: handler,
+		clock:   clock.NewFakePassiveClock(start),
+		state:   checkpointStateOf(cluster),
+	}
+}
+
+// handleInEventTime sets clock to the time of event and waits for the consumers to finish the event after it is handled,
+// so they read the time of the event they process rather than of an event read after it, and the results are the same
+// every time the events are replayed.
+func handleInEventTime(clock *clock.FakePassiveClock, state *checkpointState, handler AuditEventHandler, event *k8s_audit.Event) {
+	clock.SetTime(event.StageTimestamp.Time)
+	handler(event)
+	if !state.waitConsumed(replayEventTimeout) {
+		klog.Warningf("event %s is not consumed in %v, the clock goes on", utils.DumpsEventKeyInfo(event), replayEventTimeout)
+	}
+}
+
+// Run replays all events before return
+func (s *replaySource) Run(stopCh <-chan struct{}) {
+	for _, event := range s.events {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		if event.Annotations == nil {
+			event.Annotations = make(map[string]string)
+		}
+		if event.Annotations["cluster"] == "" {
+			event.Annotations["cluster"] = s.cluster
+		}
+		handleInEventTime(s.clock, s.state, s.handler, event)
+		s.processed++
+	}
+}
+
+func (s *replaySource) Stop() {}
+
+// Replay runs the audit events through the same processors as aggregator, with the clock following event time,
+// one event at a time. It returns after all results are flushed by the savers of xsearch.
+func Replay(options *ReplayOptions, enableTrace bool, stopCh <-chan struct{}) (int, error) {
+	var events []*k8s_audit.Event
+	var err error
+	if len(options.AuditFiles) > 0 {
+		events, err = loadAuditFiles(options.AuditFiles, options.From, options.To)
+	} else if options.ESConf != nil {
+		events, err = loadAuditEventsFromES(options.ESConf, options.Cluster, options.From, options.To)
+	} else {
+		err = fmt.Errorf("neither audit file nor elasticsearch is given")
+	}
+	if err != nil {
+		return 0, err
+	}
+	klog.Infof("%d audit events loaded for replay", len(events))
+
+	var source *replaySource
+	processor, err := NewAuditProcessor(func(handler AuditEventHandler) (AuditSource, error) {
+		source = newReplaySource(events, options.Cluster, handler)
+		return source, nil
+	}, options.Cluster, enableTrace)
+	if err != nil {
+		return 0, err
+	}
+
+	wallClock := utils.Clock
+	utils.Clock = source.clock
+	defer func() {
+		utils.Clock = wallClock
+	}()
+
+	processor.Start(stopCh)
+	select {
+	case <-stopCh:
+		// buffers are flushed when the signal is received
+		return source.processed, fmt.Errorf("replay is interrupted")
+	default:
+	}
+
+	// every event is handed over to the queues, wait them to be consumed and then flush all buffers
+	queue.WaitAllIdle(100*time.Millisecond, 10)
+	xsearch.XSearchClear.DoClear()
+	klog.Infof("%d audit events replayed", source.processed)
+
+	return source.processed, nil
+}
+
+// loadAuditFiles reads all events in [from, to) of the audit log files
+func loadAuditFiles(paths []string, from, to time.Time) ([]*k8s_audit.Event, error) {
+	events := make([]*k8s_audit.Event, 0)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		reader := bufio.NewReaderSize(file, 1024*1024)
+		lineNum := 0
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				lineNum++
+				event := &k8s_audit.Event{}
+				if er := json.Unmarshal(line, event); er != nil {
+					klog.Errorf("failed unmarshal line %d of %s: %s", lineNum, path, er.Error())
+				} else if inTimeRange(event, from, to) {
+					events = append(events, event)
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		file.Close()
+	}
+	return events, nil
+}
+
+// loadAuditEventsFromES reads all events in [from, to) of cluster from the audit index
+func loadAuditEventsFromES(conf *xsearch.ElasticSearchConf, cluster string, from, to time.Time) ([]*k8s_audit.Event, error) {
+	if from.IsZero() || to.IsZero() {
+		return nil, fmt.Errorf("time range is required to replay from elasticsearch")
+	}
+
+	client, err := elastic.NewClient(elastic.SetURL(conf.Endpoint),
+		elastic.SetBasicAuth(conf.User, conf.Password),
+		elastic.SetSniff(false),
+		elastic.SetDecoder(&utils.JsoniterDecoder{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	index := newAuditIndexResolver(client, conf, cluster).resolve(from, to)
+	query := auditQuery(conf, cluster, from, to)
+
+	events := make([]*k8s_audit.Event, 0)
+	err = xsearch.Scroll(client, index, query, func(hit json.RawMessage) error {
+		event := &k8s_audit.Event{}
+		if err := json.Unmarshal(hit, event); err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	}, 1)
+	return events, err
+}
+
+func inTimeRange(event *k8s_audit.Event, from, to time.Time) bool {
+	if !from.IsZero() && event.StageTimestamp.Time.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !event.StageTimestamp.Time.Before(to) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+package replayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/alipay/container-observability-service/pkg/shares/base_processor"
+	_ "github.com/alipay/container-observability-service/pkg/shares/extractor"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func TestReplaySource(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []*k8s_audit.Event{
+		{AuditID: "2", StageTimestamp: metav1.NewMicroTime(base.Add(2 * time.Second))},
+		{AuditID: "1", StageTimestamp: metav1.NewMicroTime(base.Add(time.Second))},
+		{AuditID: "3", StageTimestamp: metav1.NewMicroTime(base.Add(3 * time.Second)),
+			Annotations: map[string]string{"cluster": "other"}},
+	}
+
+	var source *replaySource
+	got := make([]string, 0)
+	source = newReplaySource(events, "test-cluster", func(event *k8s_audit.Event) {
+		// the clock follows the event being handled
+		assert.Equal(t, event.StageTimestamp.Time, source.clock.Now())
+		got = append(got, string(event.AuditID)+"/"+event.Annotations["cluster"])
+	})
+	assert.Equal(t, base.Add(time.Second), source.clock.Now())
+
+	source.Run(make(chan struct{}))
+	assert.Equal(t, []string{"1/test-cluster", "2/test-cluster", "3/other"}, got)
+	assert.Equal(t, 3, source.processed)
+}
+
+func TestLoadAuditFiles(t *testing.T) {
+	dir := t.TempDir()
+	file1, file2 := filepath.Join(dir, "audit-1.log"), filepath.Join(dir, "audit.log")
+	assert.Nil(t, os.WriteFile(file1, []byte(auditLine(t, "1")+"not json\n"+auditLine(t, "2")), 0644))
+	// the last line may be not terminated
+	line3 := auditLine(t, "3")
+	assert.Nil(t, os.WriteFile(file2, []byte(line3[:len(line3)-1]), 0644))
+
+	events, err := loadAuditFiles([]string{file1, file2}, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(events))
+
+	events, err = loadAuditFiles([]string{file1, file2}, time.Now().Add(time.Hour), time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(events))
+
+	_, err = loadAuditFiles([]string{filepath.Join(dir, "not-exists.log")}, time.Time{}, time.Time{})
+	assert.NotNil(t, err)
+}
+
+// replayPodLine is an audit event of the pod at seconds after base, the pod is the response object
+func replayPodLine(t *testing.T, base time.Time, verb, subresource string, pod *v1.Pod, seconds int) string {
+	raw, err := json.Marshal(pod)
+	assert.Nil(t, err)
+	code := int32(200)
+	if verb == "create" && subresource == "" {
+		code = 201
+	}
+	event := &k8s_audit.Event{
+		AuditID:        types.UID(fmt.Sprintf("%s-%s-%s-%d", verb, subresource, pod.Name, seconds)),
+		Stage:          k8s_audit.StageResponseComplete,
+		Verb:           verb,
+		StageTimestamp: metav1.NewMicroTime(base.Add(time.Duration(seconds) * time.Second)),
+		ResponseStatus: &metav1.Status{Code: code},
+		ObjectRef: &k8s_audit.ObjectReference{
+			Resource: "pods", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID, Subresource: subresource,
+		},
+		ResponseObject: &runtime.Unknown{Raw: raw},
+	}
+	if verb == "create" && subresource == "" {
+		event.ObjectRef.UID = ""
+	}
+	data, err := json.Marshal(event)
+	assert.Nil(t, err)
+	return string(data) + "\n"
+}
+
+// replayTestPods writes an audit log of pods created, scheduled, getting ready and deleted, close to each other
+func replayTestPods(t *testing.T, path string, base time.Time) {
+	lines := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		created := metav1.NewTime(base.Add(time.Duration(i) * time.Second))
+		pod := &v1.Pod{
+			TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default", Name: fmt.Sprintf("pod-%d", i), UID: types.UID(fmt.Sprintf("pod-%d-uid", i)),
+				CreationTimestamp: created,
+			},
+			Spec: v1.PodSpec{Containers: []v1.Container{{Name: "main", Image: "nginx"}}},
+		}
+		lines = append(lines, replayPodLine(t, base, "create", "", pod, i))
+
+		scheduled := pod.DeepCopy()
+		scheduled.Spec.NodeName = "node-1"
+		scheduled.Status.Conditions = []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: created}}
+		lines = append(lines, replayPodLine(t, base, "create", "binding", scheduled, i+1))
+
+		ready := scheduled.DeepCopy()
+		ready.Status.Phase = v1.PodRunning
+		readyAt := metav1.NewTime(created.Add(3 * time.Second))
+		ready.Status.Conditions = append(ready.Status.Conditions,
+			v1.PodCondition{Type: v1.ContainersReady, Status: v1.ConditionTrue, LastTransitionTime: readyAt},
+			v1.PodCondition{Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: readyAt})
+		lines = append(lines, replayPodLine(t, base, "patch", "status", ready, i+3))
+
+		if i%2 == 0 {
+			deleting := ready.DeepCopy()
+			deletedAt := metav1.NewTime(created.Add(10 * time.Second))
+			deleting.DeletionTimestamp = &deletedAt
+			lines = append(lines, replayPodLine(t, base, "delete", "", deleting, i+10))
+			lines = append(lines, replayPodLine(t, base, "delete", "", deleting, i+12))
+		}
+	}
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "")), 0644))
+}
+
+func TestReplayTwice(t *testing.T) {
+	// replays in a process of its own, as the SLOs are kept in global state
+	if output := os.Getenv("REPLAY_TEST_OUTPUT"); output != "" {
+		writer, err := xsearch.NewJSONDocWriter(output)
+		assert.Nil(t, err)
+		xsearch.SetDocWriter(writer)
+		processed, err := Replay(&ReplayOptions{
+			Cluster:    "replay-test",
+			AuditFiles: []string{os.Getenv("REPLAY_TEST_AUDIT_FILE")},
+		}, false, make(chan struct{}))
+		assert.Nil(t, err)
+		assert.True(t, processed > 0)
+		assert.Nil(t, writer.Close())
+		return
+	}
+
+	dir := t.TempDir()
+	auditFile := filepath.Join(dir, "audit.log")
+	replayTestPods(t, auditFile, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	replay := func(name string) map[string]string {
+		output := filepath.Join(dir, name)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestReplayTwice$")
+		cmd.Env = append(os.Environ(), "REPLAY_TEST_OUTPUT="+output, "REPLAY_TEST_AUDIT_FILE="+auditFile)
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+
+		docs := make(map[string]string)
+		files, err := os.ReadDir(output)
+		assert.Nil(t, err)
+		for _, file := range files {
+			data, err := os.ReadFile(filepath.Join(output, file.Name()))
+			assert.Nil(t, err)
+			docs[file.Name()] = string(data)
+		}
+		return docs
+	}
+
+	first := replay("first")
+	assert.NotEmpty(t, first["slo_data.json"])
+	// the create SLOs finish in the time of the events getting the pods ready
+	for _, line := range strings.Split(strings.TrimSpace(first["slo_data.json"]), "\n") {
+		doc := struct {
+			Source struct {
+				PodName    string
+				ReadyAt    time.Time
+				FinishTime time.Time
+			} `json:"_source"`
+		}{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &doc))
+		assert.Equal(t, doc.Source.ReadyAt, doc.Source.FinishTime, doc.Source.PodName)
+	}
+	second := replay("second")
+	assert.Equal(t, len(first), len(second))
+	for name, docs := range first {
+		assert.Equal(t, docs, second[name], name)
+	}
+}
@@ -0,0 +1,162 @@
+package replayer
+
+import (
+	"encoding/json"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+var (
+	eventsOfOtherShards = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricNamePrefix + "audit_events_of_other_shards",
+			Help: "number of audit events skipped because the objects belong to other shards",
+		},
+		[]string{"cluster"},
+	)
+	eventsObserved = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricNamePrefix + "audit_events_observed_from_other_shards",
+			Help: "number of audit events of the pods of other shards followed by the cluster shard for the nodes and workloads",
+		},
+		[]string{"cluster"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventsOfOtherShards)
+	prometheus.MustRegister(eventsObserved)
+}
+
+const auditUIDField = "objectRef.uid.keyword"
+
+// shardScript is the part of shardRoute the audit index tells, the pod events are matched by the range of the fnv32
+// hash of objectRef.uid, which wraps around in int as in uint32. The uid of a pod created and the object of an event
+// are only in the event body, so they are left to shardRoute. UIDs are ASCII, so their chars are the same as their bytes.
+const shardScript = `
+if (doc['` + auditResourceField + `'].size() == 0) {
+	return false;
+}
+String resource = doc['` + auditResourceField + `'].value;
+if (resource == 'events') {
+	return true;
+}
+if (resource != 'pods') {
+	return false;
+}
+if (doc['` + auditUIDField + `'].size() == 0 || doc['` + auditUIDField + `'].value == '') {
+	return true;
+}
+String uid = doc['` + auditUIDField + `'].value;
+int hash = -2128831035;
+for (int i = 0; i < uid.length(); i++) {
+	hash *= 16777619;
+	hash ^= (int) uid.charAt(i);
+}
+return (int) (((hash & 0xFFFFFFFFL) * params.count) >>> 32) == params.index;
+`
+
+// shardRoute is how an audit event is processed by a shard
+type shardRoute int
+
+const (
+	// processed by all consumers
+	routeOwned shardRoute = iota
+	// the pod belongs to another shard, the cluster shard follows it only for the nodes and workloads made of it
+	routeObserved
+	// skipped
+	routeOther
+)
+
+// routeAuditEvent tells how shard processes event. The events of a pod, and the events about it, go to the shard owning
+// the uid of the pod. The other objects go to the cluster shard, so do the pod events without uid, e.g. failed creates.
+func routeAuditEvent(shard utils.Shard, event *k8s_audit.Event) shardRoute {
+	if !shard.Enabled() {
+		return routeOwned
+	}
+	uid, isPod := auditPodUID(event)
+	switch {
+	case !isPod || uid == "":
+		if shard.OwnsCluster() {
+			return routeOwned
+		}
+	case shard.Owns(uid):
+		return routeOwned
+	case shard.OwnsCluster() && event.ObjectRef.Resource == "pods":
+		return routeObserved
+	}
+	return routeOther
+}
+
+// objectUIDs is the part of objects telling the uid, events are sharded by the object they are about
+type objectUIDs struct {
+	Metadata struct {
+		UID string `json:"uid"`
+	} `json:"metadata"`
+	// core/v1 events
+	InvolvedObject struct {
+		Kind string `json:"kind"`
+		UID  string `json:"uid"`
+	} `json:"involvedObject"`
+	// events.k8s.io/v1 events
+	Regarding struct {
+		Kind string `json:"kind"`
+		UID  string `json:"uid"`
+	} `json:"regarding"`
+}
+
+// auditPodUID returns the uid of the pod of event, and if it is about a pod. The uid of a pod is set in objectRef
+// except when it is created, and the one of an event is the pod it is about, both are read without decoding the objects.
+func auditPodUID(event *k8s_audit.Event) (string, bool) {
+	if event.ObjectRef == nil {
+		return "", false
+	}
+	resource := event.ObjectRef.Resource
+	if resource != "pods" && resource != "events" {
+		return "", false
+	}
+	if resource == "pods" && event.ObjectRef.UID != "" {
+		return string(event.ObjectRef.UID), true
+	}
+
+	for _, obj := range []*runtime.Unknown{event.ResponseObject, event.RequestObject} {
+		if obj == nil || len(obj.Raw) == 0 {
+			continue
+		}
+		uids := &objectUIDs{}
+		if err := json.Unmarshal(obj.Raw, uids); err != nil {
+			continue
+		}
+		if resource == "pods" {
+			// a binding has the uid of the pod too
+			if uids.Metadata.UID != "" {
+				return uids.Metadata.UID, true
+			}
+			continue
+		}
+		if uids.InvolvedObject.Kind != "" {
+			return uids.InvolvedObject.UID, uids.InvolvedObject.Kind == "Pod"
+		}
+		if uids.Regarding.Kind != "" {
+			return uids.Regarding.UID, uids.Regarding.Kind == "Pod"
+		}
+	}
+	return "", resource == "pods"
+}
+
+// shardQuery matches the audit events shard may process, so most of the pod events of the other shards are not fetched.
+// The cluster shard follows the pods of all shards, so it is not filtered.
+func shardQuery(shard utils.Shard) elastic.Query {
+	if shard.OwnsCluster() {
+		return nil
+	}
+	script := elastic.NewScript(shardScript).Lang("painless").
+		Param("index", shard.Index).
+		Param("count", shard.Count)
+	return elastic.NewScriptQuery(script)
+}
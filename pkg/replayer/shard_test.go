@@ -0,0 +1,105 @@
+package replayer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func TestAuditPodUID(t *testing.T) {
+	pod := &k8s_audit.Event{ObjectRef: &k8s_audit.ObjectReference{Resource: "pods", Namespace: "ns1", Name: "a", UID: "a-uid"}}
+	uid, isPod := auditPodUID(pod)
+	assert.Equal(t, "a-uid", uid)
+	assert.True(t, isPod)
+
+	// the uid of a pod created is in the response
+	created := &k8s_audit.Event{
+		ObjectRef:      &k8s_audit.ObjectReference{Resource: "pods", Namespace: "ns1", Name: "a"},
+		ResponseObject: &runtime.Unknown{Raw: []byte(`{"metadata":{"name":"a","uid":"a-uid"}}`)},
+	}
+	uid, isPod = auditPodUID(created)
+	assert.Equal(t, "a-uid", uid)
+	assert.True(t, isPod)
+
+	// failed to create
+	failed := &k8s_audit.Event{ObjectRef: &k8s_audit.ObjectReference{Resource: "pods", Namespace: "ns1", Name: "a"}}
+	uid, isPod = auditPodUID(failed)
+	assert.Equal(t, "", uid)
+	assert.True(t, isPod)
+
+	event := &k8s_audit.Event{
+		ObjectRef:     &k8s_audit.ObjectReference{Resource: "events", Namespace: "ns1", Name: "a.1", UID: "event-uid"},
+		RequestObject: &runtime.Unknown{Raw: []byte(`{"involvedObject":{"kind":"Pod","uid":"a-uid"}}`)},
+	}
+	uid, isPod = auditPodUID(event)
+	assert.Equal(t, "a-uid", uid)
+	assert.True(t, isPod)
+
+	nodeEvent := &k8s_audit.Event{
+		ObjectRef:     &k8s_audit.ObjectReference{Resource: "events", Namespace: "default", Name: "n1.1"},
+		RequestObject: &runtime.Unknown{Raw: []byte(`{"regarding":{"kind":"Node","uid":"n1-uid"}}`)},
+	}
+	_, isPod = auditPodUID(nodeEvent)
+	assert.False(t, isPod)
+
+	node := &k8s_audit.Event{ObjectRef: &k8s_audit.ObjectReference{Resource: "nodes", Name: "node-1", UID: "n1-uid"}}
+	_, isPod = auditPodUID(node)
+	assert.False(t, isPod)
+	_, isPod = auditPodUID(&k8s_audit.Event{})
+	assert.False(t, isPod)
+}
+
+func TestRouteAuditEvent(t *testing.T) {
+	cluster := utils.Shard{Index: 0, Count: 2}
+	other := utils.Shard{Index: 1, Count: 2}
+	podOf := func(shard utils.Shard) *k8s_audit.Event {
+		for i := 0; ; i++ {
+			uid := types.UID(string(rune('a'+i%26)) + "-uid")
+			if shard.Owns(string(uid)) {
+				return &k8s_audit.Event{ObjectRef: &k8s_audit.ObjectReference{Resource: "pods", Name: "a", UID: uid}}
+			}
+		}
+	}
+
+	assert.Equal(t, routeOwned, routeAuditEvent(cluster, podOf(cluster)))
+	assert.Equal(t, routeOther, routeAuditEvent(other, podOf(cluster)))
+	assert.Equal(t, routeOwned, routeAuditEvent(other, podOf(other)))
+	// the cluster shard follows the pods of other shards for the nodes and workloads
+	assert.Equal(t, routeObserved, routeAuditEvent(cluster, podOf(other)))
+
+	event := &k8s_audit.Event{
+		ObjectRef:     &k8s_audit.ObjectReference{Resource: "events", Namespace: "ns1"},
+		RequestObject: &runtime.Unknown{Raw: []byte(`{"involvedObject":{"kind":"Pod","uid":"` + string(podOf(other).ObjectRef.UID) + `"}}`)},
+	}
+	assert.Equal(t, routeOwned, routeAuditEvent(other, event))
+	assert.Equal(t, routeOther, routeAuditEvent(cluster, event))
+
+	for _, e := range []*k8s_audit.Event{
+		{ObjectRef: &k8s_audit.ObjectReference{Resource: "nodes", Name: "node-1", UID: "n1-uid"}},
+		{ObjectRef: &k8s_audit.ObjectReference{Resource: "deployments", Namespace: "ns1", Name: "web"}},
+		{ObjectRef: &k8s_audit.ObjectReference{Resource: "pods", Namespace: "ns1", Name: "failed"}},
+	} {
+		assert.Equal(t, routeOwned, routeAuditEvent(cluster, e))
+		assert.Equal(t, routeOther, routeAuditEvent(other, e))
+	}
+
+	single := utils.Shard{Index: 0, Count: 1}
+	assert.Equal(t, routeOwned, routeAuditEvent(single, podOf(other)))
+}
+
+func TestShardQuery(t *testing.T) {
+	assert.Nil(t, shardQuery(utils.Shard{Index: 0, Count: 4}))
+
+	source, err := shardQuery(utils.Shard{Index: 1, Count: 4}).Source()
+	assert.Nil(t, err)
+	data, err := json.Marshal(source)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"lang":"painless"`)
+	assert.Contains(t, string(data), `"params":{"count":4,"index":1}`)
+	assert.Contains(t, string(data), "doc['objectRef.uid.keyword']")
+}
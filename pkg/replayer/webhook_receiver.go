@@ -0,0 +1,184 @@
+package replayer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// AuditWebhookPath is where apiserver's audit webhook backend posts EventList to
+	AuditWebhookPath = "/audit/webhook"
+	// apiserver sends at most --audit-webhook-batch-max-size(400) events per request
+	maxWebhookBodySize = 64 * 1024 * 1024
+)
+
+var (
+	webhookRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricNamePrefix + "audit_webhook_requests",
+			Help: "number of EventList requests received by audit webhook",
+		},
+		[]string{"cluster", "code"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(webhookRequests)
+}
+
+// WebhookConfig configs the http server receiving audit events from apiserver
+type WebhookConfig struct {
+	ListenAddr  string
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// webhookReceiver receives audit.k8s.io/v1 EventList posted by apiserver's --audit-webhook-config-file backend
+type webhookReceiver struct {
+	config  *WebhookConfig
+	cluster string
+	handler AuditEventHandler
+	server  *http.Server
+
+	// handler expects events in order, so batches from different apiservers are processed one by one
+	mutex sync.Mutex
+}
+
+// NewWebhookSource returns the factory of audit source which receives events from apiserver's audit webhook backend.
+func NewWebhookSource(config *WebhookConfig, cluster string) AuditSourceFactory {
+	return func(handler AuditEventHandler) (AuditSource, error) {
+		return newWebhookReceiver(handler, config, cluster), nil
+	}
+}
+
+func newWebhookReceiver(handler AuditEventHandler, config *WebhookConfig, cluster string) *webhookReceiver {
+	receiver := &webhookReceiver{
+		config:  config,
+		cluster: cluster,
+		handler: handler,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(AuditWebhookPath, receiver)
+	receiver.server = &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: mux,
+	}
+	return receiver
+}
+
+func (r *webhookReceiver) Run(stopCh <-chan struct{}) {
+	klog.Infof("audit webhook listening on %s%s", r.config.ListenAddr, AuditWebhookPath)
+	go func() {
+		var err error
+		if r.config.TLSCertFile != "" && r.config.TLSKeyFile != "" {
+			err = r.server.ListenAndServeTLS(r.config.TLSCertFile, r.config.TLSKeyFile)
+		} else {
+			err = r.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			klog.Fatalf("audit webhook server failed: %s", err.Error())
+		}
+	}()
+}
+
+func (r *webhookReceiver) Stop() {
+	klog.Info("Stop audit webhook")
+	// apiserver retries the batch if it is not acknowledged, so let the inflight ones finish
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.server.Shutdown(ctx); err != nil {
+		klog.Errorf("failed to shutdown audit webhook: %s", err.Error())
+	}
+	// nothing to resume from but the dedupe window of the events acknowledged
+	if waitEventsConsumed(r.cluster, stopCheckpointTimeout) {
+		checkpointStateOf(r.cluster).snapshotDedup().save()
+	} else {
+		klog.Errorf("[%s]events are not consumed in %v, the last dedupe window is kept", r.cluster, stopCheckpointTimeout)
+	}
+	klog.Info("Stop audit webhook completed")
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		// apiserver resends the batch only if it is not acknowledged
+		if err := recover(); err != nil {
+			utils.LogPanic("webhookReceiver.ServeHTTP", err)
+			webhookRequests.WithLabelValues(r.cluster, "500").Inc()
+			http.Error(w, fmt.Sprintf("failed to process audit events: %v", err), http.StatusInternalServerError)
+		}
+	}()
+
+	if req.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	eventList := &auditv1.EventList{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxWebhookBodySize)).Decode(eventList); err != nil {
+		klog.Errorf("failed to decode audit event list: %s", err.Error())
+		webhookRequests.WithLabelValues(r.cluster, "400").Inc()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events := make([]*k8s_audit.Event, 0, len(eventList.Items))
+	for i := range eventList.Items {
+		event := &k8s_audit.Event{}
+		if err := auditv1.Convert_v1_Event_To_audit_Event(&eventList.Items[i], event, nil); err != nil {
+			klog.Errorf("failed to convert audit event %s: %s", eventList.Items[i].AuditID, err.Error())
+			// the batch is acknowledged, keep the event to be replayed after a fix
+			raw, _ := json.Marshal(&eventList.Items[i])
+			deadletter.CaptureRaw(deadletter.StageDecode, r.cluster, raw, err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	r.processEvents(events)
+
+	webhookRequests.WithLabelValues(r.cluster, "200").Inc()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *webhookReceiver) processEvents(events []*k8s_audit.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, event := range events {
+		// filebeat adds cluster to annotations when shipping to elasticsearch, do the same here
+		if event.Annotations == nil {
+			event.Annotations = make(map[string]string)
+		}
+		if event.Annotations["cluster"] == "" {
+			event.Annotations["cluster"] = r.cluster
+		}
+
+		xsearchFetchLagInMilliSecondsSum.WithLabelValues(r.cluster, "audit_webhook").Observe(utils.TimeSinceInSeconds(event.StageTimestamp.Time))
+		r.handleEvent(event)
+	}
+}
+
+// handleEvent keeps the event in the dead-letter store if it fails, so the rest of the batch is processed and
+// acknowledged, rather than the whole batch resent by apiserver and failed again
+func (r *webhookReceiver) handleEvent(event *k8s_audit.Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			utils.LogPanic("webhookReceiver.handleEvent", err)
+			deadletter.Capture(deadletter.StageDispatch, event, fmt.Errorf("panic in webhookReceiver.handleEvent: %v", err))
+		}
+	}()
+	r.handler(event)
+}
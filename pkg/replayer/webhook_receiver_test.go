@@ -0,0 +1,95 @@
+package replayer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+
+	"github.com/stretchr/testify/assert"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+const testEventList = `{
+  "kind": "EventList",
+  "apiVersion": "audit.k8s.io/v1",
+  "metadata": {},
+  "items": [
+    {
+      "level": "RequestResponse",
+      "auditID": "a1",
+      "stage": "ResponseComplete",
+      "requestURI": "/api/v1/namespaces/default/pods",
+      "verb": "create",
+      "user": {"username": "admin"},
+      "objectRef": {"resource": "pods", "namespace": "default", "name": "nginx", "apiVersion": "v1"},
+      "responseStatus": {"metadata": {}, "code": 201},
+      "requestReceivedTimestamp": "2023-01-01T00:00:00.000000Z",
+      "stageTimestamp": "2023-01-01T00:00:00.100000Z"
+    },
+    {
+      "level": "Metadata",
+      "auditID": "a2",
+      "stage": "ResponseComplete",
+      "verb": "delete",
+      "objectRef": {"resource": "pods", "namespace": "default", "name": "nginx"},
+      "annotations": {"cluster": "other"},
+      "stageTimestamp": "2023-01-01T00:00:01.000000Z"
+    }
+  ]
+}`
+
+func TestWebhookReceiver(t *testing.T) {
+	got := make([]*k8s_audit.Event, 0)
+	receiver := newWebhookReceiver(func(event *k8s_audit.Event) {
+		got = append(got, event)
+	}, &WebhookConfig{ListenAddr: ":0"}, "test-cluster")
+
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, httptest.NewRequest(http.MethodPost, AuditWebhookPath, strings.NewReader(testEventList)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, "a1", string(got[0].AuditID))
+	assert.Equal(t, "create", got[0].Verb)
+	assert.Equal(t, "nginx", got[0].ObjectRef.Name)
+	assert.Equal(t, int32(201), got[0].ResponseStatus.Code)
+	assert.Equal(t, "test-cluster", got[0].Annotations["cluster"])
+	assert.Equal(t, int64(1672531200100000000), got[0].StageTimestamp.UnixNano())
+	assert.Equal(t, "other", got[1].Annotations["cluster"])
+
+	w = httptest.NewRecorder()
+	receiver.ServeHTTP(w, httptest.NewRequest(http.MethodPost, AuditWebhookPath, strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	receiver.ServeHTTP(w, httptest.NewRequest(http.MethodGet, AuditWebhookPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, 2, len(got))
+}
+
+func TestWebhookReceiverPanic(t *testing.T) {
+	deadletter.SetStore(deadletter.NewMemoryStore(10))
+	got := make([]string, 0)
+	receiver := newWebhookReceiver(func(event *k8s_audit.Event) {
+		if event.AuditID == "a1" {
+			panic("handler failed")
+		}
+		got = append(got, string(event.AuditID))
+	}, &WebhookConfig{ListenAddr: ":0"}, "test-cluster")
+
+	// the batch is acknowledged, the event failed is dead-lettered and the rest are processed
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, httptest.NewRequest(http.MethodPost, AuditWebhookPath, strings.NewReader(testEventList)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"a2"}, got)
+
+	letters, err := deadletter.List(&deadletter.Filter{Stage: deadletter.StageDispatch})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "a1", letters[0].AuditID)
+	assert.Equal(t, "test-cluster", letters[0].Cluster)
+	assert.Contains(t, letters[0].Error, "handler failed")
+}
@@ -5,9 +5,8 @@ import (
 
 	"github.com/alipay/container-observability-service/pkg/metas"
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/slopolicy"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/klog/v2"
 )
 
@@ -27,7 +26,7 @@ func (s *SLOTimeComputer) CanProcess(event *shares.AuditEvent) bool {
 }
 
 func (s *SLOTimeComputer) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processPodCreation")
+	defer event.IgnorePanic("processor", "processPodCreation")
 
 	resPod, ok := event.ResponseRuntimeObj.(*v1.Pod)
 	if !ok {
@@ -35,42 +34,51 @@ func (s *SLOTimeComputer) Process(event *shares.AuditEvent) error {
 		return nil
 	}
 
+	// the SloSpec of the pod annotation comes first, then the policy, then the defaults
 	sloSpec := metas.FetchSloSpec(resPod)
+	policy := slopolicy.Match(resPod)
+	if policy != nil {
+		for delivery, sloTime := range policy.SloTimes {
+			if sloSpec[delivery] == nil {
+				sloSpec[delivery] = &metas.SloSpecItem{
+					SloTime: sloTime.String(),
+					Policy:  policy.Name,
+				}
+			}
+		}
+		if event.Verb == "create" {
+			policy.PodMatched(event.StageTimestamp.Time)
+		}
+	}
 
 	if event.Verb == "create" {
-		if sloSpec["PodCreate"] == nil {
+		if sloSpec[metas.SloSpecPodCreate] == nil {
 			sloTime, _ := metas.GetPodSLOByDeliveryPath(resPod)
-			sloSpec["PodCreate"] = &metas.SloSpecItem{
+			sloSpec[metas.SloSpecPodCreate] = &metas.SloSpecItem{
 				SloTime: sloTime.String(),
 			}
 		}
 
-		if sloSpec["PodUpgrade"] == nil {
+		if sloSpec[metas.SloSpecPodUpgrade] == nil {
 			upgradeTimeout := 9 * time.Minute
-			sloSpec["PodUpgrade"] = &metas.SloSpecItem{
+			sloSpec[metas.SloSpecPodUpgrade] = &metas.SloSpecItem{
 				SloTime: upgradeTimeout.String(),
 			}
 		}
 
-		if sloSpec["PodDelete"] == nil {
+		if sloSpec[metas.SloSpecPodDelete] == nil {
 			deleteTimeout := 10 * time.Minute
-			sloSpec["PodDelete"] = &metas.SloSpecItem{
+			sloSpec[metas.SloSpecPodDelete] = &metas.SloSpecItem{
 				SloTime: deleteTimeout.String(),
 			}
 		}
 	}
 
-	accessor, err := meta.Accessor(event.ResponseRuntimeObj)
-	if err != nil {
-		klog.Errorf("fetch slo spec error, err: %s", err)
-		return err
-	}
-
-	anno := accessor.GetAnnotations()
-	if anno == nil {
-		anno = map[string]string{}
+	// read by the SLOs of the pod, the pods not matched keep the defaults of each SLO
+	event.SloSpec = sloSpec
+	if policy != nil {
+		event.SLOPolicy = policy.Name
 	}
-	accessor.SetAnnotations(anno)
 
 	return nil
 }
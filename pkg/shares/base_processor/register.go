@@ -4,5 +4,6 @@ import "github.com/alipay/container-observability-service/pkg/shares"
 
 func init() {
 	shares.BaseObjectProcessor.Register("ObjectDecoder", &ObjectDecoder{})
-	shares.BaseObjectProcessor.Register("SLOTimeComputer", &SLOTimeComputer{})
+	// needs the objects decoded
+	shares.BaseObjectProcessor.Register("SLOTimeComputer", &SLOTimeComputer{}, shares.After("ObjectDecoder"))
 }
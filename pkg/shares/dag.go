@@ -0,0 +1,168 @@
+package shares
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	SLOProcessNode  string = "SLONode"
+	SpanProcessNode string = "SpanNode"
+)
+
+// dagNodeSpec is a consumer of audit events declared by RegisterDAGNode
+type dagNodeSpec struct {
+	dependencies []string
+	disabled     bool
+}
+
+var (
+	dagMutex sync.RWMutex
+	dagSpecs = make(map[string]*dagNodeSpec)
+)
+
+// RegisterDAGNode declares a consumer of audit events, which processes an event after the consumers it depends on
+// have finished the event. A consumer calls CanProcess before and FinishProcess after processing an event.
+func RegisterDAGNode(name string, dependencies ...string) {
+	dagMutex.Lock()
+	defer dagMutex.Unlock()
+	dagSpecs[name] = &dagNodeSpec{dependencies: dependencies}
+}
+
+// SetDAGNodeEnabled tells whether events are fed to the consumer, the ones depending on a disabled node do not wait for it
+func SetDAGNodeEnabled(name string, enabled bool) {
+	dagMutex.Lock()
+	defer dagMutex.Unlock()
+	if spec, ok := dagSpecs[name]; ok {
+		spec.disabled = !enabled
+	}
+}
+
+// IsIndependentDAGNode returns true if the node neither waits for nor is waited for by other nodes
+func IsIndependentDAGNode(name string) bool {
+	dagMutex.RLock()
+	defer dagMutex.RUnlock()
+	spec, ok := dagSpecs[name]
+	if !ok || len(spec.dependencies) > 0 {
+		return false
+	}
+	for _, other := range dagSpecs {
+		for _, dep := range other.dependencies {
+			if dep == name {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ValidateDAG checks the declared dependencies are registered and have no cycle
+func ValidateDAG() error {
+	dagMutex.RLock()
+	defer dagMutex.RUnlock()
+
+	names := make([]string, 0, len(dagSpecs))
+	for name := range dagSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch states[name] {
+		case visiting:
+			return fmt.Errorf("cycle in processing DAG: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		states[name] = visiting
+		for _, dep := range dagSpecs[name].dependencies {
+			if _, ok := dagSpecs[dep]; !ok {
+				return fmt.Errorf("node %s of processing DAG depends on unknown node %s", name, dep)
+			}
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		states[name] = visited
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processNode is the state of a DAG node for one event
+type processNode struct {
+	parents []*processNode
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (n *processNode) finish() {
+	n.once.Do(func() {
+		close(n.done)
+	})
+}
+
+// AuditProcessDAG tracks which consumers have finished an event
+type AuditProcessDAG struct {
+	nodes map[string]*processNode
+}
+
+func newAuditProcessDAG() *AuditProcessDAG {
+	dagMutex.RLock()
+	defer dagMutex.RUnlock()
+
+	dag := &AuditProcessDAG{nodes: make(map[string]*processNode, len(dagSpecs))}
+	for name, spec := range dagSpecs {
+		node := &processNode{done: make(chan struct{})}
+		if spec.disabled {
+			node.finish()
+		}
+		dag.nodes[name] = node
+	}
+	for name, spec := range dagSpecs {
+		node := dag.nodes[name]
+		for _, dep := range spec.dependencies {
+			if parent, ok := dag.nodes[dep]; ok {
+				node.parents = append(node.parents, parent)
+			}
+		}
+	}
+	return dag
+}
+
+// wait blocks until the dependencies of name have finished
+func (d *AuditProcessDAG) wait(name string) {
+	node, ok := d.nodes[name]
+	if !ok {
+		return
+	}
+	for _, parent := range node.parents {
+		<-parent.done
+	}
+}
+
+func (d *AuditProcessDAG) finish(name string) {
+	if node, ok := d.nodes[name]; ok {
+		node.finish()
+	}
+}
+
+func (d *AuditProcessDAG) finishAll() {
+	for _, node := range d.nodes {
+		node.finish()
+	}
+}
@@ -0,0 +1,68 @@
+package shares
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetDAG(t *testing.T) {
+	saved := dagSpecs
+	dagSpecs = make(map[string]*dagNodeSpec)
+	t.Cleanup(func() {
+		dagSpecs = saved
+	})
+}
+
+func TestValidateDAG(t *testing.T) {
+	resetDAG(t)
+	RegisterDAGNode("a")
+	RegisterDAGNode("b", "a")
+	RegisterDAGNode("c", "a", "b")
+	assert.Nil(t, ValidateDAG())
+	assert.False(t, IsIndependentDAGNode("d"))
+	RegisterDAGNode("d")
+	assert.True(t, IsIndependentDAGNode("d"))
+	assert.False(t, IsIndependentDAGNode("a"))
+	assert.False(t, IsIndependentDAGNode("c"))
+
+	RegisterDAGNode("e", "unknown")
+	assert.EqualError(t, ValidateDAG(), "node e of processing DAG depends on unknown node unknown")
+
+	delete(dagSpecs, "e")
+	RegisterDAGNode("a", "c")
+	assert.EqualError(t, ValidateDAG(), "cycle in processing DAG: a -> c -> a")
+}
+
+func TestAuditProcessDAG(t *testing.T) {
+	resetDAG(t)
+	RegisterDAGNode("span")
+	RegisterDAGNode("slo", "span")
+	RegisterDAGNode("report", "slo", "span")
+
+	dag := newAuditProcessDAG()
+	done := make(chan struct{})
+	go func() {
+		dag.wait("report")
+		close(done)
+	}()
+
+	dag.finish("span")
+	select {
+	case <-done:
+		t.Fatal("report should wait for slo")
+	case <-time.After(50 * time.Millisecond):
+	}
+	dag.finish("slo")
+	// finished more than once
+	dag.finish("slo")
+	<-done
+
+	// a disabled node is not waited for
+	SetDAGNodeEnabled("span", false)
+	dag = newAuditProcessDAG()
+	dag.wait("slo")
+	// unknown nodes do not wait
+	dag.wait("unknown")
+}
@@ -2,8 +2,16 @@ package shares
 
 import (
 	"encoding/json"
+	"fmt"
 	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/metas"
+	"github.com/alipay/container-observability-service/pkg/queue"
+	"github.com/alipay/container-observability-service/pkg/utils"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -32,8 +40,18 @@ type AuditEvent struct {
 	Operation map[string][]string //Operation操作
 	Reason    string              //event类型的reason
 
+	// SloSpec of the pod and the DeliverySLOPolicy matching it, set by SLOTimeComputer instead of the pod,
+	// so the stored pod is not changed
+	SloSpec   metas.SloSpec
+	SLOPolicy string
+
 	//process DAG
 	processDAG *AuditProcessDAG
+
+	// told when the event is put into and consumed from the queues, nil if not tracked
+	tracker queue.Tracked
+	// the pod belongs to another shard, only the trackers of the nodes and workloads follow the event
+	Observed bool
 }
 
 func NewAuditEvent(event *k8s_audit.Event) *AuditEvent {
@@ -41,7 +59,7 @@ func NewAuditEvent(event *k8s_audit.Event) *AuditEvent {
 		Event:      event,
 		processor:  metaProcessor,
 		Operation:  make(map[string][]string),
-		processDAG: InitAuditProcessADG(),
+		processDAG: newAuditProcessDAG(),
 	}
 }
 
@@ -82,32 +100,49 @@ func (a *AuditEvent) Process() {
 	}()
 }
 
-// 判断依赖的父亲节点是否已经结束
-func (a *AuditEvent) CanProcess(DAGNodeName string) {
-	a.Wait()
-	dagNode, ok := a.processDAG.allSpans[DAGNodeName]
-	if !ok {
-		return
+// IgnorePanic is utils.IgnorePanic keeping the event failed in stage in the dead-letter store
+func (a *AuditEvent) IgnorePanic(stage, desc string) {
+	if err := recover(); err != nil {
+		utils.LogPanic(desc, err)
+		deadletter.Capture(stage, a.Event, fmt.Errorf("panic in %s: %v", desc, err))
 	}
-	for {
-		if dagNode.IsParentsReady() {
-			return
-		}
+}
 
-		select {
-		case <-dagNode.stateNotifier:
-		}
+// Track tells tracker when the event is put into a queue and when a consumer has finished it,
+// e.g. to know whether the events read by a source are all consumed
+func (a *AuditEvent) Track(tracker queue.Tracked) {
+	a.tracker = tracker
+}
+
+// Produced implements queue.Tracked
+func (a *AuditEvent) Produced() {
+	if a.tracker != nil {
+		a.tracker.Produced()
 	}
 }
 
-func (a *AuditEvent) FinishProcess(DAGNodeName string) {
-	dagNode, ok := a.processDAG.allSpans[DAGNodeName]
-	if !ok {
-		return
+// Consumed implements queue.Tracked
+func (a *AuditEvent) Consumed() {
+	if a.tracker != nil {
+		a.tracker.Consumed()
 	}
+}
 
-	dagNode.finish()
-	dagNode.notify()
+// CanProcess blocks until the nodes DAGNodeName depends on have finished the event
+func (a *AuditEvent) CanProcess(DAGNodeName string) {
+	a.Wait()
+	a.processDAG.wait(DAGNodeName)
+}
+
+// FinishProcess tells the nodes depending on DAGNodeName that the event is finished, it could be called more than once
+func (a *AuditEvent) FinishProcess(DAGNodeName string) {
+	a.processDAG.finish(DAGNodeName)
+}
+
+// FinishAllProcess releases every node waiting for the event, which is fed to a single consumer,
+// e.g. a dead letter replayed to the stage it failed in
+func (a *AuditEvent) FinishAllProcess() {
+	a.processDAG.finishAll()
 }
 
 func (a *AuditEvent) GetObjectUID() (types.UID, error) {
@@ -148,9 +183,39 @@ func (a *AuditEvent) GetObjectUID() (types.UID, error) {
 	return resultUID, err
 }
 
+// AuditEventCodec encodes the audit events spilled to disk by queues, the decoded event is processed again.
+// Only the queues of independent DAG nodes could spill, the decoded event is not the one seen by the other queues.
+type AuditEventCodec struct{}
+
+func (AuditEventCodec) Encode(item interface{}) ([]byte, error) {
+	a, ok := item.(*AuditEvent)
+	if !ok || a == nil || a.Event == nil {
+		return nil, fmt.Errorf("unexpected item %T", item)
+	}
+	return json.Marshal(a.Event)
+}
+
+func (AuditEventCodec) Decode(data []byte) (interface{}, error) {
+	event := &k8s_audit.Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	a := NewAuditEvent(event)
+	if event.ObjectRef != nil &&
+		(event.ObjectRef.Resource == "pods" || event.ObjectRef.Resource == "events" || event.ObjectRef.Resource == "nodes") {
+		a.Process()
+	}
+	return a, nil
+}
+
 type HitEvent struct {
-	Event    *k8s_audit.Event
-	HasError bool
+	Event *k8s_audit.Event
+	// value of the field the events are queried by, which the read cursor is taken from
+	Timestamp time.Time
+	HasError  bool
+	// the hit and the error if it could not be decoded
+	Raw json.RawMessage
+	Err error
 	sync.WaitGroup
 }
 
@@ -161,19 +226,64 @@ func NewHitEvent() *HitEvent {
 	}
 }
 
-func (h *HitEvent) UnmarshalToEvent(hit *json.RawMessage) {
+// UnmarshalToEvent decodes the hit, the timestamp is taken from timestampField of the hit,
+// or StageTimestamp if it is empty or "stageTimestamp".
+func (h *HitEvent) UnmarshalToEvent(hit *json.RawMessage, timestampField string) {
 	h.Add(1)
 	go func() {
+		defer h.Done()
 		//var json = jsoniter.ConfigCompatibleWithStandardLibrary
 		err := json.Unmarshal(*hit, &h.Event)
 		if err != nil {
 			klog.Errorf("failed unmarshal %s, data %s", err.Error(), string(*hit))
 			h.HasError = true
+			h.Raw = *hit
+			h.Err = err
+			return
+		}
+		h.Timestamp = h.Event.StageTimestamp.Time
+		if timestampField == "" || timestampField == "stageTimestamp" {
+			return
+		}
+		if ts, err := hitTimestamp(*hit, timestampField); err == nil {
+			h.Timestamp = ts
+		} else {
+			klog.V(4).Infof("no %s in audit event %s, use stageTimestamp: %s", timestampField, h.Event.AuditID, err.Error())
 		}
-		h.Done()
 	}()
 }
 
+// hitTimestamp returns the time in field of the hit, a dotted field is looked up in the nested objects as well.
+// The time is either a RFC3339 string or epoch milliseconds.
+func hitTimestamp(hit json.RawMessage, field string) (time.Time, error) {
+	var source map[string]interface{}
+	if err := json.Unmarshal(hit, &source); err != nil {
+		return time.Time{}, err
+	}
+	value, ok := source[field]
+	if !ok {
+		var current interface{} = source
+		for _, name := range strings.Split(field, ".") {
+			object, isObject := current.(map[string]interface{})
+			if !isObject {
+				current = nil
+				break
+			}
+			current = object[name]
+		}
+		value = current
+	}
+
+	switch v := value.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case float64:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)), nil
+	default:
+		return time.Time{}, fmt.Errorf("unexpected %s: %v", field, value)
+	}
+}
+
 type HitEventSlice struct {
 	sync.Mutex
 	hits []*HitEvent
@@ -211,73 +321,3 @@ func (h *HitEventSlice) SortByTimeStamp(ascending bool) {
 		}
 	})
 }
-
-type ProcessState string
-
-var (
-	ProcessingState ProcessState = "Processing"
-	FinishedState   ProcessState = "Finished"
-
-	SLOProcessNode  string = "SLONode"
-	SpanProcessNode string = "SpanNode"
-)
-
-type AuditProcessDAG struct {
-	name          string
-	state         ProcessState
-	stateNotifier chan string //用于parent通知child状态变化
-	parents       []*AuditProcessDAG
-	children      []*AuditProcessDAG
-
-	allSpans map[string]*AuditProcessDAG
-}
-
-func (d *AuditProcessDAG) finish() {
-	d.state = FinishedState
-}
-
-func (d *AuditProcessDAG) notify() {
-	for _, child := range d.children {
-		child.stateNotifier <- "ok"
-	}
-}
-
-func (d *AuditProcessDAG) IsParentsReady() bool {
-	for _, parent := range d.parents {
-		if parent.state != FinishedState {
-			return false
-		}
-	}
-	return true
-}
-
-func InitAuditProcessADG() *AuditProcessDAG {
-	allSpans := make(map[string]*AuditProcessDAG, 0)
-
-	spanNode := &AuditProcessDAG{
-		name:          SpanProcessNode,
-		state:         ProcessingState,
-		stateNotifier: make(chan string, 60),
-		parents:       []*AuditProcessDAG{},
-		children:      []*AuditProcessDAG{},
-
-		allSpans: allSpans,
-	}
-
-	sloNode := &AuditProcessDAG{
-		name:          SLOProcessNode,
-		state:         ProcessingState,
-		stateNotifier: make(chan string, 60),
-		parents:       []*AuditProcessDAG{},
-		children:      []*AuditProcessDAG{},
-
-		allSpans: allSpans,
-	}
-	spanNode.children = append(spanNode.children, sloNode)
-	sloNode.parents = append(sloNode.parents, spanNode)
-
-	allSpans[spanNode.name] = spanNode
-	allSpans[sloNode.name] = sloNode
-
-	return spanNode
-}
@@ -0,0 +1,27 @@
+package shares
+
+import (
+	"testing"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+
+	"github.com/stretchr/testify/assert"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func TestIgnorePanic(t *testing.T) {
+	deadletter.SetStore(deadletter.NewMemoryStore(10))
+	event := NewAuditEvent(&k8s_audit.Event{AuditID: "a1", Annotations: map[string]string{"cluster": "c1"}})
+
+	func() {
+		defer event.IgnorePanic("stage", "consumer")
+		panic("consumer failed")
+	}()
+
+	letters, err := deadletter.List(&deadletter.Filter{Stage: "stage"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "a1", letters[0].AuditID)
+	assert.Equal(t, "c1", letters[0].Cluster)
+	assert.Contains(t, letters[0].Error, "panic in consumer: consumer failed")
+}
@@ -2,7 +2,6 @@ package extractor
 
 import (
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -21,7 +20,7 @@ func (p *PodBindingProcessor) CanProcess(event *shares.AuditEvent) bool {
 }
 
 func (p *PodBindingProcessor) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processBinding")
+	defer event.IgnorePanic("extractor", "processBinding")
 	//创建成功
 	if _, ok := event.RequestRuntimeObj.(*v1.Binding); ok {
 		event.Type = shares.AuditTypeOperation
@@ -8,7 +8,6 @@ import (
 	"fmt"
 
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -30,7 +29,7 @@ func (p *PodCreateProcessor) CanProcess(event *shares.AuditEvent) bool {
 
 // 用于在 tracing API 中添加一个 apicreate 的 phase
 func (p *PodCreateProcessor) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processPodCreation")
+	defer event.IgnorePanic("extractor", "processPodCreation")
 
 	resPod, ok := event.ResponseRuntimeObj.(*v1.Pod)
 	if !ok {
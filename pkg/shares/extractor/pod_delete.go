@@ -6,7 +6,6 @@ package extractor
 
 import (
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -27,7 +26,7 @@ func (p *PodDeleteProcessor) CanProcess(event *shares.AuditEvent) bool {
 }
 
 func (p *PodDeleteProcessor) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processPodDeletion ")
+	defer event.IgnorePanic("extractor", "processPodDeletion")
 
 	responsePod, ok := event.ResponseRuntimeObj.(*v1.Pod)
 	if !ok {
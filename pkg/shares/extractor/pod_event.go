@@ -2,7 +2,6 @@ package extractor
 
 import (
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -24,7 +23,7 @@ func (p *PodEventProcessor) CanProcess(event *shares.AuditEvent) bool {
 
 // 用于在 tracing API 中添加一个 apicreate 的 phase
 func (p *PodEventProcessor) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processPodCreation")
+	defer event.IgnorePanic("extractor", "processPodCreation")
 
 	e, ok := event.ResponseRuntimeObj.(*v1.Event)
 	if !ok {
@@ -10,7 +10,6 @@ import (
 
 	"github.com/oliveagle/jsonpath"
 
-	"github.com/alipay/container-observability-service/pkg/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -38,7 +37,7 @@ func (p *PodPatchProcessor) CanProcess(event *shares.AuditEvent) bool {
 }
 
 func (p *PodPatchProcessor) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processPodCreation")
+	defer event.IgnorePanic("extractor", "processPodCreation")
 
 	_, ok := event.ResponseRuntimeObj.(*v1.Pod)
 	if !ok {
@@ -60,7 +59,7 @@ func (p *PodPatchProcessor) Process(event *shares.AuditEvent) error {
 // 处理非 Status 的字段
 // 包括 finalizer，labels，annotation，spec.SchedulerName 等
 func (p *PodPatchProcessor) processPatch(event *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPatch ")
+	defer event.IgnorePanic("extractor", "processPatch")
 
 	// request pod
 	reqPod, ok := event.RequestRuntimeObj.(*v1.Pod)
@@ -157,7 +156,7 @@ func (p *PodPatchProcessor) processPatch(event *shares.AuditEvent) {
 
 // 处理 Status 的字段的更新
 func (p *PodPatchProcessor) processPatchStatus(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPatchStatus ")
+	defer auditEvent.IgnorePanic("extractor", "processPatchStatus")
 
 	// request pod
 	reqPod, ok := auditEvent.RequestRuntimeObj.(*v1.Pod)
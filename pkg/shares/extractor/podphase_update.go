@@ -2,7 +2,6 @@ package extractor
 
 import (
 	"github.com/alipay/container-observability-service/pkg/shares"
-	"github.com/alipay/container-observability-service/pkg/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -24,7 +23,7 @@ func (p *PodUpdateProcessor) CanProcess(event *shares.AuditEvent) bool {
 
 // 处理 Pod 的 Patch
 func (p *PodUpdateProcessor) Process(event *shares.AuditEvent) error {
-	defer utils.IgnorePanic("processPodUpdate ")
+	defer event.IgnorePanic("extractor", "processPodUpdate")
 
 	//response pod
 	_, ok := event.ResponseRuntimeObj.(*v1.Pod)
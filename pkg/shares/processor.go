@@ -1,7 +1,7 @@
 package shares
 
 import (
-	"k8s.io/klog/v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 var (
@@ -21,64 +21,70 @@ type Processor interface {
 
 // BaseProcessor 用于基础的信息提取
 type BaseProcessor struct {
-	processors map[string]Processor
+	processors *processorRegistry
 }
 
 func NewBaseProcessor() *BaseProcessor {
 	return &BaseProcessor{
-		processors: make(map[string]Processor),
+		processors: newProcessorRegistry("processor"),
 	}
 }
 
+// Process runs all the processors in order, the errors of them are aggregated
 func (l *BaseProcessor) Process(event *AuditEvent) error {
-	for name, p := range l.processors {
-		if p.CanProcess(event) {
-			if err := p.Process(event); err != nil {
-				klog.Errorf("processor %s is error: %v", name, err)
-				return err
-			}
-		}
-	}
-	return nil
+	return l.processors.process(event)
 }
 
 func (l *BaseProcessor) CanProcess(event *AuditEvent) bool {
 	return true
 }
 
-func (l *BaseProcessor) Register(name string, p Processor) {
-	l.processors[name] = p
+// Register adds the processor named name, registering the name again replaces it
+func (l *BaseProcessor) Register(name string, p Processor, opts ...RegisterOption) {
+	l.processors.register(name, p, opts...)
+}
+
+// Names returns the processors in the order they run
+func (l *BaseProcessor) Names() []string {
+	return l.processors.names()
 }
 
 // MilestoneExtractor 用于 hyper event 生命周期提取
 type MilestoneExtractor struct {
-	extractors map[string]Processor
+	extractors *processorRegistry
 }
 
 func NewMilestoneExtractor() *MilestoneExtractor {
 	return &MilestoneExtractor{
-		extractors: make(map[string]Processor),
+		extractors: newProcessorRegistry("extractor"),
 	}
 }
 
+// Process runs all the extractors in order, the errors of them are aggregated
 func (l *MilestoneExtractor) Process(event *AuditEvent) error {
-	for name, p := range l.extractors {
-		if p.CanProcess(event) {
-			if err := p.Process(event); err != nil {
-				klog.Errorf("extractors %s is error: %v", name, err)
-				return err
-			}
-		}
-	}
-	return nil
+	return l.extractors.process(event)
 }
 
 func (l *MilestoneExtractor) CanProcess(event *AuditEvent) bool {
 	return true
 }
 
-func (l *MilestoneExtractor) Register(name string, p Processor) {
-	l.extractors[name] = p
+// Register adds the extractor named name, registering the name again replaces it
+func (l *MilestoneExtractor) Register(name string, p Processor, opts ...RegisterOption) {
+	l.extractors.register(name, p, opts...)
+}
+
+// Names returns the extractors in the order they run
+func (l *MilestoneExtractor) Names() []string {
+	return l.extractors.names()
+}
+
+// ValidateProcessors checks the registered processors and extractors can be ordered
+func ValidateProcessors() error {
+	return utilerrors.NewAggregate([]error{
+		BaseObjectProcessor.processors.validate(),
+		MilestoneProcessor.extractors.validate(),
+	})
 }
 
 // MetaProcessor 元数据处理总入口
@@ -91,14 +97,10 @@ func (m *MetaProcessor) CanProcess(event *AuditEvent) bool {
 	return true
 }
 
+// Process runs the extractors only after all processors succeeded, they rely on the objects decoded by the processors
 func (m *MetaProcessor) Process(event *AuditEvent) error {
 	if err := m.objectProcessor.Process(event); err != nil {
 		return err
 	}
-
-	if err := m.milestoneExtractor.Process(event); err != nil {
-		return err
-	}
-
-	return nil
+	return m.milestoneExtractor.Process(event)
 }
@@ -0,0 +1,215 @@
+package shares
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+var (
+	processorDurationMilliSeconds = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "lunettes_audit_processor_duration_milliseconds",
+			Help:       "time spent by a registered processor on an audit event",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"stage", "processor"},
+	)
+	processorErrorCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lunettes_audit_processor_errors_count",
+			Help: "audit events failed in a registered processor",
+		},
+		[]string{"stage", "processor"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(processorDurationMilliSeconds)
+	prometheus.MustRegister(processorErrorCount)
+}
+
+// RegisterOption sets how a processor is ordered
+type RegisterOption func(p *registeredProcessor)
+
+// WithPriority runs the processor before the ones with a larger priority, 0 by default.
+// Processors of the same priority run in the order they are registered.
+func WithPriority(priority int) RegisterOption {
+	return func(p *registeredProcessor) {
+		p.priority = priority
+	}
+}
+
+// After runs the processor after the named ones
+func After(names ...string) RegisterOption {
+	return func(p *registeredProcessor) {
+		p.after = append(p.after, names...)
+	}
+}
+
+type registeredProcessor struct {
+	name      string
+	processor Processor
+	priority  int
+	after     []string
+	// registration order
+	seq int
+}
+
+// processorRegistry runs the registered processors in a deterministic order
+type processorRegistry struct {
+	// dead letter stage and metric label
+	stage string
+
+	mutex      sync.RWMutex
+	processors map[string]*registeredProcessor
+	ordered    []*registeredProcessor
+	// processors in a cycle, which run last
+	cyclic  []string
+	nextSeq int
+}
+
+func newProcessorRegistry(stage string) *processorRegistry {
+	return &processorRegistry{
+		stage:      stage,
+		processors: make(map[string]*registeredProcessor),
+	}
+}
+
+func (r *processorRegistry) register(name string, p Processor, opts ...RegisterOption) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rp := &registeredProcessor{name: name, processor: p, seq: r.nextSeq}
+	if old, ok := r.processors[name]; ok {
+		// replaced in place
+		rp.seq = old.seq
+	} else {
+		r.nextSeq++
+	}
+	for _, opt := range opts {
+		opt(rp)
+	}
+	r.processors[name] = rp
+	r.ordered, r.cyclic = r.sort()
+}
+
+// sort orders the processors topologically, the ready ones by priority and registration order.
+// Dependencies not registered are ignored, processors in a cycle run last, see validate.
+func (r *processorRegistry) sort() ([]*registeredProcessor, []string) {
+	less := func(a, b *registeredProcessor) bool {
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return a.seq < b.seq
+	}
+
+	waiting := make(map[string]int, len(r.processors))
+	children := make(map[string][]*registeredProcessor)
+	ready := make([]*registeredProcessor, 0, len(r.processors))
+	for _, p := range r.processors {
+		for _, dep := range p.after {
+			if _, ok := r.processors[dep]; ok && dep != p.name {
+				waiting[p.name]++
+				children[dep] = append(children[dep], p)
+			}
+		}
+		if waiting[p.name] == 0 {
+			ready = append(ready, p)
+		}
+	}
+
+	ordered := make([]*registeredProcessor, 0, len(r.processors))
+	var cyclic []string
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		next := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, next)
+		for _, child := range children[next.name] {
+			waiting[child.name]--
+			if waiting[child.name] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	if len(ordered) < len(r.processors) {
+		rest := make([]*registeredProcessor, 0, len(r.processors)-len(ordered))
+		for _, p := range r.processors {
+			if waiting[p.name] > 0 {
+				rest = append(rest, p)
+			}
+		}
+		sort.Slice(rest, func(i, j int) bool { return less(rest[i], rest[j]) })
+		for _, p := range rest {
+			cyclic = append(cyclic, p.name)
+		}
+		ordered = append(ordered, rest...)
+	}
+	return ordered, cyclic
+}
+
+// validate checks the dependencies are registered and have no cycle
+func (r *processorRegistry) validate() error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	errs := make([]error, 0)
+	for _, p := range r.ordered {
+		for _, dep := range p.after {
+			if _, ok := r.processors[dep]; !ok {
+				errs = append(errs, fmt.Errorf("%s %s runs after unknown %s", r.stage, p.name, dep))
+			}
+		}
+	}
+	if len(r.cyclic) > 0 {
+		errs = append(errs, fmt.Errorf("cycle in %s order: %s", r.stage, strings.Join(r.cyclic, ", ")))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// names returns the processors in the order they run
+func (r *processorRegistry) names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.ordered))
+	for _, p := range r.ordered {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+// process runs all the processors accepting event, the errors are aggregated rather than aborting the rest
+func (r *processorRegistry) process(event *AuditEvent) error {
+	r.mutex.RLock()
+	ordered := r.ordered
+	r.mutex.RUnlock()
+
+	var errs []error
+	for _, p := range ordered {
+		if !p.processor.CanProcess(event) {
+			continue
+		}
+		start := time.Now()
+		err := p.processor.Process(event)
+		processorDurationMilliSeconds.WithLabelValues(r.stage, p.name).Observe(utils.TimeSinceInMilliSeconds(start))
+		if err != nil {
+			klog.Errorf("%s %s is error: %v", r.stage, p.name, err)
+			processorErrorCount.WithLabelValues(r.stage, p.name).Inc()
+			err = fmt.Errorf("%s: %w", p.name, err)
+			deadletter.Capture(r.stage, event.Event, err)
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,97 @@
+package shares
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+type recordingProcessor struct {
+	name string
+	runs *[]string
+	err  error
+}
+
+func (p *recordingProcessor) CanProcess(event *AuditEvent) bool {
+	return true
+}
+
+func (p *recordingProcessor) Process(event *AuditEvent) error {
+	*p.runs = append(*p.runs, p.name)
+	return p.err
+}
+
+func TestProcessorOrder(t *testing.T) {
+	runs := make([]string, 0)
+	newProcessor := func(name string) Processor {
+		return &recordingProcessor{name: name, runs: &runs}
+	}
+
+	r := newProcessorRegistry("processor")
+	r.register("c", newProcessor("c"), After("a"))
+	r.register("b", newProcessor("b"))
+	r.register("a", newProcessor("a"))
+	r.register("first", newProcessor("first"), WithPriority(-1))
+	r.register("late", newProcessor("late"), WithPriority(1))
+	assert.Nil(t, r.validate())
+	assert.Equal(t, []string{"first", "b", "a", "c", "late"}, r.names())
+
+	for i := 0; i < 10; i++ {
+		runs = runs[:0]
+		assert.Nil(t, r.process(NewAuditEvent(&k8s_audit.Event{})))
+		assert.Equal(t, []string{"first", "b", "a", "c", "late"}, runs)
+	}
+
+	// registered again in place
+	r.register("b", newProcessor("b"), After("late"))
+	assert.Equal(t, []string{"first", "a", "c", "late", "b"}, r.names())
+}
+
+func TestProcessorValidate(t *testing.T) {
+	runs := make([]string, 0)
+	r := newProcessorRegistry("extractor")
+	r.register("a", &recordingProcessor{name: "a", runs: &runs}, After("b"))
+	r.register("b", &recordingProcessor{name: "b", runs: &runs}, After("a"))
+	r.register("c", &recordingProcessor{name: "c", runs: &runs}, After("unknown"))
+
+	err := r.validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "extractor c runs after unknown unknown")
+	assert.Contains(t, err.Error(), "cycle in extractor order: a, b")
+	// still all run
+	assert.Equal(t, []string{"c", "a", "b"}, r.names())
+}
+
+func TestProcessorErrorsAggregated(t *testing.T) {
+	runs := make([]string, 0)
+	r := newProcessorRegistry("extractor")
+	r.register("a", &recordingProcessor{name: "a", runs: &runs, err: fmt.Errorf("boom")})
+	r.register("b", &recordingProcessor{name: "b", runs: &runs})
+	r.register("c", &recordingProcessor{name: "c", runs: &runs, err: fmt.Errorf("bang")})
+
+	err := r.process(NewAuditEvent(&k8s_audit.Event{AuditID: "audit-1"}))
+	assert.Equal(t, []string{"a", "b", "c"}, runs)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "a: boom")
+	assert.Contains(t, err.Error(), "c: bang")
+}
+
+func TestMetaProcessorStopsOnError(t *testing.T) {
+	runs := make([]string, 0)
+	processors := NewBaseProcessor()
+	extractors := NewMilestoneExtractor()
+	extractors.Register("extractor", &recordingProcessor{name: "extractor", runs: &runs})
+	m := &MetaProcessor{objectProcessor: processors, milestoneExtractor: extractors}
+
+	assert.Nil(t, m.Process(NewAuditEvent(&k8s_audit.Event{AuditID: "audit-1"})))
+	assert.Equal(t, []string{"extractor"}, runs)
+
+	// the object is not decoded
+	runs = runs[:0]
+	processors.Register("decoder", &recordingProcessor{name: "decoder", runs: &runs, err: fmt.Errorf("boom")})
+	err := m.Process(NewAuditEvent(&k8s_audit.Event{AuditID: "audit-2"}))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"decoder"}, runs)
+}
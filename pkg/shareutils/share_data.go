@@ -34,7 +34,7 @@ func GetSpansByUIDAndType(objectUID types.UID, event *shares.AuditEvent, actionT
 		}
 	}
 
-	tmpList, ok := spans.DeliverySpanProcessor.SpanMetas.Load(string(uid))
+	tmpList, ok := spans.LoadSpanMetas(string(uid))
 	if !ok || tmpList == nil {
 		return nil, nil
 	}
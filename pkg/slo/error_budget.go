@@ -0,0 +1,589 @@
+package slo
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/alipay/container-observability-service/pkg/slopolicy"
+	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// ErrorBudget is the error budget of an SLO objective for one group of deliveries
+type ErrorBudget struct {
+	Objective string
+	Type      string
+	Target    float64
+	Window    string
+	// set if the objective is grouped by them
+	Cluster   string `json:",omitempty"`
+	Namespace string `json:",omitempty"`
+	OwnerRef  string `json:",omitempty"`
+	// deliveries in the window
+	Good int64
+	Bad  int64
+	// ratio of the error budget left in the window, negative if overspent
+	BudgetRemaining float64
+	// how fast the error budget is spent in the latest windows, 1 spends it in exactly the objective window
+	BurnRates map[string]float64
+	// severities of the burn rate alerts firing
+	Alerts []string `json:",omitempty"`
+}
+
+// burnRateAlert fires when the burn rates of both windows are above rate
+type burnRateAlert struct {
+	severity string
+	long     time.Duration
+	short    time.Duration
+	rate     float64
+}
+
+const (
+	// the latest deliveries are counted by minute, the older ones by hour
+	budgetFineResolution   = time.Minute
+	budgetFineSpan         = 6 * time.Hour
+	budgetCoarseResolution = time.Hour
+	// groups of deliveries of an objective at most
+	maxBudgetSeries = 10000
+)
+
+var (
+	burnRateWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour,
+		6 * time.Hour, 24 * time.Hour, 3 * 24 * time.Hour}
+	// the multi-window alerts of the SRE workbook
+	burnRateAlerts = []burnRateAlert{
+		{severity: "page", long: time.Hour, short: 5 * time.Minute, rate: 14.4},
+		{severity: "page", long: 6 * time.Hour, short: 30 * time.Minute, rate: 6},
+		{severity: "ticket", long: 24 * time.Hour, short: 2 * time.Hour, rate: 3},
+		{severity: "ticket", long: 3 * 24 * time.Hour, short: 6 * time.Hour, rate: 1},
+	}
+	burnRateSeverities = []string{"page", "ticket"}
+
+	errorBudgetRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_error_budget_remaining_ratio",
+			Help: "ratio of the error budget of the slo objective left in its window, of the deliveries of the shard",
+		},
+		[]string{"objective", "cluster", "namespace", "ownerref", "shard"},
+	)
+	errorBudgetBurnRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_error_budget_burn_rate",
+			Help: "how fast the error budget of the slo objective is spent in the window, of the deliveries of the shard",
+		},
+		[]string{"objective", "cluster", "namespace", "ownerref", "shard", "window"},
+	)
+	errorBudgetBurnAlert = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_error_budget_burn_alert",
+			Help: "1 if a multi-window burn rate alert of the slo objective fires, of the deliveries of the shard",
+		},
+		[]string{"objective", "cluster", "namespace", "ownerref", "shard", "severity"},
+	)
+	errorBudgetDeliveries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_error_budget_deliveries",
+			Help: "deliveries of the slo objective in its window by result, summed across the shards for the whole budget",
+		},
+		[]string{"objective", "cluster", "namespace", "ownerref", "shard", "result"},
+	)
+
+	budgets = newErrorBudgets(utils.Now)
+)
+
+func init() {
+	prometheus.MustRegister(errorBudgetRemaining)
+	prometheus.MustRegister(errorBudgetBurnRate)
+	prometheus.MustRegister(errorBudgetBurnAlert)
+	prometheus.MustRegister(errorBudgetDeliveries)
+
+	config.OnLunettesConfigChange("error-budget", func(_, c *config.LunettesConfig) {
+		budgets.setObjectives(append(slopolicy.Objectives(), c.SLOObjectives...))
+	})
+	slopolicy.OnChange("error-budget", func() {
+		budgets.setObjectives(append(slopolicy.Objectives(), config.GlobalLunettesConfig().SLOObjectives...))
+	})
+
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			budgets.updateMetrics()
+		}
+	}()
+}
+
+// ErrorBudgets returns the error budgets of the objective, of all the objectives if it is empty
+func ErrorBudgets(objective string) []*ErrorBudget {
+	return budgets.list(objective)
+}
+
+// budgetBucket counts the deliveries of one resolution
+type budgetBucket struct {
+	index int64
+	good  int64
+	bad   int64
+}
+
+// budgetRing keeps the counts of the latest len(buckets) resolutions
+type budgetRing struct {
+	resolution time.Duration
+	buckets    []budgetBucket
+	latest     int64
+}
+
+func newBudgetRing(resolution, span time.Duration) *budgetRing {
+	return &budgetRing{resolution: resolution, buckets: make([]budgetBucket, int(span/resolution)+1)}
+}
+
+func (r *budgetRing) add(t time.Time, good bool) {
+	index := t.UnixNano() / int64(r.resolution)
+	if index <= r.latest-int64(len(r.buckets)) {
+		return
+	}
+	if index > r.latest {
+		r.latest = index
+	}
+	b := &r.buckets[index%int64(len(r.buckets))]
+	if b.index != index {
+		*b = budgetBucket{index: index}
+	}
+	if good {
+		b.good++
+	} else {
+		b.bad++
+	}
+}
+
+// sum counts the deliveries of the window ending at now
+func (r *budgetRing) sum(now time.Time, window time.Duration) (good, bad int64) {
+	last := now.UnixNano() / int64(r.resolution)
+	first := last - int64((window+r.resolution-1)/r.resolution) + 1
+	if n := last - int64(len(r.buckets)) + 1; first < n {
+		first = n
+	}
+	for index := first; index <= last; index++ {
+		b := r.buckets[index%int64(len(r.buckets))]
+		if b.index == index {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return good, bad
+}
+
+type budgetLabels struct {
+	cluster   string
+	namespace string
+	ownerRef  string
+}
+
+type budgetSeries struct {
+	// cluster -> counts, so each cluster is checkpointed with its own audit events
+	clusters map[string]*budgetCounts
+	last     time.Time
+}
+
+// budgetCounts counts the deliveries by minute within 6h and by hour beyond
+type budgetCounts struct {
+	fine   *budgetRing
+	coarse *budgetRing
+}
+
+type budgetObjective struct {
+	objective  config.SLOObjective
+	window     time.Duration
+	namespaces map[string]bool
+	series     map[budgetLabels]*budgetSeries
+}
+
+func (o *budgetObjective) labels(cluster, namespace, ownerRef string) budgetLabels {
+	l := budgetLabels{}
+	for _, label := range o.objective.GroupBy {
+		switch label {
+		case config.SLOGroupByCluster:
+			l.cluster = cluster
+		case config.SLOGroupByNamespace:
+			l.namespace = namespace
+		case config.SLOGroupByOwnerRef:
+			l.ownerRef = ownerRef
+		}
+	}
+	return l
+}
+
+// sum counts the deliveries of the window of all clusters
+func (s *budgetSeries) sum(now time.Time, window time.Duration) (good, bad int64) {
+	for _, counts := range s.clusters {
+		ring := counts.coarse
+		if window <= budgetFineSpan {
+			ring = counts.fine
+		}
+		g, b := ring.sum(now, window)
+		good += g
+		bad += b
+	}
+	return good, bad
+}
+
+func (s *budgetSeries) counts(cluster string, window time.Duration) *budgetCounts {
+	counts, ok := s.clusters[cluster]
+	if !ok {
+		counts = &budgetCounts{
+			fine:   newBudgetRing(budgetFineResolution, budgetFineSpan),
+			coarse: newBudgetRing(budgetCoarseResolution, window),
+		}
+		s.clusters[cluster] = counts
+	}
+	return counts
+}
+
+// errorBudgets counts the deliveries of the SLO objectives of the lunettes config
+type errorBudgets struct {
+	mutex      sync.Mutex
+	objectives map[string]*budgetObjective
+	// objective -> counts restored before the objective is loaded
+	pending map[string][]*budgetSnapshot
+	// label of the shard whose deliveries are counted
+	shard string
+	now   func() time.Time
+}
+
+func newErrorBudgets(now func() time.Time) *errorBudgets {
+	return &errorBudgets{
+		objectives: make(map[string]*budgetObjective),
+		pending:    make(map[string][]*budgetSnapshot),
+		shard:      "0",
+		now:        now,
+	}
+}
+
+func (b *errorBudgets) setShard(shard utils.Shard) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for name, o := range b.objectives {
+		for l := range o.series {
+			deleteBudgetMetrics(name, l, b.shard)
+		}
+	}
+	b.shard = strconv.Itoa(shard.Index)
+}
+
+// setObjectives keeps the counts of the objectives not changed
+func (b *errorBudgets) setObjectives(objectives []config.SLOObjective) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	current := make(map[string]*budgetObjective, len(objectives))
+	for _, objective := range objectives {
+		if o, ok := b.objectives[objective.Name]; ok && reflect.DeepEqual(o.objective, objective) {
+			current[objective.Name] = o
+			continue
+		}
+		o := &budgetObjective{
+			objective: objective,
+			window:    objective.WindowDuration(),
+			series:    make(map[budgetLabels]*budgetSeries),
+		}
+		if len(objective.Namespaces) > 0 {
+			o.namespaces = make(map[string]bool, len(objective.Namespaces))
+			for _, ns := range objective.Namespaces {
+				o.namespaces[ns] = true
+			}
+		}
+		current[objective.Name] = o
+		for _, snapshot := range b.pending[objective.Name] {
+			o.restore(snapshot)
+		}
+		delete(b.pending, objective.Name)
+	}
+	for name, o := range b.objectives {
+		if current[name] != o {
+			for l := range o.series {
+				deleteBudgetMetrics(name, l, b.shard)
+			}
+		}
+	}
+	b.objectives = current
+}
+
+// observe counts a delivery of sloType finished at the audit time at for the objectives matching it,
+// policy is the DeliverySLOPolicy of the pod
+func (b *errorBudgets) observe(sloType, cluster, namespace, ownerRef, policy string, good bool, at time.Time) {
+	defer utils.IgnorePanic("errorBudgets.observe")
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.objectives) == 0 {
+		return
+	}
+
+	if at.IsZero() {
+		at = b.now()
+	}
+	for name, o := range b.objectives {
+		if o.objective.ObjectiveType() != sloType || (o.namespaces != nil && !o.namespaces[namespace]) ||
+			(o.objective.Policy != "" && o.objective.Policy != policy) {
+			continue
+		}
+		l := o.labels(cluster, namespace, ownerRef)
+		s, ok := o.series[l]
+		if !ok {
+			if len(o.series) >= maxBudgetSeries {
+				klog.V(4).Infof("too many error budgets of slo objective %s, %+v is not counted", name, l)
+				continue
+			}
+			s = &budgetSeries{clusters: make(map[string]*budgetCounts)}
+			o.series[l] = s
+		}
+		counts := s.counts(cluster, o.window)
+		counts.fine.add(at, good)
+		counts.coarse.add(at, good)
+		if at.After(s.last) {
+			s.last = at
+		}
+	}
+}
+
+func (b *errorBudgets) budget(o *budgetObjective, l budgetLabels, s *budgetSeries, now time.Time) *ErrorBudget {
+	allowed := 1 - o.objective.Target/100
+	burnRate := func(window time.Duration) float64 {
+		good, bad := s.sum(now, window)
+		if good+bad == 0 {
+			return 0
+		}
+		return float64(bad) / float64(good+bad) / allowed
+	}
+
+	budget := &ErrorBudget{
+		Objective: o.objective.Name,
+		Type:      o.objective.ObjectiveType(),
+		Target:    o.objective.Target,
+		Window:    durationLabel(o.window),
+		Cluster:   l.cluster,
+		Namespace: l.namespace,
+		OwnerRef:  l.ownerRef,
+		BurnRates: make(map[string]float64, len(burnRateWindows)),
+	}
+	budget.Good, budget.Bad = s.sum(now, o.window)
+	budget.BudgetRemaining = 1 - burnRate(o.window)
+	for _, window := range burnRateWindows {
+		if window <= o.window {
+			budget.BurnRates[durationLabel(window)] = burnRate(window)
+		}
+	}
+	firing := make(map[string]bool)
+	for _, alert := range burnRateAlerts {
+		if alert.long <= o.window && burnRate(alert.long) > alert.rate && burnRate(alert.short) > alert.rate {
+			firing[alert.severity] = true
+		}
+	}
+	for _, severity := range burnRateSeverities {
+		if firing[severity] {
+			budget.Alerts = append(budget.Alerts, severity)
+		}
+	}
+	return budget
+}
+
+// list returns the error budgets of the objective, of all if objective is empty
+func (b *errorBudgets) list(objective string) []*ErrorBudget {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	result := make([]*ErrorBudget, 0)
+	for name, o := range b.objectives {
+		if objective != "" && name != objective {
+			continue
+		}
+		for l, s := range o.series {
+			result = append(result, b.budget(o, l, s, now))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		x, y := result[i], result[j]
+		if x.Objective != y.Objective {
+			return x.Objective < y.Objective
+		}
+		return fmt.Sprintf("%s/%s/%s", x.Cluster, x.Namespace, x.OwnerRef) < fmt.Sprintf("%s/%s/%s", y.Cluster, y.Namespace, y.OwnerRef)
+	})
+	return result
+}
+
+// updateMetrics sets the gauges of the error budgets, the ones without deliveries in the window are removed
+func (b *errorBudgets) updateMetrics() {
+	defer utils.IgnorePanic("errorBudgets.updateMetrics")
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	for name, o := range b.objectives {
+		for l, s := range o.series {
+			if now.Sub(s.last) > o.window {
+				delete(o.series, l)
+				deleteBudgetMetrics(name, l, b.shard)
+				continue
+			}
+
+			budget := b.budget(o, l, s, now)
+			errorBudgetRemaining.WithLabelValues(name, l.cluster, l.namespace, l.ownerRef, b.shard).Set(budget.BudgetRemaining)
+			errorBudgetDeliveries.WithLabelValues(name, l.cluster, l.namespace, l.ownerRef, b.shard, "good").Set(float64(budget.Good))
+			errorBudgetDeliveries.WithLabelValues(name, l.cluster, l.namespace, l.ownerRef, b.shard, "bad").Set(float64(budget.Bad))
+			for window, rate := range budget.BurnRates {
+				errorBudgetBurnRate.WithLabelValues(name, l.cluster, l.namespace, l.ownerRef, b.shard, window).Set(rate)
+			}
+			for _, severity := range burnRateSeverities {
+				firing := 0.0
+				if utils.SliceContainsString(budget.Alerts, severity) {
+					firing = 1
+				}
+				errorBudgetBurnAlert.WithLabelValues(name, l.cluster, l.namespace, l.ownerRef, b.shard, severity).Set(firing)
+			}
+		}
+	}
+}
+
+func deleteBudgetMetrics(objective string, l budgetLabels, shard string) {
+	errorBudgetRemaining.DeleteLabelValues(objective, l.cluster, l.namespace, l.ownerRef, shard)
+	errorBudgetDeliveries.DeleteLabelValues(objective, l.cluster, l.namespace, l.ownerRef, shard, "good")
+	errorBudgetDeliveries.DeleteLabelValues(objective, l.cluster, l.namespace, l.ownerRef, shard, "bad")
+	for _, window := range burnRateWindows {
+		errorBudgetBurnRate.DeleteLabelValues(objective, l.cluster, l.namespace, l.ownerRef, shard, durationLabel(window))
+	}
+	for _, severity := range burnRateSeverities {
+		errorBudgetBurnAlert.DeleteLabelValues(objective, l.cluster, l.namespace, l.ownerRef, shard, severity)
+	}
+}
+
+// durationLabel formats the window like 5m, 6h or 28d
+func durationLabel(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+// budgetSnapshot keeps the counts of a cluster in an error budget, to go on after restart
+type budgetSnapshot struct {
+	Objective string
+	Window    string
+	// labels of the budget
+	Cluster   string
+	Namespace string
+	OwnerRef  string
+	// cluster of the deliveries counted
+	CountsCluster string
+	Last          time.Time
+	Fine          []budgetBucketSnapshot
+	Coarse        []budgetBucketSnapshot
+}
+
+type budgetBucketSnapshot struct {
+	Index int64
+	Good  int64
+	Bad   int64
+}
+
+func (r *budgetRing) snapshot() []budgetBucketSnapshot {
+	buckets := make([]budgetBucketSnapshot, 0)
+	for _, bucket := range r.buckets {
+		if bucket.good+bucket.bad > 0 {
+			buckets = append(buckets, budgetBucketSnapshot{Index: bucket.index, Good: bucket.good, Bad: bucket.bad})
+		}
+	}
+	return buckets
+}
+
+func (r *budgetRing) restore(buckets []budgetBucketSnapshot) {
+	for _, bucket := range buckets {
+		if bucket.Index > r.latest {
+			r.latest = bucket.Index
+		}
+	}
+	for _, bucket := range buckets {
+		if bucket.Index <= r.latest-int64(len(r.buckets)) {
+			continue
+		}
+		r.buckets[bucket.Index%int64(len(r.buckets))] = budgetBucket{index: bucket.Index, good: bucket.Good, bad: bucket.Bad}
+	}
+}
+
+// snapshot returns the counts of the deliveries of cluster in the error budgets
+func (b *errorBudgets) snapshot(cluster string) []*budgetSnapshot {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	snapshots := make([]*budgetSnapshot, 0)
+	for name, o := range b.objectives {
+		for l, s := range o.series {
+			counts, ok := s.clusters[cluster]
+			if !ok {
+				continue
+			}
+			snapshots = append(snapshots, &budgetSnapshot{
+				Objective:     name,
+				Window:        durationLabel(o.window),
+				Cluster:       l.cluster,
+				Namespace:     l.namespace,
+				OwnerRef:      l.ownerRef,
+				CountsCluster: cluster,
+				Last:          s.last,
+				Fine:          counts.fine.snapshot(),
+				Coarse:        counts.coarse.snapshot(),
+			})
+		}
+	}
+	return snapshots
+}
+
+// restore rehydrates the counts, kept until the objective is loaded if it is not yet
+func (b *errorBudgets) restore(snapshot *budgetSnapshot) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	o, ok := b.objectives[snapshot.Objective]
+	if !ok {
+		b.pending[snapshot.Objective] = append(b.pending[snapshot.Objective], snapshot)
+		return
+	}
+	o.restore(snapshot)
+}
+
+// restore adds the counts of the snapshot if the window is not changed and the cluster is not counted yet
+func (o *budgetObjective) restore(snapshot *budgetSnapshot) {
+	if snapshot.Window != durationLabel(o.window) {
+		return
+	}
+	l := budgetLabels{cluster: snapshot.Cluster, namespace: snapshot.Namespace, ownerRef: snapshot.OwnerRef}
+	s, ok := o.series[l]
+	if !ok {
+		if len(o.series) >= maxBudgetSeries {
+			return
+		}
+		s = &budgetSeries{clusters: make(map[string]*budgetCounts)}
+		o.series[l] = s
+	}
+	if _, counted := s.clusters[snapshot.CountsCluster]; counted {
+		return
+	}
+	counts := s.counts(snapshot.CountsCluster, o.window)
+	counts.fine.restore(snapshot.Fine)
+	counts.coarse.restore(snapshot.Coarse)
+	if snapshot.Last.After(s.last) {
+		s.last = snapshot.Last
+	}
+}
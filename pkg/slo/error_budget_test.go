@@ -0,0 +1,149 @@
+package slo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorBudgets(t *testing.T) {
+	now := testStart
+	b := newErrorBudgets(func() time.Time { return now })
+	objectives := []config.SLOObjective{
+		{Name: "create", Target: 99, Window: "1d", Namespaces: []string{"ns1", "ns2"}, GroupBy: []string{config.SLOGroupByNamespace}},
+		{Name: "delete", Type: config.SLOObjectivePodDelete, Target: 99.9},
+	}
+	b.setObjectives(objectives)
+
+	for i := 0; i < 198; i++ {
+		b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/web", "", true, time.Time{})
+	}
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/web", "", false, time.Time{})
+	now = now.Add(2 * time.Hour)
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/web", "", false, time.Time{})
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns3", "ReplicaSet/web", "", false, time.Time{})
+	b.observe(config.SLOObjectivePodDelete, "c1", "ns2", "", "", true, time.Time{})
+
+	budgets := b.list("create")
+	assert.Equal(t, 1, len(budgets))
+	budget := budgets[0]
+	assert.Equal(t, "ns1", budget.Namespace)
+	assert.Empty(t, budget.Cluster)
+	assert.Equal(t, "1d", budget.Window)
+	assert.Equal(t, int64(198), budget.Good)
+	assert.Equal(t, int64(2), budget.Bad)
+	assert.InDelta(t, 0, budget.BudgetRemaining, 1e-9)
+	// the only delivery of the latest 5m failed
+	assert.InDelta(t, 100, budget.BurnRates["5m"], 1e-9)
+	assert.InDelta(t, 100, budget.BurnRates["1h"], 1e-9)
+	assert.InDelta(t, 1, budget.BurnRates["6h"], 1e-9)
+	assert.NotContains(t, budget.BurnRates, "3d")
+	assert.Equal(t, []string{"page"}, budget.Alerts)
+	assert.Equal(t, 2, len(b.list("")))
+
+	b.updateMetrics()
+	assert.Equal(t, 1.0, testutil.ToFloat64(errorBudgetBurnAlert.WithLabelValues("create", "", "ns1", "", "0", "page")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(errorBudgetBurnAlert.WithLabelValues("create", "", "ns1", "", "0", "ticket")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(errorBudgetDeliveries.WithLabelValues("create", "", "ns1", "", "0", "bad")))
+
+	// the short window recovers
+	now = now.Add(10 * time.Minute)
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/web", "", true, time.Time{})
+	budget = b.list("create")[0]
+	assert.Equal(t, 0.0, budget.BurnRates["5m"])
+	assert.Empty(t, budget.Alerts)
+
+	// unchanged objectives keep the counts
+	objectives[1].Target = 99.5
+	b.setObjectives(objectives)
+	assert.Equal(t, int64(199), b.list("create")[0].Good)
+	assert.Empty(t, b.list("delete"))
+
+	// the budgets without deliveries in the window are removed
+	now = now.Add(25 * time.Hour)
+	assert.Equal(t, int64(0), b.list("create")[0].Good)
+	assert.InDelta(t, 1, b.list("create")[0].BudgetRemaining, 1e-9)
+	b.updateMetrics()
+	assert.Empty(t, b.list(""))
+}
+
+func TestBudgetRing(t *testing.T) {
+	r := newBudgetRing(time.Minute, 10*time.Minute)
+	r.add(testStart, true)
+	r.add(testStart.Add(30*time.Minute), false)
+	// older than the ring
+	r.add(testStart.Add(5*time.Minute), true)
+	r.add(testStart.Add(25*time.Minute), true)
+
+	good, bad := r.sum(testStart.Add(30*time.Minute), time.Hour)
+	assert.Equal(t, int64(1), good)
+	assert.Equal(t, int64(1), bad)
+	good, bad = r.sum(testStart.Add(30*time.Minute), time.Minute)
+	assert.Equal(t, int64(0), good)
+	assert.Equal(t, int64(1), bad)
+	assert.Equal(t, "28d", durationLabel(config.DefaultSLOWindow))
+	assert.Equal(t, "30m", durationLabel(30*time.Minute))
+}
+
+func TestPolicyErrorBudgets(t *testing.T) {
+	b := newErrorBudgets(func() time.Time { return testStart })
+	b.setObjectives([]config.SLOObjective{{Name: "web/pod_create", Type: config.SLOObjectivePodCreate, Target: 99, Policy: "web"}})
+
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/web", "web", true, time.Time{})
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/db", "db", false, time.Time{})
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "ReplicaSet/db", "", false, time.Time{})
+
+	budgets := b.list("")
+	assert.Equal(t, 1, len(budgets))
+	assert.Equal(t, int64(1), budgets[0].Good)
+	assert.Equal(t, int64(0), budgets[0].Bad)
+}
+
+func TestErrorBudgetSnapshot(t *testing.T) {
+	now := testStart
+	objectives := []config.SLOObjective{{Name: "create", Target: 99, Window: "1d", GroupBy: []string{config.SLOGroupByNamespace}}}
+	b := newErrorBudgets(func() time.Time { return now })
+	b.setObjectives(objectives)
+
+	// counted at the audit time the deliveries finished, not when they are observed
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "", "", true, now.Add(-2*time.Hour))
+	b.observe(config.SLOObjectivePodCreate, "c1", "ns1", "", "", false, now.Add(-10*time.Minute))
+	b.observe(config.SLOObjectivePodCreate, "c2", "ns1", "", "", false, now)
+	budget := b.list("create")[0]
+	assert.Equal(t, int64(1), budget.Good)
+	assert.Equal(t, int64(2), budget.Bad)
+	assert.InDelta(t, 200.0/3, budget.BurnRates["6h"], 1e-9)
+	assert.InDelta(t, 100, budget.BurnRates["5m"], 1e-9)
+
+	snapshots := b.snapshot("c1")
+	assert.Equal(t, 1, len(snapshots))
+	data, err := json.Marshal(snapshots[0])
+	assert.Nil(t, err)
+
+	// restarted, the snapshot is restored before the objectives are loaded
+	restarted := newErrorBudgets(func() time.Time { return now })
+	snapshot := &budgetSnapshot{}
+	assert.Nil(t, json.Unmarshal(data, snapshot))
+	restarted.restore(snapshot)
+	assert.Empty(t, restarted.list(""))
+	restarted.setObjectives(objectives)
+	// counted once
+	restarted.restore(snapshot)
+	budget = restarted.list("create")[0]
+	assert.Equal(t, "ns1", budget.Namespace)
+	assert.Equal(t, int64(1), budget.Good)
+	assert.Equal(t, int64(1), budget.Bad)
+	assert.InDelta(t, 50, budget.BurnRates["6h"], 1e-9)
+	assert.Equal(t, 0.0, budget.BurnRates["5m"])
+
+	// a changed window starts over
+	objectives[0].Window = "7d"
+	changed := newErrorBudgets(func() time.Time { return now })
+	changed.setObjectives(objectives)
+	changed.restore(snapshot)
+	assert.Empty(t, changed.list(""))
+}
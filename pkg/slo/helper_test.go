@@ -0,0 +1,50 @@
+package slo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/shares"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+var testStart = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func generatePod(name, ownerKind, ownerName string) *v1.Pod {
+	controller := true
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "ns",
+			Name:            name,
+			UID:             types.UID(name + "-uid"),
+			OwnerReferences: []metav1.OwnerReference{{Kind: ownerKind, Name: ownerName, Controller: &controller}},
+		},
+	}
+}
+
+func generateAuditEvent(t *testing.T, verb, resource, subresource string, obj metav1.Object, at time.Duration) *shares.AuditEvent {
+	raw, err := json.Marshal(obj)
+	assert.Nil(t, err)
+	event := shares.NewAuditEvent(&k8s_audit.Event{
+		AuditID: types.UID(verb + "-" + resource + "-" + obj.GetName()),
+		Verb:    verb,
+		ObjectRef: &k8s_audit.ObjectReference{
+			Namespace: obj.GetNamespace(), Name: obj.GetName(), Resource: resource, Subresource: subresource,
+		},
+		ResponseStatus: &metav1.Status{Code: 200},
+		ResponseObject: &runtime.Unknown{Raw: raw},
+		StageTimestamp: metav1.NewMicroTime(testStart.Add(at)),
+		Annotations:    map[string]string{"cluster": "c1"},
+	})
+	if runtimeObj, ok := obj.(runtime.Object); ok {
+		event.ResponseRuntimeObj = runtimeObj
+	}
+	return event
+}
@@ -0,0 +1,457 @@
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/dal/storage-client/model"
+	"github.com/alipay/container-observability-service/pkg/metas"
+	"github.com/alipay/container-observability-service/pkg/shares"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/prometheus/client_golang/prometheus"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// JobMileStone is the completion of a Job, from its creation, or the schedule time of its CronJob
+type JobMileStone struct {
+	Type        string
+	Cluster     string
+	Namespace   string
+	JobName     string
+	JobUID      string
+	CronJobName string
+	// the schedule time if created by a CronJob, otherwise the creation of the Job
+	CreatedTime time.Time
+	// time from the schedule time of the CronJob to the creation of the Job
+	ScheduleDelay   time.Duration
+	FinishTime      time.Time
+	Duration        time.Duration
+	TriggerAuditLog string
+	Completions     int32
+	Parallelism     int32
+	BackoffLimit    int32
+	Succeeded       int32
+	// the pods failed, each one retried until BackoffLimit
+	Failed      int32
+	PodsCreated int
+	// success, BackoffLimitExceeded, DeadlineExceeded, deleted or timeout
+	JobResult string
+	// the most common failure of the pods, or the reason of the Failed condition if none of them failed
+	JobFailedReason string
+	// failures of the pods, by the create SLO or by the containers terminated
+	PodFailedReasons map[string]int
+	//内部变量
+	key string
+	// pods of the job whose create SLO has not finished
+	pendingPods map[string]string // uid -> name
+	// pods of the job failed after created
+	failedPods map[string]bool
+}
+
+const (
+	JOB_SUCCESS = "success"
+	JOB_DELETED = "deleted"
+	JOB_TIMEOUT = "timeout"
+	// the Failed condition without a reason
+	JOB_FAILED = "Failed"
+
+	JobMileStoneType = model.SloTypeJob
+
+	kindJob     = "Job"
+	kindCronJob = "CronJob"
+
+	cronJobScheduledTimestampAnnotation = "batch.kubernetes.io/cronjob-scheduled-timestamp"
+
+	// jobs not finished in a day are saved as timeout
+	jobTimeout = 24 * time.Hour
+	// after a job finishes, the create SLOs of its pods are waited for so long
+	jobSettleTime = time.Minute
+)
+
+var (
+	jobResult = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_job_result_count",
+			Help: "jobs finished by result",
+		},
+		[]string{"cluster", "namespace", "result"},
+	)
+	jobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "slo_job_duration_seconds",
+			Help:    "time from the creation, or the schedule time of the cronjob, to the job finished",
+			Buckets: []float64{60, 300, 600, 1800, 3600, 7200, 21600, 86400},
+		},
+		[]string{"cluster", "result"},
+	)
+	jobRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_job_pod_retries_count",
+			Help: "pods of the finished jobs failed and retried",
+		},
+		[]string{"cluster", "namespace"},
+	)
+
+	jobs = newJobTracker(saveJobMileStone)
+	// the dead letters of the jobs re-processed
+	jobQueue = newTrackerQueue("slo-watcher-job", processJobEvent)
+)
+
+func init() {
+	prometheus.MustRegister(jobResult)
+	prometheus.MustRegister(jobDuration)
+	prometheus.MustRegister(jobRetries)
+
+	metas.RegisterPublisher(metas.JobSLO)
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			sloOngoingSize.WithLabelValues("job").Set(float64(jobs.size()))
+			jobs.checkTimeout()
+		}
+	}()
+}
+
+// processJobEvent is called by the SLO watcher before the event is handed to the create SLO,
+// so the pods of a job are known to it before their SLOs finish
+func processJobEvent(event *shares.AuditEvent) {
+	defer event.IgnorePanic("slo_job", "processJobEvent")
+
+	if event.ObjectRef == nil {
+		return
+	}
+	jobs.processEvent(event)
+}
+
+func saveJobMileStone(ms *JobMileStone) {
+	jobResult.WithLabelValues(ms.Cluster, ms.Namespace, ms.JobResult).Inc()
+	jobDuration.WithLabelValues(ms.Cluster, ms.JobResult).Observe(ms.Duration.Seconds())
+	jobRetries.WithLabelValues(ms.Cluster, ms.Namespace).Add(float64(ms.Failed))
+
+	data, err := json.Marshal(ms)
+	if err != nil {
+		klog.Errorf("failed to marshal job %s: %s", ms.key, err.Error())
+		return
+	}
+	if err := xsearch.SaveSloTraceData(ms.Cluster, ms.Namespace, ms.JobName, ms.JobUID, JobMileStoneType, data); err != nil {
+		klog.Errorf("failed to save job %s: %s", ms.key, err.Error())
+	}
+	publishDeliveryResult(metas.JobSLO, json.RawMessage(data))
+	klog.V(6).Infof("job %s finished: %s", ms.key, ms.JobResult)
+}
+
+// jobTracker follows the jobs to completion, and the create SLOs of their pods
+type jobTracker struct {
+	mutex sync.Mutex
+	// job key -> job running
+	active map[string]*JobMileStone
+	// the jobs finished, waiting for the create SLOs of their pods
+	settler *podSettler
+	save    func(*JobMileStone)
+}
+
+func newJobTracker(save func(*JobMileStone)) *jobTracker {
+	return &jobTracker{
+		active:  make(map[string]*JobMileStone),
+		settler: newPodSettler(jobSettleTime),
+		save:    save,
+	}
+}
+
+func jobKey(cluster, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", cluster, namespace, name)
+}
+
+func (t *jobTracker) size() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.active) + len(t.settler.ending)
+}
+
+func (ms *JobMileStone) settling() (string, time.Time, map[string]string) {
+	return ms.Cluster, ms.FinishTime, ms.pendingPods
+}
+
+func (t *jobTracker) processEvent(event *shares.AuditEvent) {
+	if event.ResponseStatus == nil || event.ResponseStatus.Code >= 300 {
+		return
+	}
+	cluster := event.Annotations["cluster"]
+	now := event.StageTimestamp.Time
+
+	t.mutex.Lock()
+	// saved after unlocked
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	t.settler.observeAuditTime(cluster, now)
+
+	ref := event.ObjectRef
+	switch {
+	case ref.Resource == "jobs":
+		t.processJob(event, cluster, now)
+	case ref.Resource == "pods" && ref.Subresource == "" && event.Verb == "create":
+		pod, ok := event.ResponseRuntimeObj.(*v1.Pod)
+		if !ok || pod == nil {
+			return
+		}
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || owner.Kind != kindJob {
+			return
+		}
+		ms, ok := t.active[jobKey(cluster, pod.Namespace, owner.Name)]
+		if !ok {
+			return
+		}
+		ms.PodsCreated++
+		t.settler.addPod(ms, string(pod.UID), pod.Name, POD_CREATE)
+	case ref.Resource == "pods" && ref.Subresource == "status":
+		pod, ok := event.ResponseRuntimeObj.(*v1.Pod)
+		if !ok || pod == nil || pod.Status.Phase != v1.PodFailed {
+			return
+		}
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || owner.Kind != kindJob {
+			return
+		}
+		ms, ok := t.active[jobKey(cluster, pod.Namespace, owner.Name)]
+		if !ok || ms.failedPods[string(pod.UID)] {
+			return
+		}
+		ms.failedPods[string(pod.UID)] = true
+		ms.PodFailedReasons[podFailureReason(pod)]++
+	}
+}
+
+// podFailureReason tells why a pod failed after created, by its status or its containers terminated
+func podFailureReason(pod *v1.Pod) string {
+	if pod.Status.Reason != "" {
+		// DeadlineExceeded, Evicted
+		return pod.Status.Reason
+	}
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if terminated := cs.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			if terminated.Reason != "" {
+				// Error, OOMKilled
+				return terminated.Reason
+			}
+			return fmt.Sprintf("ExitCode%d", terminated.ExitCode)
+		}
+	}
+	return JOB_FAILED
+}
+
+func (t *jobTracker) processJob(event *shares.AuditEvent, cluster string, now time.Time) {
+	ref := event.ObjectRef
+	key := jobKey(cluster, ref.Namespace, ref.Name)
+	if event.Verb == "delete" && ref.Subresource == "" {
+		if ms, ok := t.active[key]; ok {
+			t.endJob(ms, JOB_DELETED, now)
+		}
+		return
+	}
+	if (event.Verb != "create" && event.Verb != "update" && event.Verb != "patch") || event.ResponseObject == nil {
+		return
+	}
+	job := &batchv1.Job{}
+	if err := json.Unmarshal(event.ResponseObject.Raw, job); err != nil {
+		return
+	}
+
+	ms, ok := t.active[key]
+	if ok && ms.JobUID != string(job.UID) {
+		// recreated with the same name
+		t.endJob(ms, JOB_DELETED, now)
+		ok = false
+	}
+	if !ok {
+		// the jobs running before restart are followed from their creation too
+		if jobFinished(job) != "" {
+			return
+		}
+		ms = newJobMileStone(cluster, job, now)
+		if event.Verb == "create" {
+			ms.TriggerAuditLog = string(event.AuditID)
+		}
+		t.active[key] = ms
+		klog.V(6).Infof("job %s started", key)
+	}
+
+	ms.Succeeded = job.Status.Succeeded
+	ms.Failed = job.Status.Failed
+	if result := jobFinished(job); result != "" {
+		t.endJob(ms, result, now)
+	}
+}
+
+func newJobMileStone(cluster string, job *batchv1.Job, now time.Time) *JobMileStone {
+	ms := &JobMileStone{
+		Type:             JobMileStoneType,
+		Cluster:          cluster,
+		Namespace:        job.Namespace,
+		JobName:          job.Name,
+		JobUID:           string(job.UID),
+		CreatedTime:      job.CreationTimestamp.Time,
+		PodFailedReasons: make(map[string]int),
+		key:              jobKey(cluster, job.Namespace, job.Name),
+		pendingPods:      make(map[string]string),
+		failedPods:       make(map[string]bool),
+	}
+	if ms.CreatedTime.IsZero() {
+		ms.CreatedTime = now
+	}
+	if job.Spec.Completions != nil {
+		ms.Completions = *job.Spec.Completions
+	}
+	if job.Spec.Parallelism != nil {
+		ms.Parallelism = *job.Spec.Parallelism
+	}
+	if job.Spec.BackoffLimit != nil {
+		ms.BackoffLimit = *job.Spec.BackoffLimit
+	}
+	if owner := metav1.GetControllerOf(job); owner != nil && owner.Kind == kindCronJob {
+		ms.CronJobName = owner.Name
+		if scheduled := cronJobScheduledTime(job, owner.Name); !scheduled.IsZero() {
+			ms.ScheduleDelay = ms.CreatedTime.Sub(scheduled)
+			ms.CreatedTime = scheduled
+		}
+	}
+	return ms
+}
+
+// cronJobScheduledTime tells the schedule time of the job created by a CronJob, zero if unknown
+func cronJobScheduledTime(job *batchv1.Job, cronJob string) time.Time {
+	if v, ok := job.Annotations[cronJobScheduledTimestampAnnotation]; ok {
+		if scheduled, err := time.Parse(time.RFC3339, v); err == nil {
+			return scheduled
+		}
+	}
+	// the job is named <cronjob>-<schedule time>, in minutes by the CronJob controller v2, in seconds before
+	if !strings.HasPrefix(job.Name, cronJob+"-") {
+		return time.Time{}
+	}
+	n, err := strconv.ParseInt(strings.TrimPrefix(job.Name, cronJob+"-"), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	created := job.CreationTimestamp.Time
+	for _, scheduled := range []time.Time{time.Unix(n*60, 0), time.Unix(n, 0)} {
+		if !scheduled.After(created) && created.Sub(scheduled) < jobTimeout {
+			return scheduled
+		}
+	}
+	return time.Time{}
+}
+
+// jobFinished returns the result of the job by its conditions, empty if it is running
+func jobFinished(job *batchv1.Job) string {
+	for _, c := range job.Status.Conditions {
+		if c.Status != v1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return JOB_SUCCESS
+		case batchv1.JobFailed:
+			if c.Reason != "" {
+				return c.Reason
+			}
+			return JOB_FAILED
+		}
+	}
+	return ""
+}
+
+// observePodCreated is called when the create SLO of a pod finishes
+func (t *jobTracker) observePodCreated(uid, result string) {
+	t.mutex.Lock()
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	ms, ok := t.settler.popPod(uid, POD_CREATE).(*JobMileStone)
+	if !ok {
+		return
+	}
+	if result != CREATE_RESULT_SUCCESS && result != "" {
+		ms.PodFailedReasons[result]++
+	}
+	t.settle()
+}
+
+func (t *jobTracker) endJob(ms *JobMileStone, result string, now time.Time) {
+	delete(t.active, ms.key)
+	ms.JobResult = result
+	ms.FinishTime = now
+	ms.Duration = now.Sub(ms.CreatedTime)
+	t.settler.end(ms)
+	t.settle()
+}
+
+// settle finishes the jobs whose pods have all finished their create SLOs, or which have waited long enough
+func (t *jobTracker) settle() {
+	t.settler.settle(func(settled settlingMilestone, _ []string) {
+		ms := settled.(*JobMileStone)
+		if ms.JobResult == JOB_SUCCESS {
+			return
+		}
+		ms.JobFailedReason = mostCommonReason(ms.PodFailedReasons)
+		if ms.JobFailedReason == "" && ms.JobResult != JOB_DELETED && ms.JobResult != JOB_TIMEOUT {
+			// the reason of the Failed condition, e.g. DeadlineExceeded
+			ms.JobFailedReason = ms.JobResult
+		}
+	})
+}
+
+// saveSettled saves the jobs settled out of the lock, as saving them may block
+func (t *jobTracker) saveSettled() {
+	t.mutex.Lock()
+	settled := t.settler.takeSettled()
+	t.mutex.Unlock()
+	for _, ms := range settled {
+		t.save(ms.(*JobMileStone))
+	}
+}
+
+// mostCommonReason returns the reason counted most, the first by name on ties
+func mostCommonReason(reasons map[string]int) string {
+	names := make([]string, 0, len(reasons))
+	for name := range reasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result, max := "", 0
+	for _, name := range names {
+		if reasons[name] > max {
+			result, max = name, reasons[name]
+		}
+	}
+	return result
+}
+
+// podsWaited adds the pods whose SLOs are waited for and are not followed here, as tells local, to pods by cluster
+func (t *jobTracker) podsWaited(local func(uid string) bool, pods remotePods) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.settler.podsWaited(local, pods)
+}
+
+// checkTimeout ends the jobs not finished in jobTimeout by the audit time
+func (t *jobTracker) checkTimeout() {
+	t.mutex.Lock()
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	for _, ms := range t.active {
+		if now, timedOut := t.settler.timedOut(ms.Cluster, ms.CreatedTime, jobTimeout); timedOut {
+			t.endJob(ms, JOB_TIMEOUT, now)
+		}
+	}
+	t.settle()
+}
@@ -0,0 +1,174 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestJob(name string, status batchv1.JobStatus, owner *metav1.OwnerReference) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "ns",
+			Name:              name,
+			UID:               types.UID(name + "-uid"),
+			CreationTimestamp: metav1.NewTime(testStart),
+		},
+		Spec:   batchv1.JobSpec{Completions: int32Ptr(2), Parallelism: int32Ptr(1), BackoffLimit: int32Ptr(3)},
+		Status: status,
+	}
+	if owner != nil {
+		job.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return job
+}
+
+func jobCondition(conditionType batchv1.JobConditionType, reason string) []batchv1.JobCondition {
+	return []batchv1.JobCondition{{Type: conditionType, Status: v1.ConditionTrue, Reason: reason}}
+}
+
+func TestJobCompletion(t *testing.T) {
+	saved := make([]*JobMileStone, 0)
+	var tracker *jobTracker
+	tracker = newJobTracker(func(ms *JobMileStone) {
+		// saved out of the lock
+		tracker.size()
+		saved = append(saved, ms)
+	})
+
+	tracker.processEvent(generateAuditEvent(t, "create", "jobs", "", newTestJob("batch", batchv1.JobStatus{}, nil), 0))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod("batch-a", kindJob, "batch"), time.Second))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod("batch-b", kindJob, "batch"), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod("batch-c", kindJob, "batch"), 2*time.Minute))
+	tracker.observePodCreated("batch-a-uid", "RunContainerError")
+	tracker.observePodCreated("batch-b-uid", CREATE_RESULT_SUCCESS)
+	tracker.processEvent(generateAuditEvent(t, "update", "jobs", "status", newTestJob("batch", batchv1.JobStatus{
+		Succeeded: 2, Failed: 1, Conditions: jobCondition(batchv1.JobComplete, ""),
+	}, nil), 5*time.Minute))
+
+	// waiting for the create SLO of batch-c
+	assert.Equal(t, 0, len(saved))
+	tracker.observePodCreated("batch-c-uid", CREATE_RESULT_SUCCESS)
+	assert.Equal(t, 1, len(saved))
+
+	ms := saved[0]
+	assert.Equal(t, JobMileStoneType, ms.Type)
+	assert.Equal(t, JOB_SUCCESS, ms.JobResult)
+	assert.Equal(t, "create-jobs-batch", ms.TriggerAuditLog)
+	assert.Equal(t, 5*time.Minute, ms.Duration)
+	assert.Equal(t, int32(2), ms.Completions)
+	assert.Equal(t, int32(1), ms.Failed)
+	assert.Equal(t, 3, ms.PodsCreated)
+	assert.Equal(t, map[string]int{"RunContainerError": 1}, ms.PodFailedReasons)
+	assert.Empty(t, ms.JobFailedReason)
+	assert.Equal(t, 0, tracker.size())
+
+	// finished jobs are not followed again
+	tracker.processEvent(generateAuditEvent(t, "patch", "jobs", "", newTestJob("batch", batchv1.JobStatus{
+		Succeeded: 2, Conditions: jobCondition(batchv1.JobComplete, ""),
+	}, nil), 6*time.Minute))
+	assert.Equal(t, 0, tracker.size())
+}
+
+func TestCronJobFailed(t *testing.T) {
+	saved := make([]*JobMileStone, 0)
+	tracker := newJobTracker(func(ms *JobMileStone) {
+		saved = append(saved, ms)
+	})
+
+	controller := true
+	owner := &metav1.OwnerReference{Kind: kindCronJob, Name: "report", Controller: &controller}
+	// scheduled a minute before the job created, named by the minutes
+	name := "report-28092959"
+
+	// followed from the status update after restart
+	tracker.processEvent(generateAuditEvent(t, "update", "jobs", "status", newTestJob(name, batchv1.JobStatus{Failed: 1}, owner), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod(name+"-a", kindJob, name), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod(name+"-b", kindJob, name), 2*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod(name+"-c", kindJob, name), 3*time.Minute))
+	tracker.observePodCreated(name+"-a-uid", "ImagePullFailed")
+	tracker.observePodCreated(name+"-b-uid", "ImagePullFailed")
+	tracker.processEvent(generateAuditEvent(t, "update", "jobs", "status", newTestJob(name, batchv1.JobStatus{
+		Failed: 4, Conditions: jobCondition(batchv1.JobFailed, "BackoffLimitExceeded"),
+	}, owner), 4*time.Minute))
+	// the create SLO of the last pod never finishes
+	assert.Equal(t, 0, len(saved))
+	tracker.processEvent(generateAuditEvent(t, "create", "jobs", "", newTestJob("other", batchv1.JobStatus{}, nil), 6*time.Minute))
+	tracker.checkTimeout()
+	assert.Equal(t, 1, len(saved))
+
+	ms := saved[0]
+	assert.Equal(t, "BackoffLimitExceeded", ms.JobResult)
+	assert.Equal(t, "report", ms.CronJobName)
+	assert.Equal(t, time.Minute, ms.ScheduleDelay)
+	assert.Equal(t, testStart.Add(-time.Minute), ms.CreatedTime.UTC())
+	assert.Equal(t, 5*time.Minute, ms.Duration)
+	assert.Equal(t, int32(4), ms.Failed)
+	assert.Equal(t, "ImagePullFailed", ms.JobFailedReason)
+	assert.Equal(t, 1, tracker.size())
+
+	tracker.processEvent(generateAuditEvent(t, "delete", "jobs", "", newTestJob("other", batchv1.JobStatus{}, nil), 7*time.Minute))
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, JOB_DELETED, saved[1].JobResult)
+}
+
+func failedJobPod(name, job, reason string) *v1.Pod {
+	pod := generatePod(name, kindJob, job)
+	pod.Status.Phase = v1.PodFailed
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name: "main", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 137, Reason: reason}},
+	}}
+	return pod
+}
+
+func TestJobFailedAfterPodsCreated(t *testing.T) {
+	saved := make([]*JobMileStone, 0)
+	tracker := newJobTracker(func(ms *JobMileStone) {
+		saved = append(saved, ms)
+	})
+
+	tracker.processEvent(generateAuditEvent(t, "create", "jobs", "", newTestJob("batch", batchv1.JobStatus{}, nil), 0))
+	for i, name := range []string{"batch-a", "batch-b", "batch-c"} {
+		tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod(name, kindJob, "batch"), time.Duration(i)*time.Minute))
+		tracker.observePodCreated(name+"-uid", CREATE_RESULT_SUCCESS)
+	}
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", failedJobPod("batch-a", "batch", "OOMKilled"), 3*time.Minute))
+	// counted once
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", failedJobPod("batch-a", "batch", "OOMKilled"), 3*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", failedJobPod("batch-b", "batch", "OOMKilled"), 4*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", failedJobPod("batch-c", "batch", "Error"), 5*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "update", "jobs", "status", newTestJob("batch", batchv1.JobStatus{
+		Failed: 3, Conditions: jobCondition(batchv1.JobFailed, "BackoffLimitExceeded"),
+	}, nil), 6*time.Minute))
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, "BackoffLimitExceeded", saved[0].JobResult)
+	assert.Equal(t, map[string]int{"OOMKilled": 2, "Error": 1}, saved[0].PodFailedReasons)
+	assert.Equal(t, "OOMKilled", saved[0].JobFailedReason)
+
+	// the pods are deleted at the deadline, not failed
+	tracker.processEvent(generateAuditEvent(t, "create", "jobs", "", newTestJob("slow", batchv1.JobStatus{}, nil), 7*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", generatePod("slow-a", kindJob, "slow"), 7*time.Minute))
+	tracker.observePodCreated("slow-a-uid", CREATE_RESULT_SUCCESS)
+	tracker.processEvent(generateAuditEvent(t, "update", "jobs", "status", newTestJob("slow", batchv1.JobStatus{
+		Conditions: jobCondition(batchv1.JobFailed, "DeadlineExceeded"),
+	}, nil), 9*time.Minute))
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, "DeadlineExceeded", saved[1].JobFailedReason)
+}
+
+func TestCronJobScheduledTime(t *testing.T) {
+	created := metav1.NewTime(time.Unix(1685577630, 0))
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "report-1685577600", CreationTimestamp: created}}
+	assert.Equal(t, time.Unix(1685577600, 0), cronJobScheduledTime(job, "report"))
+	job.Name = "report-28092960"
+	assert.Equal(t, time.Unix(1685577600, 0), cronJobScheduledTime(job, "report"))
+	job.Name = "report-manual"
+	assert.True(t, cronJobScheduledTime(job, "report").IsZero())
+	job.Annotations = map[string]string{cronJobScheduledTimestampAnnotation: "2023-06-01T00:00:00Z"}
+	assert.Equal(t, "2023-06-01T00:00:00Z", cronJobScheduledTime(job, "report").UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,45 @@
+package slo
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/config"
+)
+
+// sloConfig holds the durations of the lunettes config parsed once per change
+type sloConfig struct {
+	// UserOnlineConfigMap
+	namespaceTimeouts map[string]time.Duration
+	// 0 if not set
+	postStartHookTimeout time.Duration
+}
+
+var sloConfigValue atomic.Value
+
+func init() {
+	config.OnLunettesConfigChange("slo", func(_, c *config.LunettesConfig) {
+		sloConfigValue.Store(newSLOConfig(c))
+	})
+}
+
+// the config is validated, invalid durations are skipped anyway
+func newSLOConfig(c *config.LunettesConfig) *sloConfig {
+	s := &sloConfig{namespaceTimeouts: make(map[string]time.Duration, len(c.UserOnlineConfigMap))}
+	for ns, timeout := range c.UserOnlineConfigMap {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			s.namespaceTimeouts[ns] = d
+		}
+	}
+	if c.PostStartHookTimeout != "" {
+		s.postStartHookTimeout, _ = time.ParseDuration(c.PostStartHookTimeout)
+	}
+	return s
+}
+
+func currentSLOConfig() *sloConfig {
+	if s, ok := sloConfigValue.Load().(*sloConfig); ok {
+		return s
+	}
+	return &sloConfig{}
+}
@@ -0,0 +1,250 @@
+package slo
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SnapshotPodCreate  = "pod_create"
+	SnapshotPodUpgrade = "pod_upgrade"
+	SnapshotPVCCreate  = "pvc_create"
+
+	SnapshotWorkloadRollout = "workload_rollout"
+	SnapshotNodeLifecycle   = "node_lifecycle"
+	SnapshotErrorBudget     = "error_budget"
+)
+
+// podCreateSnapshot keeps the internal state of PodStartupMilestones needed to go on after restart
+type podCreateSnapshot struct {
+	Milestone        *PodStartupMilestones
+	LatestPod        *v1.Pod
+	ShouldFinishTime *time.Time
+	TrickTime        *time.Time
+	Events           []*v1.Event
+}
+
+type podUpgradeSnapshot struct {
+	Milestone         *PodUpgradeMileStone
+	SubKey            string
+	UpgradeContainers []string
+}
+
+// SnapshotMilestones returns the in-flight create/upgrade/pvc milestones, workload rollouts and nodes of cluster,
+// and the deliveries of cluster counted by the error budgets
+func SnapshotMilestones(cluster string) []*xsearch.MilestoneSnapshot {
+	defer utils.IgnorePanic("SnapshotMilestones")
+
+	var mutex sync.Mutex
+	snapshots := make([]*xsearch.MilestoneSnapshot, 0)
+	add := func(kind, key, uid string, v interface{}) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			klog.Errorf("failed to marshal %s milestone %s: %s", kind, key, err.Error())
+			return
+		}
+		mutex.Lock()
+		snapshots = append(snapshots, &xsearch.MilestoneSnapshot{Kind: kind, Key: key, UID: uid, Data: data})
+		mutex.Unlock()
+	}
+
+	podMilestoneMap.IterateWithFunc(func(i interface{}) {
+		ms, ok := i.(*PodStartupMilestones)
+		if !ok || ms.Cluster != cluster || ms.Finished || ms.StopInterEvents {
+			return
+		}
+		snapshot := &podCreateSnapshot{Events: make([]*v1.Event, 0)}
+		if v, ok := podEventsMap.Get(ms.key); ok && v != nil {
+			for item := v.(*list.List).Front(); item != nil; item = item.Next() {
+				snapshot.Events = append(snapshot.Events, item.Value.(*v1.Event))
+			}
+		}
+
+		ms.mutex.RLock()
+		defer ms.mutex.RUnlock()
+		snapshot.Milestone = ms
+		snapshot.LatestPod = ms.latestPod
+		snapshot.ShouldFinishTime = ms.shouldFinishTime
+		snapshot.TrickTime = ms.trickTime
+		add(SnapshotPodCreate, ms.key, ms.PodUID, snapshot)
+	})
+
+	podUpgradeMileStoneMap.IterateWithFunc(func(i interface{}) {
+		upgradeMsMap, ok := i.(map[string]*PodUpgradeMileStone)
+		if !ok {
+			return
+		}
+		for _, ms := range upgradeMsMap {
+			if ms.Cluster != cluster || ms.UpgradeResult != "" {
+				continue
+			}
+			add(SnapshotPodUpgrade, ms.key+"/"+ms.subKey, ms.PodUID, &podUpgradeSnapshot{
+				Milestone:         ms,
+				SubKey:            ms.subKey,
+				UpgradeContainers: ms.upgradeContainers,
+			})
+		}
+	})
+
+	keyToMileStone.IterateWithFunc(func(i interface{}) {
+		ms, ok := i.(*PersistentVolumeClaimMileStone)
+		if !ok || ms.Cluster != cluster || ms.CreateResult != "" {
+			return
+		}
+		add(SnapshotPVCCreate, ms.key, ms.PVCUID, ms)
+	})
+
+	for _, snapshot := range rollouts.snapshot(cluster) {
+		// an ended rollout and the next one of the workload may be both waited for
+		add(SnapshotWorkloadRollout, snapshot.Key+"/"+snapshot.Milestone.Revision, snapshot.Milestone.UID, snapshot)
+	}
+	for _, snapshot := range nodes.snapshot(cluster) {
+		add(SnapshotNodeLifecycle, nodeKey(snapshot.Cluster, snapshot.Name), snapshot.UID, snapshot)
+	}
+	for _, snapshot := range budgets.snapshot(cluster) {
+		add(SnapshotErrorBudget, fmt.Sprintf("%s/%s/%s/%s/%s", snapshot.Objective, snapshot.Cluster, snapshot.Namespace,
+			snapshot.OwnerRef, snapshot.CountsCluster), "", snapshot)
+	}
+	return snapshots
+}
+
+// RestoreMilestones rehydrates the snapshots, milestones being tracked already are kept.
+// It returns the number of milestones restored.
+func RestoreMilestones(snapshots []*xsearch.MilestoneSnapshot) int {
+	restored := 0
+	for _, snapshot := range snapshots {
+		var err error
+		switch snapshot.Kind {
+		case SnapshotPodCreate:
+			err = restorePodCreateMilestone(snapshot)
+		case SnapshotPodUpgrade:
+			err = restorePodUpgradeMilestone(snapshot)
+		case SnapshotPVCCreate:
+			err = restorePVCCreateMilestone(snapshot)
+		case SnapshotWorkloadRollout:
+			err = restoreWorkloadRollout(snapshot)
+		case SnapshotNodeLifecycle:
+			err = restoreNodeLifecycle(snapshot)
+		case SnapshotErrorBudget:
+			err = restoreErrorBudget(snapshot)
+		default:
+			klog.Warningf("unknown milestone snapshot %s of %s", snapshot.Kind, snapshot.Key)
+			continue
+		}
+		if err != nil {
+			klog.Errorf("failed to restore %s milestone %s: %s", snapshot.Kind, snapshot.Key, err.Error())
+			continue
+		}
+		restored++
+	}
+	return restored
+}
+
+func restorePodCreateMilestone(snapshot *xsearch.MilestoneSnapshot) error {
+	podSnapshot := &podCreateSnapshot{}
+	if err := json.Unmarshal(snapshot.Data, podSnapshot); err != nil {
+		return err
+	}
+	ms := podSnapshot.Milestone
+	if ms == nil {
+		return nil
+	}
+	if _, ok := podMilestoneMap.Get(snapshot.Key); ok {
+		return nil
+	}
+
+	ms.mutex = &sync.RWMutex{}
+	ms.key = snapshot.Key
+	ms.notifyQueue = notifyQueue
+	ms.closeCh = make(chan struct{})
+	ms.inputQueue = make(chan *PodEvent, 10000)
+	ms.auditTimeQueue = make(chan *time.Time, 200)
+	ms.latestPod = podSnapshot.LatestPod
+	ms.shouldFinishTime = podSnapshot.ShouldFinishTime
+	ms.trickTime = podSnapshot.TrickTime
+
+	if len(podSnapshot.Events) > 0 {
+		events := list.New()
+		for _, event := range podSnapshot.Events {
+			events.PushBack(event)
+		}
+		podEventsMap.Set(snapshot.Key, events)
+	}
+	podMilestoneMap.Set(snapshot.Key, ms)
+	go ms.start()
+	return nil
+}
+
+func restorePodUpgradeMilestone(snapshot *xsearch.MilestoneSnapshot) error {
+	upgradeSnapshot := &podUpgradeSnapshot{}
+	if err := json.Unmarshal(snapshot.Data, upgradeSnapshot); err != nil {
+		return err
+	}
+	ms := upgradeSnapshot.Milestone
+	if ms == nil {
+		return nil
+	}
+	ms.key = genPodKey(ms.Cluster, ms.Namespace, ms.PodName)
+	ms.subKey = upgradeSnapshot.SubKey
+	ms.upgradeContainers = upgradeSnapshot.UpgradeContainers
+
+	podMsMap, ok := podUpgradeMileStoneMap.Get(ms.key)
+	if !ok || podMsMap == nil {
+		podMsMap = map[string]*PodUpgradeMileStone{}
+	}
+	if _, has := podMsMap.(map[string]*PodUpgradeMileStone)[ms.subKey]; has {
+		return nil
+	}
+	podMsMap.(map[string]*PodUpgradeMileStone)[ms.subKey] = ms
+	podUpgradeMileStoneMap.Set(ms.key, podMsMap)
+	return nil
+}
+
+func restorePVCCreateMilestone(snapshot *xsearch.MilestoneSnapshot) error {
+	ms := &PersistentVolumeClaimMileStone{}
+	if err := json.Unmarshal(snapshot.Data, ms); err != nil {
+		return err
+	}
+	if _, ok := keyToMileStone.Get(snapshot.Key); ok {
+		return nil
+	}
+	ms.key = snapshot.Key
+	keyToMileStone.Set(snapshot.Key, ms)
+	return nil
+}
+
+func restoreWorkloadRollout(snapshot *xsearch.MilestoneSnapshot) error {
+	rolloutSnapshot := &rolloutSnapshot{}
+	if err := json.Unmarshal(snapshot.Data, rolloutSnapshot); err != nil {
+		return err
+	}
+	rollouts.restore(rolloutSnapshot)
+	return nil
+}
+
+func restoreNodeLifecycle(snapshot *xsearch.MilestoneSnapshot) error {
+	nodeSnapshot := &nodeSnapshot{}
+	if err := json.Unmarshal(snapshot.Data, nodeSnapshot); err != nil {
+		return err
+	}
+	nodes.restore(nodeSnapshot)
+	return nil
+}
+
+func restoreErrorBudget(snapshot *xsearch.MilestoneSnapshot) error {
+	budgetSnapshot := &budgetSnapshot{}
+	if err := json.Unmarshal(snapshot.Data, budgetSnapshot); err != nil {
+		return err
+	}
+	budgets.restore(budgetSnapshot)
+	return nil
+}
@@ -0,0 +1,102 @@
+package slo
+
+import (
+	"container/list"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMilestoneSnapshot(t *testing.T) {
+	cluster := "snapshot-cluster"
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	createKey := genPodKey(cluster, "ns", "creating")
+	createMs := &PodStartupMilestones{
+		mutex:       &sync.RWMutex{},
+		Cluster:     cluster,
+		Namespace:   "ns",
+		PodName:     "creating",
+		PodUID:      "uid-1",
+		Created:     created,
+		CreatedTime: created,
+		PodSLO:      int64(SERVICE_POD_TIMEOUT_TIME),
+		key:         createKey,
+		latestPod:   &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "creating", Namespace: "ns", UID: "uid-1"}},
+	}
+	podMilestoneMap.Set(createKey, createMs)
+	events := list.New()
+	events.PushBack(&v1.Event{Reason: "Pulling", Message: "pulling image"})
+	podEventsMap.Set(createKey, events)
+	// milestones of other clusters are not included
+	otherKey := genPodKey("other-cluster", "ns", "creating")
+	podMilestoneMap.Set(otherKey, &PodStartupMilestones{mutex: &sync.RWMutex{}, Cluster: "other-cluster", key: otherKey})
+
+	upgradeKey := genPodKey(cluster, "ns", "upgrading")
+	podUpgradeMileStoneMap.Set(upgradeKey, map[string]*PodUpgradeMileStone{
+		"1": {
+			Cluster:            cluster,
+			Namespace:          "ns",
+			PodName:            "upgrading",
+			PodUID:             "uid-2",
+			CreatedTime:        created,
+			UpgradeTimeoutTime: created.Add(timeoutDuration),
+			key:                upgradeKey,
+			subKey:             "1",
+			upgradeContainers:  []string{"main"},
+		},
+	})
+
+	pvcKey := genKey(cluster, "ns", "pvc")
+	keyToMileStone.Set(pvcKey, &PersistentVolumeClaimMileStone{
+		Cluster:     cluster,
+		Namespace:   "ns",
+		PVCName:     "pvc",
+		PVCUID:      "uid-3",
+		CreatedTime: created,
+		TimeoutTime: created.Add(10 * time.Minute),
+		key:         pvcKey,
+	})
+
+	snapshots := SnapshotMilestones(cluster)
+	assert.Equal(t, 3, len(snapshots))
+
+	// restarted
+	for _, key := range []string{createKey, otherKey} {
+		podMilestoneMap.Delete(key)
+		podEventsMap.Delete(key)
+	}
+	podUpgradeMileStoneMap.Delete(upgradeKey)
+	keyToMileStone.Delete(pvcKey)
+
+	assert.Equal(t, 3, RestoreMilestones(snapshots))
+
+	v, ok := podMilestoneMap.Get(createKey)
+	assert.True(t, ok)
+	restored := v.(*PodStartupMilestones)
+	assert.Equal(t, "uid-1", restored.PodUID)
+	assert.True(t, restored.CreatedTime.Equal(created))
+	assert.Equal(t, createKey, restored.key)
+	assert.Equal(t, "uid-1", string(restored.latestPod.UID))
+	v, ok = podEventsMap.Get(createKey)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v.(*list.List).Len())
+	close(restored.closeCh)
+
+	v, ok = podUpgradeMileStoneMap.Get(upgradeKey)
+	assert.True(t, ok)
+	upgradeMs := v.(map[string]*PodUpgradeMileStone)["1"]
+	assert.Equal(t, []string{"main"}, upgradeMs.upgradeContainers)
+	assert.Equal(t, upgradeKey, upgradeMs.key)
+
+	v, ok = keyToMileStone.Get(pvcKey)
+	assert.True(t, ok)
+	assert.Equal(t, pvcKey, v.(*PersistentVolumeClaimMileStone).key)
+
+	podUpgradeMileStoneMap.Delete(upgradeKey)
+	keyToMileStone.Delete(pvcKey)
+}
@@ -0,0 +1,629 @@
+package slo
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/shares"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// NodeLifecycleMileStone is a phase in the lifecycle of a node, saved as a node life phase
+type NodeLifecycleMileStone struct {
+	Cluster  string
+	NodeName string
+	NodeUID  string
+	// NodeJoin, NodeDrain or NodeNotReady
+	Phase           string
+	Result          string
+	TriggerAuditLog string
+	StartTime       time.Time
+	EndTime         time.Time
+	Duration        time.Duration
+	// reason and message of the Ready condition, of NodeNotReady
+	Reason  string
+	Message string
+	// pods deleted by the drain, and the ones left when it ends
+	PodsDeleted   int
+	RemainingPods []string
+}
+
+const (
+	// from the node registered to Ready
+	NODE_JOIN = "NodeJoin"
+	// from the node cordoned to all of its pods except DaemonSets deleted
+	NODE_DRAIN = "NodeDrain"
+	// from Ready to NotReady and back
+	NODE_NOT_READY = "NodeNotReady"
+
+	NODE_PHASE_SUCCESS = "success"
+	NODE_PHASE_TIMEOUT = "timeout"
+	// the node is deleted before the phase finishes
+	NODE_PHASE_DELETED = "deleted"
+	// the node is uncordoned before drained
+	NODE_PHASE_UNCORDONED = "uncordoned"
+	// the pods seen on the node are all deleted, but not all of its pods were seen, e.g. it joined before restart
+	NODE_PHASE_UNKNOWN = "unknown"
+
+	nodeJoinTimeout  = 30 * time.Minute
+	nodeDrainTimeout = time.Hour
+	// the pods left by a drain saved at most
+	maxRemainingPods = 20
+	// the pods followed on all nodes at most, the nodes of the pods beyond are not drained completely
+	maxNodePods = 500000
+
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+var (
+	nodeLifecycleResult = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_node_lifecycle_result_count",
+			Help: "node join, drain and NotReady phases by result",
+		},
+		[]string{"cluster", "phase", "result"},
+	)
+	nodeLifecycleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "slo_node_lifecycle_duration_seconds",
+			Help:    "duration of node join, drain and NotReady phases",
+			Buckets: []float64{10, 30, 60, 120, 300, 600, 1800, 3600},
+		},
+		[]string{"cluster", "phase", "result"},
+	)
+
+	nodes = newNodeTracker(saveNodeMileStone)
+	// the dead letters of the nodes re-processed
+	nodeQueue = newTrackerQueue("slo-watcher-node", processNodeEvent)
+
+	// looks up the pods on a node of cluster by its host ip, the pods of a node drained not all seen
+	lookupNodePods = xsearch.GetNodePods
+)
+
+func init() {
+	prometheus.MustRegister(nodeLifecycleResult)
+	prometheus.MustRegister(nodeLifecycleDuration)
+
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			sloOngoingSize.WithLabelValues("nodeLifecycle").Set(float64(nodes.size()))
+			nodes.checkTimeout()
+		}
+	}()
+}
+
+// processNodeEvent is called by the SLO watcher before the event is handed to the delete SLO,
+// so the pods on a node are known to it before their SLOs finish
+func processNodeEvent(event *shares.AuditEvent) {
+	defer event.IgnorePanic("slo_node", "processNodeEvent")
+
+	if event.ObjectRef == nil {
+		return
+	}
+	if node := nodes.processEvent(event); node != nil {
+		nodes.seedPods(event.Annotations["cluster"], node, event.StageTimestamp.Time)
+	}
+}
+
+func saveNodeMileStone(ms *NodeLifecycleMileStone) {
+	nodeLifecycleResult.WithLabelValues(ms.Cluster, ms.Phase, ms.Result).Inc()
+	nodeLifecycleDuration.WithLabelValues(ms.Cluster, ms.Phase, ms.Result).Observe(ms.Duration.Seconds())
+
+	extraInfo := map[string]interface{}{
+		"result":   ms.Result,
+		"duration": ms.Duration.String(),
+	}
+	if ms.Reason != "" {
+		extraInfo["reason"] = ms.Reason
+		extraInfo["message"] = ms.Message
+	}
+	if ms.Phase == NODE_DRAIN {
+		extraInfo["podsDeleted"] = ms.PodsDeleted
+		extraInfo["remainingPods"] = ms.RemainingPods
+	}
+	hasErr := ms.Result != NODE_PHASE_SUCCESS && ms.Result != NODE_PHASE_UNKNOWN
+	err := xsearch.SaveNodeLifePhase(ms.Cluster, ms.NodeName, ms.NodeUID, ms.Phase, hasErr,
+		ms.StartTime, ms.EndTime, extraInfo, ms.TriggerAuditLog)
+	if err != nil {
+		klog.Errorf("failed to save %s of node %s: %s", ms.Phase, ms.NodeName, err.Error())
+	}
+	klog.V(6).Infof("%s of node %s/%s finished: %s", ms.Phase, ms.Cluster, ms.NodeName, ms.Result)
+}
+
+// nodeState is what is known about a node, and its phases in progress
+type nodeState struct {
+	cluster string
+	name    string
+	uid     string
+	// ready and unschedulable are unknown until the node is seen
+	seen          bool
+	ready         bool
+	unschedulable bool
+
+	join     *NodeLifecycleMileStone
+	drain    *NodeLifecycleMileStone
+	notReady *NodeLifecycleMileStone
+	// pods on the node to be deleted by the drain, uid -> name
+	pods map[string]string
+	// all pods on the node are seen, as it is followed since it joined
+	podsComplete bool
+}
+
+// nodeTracker follows the nodes by their audit events, and the pods on them by the delete SLOs
+type nodeTracker struct {
+	mutex sync.Mutex
+	// cluster/node -> state
+	nodes map[string]*nodeState
+	// pod uid -> node of the pod, the pods of DaemonSets and mirror pods are not drained
+	podNodes map[string]*nodeState
+	// the pods on the nodes being deleted, whose delete SLOs are waited for
+	deleting map[string]bool
+	// cluster -> time of the latest audit event
+	auditTime map[string]time.Time
+	// phases ended, to be saved out of the lock
+	ended []*NodeLifecycleMileStone
+	save  func(*NodeLifecycleMileStone)
+}
+
+func newNodeTracker(save func(*NodeLifecycleMileStone)) *nodeTracker {
+	return &nodeTracker{
+		nodes:     make(map[string]*nodeState),
+		podNodes:  make(map[string]*nodeState),
+		deleting:  make(map[string]bool),
+		auditTime: make(map[string]time.Time),
+		save:      save,
+	}
+}
+
+func nodeKey(cluster, name string) string {
+	return cluster + "/" + name
+}
+
+// size is the number of phases in progress
+func (t *nodeTracker) size() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	size := 0
+	for _, state := range t.nodes {
+		for _, ms := range []*NodeLifecycleMileStone{state.join, state.drain, state.notReady} {
+			if ms != nil {
+				size++
+			}
+		}
+	}
+	return size
+}
+
+// processEvent returns the node cordoned if not all pods on it are seen, whose pods are to be seeded
+func (t *nodeTracker) processEvent(event *shares.AuditEvent) *v1.Node {
+	if event.ResponseStatus == nil || event.ResponseStatus.Code >= 300 {
+		return nil
+	}
+	cluster := event.Annotations["cluster"]
+	now := event.StageTimestamp.Time
+
+	t.mutex.Lock()
+	// saved after unlocked
+	defer t.saveEnded()
+	defer t.mutex.Unlock()
+	if now.After(t.auditTime[cluster]) {
+		t.auditTime[cluster] = now
+	}
+
+	switch event.ObjectRef.Resource {
+	case "nodes":
+		return t.processNode(event, cluster, now)
+	case "pods":
+		if event.Verb == "delete" {
+			// the delete SLO tells when it is gone
+			uid := string(event.ObjectRef.UID)
+			if pod := event.TryGetPodFromEvent(); pod != nil {
+				uid = string(pod.UID)
+			}
+			if _, ok := t.podNodes[uid]; ok {
+				t.deleting[uid] = true
+			}
+			return nil
+		}
+		pod, ok := event.ResponseRuntimeObj.(*v1.Pod)
+		if !ok || pod == nil || pod.Spec.NodeName == "" {
+			return nil
+		}
+		t.addPod(t.getNode(cluster, pod.Spec.NodeName), pod)
+	}
+	return nil
+}
+
+// addPod follows the pod on the node to be deleted by a drain
+func (t *nodeTracker) addPod(state *nodeState, pod *v1.Pod) {
+	if _, ok := t.podNodes[string(pod.UID)]; ok {
+		return
+	}
+	if _, mirror := pod.Annotations[mirrorPodAnnotation]; mirror {
+		return
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return
+	}
+	if len(t.podNodes) >= maxNodePods {
+		state.podsComplete = false
+		return
+	}
+	state.pods[string(pod.UID)] = pod.Name
+	t.podNodes[string(pod.UID)] = state
+}
+
+func (t *nodeTracker) getNode(cluster, name string) *nodeState {
+	key := nodeKey(cluster, name)
+	state, ok := t.nodes[key]
+	if !ok {
+		state = &nodeState{cluster: cluster, name: name, pods: make(map[string]string)}
+		t.nodes[key] = state
+	}
+	return state
+}
+
+func (t *nodeTracker) processNode(event *shares.AuditEvent, cluster string, now time.Time) *v1.Node {
+	ref := event.ObjectRef
+	state := t.getNode(cluster, ref.Name)
+	auditID := string(event.AuditID)
+
+	if event.Verb == "delete" && ref.Subresource == "" {
+		for _, ms := range []*NodeLifecycleMileStone{state.join, state.drain, state.notReady} {
+			if ms != nil {
+				t.endPhase(ms, NODE_PHASE_DELETED, now)
+			}
+		}
+		for uid := range state.pods {
+			delete(t.podNodes, uid)
+			delete(t.deleting, uid)
+		}
+		delete(t.nodes, nodeKey(cluster, ref.Name))
+		return nil
+	}
+	node, ok := event.ResponseRuntimeObj.(*v1.Node)
+	if !ok || node == nil || node.UID == "" {
+		return nil
+	}
+	if state.uid != "" && state.uid != string(node.UID) {
+		// recreated with the same name, the pods are kept as they are bound by name
+		pods := state.pods
+		delete(t.nodes, nodeKey(cluster, ref.Name))
+		state = t.getNode(cluster, ref.Name)
+		state.pods = pods
+		for uid := range pods {
+			t.podNodes[uid] = state
+		}
+	}
+	state.uid = string(node.UID)
+
+	if event.Verb == "create" && ref.Subresource == "" {
+		state.join = t.newPhase(state, NODE_JOIN, node.CreationTimestamp.Time, now, auditID)
+		// no pod is bound to the node before
+		state.podsComplete = true
+	}
+
+	ready, condition := nodeReady(node)
+	transition := now
+	if condition != nil && !condition.LastTransitionTime.IsZero() && !condition.LastTransitionTime.After(now) {
+		transition = condition.LastTransitionTime.Time
+	}
+	if ready {
+		if state.join != nil {
+			t.endPhaseAt(state.join, NODE_PHASE_SUCCESS, transition)
+			state.join = nil
+		}
+		if state.notReady != nil {
+			t.endPhaseAt(state.notReady, NODE_PHASE_SUCCESS, transition)
+			state.notReady = nil
+		}
+	} else if condition != nil && state.join == nil && state.notReady == nil && (state.ready || !state.seen) {
+		// a node seen NotReady first, e.g. after restart, is NotReady since the transition
+		state.notReady = t.newPhase(state, NODE_NOT_READY, transition, now, auditID)
+		state.notReady.Reason = condition.Reason
+		state.notReady.Message = condition.Message
+	}
+
+	cordoned := node.Spec.Unschedulable && state.seen && !state.unschedulable
+	if cordoned {
+		state.drain = t.newPhase(state, NODE_DRAIN, now, now, auditID)
+	} else if !node.Spec.Unschedulable && state.drain != nil {
+		t.endPhase(state.drain, NODE_PHASE_UNCORDONED, now)
+		state.drain = nil
+	}
+
+	state.seen = true
+	state.ready = ready
+	state.unschedulable = node.Spec.Unschedulable
+	if cordoned && !state.podsComplete {
+		// checked after the pods are seeded
+		return node
+	}
+	t.checkDrained(state, now)
+	return nil
+}
+
+// seedPods adds the pods on the node cordoned not seen yet, e.g. the node joined before restart, so the drain waits
+// for them. The pods are looked up outside the lock, the drain is checked as usual if they are not found.
+func (t *nodeTracker) seedPods(cluster string, node *v1.Node, now time.Time) {
+	var pods []*v1.Pod
+	var err error
+	if hostIP := nodeInternalIP(node); hostIP != "" {
+		pods, err = lookupNodePods(cluster, hostIP)
+		if err != nil {
+			klog.Warningf("failed to look up the pods on node %s/%s: %s", cluster, node.Name, err.Error())
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.saveEnded()
+	defer t.mutex.Unlock()
+	state, ok := t.nodes[nodeKey(cluster, node.Name)]
+	if !ok || state.uid != string(node.UID) {
+		return
+	}
+	if err == nil && pods != nil && state.drain != nil && !state.podsComplete {
+		state.podsComplete = true
+		for _, pod := range pods {
+			// the ip may have been of another node
+			if pod.Spec.NodeName != node.Name {
+				continue
+			}
+			t.addPod(state, pod)
+			if _, ok := state.pods[string(pod.UID)]; ok && pod.DeletionTimestamp != nil {
+				// its delete event is not seen again
+				t.deleting[string(pod.UID)] = true
+			}
+		}
+	}
+	t.checkDrained(state, now)
+}
+
+func nodeInternalIP(node *v1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+func nodeReady(node *v1.Node) (bool, *v1.NodeCondition) {
+	for i := range node.Status.Conditions {
+		c := &node.Status.Conditions[i]
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue, c
+		}
+	}
+	return false, nil
+}
+
+func (t *nodeTracker) newPhase(state *nodeState, phase string, start, now time.Time, auditID string) *NodeLifecycleMileStone {
+	if start.IsZero() || start.After(now) {
+		start = now
+	}
+	return &NodeLifecycleMileStone{
+		Cluster:         state.cluster,
+		NodeName:        state.name,
+		NodeUID:         state.uid,
+		Phase:           phase,
+		TriggerAuditLog: auditID,
+		StartTime:       start,
+	}
+}
+
+func (t *nodeTracker) endPhase(ms *NodeLifecycleMileStone, result string, now time.Time) {
+	if ms.Phase == NODE_DRAIN {
+		if state, ok := t.nodes[nodeKey(ms.Cluster, ms.NodeName)]; ok {
+			ms.RemainingPods = remainingPods(state.pods)
+		}
+	}
+	t.endPhaseAt(ms, result, now)
+}
+
+func (t *nodeTracker) endPhaseAt(ms *NodeLifecycleMileStone, result string, end time.Time) {
+	if end.Before(ms.StartTime) {
+		end = ms.StartTime
+	}
+	ms.Result = result
+	ms.EndTime = end
+	ms.Duration = end.Sub(ms.StartTime)
+	t.ended = append(t.ended, ms)
+}
+
+// saveEnded saves the phases ended out of the lock, as saving them may block
+func (t *nodeTracker) saveEnded() {
+	t.mutex.Lock()
+	ended := t.ended
+	t.ended = nil
+	t.mutex.Unlock()
+	for _, ms := range ended {
+		t.save(ms)
+	}
+}
+
+func remainingPods(pods map[string]string) []string {
+	names := make([]string, 0, len(pods))
+	for _, name := range pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxRemainingPods {
+		names = append(names[:maxRemainingPods], fmt.Sprintf("and %d more", len(names)-maxRemainingPods))
+	}
+	return names
+}
+
+// checkDrained ends the drain when the pods on the node are all deleted, as unknown if not all of them were seen
+func (t *nodeTracker) checkDrained(state *nodeState, now time.Time) {
+	if state.drain == nil || len(state.pods) > 0 {
+		return
+	}
+	result := NODE_PHASE_SUCCESS
+	if !state.podsComplete {
+		result = NODE_PHASE_UNKNOWN
+	}
+	t.endPhase(state.drain, result, now)
+	state.drain = nil
+}
+
+// observePodDeleted is called when the delete SLO of a pod finishes
+func (t *nodeTracker) observePodDeleted(uid, result string, deleted time.Time) {
+	t.mutex.Lock()
+	defer t.saveEnded()
+	defer t.mutex.Unlock()
+	state, ok := t.podNodes[uid]
+	if !ok {
+		return
+	}
+	delete(t.deleting, uid)
+	if result != SUCCESS && state.drain != nil {
+		// still on the node being drained
+		return
+	}
+	// a pod failed to be deleted is seen again by its next update
+	delete(t.podNodes, uid)
+	delete(state.pods, uid)
+	if result != SUCCESS {
+		return
+	}
+	if state.drain != nil {
+		state.drain.PodsDeleted++
+	}
+	t.checkDrained(state, deleted)
+}
+
+// podsWaited adds the pods being deleted not followed here, as tells local, to pods by cluster
+func (t *nodeTracker) podsWaited(local func(uid string) bool, pods remotePods) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for uid := range t.deleting {
+		if !local(uid) {
+			pods.add(t.podNodes[uid].cluster, uid)
+		}
+	}
+}
+
+// nodeSnapshot keeps the state of a node needed to go on after restart
+type nodeSnapshot struct {
+	Cluster       string
+	Name          string
+	UID           string
+	Seen          bool
+	Ready         bool
+	Unschedulable bool
+	Join          *NodeLifecycleMileStone
+	Drain         *NodeLifecycleMileStone
+	NotReady      *NodeLifecycleMileStone
+	Pods          map[string]string
+	PodsComplete  bool
+	// the pods waited for to be deleted
+	DeletingPods []string
+}
+
+// snapshot returns the nodes of cluster with the pods on them and their phases in progress
+func (t *nodeTracker) snapshot(cluster string) []*nodeSnapshot {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	snapshots := make([]*nodeSnapshot, 0)
+	copyPhase := func(ms *NodeLifecycleMileStone) *NodeLifecycleMileStone {
+		if ms == nil {
+			return nil
+		}
+		copied := *ms
+		return &copied
+	}
+	for _, state := range t.nodes {
+		if state.cluster != cluster {
+			continue
+		}
+		pods := make(map[string]string, len(state.pods))
+		var deletingPods []string
+		for uid, name := range state.pods {
+			pods[uid] = name
+			if t.deleting[uid] {
+				deletingPods = append(deletingPods, uid)
+			}
+		}
+		sort.Strings(deletingPods)
+		snapshots = append(snapshots, &nodeSnapshot{
+			Cluster:       state.cluster,
+			Name:          state.name,
+			UID:           state.uid,
+			Seen:          state.seen,
+			Ready:         state.ready,
+			Unschedulable: state.unschedulable,
+			Join:          copyPhase(state.join),
+			Drain:         copyPhase(state.drain),
+			NotReady:      copyPhase(state.notReady),
+			Pods:          pods,
+			PodsComplete:  state.podsComplete,
+			DeletingPods:  deletingPods,
+		})
+	}
+	return snapshots
+}
+
+// restore rehydrates a node, a node being tracked already is kept
+func (t *nodeTracker) restore(snapshot *nodeSnapshot) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	key := nodeKey(snapshot.Cluster, snapshot.Name)
+	if _, ok := t.nodes[key]; ok {
+		return false
+	}
+	state := &nodeState{
+		cluster:       snapshot.Cluster,
+		name:          snapshot.Name,
+		uid:           snapshot.UID,
+		seen:          snapshot.Seen,
+		ready:         snapshot.Ready,
+		unschedulable: snapshot.Unschedulable,
+		join:          snapshot.Join,
+		drain:         snapshot.Drain,
+		notReady:      snapshot.NotReady,
+		pods:          make(map[string]string, len(snapshot.Pods)),
+		podsComplete:  snapshot.PodsComplete,
+	}
+	for uid, name := range snapshot.Pods {
+		if _, ok := t.podNodes[uid]; ok {
+			continue
+		}
+		state.pods[uid] = name
+		t.podNodes[uid] = state
+	}
+	for _, uid := range snapshot.DeletingPods {
+		if t.podNodes[uid] == state {
+			t.deleting[uid] = true
+		}
+	}
+	t.nodes[key] = state
+	return true
+}
+
+// checkTimeout ends the joins and drains not finished in time by the audit time, NotReady lasts until the node recovers
+func (t *nodeTracker) checkTimeout() {
+	t.mutex.Lock()
+	defer t.saveEnded()
+	defer t.mutex.Unlock()
+	for _, state := range t.nodes {
+		now := t.auditTime[state.cluster]
+		if state.join != nil && now.After(state.join.StartTime.Add(nodeJoinTimeout)) {
+			t.endPhase(state.join, NODE_PHASE_TIMEOUT, now)
+			state.join = nil
+		}
+		if state.drain != nil && now.After(state.drain.StartTime.Add(nodeDrainTimeout)) {
+			t.endPhase(state.drain, NODE_PHASE_TIMEOUT, now)
+			state.drain = nil
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestNode(name string, unschedulable bool, ready v1.ConditionStatus, transition time.Duration) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name + "-uid"), CreationTimestamp: metav1.NewTime(testStart)},
+		Spec:       v1.NodeSpec{Unschedulable: unschedulable},
+	}
+	if ready != "" {
+		node.Status.Conditions = []v1.NodeCondition{{
+			Type: v1.NodeReady, Status: ready, Reason: "KubeletNotReady", LastTransitionTime: metav1.NewTime(testStart.Add(transition)),
+		}}
+	}
+	return node
+}
+
+func newNodePod(name, node, ownerKind string) *v1.Pod {
+	pod := generatePod(name, ownerKind, "owner")
+	pod.Spec.NodeName = node
+	return pod
+}
+
+func TestNodeJoinAndDrain(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	var tracker *nodeTracker
+	tracker = newNodeTracker(func(ms *NodeLifecycleMileStone) {
+		// saved out of the lock
+		tracker.size()
+		saved = append(saved, ms)
+	})
+
+	tracker.processEvent(generateAuditEvent(t, "create", "nodes", "", newTestNode("n1", false, "", 0), time.Second))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", false, v1.ConditionFalse, 0), 10*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", false, v1.ConditionTrue, 40*time.Second), 45*time.Second))
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, NODE_JOIN, saved[0].Phase)
+	assert.Equal(t, NODE_PHASE_SUCCESS, saved[0].Result)
+	assert.Equal(t, 40*time.Second, saved[0].Duration)
+
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("web", "n1", "ReplicaSet"), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("db", "n1", "StatefulSet"), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("agent", "n1", "DaemonSet"), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 40*time.Second), 2*time.Minute))
+	assert.Equal(t, 1, tracker.size())
+
+	tracker.observePodDeleted("web-uid", SUCCESS, testStart.Add(3*time.Minute))
+	tracker.observePodDeleted("db-uid", TIMEOUT, testStart.Add(4*time.Minute))
+	assert.Equal(t, 1, len(saved))
+	tracker.observePodDeleted("db-uid", SUCCESS, testStart.Add(5*time.Minute))
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, NODE_DRAIN, saved[1].Phase)
+	assert.Equal(t, NODE_PHASE_SUCCESS, saved[1].Result)
+	assert.Equal(t, 3*time.Minute, saved[1].Duration)
+	assert.Equal(t, 2, saved[1].PodsDeleted)
+	assert.Empty(t, saved[1].RemainingPods)
+	assert.Equal(t, 0, tracker.size())
+}
+
+func TestNodeNotReadyAndDrainTimeout(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	tracker := newNodeTracker(func(ms *NodeLifecycleMileStone) {
+		saved = append(saved, ms)
+	})
+
+	// seen NotReady first after restart
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", false, v1.ConditionUnknown, -time.Minute), 0))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", false, v1.ConditionTrue, 30*time.Second), time.Minute))
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, NODE_NOT_READY, saved[0].Phase)
+	assert.Equal(t, 90*time.Second, saved[0].Duration)
+	assert.Equal(t, "KubeletNotReady", saved[0].Reason)
+
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "", newNodePod("web", "n1", "ReplicaSet"), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "update", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 30*time.Second), 2*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", true, v1.ConditionFalse, 3*time.Minute), 3*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", true, v1.ConditionFalse, 3*time.Minute), 63*time.Minute))
+	tracker.checkTimeout()
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, NODE_DRAIN, saved[1].Phase)
+	assert.Equal(t, NODE_PHASE_TIMEOUT, saved[1].Result)
+	assert.Equal(t, []string{"web"}, saved[1].RemainingPods)
+
+	// the NotReady episode ends with the node
+	tracker.processEvent(generateAuditEvent(t, "delete", "nodes", "", newTestNode("n1", true, v1.ConditionFalse, 3*time.Minute), 70*time.Minute))
+	assert.Equal(t, 3, len(saved))
+	assert.Equal(t, NODE_NOT_READY, saved[2].Phase)
+	assert.Equal(t, NODE_PHASE_DELETED, saved[2].Result)
+	assert.Equal(t, 67*time.Minute, saved[2].Duration)
+	assert.Equal(t, 0, tracker.size())
+	assert.Equal(t, 0, len(tracker.podNodes))
+}
+
+func TestNodeUncordoned(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	tracker := newNodeTracker(func(ms *NodeLifecycleMileStone) {
+		saved = append(saved, ms)
+	})
+
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "", newNodePod("web", "n1", "ReplicaSet"), 0))
+	// cordoned before seen is not a drain
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 0), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", false, v1.ConditionTrue, 0), 2*time.Minute))
+	assert.Equal(t, 0, len(saved))
+
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 0), 3*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", false, v1.ConditionTrue, 0), 5*time.Minute))
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, NODE_PHASE_UNCORDONED, saved[0].Result)
+	assert.Equal(t, 2*time.Minute, saved[0].Duration)
+	assert.Equal(t, []string{"web"}, saved[0].RemainingPods)
+}
+
+func TestNodeDrainUnknown(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	tracker := newNodeTracker(func(ms *NodeLifecycleMileStone) {
+		saved = append(saved, ms)
+	})
+
+	// joined before restart, the pods not updated since are not seen
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", false, v1.ConditionTrue, 0), 0))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("web", "n1", "ReplicaSet"), time.Second))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("db", "n1", "StatefulSet"), time.Second))
+	// failed to be deleted out of a drain, seen again by its next update
+	tracker.observePodDeleted("db-uid", TIMEOUT, testStart.Add(2*time.Second))
+	assert.Equal(t, 1, len(tracker.podNodes))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("db", "n1", "StatefulSet"), 3*time.Second))
+	assert.Equal(t, 2, len(tracker.podNodes))
+
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 0), time.Minute))
+	tracker.observePodDeleted("web-uid", SUCCESS, testStart.Add(2*time.Minute))
+	tracker.observePodDeleted("db-uid", SUCCESS, testStart.Add(3*time.Minute))
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, NODE_DRAIN, saved[0].Phase)
+	assert.Equal(t, NODE_PHASE_UNKNOWN, saved[0].Result)
+	assert.Equal(t, 2, saved[0].PodsDeleted)
+	assert.Equal(t, 0, len(tracker.podNodes))
+}
+
+func TestNodeDrainSeeded(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	tracker := newNodeTracker(func(ms *NodeLifecycleMileStone) {
+		saved = append(saved, ms)
+	})
+	lookup := lookupNodePods
+	defer func() {
+		lookupNodePods = lookup
+	}()
+	lookedUp := ""
+	lookupNodePods = func(cluster, hostIP string) ([]*v1.Pod, error) {
+		lookedUp = cluster + "/" + hostIP
+		deleting := newNodePod("db", "n1", "StatefulSet")
+		deleting.DeletionTimestamp = &metav1.Time{Time: testStart}
+		return []*v1.Pod{newNodePod("web", "n1", "ReplicaSet"), deleting,
+			newNodePod("agent", "n1", "DaemonSet"), newNodePod("other", "n2", "ReplicaSet")}, nil
+	}
+
+	// joined before restart, no pod is seen before cordoned
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n1", false, v1.ConditionTrue, 0), 0))
+	cordoned := newTestNode("n1", true, v1.ConditionTrue, 0)
+	cordoned.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}
+	node := tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", cordoned, time.Minute))
+	assert.NotNil(t, node)
+	assert.Equal(t, 1, tracker.size())
+	tracker.seedPods("c1", node, testStart.Add(time.Minute))
+	assert.Equal(t, "c1/10.0.0.1", lookedUp)
+	assert.Equal(t, 2, len(tracker.podNodes))
+	assert.True(t, tracker.deleting["db-uid"])
+
+	tracker.observePodDeleted("web-uid", SUCCESS, testStart.Add(2*time.Minute))
+	tracker.observePodDeleted("db-uid", SUCCESS, testStart.Add(3*time.Minute))
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, NODE_PHASE_SUCCESS, saved[0].Result)
+	assert.Equal(t, 2, saved[0].PodsDeleted)
+
+	// not looked up, the drain goes on with the pods seen
+	lookupNodePods = func(cluster, hostIP string) ([]*v1.Pod, error) {
+		return nil, fmt.Errorf("no pod yaml")
+	}
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "status", newTestNode("n3", false, v1.ConditionTrue, 0), 0))
+	cordoned3 := newTestNode("n3", true, v1.ConditionTrue, 0)
+	cordoned3.Status.Addresses = cordoned.Status.Addresses
+	node = tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", cordoned3, time.Minute))
+	tracker.seedPods("c1", node, testStart.Add(time.Minute))
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, NODE_PHASE_UNKNOWN, saved[1].Result)
+}
+
+func TestNodeSnapshot(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	save := func(ms *NodeLifecycleMileStone) {
+		saved = append(saved, ms)
+	}
+	tracker := newNodeTracker(save)
+	tracker.processEvent(generateAuditEvent(t, "create", "nodes", "", newTestNode("n1", false, v1.ConditionTrue, 0), 0))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("web", "n1", "ReplicaSet"), time.Second))
+	tracker.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("db", "n1", "StatefulSet"), time.Second))
+	tracker.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 0), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "delete", "pods", "", newNodePod("db", "n1", "StatefulSet"), 2*time.Minute))
+	assert.Equal(t, 1, len(saved))
+
+	snapshots := tracker.snapshot("c1")
+	assert.Equal(t, 1, len(snapshots))
+	assert.Empty(t, tracker.snapshot("c2"))
+	data, err := json.Marshal(snapshots[0])
+	assert.Nil(t, err)
+
+	// restarted
+	restarted := newNodeTracker(save)
+	snapshot := &nodeSnapshot{}
+	assert.Nil(t, json.Unmarshal(data, snapshot))
+	assert.True(t, restarted.restore(snapshot))
+	assert.False(t, restarted.restore(snapshot))
+	assert.Equal(t, 1, restarted.size())
+	assert.True(t, restarted.deleting["db-uid"])
+
+	restarted.observePodDeleted("web-uid", SUCCESS, testStart.Add(3*time.Minute))
+	restarted.observePodDeleted("db-uid", SUCCESS, testStart.Add(4*time.Minute))
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, NODE_DRAIN, saved[1].Phase)
+	assert.Equal(t, NODE_PHASE_SUCCESS, saved[1].Result)
+	assert.Equal(t, 3*time.Minute, saved[1].Duration)
+	assert.Equal(t, 0, len(restarted.podNodes))
+}
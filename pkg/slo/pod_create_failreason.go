@@ -11,7 +11,6 @@ import (
 
 	"github.com/alipay/container-observability-service/pkg/reason"
 
-	"github.com/alipay/container-observability-service/pkg/config"
 	"github.com/alipay/container-observability-service/pkg/featuregates"
 	"github.com/alipay/container-observability-service/pkg/reason/analyzers"
 	"github.com/alipay/container-observability-service/pkg/shares"
@@ -101,14 +100,8 @@ func analyzeFailureReasonDetails(podyaml *v1.Pod, events []*v1.Event, cTime time
 	// postStartHookTime failed
 	postStartHookTime := calculatePostStartHookTime(events)
 	postStartHookTimeout := 180
-	if config.GlobalLunettesConfig().PostStartHookTimeout != "" {
-		postStartHookTimeoutFromConfigMap, err := time.ParseDuration(config.GlobalLunettesConfig().PostStartHookTimeout)
-		if err != nil {
-			klog.Errorf("Error parsing user timeout from configmap, %v", err)
-		} else {
-			// klog.Infof("customized timeout in configmap is %s", postStartHookTimeoutFromConfigMap)
-			postStartHookTimeout = int(postStartHookTimeoutFromConfigMap.Seconds())
-		}
+	if timeout := currentSLOConfig().postStartHookTimeout; timeout > 0 {
+		postStartHookTimeout = int(timeout.Seconds())
 	}
 	if postStartHookTime >= postStartHookTimeout {
 		return "PostStartHookTooMuchTime"
@@ -72,7 +72,7 @@ var (
 	podAuditLogMap    *utils.SafeMap
 	notifyQueue       chan string
 	podMilestoneMap   *utils.SafeMap
-	timeBroadcastChan chan *time.Time
+	timeBroadcastChan chan *clusterTime
 )
 
 func init() {
@@ -113,21 +113,24 @@ func init() {
 		metrics.MethodDurationMilliSeconds.WithLabelValues("syncAuditTime").Set(utils.TimeDiffInMilliSeconds(time5, time6))
 	})
 
-	timeBroadcastChan = make(chan *time.Time, 10000)
+	timeBroadcastChan = make(chan *clusterTime, 10000)
 	go func() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 		for {
-			// 连续取最后一个
-			t := <-timeBroadcastChan
+			// 连续取每个集群的最后一个
+			ct := <-timeBroadcastChan
+			latest := map[string]*time.Time{ct.cluster: &ct.t}
 			currentAuditLen := len(timeBroadcastChan)
 			for i := 0; i < currentAuditLen; i++ {
-				t = <-timeBroadcastChan
+				ct = <-timeBroadcastChan
+				latest[ct.cluster] = &ct.t
 			}
 
 			podMilestoneMap.IterateWithFunc(func(obj interface{}) {
 				podMilestone := obj.(*PodStartupMilestones)
-				if !podMilestone.Finished {
+				t, ok := latest[podMilestone.Cluster]
+				if ok && !podMilestone.Finished {
 					if len(podMilestone.auditTimeQueue) < 10 {
 						podMilestone.auditTimeQueue <- t
 					}
@@ -228,6 +231,8 @@ type PodStartupMilestones struct {
 	latestPod        *v1.Pod
 	shouldFinishTime *time.Time
 	trickTime        *time.Time
+	// DeliverySLOPolicy matching the pod, empty if none
+	sloPolicy string
 }
 
 // finish 结束Pod跟踪，线程安全的
@@ -249,7 +254,7 @@ func (data *PodStartupMilestones) finish() {
 		data.ImageNameToPullTime = calculateImagePullTime(eventsNormalOrder, data.shouldFinishTime)
 	}
 
-	data.FinishTime = time.Now()
+	data.FinishTime = utils.Now()
 	data.DebugUrl = ""
 	if data.PodUID != "" {
 		data.DebugUrl = "http://host:port/api/v1/debugpod?uid=" + data.PodUID
@@ -260,9 +265,43 @@ func (data *PodStartupMilestones) finish() {
 	//save milestone to zsearch
 	data.saveMileStone()
 
+	observePodCreated(data)
+	// the error budgets, pods killed while creating are not counted
+	if data.DeliveryStatusOrig == "SUCCESS" || data.DeliveryStatusOrig == "FAIL" {
+		budgets.observe(config.SLOObjectivePodCreate, data.Cluster, data.Namespace, data.OwnerRefStr, data.sloPolicy, data.DeliveryStatusOrig == "SUCCESS", data.deliveredAt())
+	}
+
 	close(data.closeCh)
 }
 
+// observePodCreated tells the rollout creating the pod, and the job of it, that its create SLO has finished
+func observePodCreated(data *PodStartupMilestones) {
+	duration := data.DeliveryDuration
+	if duration == 0 && !data.ReadyAt.IsZero() {
+		duration = data.ReadyAt.Sub(data.Created)
+	}
+	rollouts.observePodCreated(data.PodUID, data.PodName, data.StartUpResultFromCreate, duration)
+	jobs.observePodCreated(data.PodUID, data.StartUpResultFromCreate)
+}
+
+// deliveredAt is the audit time the delivery finished, when the pod got ready, or the latest audit time seen
+// if it did not in time
+func (data *PodStartupMilestones) deliveredAt() time.Time {
+	delivered := []time.Time{data.ReadyAt}
+	if data.IsJob {
+		delivered = []time.Time{data.RunningAt, data.SucceedAt, data.FailedAt}
+	}
+	for _, t := range delivered {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	if data.trickTime != nil {
+		return *data.trickTime
+	}
+	return data.FinishTime
+}
+
 func (data *PodStartupMilestones) saveMileStone() {
 	sloData, err := json.Marshal(data)
 	if err == nil {
@@ -281,6 +320,7 @@ func (data *PodStartupMilestones) start() {
 		case pe := <-data.inputQueue:
 			if pe != nil {
 				data.processEvent(pe)
+				pe.Event.Consumed()
 			}
 		case t := <-data.auditTimeQueue:
 			data.processTime(*t)
@@ -289,6 +329,13 @@ func (data *PodStartupMilestones) start() {
 			for i := 0; i < auditTimeLen; i++ {
 				<-data.auditTimeQueue //chain可能满了，外部product可能已经阻塞写入了
 			}
+			// the events put in after the tracking finished are dropped
+			inputLen := len(data.inputQueue)
+			for i := 0; i < inputLen; i++ {
+				if pe := <-data.inputQueue; pe != nil {
+					pe.Event.Consumed()
+				}
+			}
 			klog.V(8).Infof("finish for [%s], GoId: %d", data.PodName, utils.GoID())
 			data.notifyQueue <- data.key
 			//send slo data to publisher
@@ -551,7 +598,7 @@ func (data *PodStartupMilestones) processEvent(pe *PodEvent) {
 			data.SLOViolationReason = CREATE_RESULT_SUCCESS
 			data.DeliveryStatus = "SUCCESS"
 			// update
-			sloTime, adjusted := metas.GetPodSLOByDeliveryPath(pod)
+			adjusted := data.DeliverySLOAdjusted
 			priority := metas.GetPriority(pod)
 			sloReason := metas.IsTypicalPodNew(data.latestPod)
 			metrics.PodStartupSLOResult.WithLabelValues(data.Cluster, data.Namespace, data.OwnerRefStr,
@@ -568,7 +615,7 @@ func (data *PodStartupMilestones) processEvent(pe *PodEvent) {
 	}
 
 	traceProcessingTime := time.Now().Sub(pe.Event.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_create").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(data.Cluster, "slo_create", traceProcessingTime)
 }
 
 func (data *PodStartupMilestones) updateLatencyMetrics(milestone string, end time.Time) {
@@ -618,7 +665,7 @@ func (data *PodStartupMilestones) GetFinishTime() time.Time {
 
 // 处理 pod 创建的审计日志
 func processPodCreateLog(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPodCreateLog")
+	defer auditEvent.IgnorePanic("slo_create", "processPodCreateLog")
 
 	if auditEvent.ObjectRef.Resource != "pods" {
 		return
@@ -712,7 +759,7 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 				DeliveryStatus:          "FAIL",
 			}
 
-			sloTime, adjusted := metas.GetPodSLOByDeliveryPath(pod)
+			sloTime, adjusted := metas.GetPodSLO(pod, auditEvent.SloSpec)
 			podslo, _ := apiFailedMilestone.getPodDeliverySLO()
 			apiFailedMilestone.PodSLO = podslo
 			apiFailedMilestone.DeliverySLO = int64(sloTime)
@@ -729,7 +776,7 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 
 			apiFailedMilestone.SloHint = sloReason
 
-			apiFailedMilestone.PodUID = "NOPODID" + time.Now().Format(time.RFC3339)
+			apiFailedMilestone.PodUID = "NOPODID" + utils.Now().Format(time.RFC3339)
 			apiFailedMilestone.saveMileStone()
 		}
 	} else if auditEvent.Verb == "create" && auditResponseCode == 201 && auditEvent.ObjectRef.Subresource == "" {
@@ -756,9 +803,10 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 				inputQueue:         make(chan *PodEvent, 10000),
 				auditTimeQueue:     make(chan *time.Time, 200),
 				latestPod:          pod,
+				sloPolicy:          auditEvent.SLOPolicy,
 			}
 
-			sloTime, adjusted := metas.GetPodSLOByDeliveryPath(pod)
+			sloTime, adjusted := metas.GetPodSLO(pod, auditEvent.SloSpec)
 			podslo, _ := newMilestone.getPodDeliverySLO()
 			newMilestone.PodSLO = podslo
 			newMilestone.DeliverySLO = int64(sloTime)
@@ -775,7 +823,10 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 		if exist && milestone != nil {
 			podMs := milestone.(*PodStartupMilestones)
 			podMs.StopInterEvents = true
+			// consumed when the tracking is finished, so the checkpoint and replay wait for it
+			auditEvent.Produced()
 			go func() {
+				defer auditEvent.Consumed()
 				if !podMs.IsComplete() && !podMs.Finished && podMs.StartUpResultFromCreate == "" {
 					ss, cores := getSchedulingStrategyAndCores(pod)
 					coresStr := fmt.Sprintf("%d", cores)
@@ -818,11 +869,10 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 						podMs.DeliveryStatus = "KILL"
 						// update PodStartupSLOResult
 						//slo_time := xsearch.GetScheduleTimeLimit(pod)
-						sloTime, adjusted := metas.GetPodSLOByDeliveryPath(pod)
+						sloTime, adjusted := time.Duration(podMs.DeliverySLO), podMs.DeliverySLOAdjusted
 						priority := metas.GetPriority(pod)
 
 						sloReason := metas.IsTypicalPodNew(podMs.latestPod)
-						podMs.DeliverySLO = int64(sloTime)
 						metrics.PodStartupSLOResult.WithLabelValues(podMs.Cluster, podMs.Namespace, ownerRefStr,
 							reason, sloTime.String(), coresStr, isJobStr, priority, "KILL", sloReason, strconv.FormatBool(adjusted)).Inc()
 					}
@@ -836,6 +886,8 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 	} else if exist && milestone != nil && auditResponseCode < 300 && !milestone.(*PodStartupMilestones).Finished && !milestone.(*PodStartupMilestones).StopInterEvents {
 		// in creating, add to queue
 		// 其它审计事件，放入 inputQueue，用于 processEvent 的处理
+		// consumed after processEvent, so the checkpoint and replay wait for it
+		auditEvent.Produced()
 		milestone.(*PodStartupMilestones).inputQueue <- &PodEvent{
 			Pod:   pod,
 			Event: auditEvent,
@@ -845,7 +897,7 @@ func processPodCreateLog(auditEvent *shares.AuditEvent) {
 
 // todo 收集 pod event 事件
 func collectPodEvents(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("collectPodEvents")
+	defer auditEvent.IgnorePanic("slo_create", "collectPodEvents")
 	if auditEvent.ResponseStatus.Code >= 300 {
 		return
 	}
@@ -900,7 +952,7 @@ func collectPodEvents(auditEvent *shares.AuditEvent) {
 }
 
 func collectPodAuditLog(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("collectPodAuditLog")
+	defer auditEvent.IgnorePanic("slo_create", "collectPodAuditLog")
 
 	if auditEvent.ObjectRef.Resource != "events" && auditEvent.ObjectRef.Resource != "pods" {
 		return
@@ -956,7 +1008,7 @@ func collectPodAuditLog(auditEvent *shares.AuditEvent) {
 
 // PodCreateAPIResult 记录pod create的api返回结果
 func genPodCreateAPIResultMetrics(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("genPodCreateAPIResultMetrics")
+	defer auditEvent.IgnorePanic("slo_create", "genPodCreateAPIResultMetrics")
 
 	clusterName := auditEvent.Annotations["cluster"]
 	/*pod, err := metas.GeneratePodFromEvent(auditEvent)
@@ -978,10 +1030,10 @@ func genPodCreateAPIResultMetrics(auditEvent *shares.AuditEvent) {
 
 // syncAuditTime 同步审计日志时间
 func syncAuditTime(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("syncAuditTime")
-	t := auditEvent.StageTimestamp.Time
+	defer auditEvent.IgnorePanic("slo_create", "syncAuditTime")
+	ct := &clusterTime{cluster: auditEvent.Annotations["cluster"], t: auditEvent.StageTimestamp.Time}
 	select {
-	case timeBroadcastChan <- &t:
+	case timeBroadcastChan <- ct:
 	default:
 	}
 }
@@ -996,16 +1048,10 @@ func getSloTimeoutFromConfigMap(data *PodStartupMilestones) (time.Time, int64) {
 
 	// 1）先看 namespace 的超时时间
 	// 从 configmap 获取用户自己配置的 configmap，注：同时修改在线任务和离线任务
-	customizedTimeoutStr, ok := config.GlobalLunettesConfig().UserOnlineConfigMap[data.Namespace]
+	customizedTimeout, ok := currentSLOConfig().namespaceTimeouts[data.Namespace]
 	if ok {
-		customizedTimeout, err := time.ParseDuration(customizedTimeoutStr)
-		if err != nil {
-			klog.Errorf("Error parsing Namespace timeout from configmap, %v", err)
-		} else {
-			finishTimeForSlo = data.Created.Add(customizedTimeout)
-			slo = customizedTimeout
-			// klog.Infof("customized timeout in configmap is %s for %s", customizedTimeout, data.Namespace)
-		}
+		finishTimeForSlo = data.Created.Add(customizedTimeout)
+		slo = customizedTimeout
 	}
 
 	return finishTimeForSlo, int64(slo)
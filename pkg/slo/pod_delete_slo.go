@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alipay/container-observability-service/pkg/config"
 	"github.com/alipay/container-observability-service/pkg/metrics"
 	"github.com/alipay/container-observability-service/pkg/shares"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -75,7 +76,7 @@ func (c *PodCache) RecordPod(ms *xsearch.PodDeleteMileStone, tm time.Time) {
 // remove entries elder than 30 min (auditMaxLatency) periodically
 func (c *PodCache) compact() {
 	wait.Forever(func() {
-		now := currentDeleteAuditTime
+		now := deleteAuditTime.Oldest()
 		c.processedCache.Range(func(key, value interface{}) bool {
 			if stageTime, ok := value.(*time.Time); ok {
 				if now.Sub(*stageTime) > auditMaxLatency {
@@ -90,9 +91,9 @@ func (c *PodCache) compact() {
 var (
 	podCache = &PodCache{processedCache: &sync.Map{}}
 
-	PodDeleteMileStoneMap  *utils.SafeMap // podKey -> podDeleteMileStone
-	deleteQueue            *queue.BoundedQueue
-	currentDeleteAuditTime time.Time
+	PodDeleteMileStoneMap *utils.SafeMap // podKey -> podDeleteMileStone
+	deleteQueue           *queue.BoundedQueue
+	deleteAuditTime       = newClusterAuditTime()
 )
 
 func init() {
@@ -118,6 +119,7 @@ func init() {
 	})
 
 	go podCache.compact()
+	metas.RegisterPublisher(metas.PodDeleteSLO)
 
 	deleteQueue = queue.NewBoundedQueue("slo-watcher-delete", 100000, nil)
 	deleteQueue.StartLengthReporting(10 * time.Second)
@@ -133,23 +135,26 @@ func init() {
 }
 
 func checkTimeout() {
-	toDeleteKeys := make([]string, 0)
+	var mutex sync.Mutex
+	toDeleteMs := make([]*xsearch.PodDeleteMileStone, 0)
 	PodDeleteMileStoneMap.IterateWithFunc(func(i interface{}) {
 		deleteMS, ok := i.(*xsearch.PodDeleteMileStone)
 		if !ok {
 			return
 		}
-		if currentDeleteAuditTime.After(deleteMS.DeleteTimeoutTime) {
-			toDeleteKeys = append(toDeleteKeys, deleteMS.Key)
+		if deleteAuditTime.Get(deleteMS.Cluster).After(deleteMS.DeleteTimeoutTime) {
+			mutex.Lock()
+			toDeleteMs = append(toDeleteMs, deleteMS)
+			mutex.Unlock()
 		}
 	})
-	for _, key := range toDeleteKeys {
-		finishMileStoneWithResult(key, TIMEOUT, currentDeleteAuditTime)
+	for _, deleteMS := range toDeleteMs {
+		finishMileStoneWithResult(deleteMS.Key, TIMEOUT, deleteAuditTime.Get(deleteMS.Cluster))
 	}
 }
 
 func doDeleteSLO(auditEvent *shares.AuditEvent) {
-	currentDeleteAuditTime = auditEvent.StageTimestamp.Time
+	deleteAuditTime.Set(auditEvent.Annotations["cluster"], auditEvent.StageTimestamp.Time)
 	//处理patch操作
 	processPatchOp(auditEvent)
 	//delete
@@ -159,7 +164,7 @@ func doDeleteSLO(auditEvent *shares.AuditEvent) {
 }
 
 func processEvents(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processEvents")
+	defer auditEvent.IgnorePanic("slo_delete", "processEvents")
 	if auditEvent.ResponseStatus.Code >= 300 || auditEvent.ObjectRef.Resource != "events" {
 		return
 	}
@@ -206,11 +211,11 @@ func processEvents(auditEvent *shares.AuditEvent) {
 	}
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_delete_events").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "slo_delete_events", traceProcessingTime)
 }
 
 func processDeleteOp(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processDeleteOp")
+	defer auditEvent.IgnorePanic("slo_delete", "processDeleteOp")
 	if auditEvent.ObjectRef.Resource != "pods" || auditEvent.Verb != "delete" || auditEvent.ObjectRef.Subresource != "" {
 		return
 	}
@@ -245,15 +250,15 @@ func processDeleteOp(auditEvent *shares.AuditEvent) {
 	}
 
 	if len(resPod.ObjectMeta.GetFinalizers()) == 0 && (resPod.DeletionGracePeriodSeconds == nil || *resPod.DeletionGracePeriodSeconds == 0) {
-		finishMileStoneWithResult(podKey, SUCCESS, currentDeleteAuditTime)
+		finishMileStoneWithResult(podKey, SUCCESS, auditEvent.StageTimestamp.Time)
 	}
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_delete_deleteOp").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "slo_delete_deleteOp", traceProcessingTime)
 }
 
 func processPatchOp(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processPatchOp")
+	defer auditEvent.IgnorePanic("slo_delete", "processPatchOp")
 	if auditEvent == nil || auditEvent.ObjectRef.Resource != "pods" || auditEvent.Verb != "patch" ||
 		auditEvent.ObjectRef.Subresource != "" {
 		return
@@ -309,11 +314,11 @@ func processPatchOp(auditEvent *shares.AuditEvent) {
 	mileStone.Mutex.Unlock()
 
 	if len(mileStone.RemainingFinalizers) == 0 && (resPod.DeletionGracePeriodSeconds == nil || *resPod.DeletionGracePeriodSeconds == 0) {
-		finishMileStoneWithResult(podKey, SUCCESS, currentDeleteAuditTime)
+		finishMileStoneWithResult(podKey, SUCCESS, auditEvent.StageTimestamp.Time)
 	}
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_delete_patchOp").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "slo_delete_patchOp", traceProcessingTime)
 }
 
 func finishMileStoneWithResult(podKey string, result string, currentTime time.Time) {
@@ -343,19 +348,23 @@ func finishMileStoneWithResult(podKey string, result string, currentTime time.Ti
 	}
 
 	saveSLOData(milestone)
+	publishDeliveryResult(metas.PodDeleteSLO, milestone)
+	observePodDeleted(milestone.PodUID, milestone.PodName, result, currentTime)
 	if milestone.Type == DeleteMileStoneType {
 		metrics.PodDeleteResult.WithLabelValues(milestone.Cluster, milestone.Namespace, milestone.NodeIP, result).Inc()
+		budgets.observe(config.SLOObjectivePodDelete, milestone.Cluster, milestone.Namespace, "", milestone.SLOPolicy, result == SUCCESS, currentTime)
 		if result != SUCCESS {
 			milestone.Type = StaleDeletionMileStoneType
 			milestone.DeleteResult = ""
-			milestone.DeleteTimeoutTime = milestone.DeleteTimeoutTime.Add(PodStaleTimeoutPeriod - PodDeleteTimeoutPeriod)
+			// the delete timeout may be set by a DeliverySLOPolicy
+			milestone.DeleteTimeoutTime = milestone.CreatedTime.Add(PodStaleTimeoutPeriod)
 		} else {
 			// also count success records for 24h / 7d metrics
 			metrics.PodDeleteResultInDay.WithLabelValues(milestone.Cluster, milestone.Namespace, result).Inc()
 			metrics.PodDeleteResultInWeek.WithLabelValues(milestone.Cluster, milestone.Namespace, result).Inc()
 			if utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime) >= 0 {
 				metrics.PodDeleteLatency.WithLabelValues(milestone.Cluster, milestone.Namespace, FINISH).Observe(utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
-				metrics.PodDeleteLatencyQuantiles.WithLabelValues(getPodType(milestone)).Observe(utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
+				metrics.ObservePodDeleteLatencyQuantiles(milestone.Cluster, getPodType(milestone), utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
 			}
 			PodDeleteMileStoneMap.Delete(podKey)
 		}
@@ -370,7 +379,7 @@ func finishMileStoneWithResult(podKey string, result string, currentTime time.Ti
 			metrics.PodDeleteResultInWeek.WithLabelValues(milestone.Cluster, milestone.Namespace, result).Inc()
 			if utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime) >= 0 {
 				metrics.PodDeleteLatency.WithLabelValues(milestone.Cluster, milestone.Namespace, FINISH).Observe(utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
-				metrics.PodDeleteLatencyQuantiles.WithLabelValues(getPodType(milestone)).Observe(utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
+				metrics.ObservePodDeleteLatencyQuantiles(milestone.Cluster, getPodType(milestone), utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
 			}
 			PodDeleteMileStoneMap.Delete(podKey)
 		}
@@ -379,7 +388,7 @@ func finishMileStoneWithResult(podKey string, result string, currentTime time.Ti
 		if utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime) >= 0 {
 			// no matter whether finally this pod is deleted, just mark it as 1 week
 			metrics.PodDeleteLatency.WithLabelValues(milestone.Cluster, milestone.Namespace, FINISH).Observe(utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
-			metrics.PodDeleteLatencyQuantiles.WithLabelValues(getPodType(milestone)).Observe(utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
+			metrics.ObservePodDeleteLatencyQuantiles(milestone.Cluster, getPodType(milestone), utils.TimeDiffInSeconds(milestone.CreatedTime, currentTime))
 		}
 		PodDeleteMileStoneMap.Delete(podKey)
 	} else {
@@ -388,6 +397,12 @@ func finishMileStoneWithResult(podKey string, result string, currentTime time.Ti
 	}
 }
 
+// observePodDeleted tells the rollout deleting the old pod, and the drain of its node, that its delete SLO has finished
+func observePodDeleted(uid, name, result string, deleted time.Time) {
+	rollouts.observePodDeleted(uid, name, result)
+	nodes.observePodDeleted(uid, result, deleted)
+}
+
 func saveSLODataToZSearch(milestone *xsearch.PodDeleteMileStone) {
 	sloData, err := json.Marshal(milestone)
 	if err == nil {
@@ -423,11 +438,12 @@ func addToTraceMap(podKey string, resPod *v1.Pod, auditEvent *shares.AuditEvent)
 				TrigerAuditLog:      string(auditEvent.AuditID),
 				Type:                DeleteMileStoneType,
 				CreatedTime:         auditEvent.StageTimestamp.Time,
-				DeleteTimeoutTime:   auditEvent.StageTimestamp.Time.Add(PodDeleteTimeoutPeriod),
+				DeleteTimeoutTime:   auditEvent.StageTimestamp.Time.Add(deleteTimeout(auditEvent.SloSpec)),
 				RemainingFinalizers: resPod.ObjectMeta.GetFinalizers(),
 				DeleteResult:        "",
 				DebugUrl:            fmt.Sprintf("http://host:port/api/v1/debugpod?uid=%s", string(resPod.UID)),
 				IsJob:               metas.IsJobPod(resPod),
+				SLOPolicy:           auditEvent.SLOPolicy,
 				Key:                 podKey,
 				Mutex:               sync.Mutex{},
 				LifeDuration:        resPod.DeletionTimestamp.Sub(resPod.CreationTimestamp.Time),
@@ -449,3 +465,11 @@ func getPodType(milestone *xsearch.PodDeleteMileStone) string {
 	}
 	return podType
 }
+
+// deleteTimeout returns the PodDelete SLO time set by a DeliverySLOPolicy, PodDeleteTimeoutPeriod by default
+func deleteTimeout(sloSpec metas.SloSpec) time.Duration {
+	if sloTime, ok := sloSpec.SloTime(metas.SloSpecPodDelete); ok {
+		return sloTime
+	}
+	return PodDeleteTimeoutPeriod
+}
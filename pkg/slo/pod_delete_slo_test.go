@@ -246,7 +246,7 @@ func Test_PodDeleteLatencyQuantiles(t *testing.T) {
 		for key, val := range tc.latencies {
 			for _, rec := range val {
 				for i := 0; i < rec.count; i++ {
-					metrics.PodDeleteLatencyQuantiles.WithLabelValues(key).Observe(rec.val)
+					metrics.ObservePodDeleteLatencyQuantiles("test-cluster", key, rec.val)
 				}
 			}
 		}
@@ -0,0 +1,115 @@
+package slo
+
+import (
+	"sort"
+	"time"
+)
+
+// settlingMilestone is a milestone which may end before the SLOs of its pods finish, e.g. a rollout or a job
+type settlingMilestone interface {
+	// settling returns the cluster of the milestone, the time it ended, and its pods whose SLOs have not finished
+	settling() (cluster string, end time.Time, pendingPods map[string]string)
+}
+
+// podSettler correlates the pods with the milestones creating or deleting them, and keeps the milestones ended
+// until the SLOs of their pods finish or settleTime passes by the audit time. It is guarded by the tracker using it.
+type podSettler struct {
+	settleTime time.Duration
+	// milestones ended, waiting for the SLOs of their pods
+	ending []settlingMilestone
+	// milestones settled, to be saved out of the lock of the tracker
+	settled []settlingMilestone
+	// pod uid -> milestone of the pod
+	pods map[string]settlingMilestone
+	// pod uid -> the SLO of the pod waited for, POD_CREATE or POD_DELETE
+	slos map[string]string
+	// cluster -> time of the latest audit event
+	auditTime map[string]time.Time
+}
+
+func newPodSettler(settleTime time.Duration) *podSettler {
+	return &podSettler{
+		settleTime: settleTime,
+		pods:       make(map[string]settlingMilestone),
+		slos:       make(map[string]string),
+		auditTime:  make(map[string]time.Time),
+	}
+}
+
+func (s *podSettler) observeAuditTime(cluster string, now time.Time) {
+	if now.After(s.auditTime[cluster]) {
+		s.auditTime[cluster] = now
+	}
+}
+
+// timedOut returns the audit time of cluster, and if it is past start by timeout
+func (s *podSettler) timedOut(cluster string, start time.Time, timeout time.Duration) (time.Time, bool) {
+	now := s.auditTime[cluster]
+	return now, now.After(start.Add(timeout))
+}
+
+// addPod waits for the slo of the pod, POD_CREATE or POD_DELETE, before ms is saved
+func (s *podSettler) addPod(ms settlingMilestone, uid, name, slo string) {
+	_, _, pendingPods := ms.settling()
+	pendingPods[uid] = name
+	s.pods[uid] = ms
+	s.slos[uid] = slo
+}
+
+// popPod returns the milestone waiting for the slo of the pod, nil if none
+func (s *podSettler) popPod(uid, slo string) settlingMilestone {
+	ms, ok := s.pods[uid]
+	if !ok || s.slos[uid] != slo {
+		return nil
+	}
+	delete(s.pods, uid)
+	delete(s.slos, uid)
+	_, _, pendingPods := ms.settling()
+	delete(pendingPods, uid)
+	return ms
+}
+
+// podsWaited adds the pods whose SLOs are waited for and are not followed here, as tells local, to pods by cluster
+func (s *podSettler) podsWaited(local func(uid string) bool, pods remotePods) {
+	for uid, ms := range s.pods {
+		if local(uid) {
+			continue
+		}
+		cluster, _, _ := ms.settling()
+		pods.add(cluster, uid)
+	}
+}
+
+func (s *podSettler) end(ms settlingMilestone) {
+	s.ending = append(s.ending, ms)
+}
+
+// settle calls finish with the ended milestones whose pods have all finished, or which have waited long enough,
+// and the names of the pods not finished, and keeps them to be saved
+func (s *podSettler) settle(finish func(ms settlingMilestone, stuckPods []string)) {
+	remaining := s.ending[:0]
+	for _, ms := range s.ending {
+		cluster, end, pendingPods := ms.settling()
+		if len(pendingPods) > 0 && !s.auditTime[cluster].After(end.Add(s.settleTime)) {
+			remaining = append(remaining, ms)
+			continue
+		}
+		names := make([]string, 0, len(pendingPods))
+		for uid, name := range pendingPods {
+			names = append(names, name)
+			delete(s.pods, uid)
+			delete(s.slos, uid)
+		}
+		sort.Strings(names)
+		finish(ms, names)
+		s.settled = append(s.settled, ms)
+	}
+	s.ending = remaining
+}
+
+// takeSettled returns the milestones settled since the last call
+func (s *podSettler) takeSettled() []settlingMilestone {
+	settled := s.settled
+	s.settled = nil
+	return settled
+}
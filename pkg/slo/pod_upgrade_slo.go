@@ -14,6 +14,7 @@ import (
 	"github.com/alipay/container-observability-service/pkg/reason"
 
 	"github.com/alipay/container-observability-service/pkg/featuregates"
+	"github.com/alipay/container-observability-service/pkg/metas"
 	"github.com/alipay/container-observability-service/pkg/metrics"
 	"github.com/alipay/container-observability-service/pkg/reason/analyzers"
 	"github.com/alipay/container-observability-service/pkg/shares"
@@ -69,7 +70,7 @@ var (
 	podUpgradeMileStoneMap *utils.SafeMap // podKey -> *PodUpgradeMileStone
 	upgradeQueue           *queue.BoundedQueue
 	podUpgradeAuditLogMap  *utils.SafeMap
-	auditTimeChan          chan *time.Time
+	auditTimeChan          chan *clusterTime
 )
 
 func init() {
@@ -85,18 +86,22 @@ func init() {
 		}
 	}()
 
-	auditTimeChan = make(chan *time.Time, 10000)
+	auditTimeChan = make(chan *clusterTime, 10000)
 	go func() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 		for {
-			// 连续取最后一个
-			t := <-auditTimeChan
+			// 连续取每个集群的最后一个
+			ct := <-auditTimeChan
+			latest := map[string]*time.Time{ct.cluster: &ct.t}
 			currentAuditLen := len(auditTimeChan)
 			for i := 0; i < currentAuditLen; i++ {
-				t = <-auditTimeChan
+				ct = <-auditTimeChan
+				latest[ct.cluster] = &ct.t
+			}
+			for cluster, t := range latest {
+				checkUpgradeTimeout(cluster, t)
 			}
-			checkUpgradeTimeout(t)
 		}
 	}()
 
@@ -118,19 +123,21 @@ func init() {
 	})
 
 	spans.RegisterLuaHelperFunc("finish_upgrade", isFinishUpgradeHelper)
+	metas.RegisterPublisher(metas.PodUpgradeSLO)
 }
 
 // syncAuditTime 同步审计日志时间
 func syncAuditTimeForUpgrade(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("syncAuditTimeForUpgrade")
-	t := auditEvent.StageTimestamp.Time
+	defer auditEvent.IgnorePanic("slo_upgrade", "syncAuditTimeForUpgrade")
+	ct := &clusterTime{cluster: auditEvent.Annotations["cluster"], t: auditEvent.StageTimestamp.Time}
 	select {
-	case auditTimeChan <- &t:
+	case auditTimeChan <- ct:
 	default:
 	}
 }
 
-func checkUpgradeTimeout(auditTime *time.Time) {
+func checkUpgradeTimeout(cluster string, auditTime *time.Time) {
+	var mutex sync.Mutex
 	var toDeleteMs []*PodUpgradeMileStone
 	podUpgradeMileStoneMap.IterateWithFunc(func(i interface{}) {
 		upgradeMsMap, ok := i.(map[string]*PodUpgradeMileStone)
@@ -138,12 +145,14 @@ func checkUpgradeTimeout(auditTime *time.Time) {
 			return
 		}
 		for _, upgradeMS := range upgradeMsMap {
-			if auditTime.After(upgradeMS.UpgradeTimeoutTime) {
+			if upgradeMS.Cluster == cluster && auditTime.After(upgradeMS.UpgradeTimeoutTime) {
 				if upgradeMS.UpgradeResult == "" {
 					upgradeMS.UpgradeResult = UPGRADE_TIMEOUT
 				}
 				upgradeMS.trickTime = auditTime
+				mutex.Lock()
 				toDeleteMs = append(toDeleteMs, upgradeMS)
+				mutex.Unlock()
 			}
 		}
 	})
@@ -182,6 +191,7 @@ func finishUpgradeMileStoneWithResult(milestones []*PodUpgradeMileStone) {
 			if e != nil {
 				klog.Info(e)
 			}
+			publishDeliveryResult(metas.PodUpgradeSLO, json.RawMessage(sloData))
 		}
 
 		klog.Infof("upgrade finish for %s result %s\n", milestone.PodName, milestone.UpgradeResult)
@@ -199,7 +209,7 @@ func finishUpgradeMileStoneWithResult(milestones []*PodUpgradeMileStone) {
 }
 
 func processUpgradeStatus(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processUpgradeStatus")
+	defer auditEvent.IgnorePanic("slo_upgrade", "processUpgradeStatus")
 
 	if (auditEvent.Verb != "patch" && auditEvent.Verb != "update" && auditEvent.Verb != "delete") || auditEvent.ObjectRef.Resource != "pods" {
 		return
@@ -263,10 +273,10 @@ func processUpdateUpgrade(auditEvent *shares.AuditEvent) {
 	finishUpgradeMileStoneWithResult(toFinish)
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_upgrade_status").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "slo_upgrade_status", traceProcessingTime)
 }
 func processDelete(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processDeleteUpgrade")
+	defer auditEvent.IgnorePanic("slo_upgrade", "processDeleteUpgrade")
 	if auditEvent.ObjectRef.Resource != "pods" || auditEvent.Verb != "delete" || auditEvent.ObjectRef.Subresource != "" {
 		return
 	}
@@ -297,7 +307,7 @@ func processDelete(auditEvent *shares.AuditEvent) {
 
 	var toFinish []*PodUpgradeMileStone
 	for _, podMs := range podMsMap {
-		if time.Now().Before(podMs.CreatedTime.Add(10 * time.Second)) {
+		if utils.Now().Before(podMs.CreatedTime.Add(10 * time.Second)) {
 			podMs.UpgradeResult = UPGRADE_BEFOREFINISH
 		}
 		podMs.trickTime = &auditEvent.StageTimestamp.Time
@@ -306,11 +316,11 @@ func processDelete(auditEvent *shares.AuditEvent) {
 	finishUpgradeMileStoneWithResult(toFinish)
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_upgrade_status").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "slo_upgrade_status", traceProcessingTime)
 }
 
 func processUpgradeTrigger(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("processUpgradeTrigger")
+	defer auditEvent.IgnorePanic("slo_upgrade", "processUpgradeTrigger")
 
 	if auditEvent == nil || (auditEvent.Verb != "patch" && auditEvent.Verb != "update") || auditEvent.ObjectRef.Resource != "pods" {
 		return
@@ -367,7 +377,7 @@ func processUpgradeTrigger(auditEvent *shares.AuditEvent) {
 		UpgradeResult:        "",
 		UpgradeContainerName: strings.Join(containers, ","),
 		CreatedTime:          auditEvent.StageTimestamp.Time,
-		UpgradeTimeoutTime:   auditEvent.StageTimestamp.Time.Add(timeoutDuration),
+		UpgradeTimeoutTime:   auditEvent.StageTimestamp.Time.Add(upgradeTimeout(auditEvent.SloSpec)),
 		DebugUrl:             fmt.Sprintf("http://host:port/api/v1/debugpod?uid=%s", string(resPod.UID)),
 		key:                  podKey,
 		subKey:               timeStamp,
@@ -380,7 +390,7 @@ func processUpgradeTrigger(auditEvent *shares.AuditEvent) {
 	podUpgradeMileStoneMap.Set(podKey, podMsMap)
 
 	traceProcessingTime := time.Now().Sub(auditEvent.StageTimestamp.Time).Seconds()
-	metrics.TraceProcessingLatency.WithLabelValues("slo_upgrade_trigger").Observe(traceProcessingTime)
+	metrics.ObserveTraceProcessingLatency(auditEvent.Annotations["cluster"], "slo_upgrade_trigger", traceProcessingTime)
 }
 
 func isNewUpgradeDelivery(pod *v1.Pod) (bool, []string, string) {
@@ -449,7 +459,7 @@ func isFinishUpgrade(podMs *PodUpgradeMileStone, pod *v1.Pod) (bool, string) {
 }
 
 func collectPodUpgradeAuditLog(auditEvent *shares.AuditEvent) {
-	defer utils.IgnorePanic("collectPodAuditLog")
+	defer auditEvent.IgnorePanic("slo_upgrade", "collectPodAuditLog")
 
 	if auditEvent.ObjectRef.Resource != "events" && auditEvent.ObjectRef.Resource != "pods" {
 		return
@@ -577,3 +587,11 @@ func isFinishUpgradeHelper(L *lua.LState) int {
 	L.Push(lua.LBool(true))
 	return 1
 }
+
+// upgradeTimeout returns the PodUpgrade SLO time set by a DeliverySLOPolicy, timeoutDuration by default
+func upgradeTimeout(sloSpec metas.SloSpec) time.Duration {
+	if sloTime, ok := sloSpec.SloTime(metas.SloSpecPodUpgrade); ok {
+		return sloTime
+	}
+	return timeoutDuration
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alipay/container-observability-service/pkg/metas"
 	"github.com/alipay/container-observability-service/pkg/xsearch"
 
 	"k8s.io/klog/v2"
@@ -75,6 +76,7 @@ func init() {
 	})
 
 	keyToMileStone = utils.NewSafeMap()
+	metas.RegisterPublisher(metas.PVCCreateSLO)
 	ticker := time.NewTicker(30 * time.Second)
 	go func() {
 		defer ticker.Stop()
@@ -102,6 +104,7 @@ func finishPVCCreateWithResult(key string, result string) {
 		data, err := json.Marshal(milestone)
 		if err == nil {
 			_ = xsearch.SaveSloTraceData(milestone.Cluster, milestone.Namespace, milestone.PVCName, milestone.PVCUID, "create", data)
+			publishDeliveryResult(metas.PVCCreateSLO, json.RawMessage(data))
 		}
 	}
 	keyToMileStone.Delete(key)
@@ -0,0 +1,112 @@
+package slo
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"k8s.io/klog/v2"
+)
+
+// remotePods are the pods of other shards whose SLOs are waited for by the rollouts, jobs and nodes of the cluster
+// shard, cluster -> pod uids
+type remotePods map[string]map[string]bool
+
+func (p remotePods) add(cluster, uid string) {
+	uids, ok := p[cluster]
+	if !ok {
+		uids = make(map[string]bool)
+		p[cluster] = uids
+	}
+	uids[uid] = true
+}
+
+// podSLORecord is the part of the create and delete SLO records of a pod telling whether the SLO has finished
+type podSLORecord struct {
+	Type    string
+	PodUID  string
+	PodName string
+	// of the create SLOs, which are saved before finished too
+	FinishTime time.Time
+	// of the delete SLOs
+	DeleteResult  string
+	DeleteEndTime time.Time
+}
+
+var (
+	shardMutex sync.Mutex
+	shard      utils.Shard
+
+	// looks up the SLO records of the pods of cluster saved by the shards following them
+	lookupPodSLOs = xsearch.GetPodSloTraceData
+)
+
+func init() {
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			resolveRemotePods()
+		}
+	}()
+}
+
+// SetShard tells the SLOs which shard the aggregator is. The pods of other shards are seen by the cluster shard only
+// for the rollouts, jobs and nodes made of them, whose SLOs are looked up from the records saved by their shards.
+// The error budgets are labeled by the shard.
+func SetShard(s utils.Shard) {
+	shardMutex.Lock()
+	shard = s
+	shardMutex.Unlock()
+	budgets.setShard(s)
+}
+
+func currentShard() utils.Shard {
+	shardMutex.Lock()
+	defer shardMutex.Unlock()
+	return shard
+}
+
+// resolveRemotePods finishes the pods of other shards waited for by the records of their create and delete SLOs
+func resolveRemotePods() {
+	defer utils.IgnorePanic("resolveRemotePods")
+
+	s := currentShard()
+	if !s.Enabled() || !s.OwnsCluster() {
+		return
+	}
+	pods := make(remotePods)
+	rollouts.podsWaited(s.Owns, pods)
+	jobs.podsWaited(s.Owns, pods)
+	nodes.podsWaited(s.Owns, pods)
+
+	for cluster, uids := range pods {
+		values := make([]string, 0, len(uids))
+		for uid := range uids {
+			values = append(values, uid)
+		}
+		records, err := lookupPodSLOs(cluster, values)
+		if err != nil {
+			klog.Errorf("failed to look up the SLOs of %d pods of other shards in %s: %s", len(values), cluster, err.Error())
+			continue
+		}
+		for _, data := range records {
+			record := &podSLORecord{}
+			if err := json.Unmarshal(data, record); err != nil || !uids[record.PodUID] {
+				continue
+			}
+			switch {
+			case record.Type == "create" && !record.FinishTime.IsZero():
+				created := &PodStartupMilestones{}
+				if err := json.Unmarshal(data, created); err == nil {
+					observePodCreated(created)
+				}
+			case record.DeleteResult != "":
+				observePodDeleted(record.PodUID, record.PodName, record.DeleteResult, record.DeleteEndTime)
+			}
+		}
+	}
+}
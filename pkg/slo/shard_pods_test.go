@@ -0,0 +1,65 @@
+package slo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestResolveRemotePods(t *testing.T) {
+	saved := make([]*NodeLifecycleMileStone, 0)
+	oldNodes, oldLookup := nodes, lookupPodSLOs
+	defer func() {
+		nodes, lookupPodSLOs = oldNodes, oldLookup
+		SetShard(utils.Shard{Index: 0, Count: 1})
+	}()
+	nodes = newNodeTracker(func(ms *NodeLifecycleMileStone) {
+		saved = append(saved, ms)
+	})
+	// web-uid belongs to the cluster shard, db-uid to the other one
+	SetShard(utils.Shard{Index: 0, Count: 2})
+
+	records := make([]json.RawMessage, 0)
+	looked := make([]string, 0)
+	lookupPodSLOs = func(cluster string, uids []string) ([]json.RawMessage, error) {
+		assert.Equal(t, "c1", cluster)
+		looked = append(looked, uids...)
+		return records, nil
+	}
+
+	nodes.processEvent(generateAuditEvent(t, "create", "nodes", "", newTestNode("n1", false, v1.ConditionTrue, 0), 0))
+	nodes.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("web", "n1", "ReplicaSet"), time.Second))
+	nodes.processEvent(generateAuditEvent(t, "patch", "pods", "status", newNodePod("db", "n1", "StatefulSet"), time.Second))
+	nodes.processEvent(generateAuditEvent(t, "patch", "nodes", "", newTestNode("n1", true, v1.ConditionTrue, 0), time.Minute))
+	nodes.processEvent(generateAuditEvent(t, "delete", "pods", "", newNodePod("web", "n1", "ReplicaSet"), 2*time.Minute))
+	nodes.processEvent(generateAuditEvent(t, "delete", "pods", "", newNodePod("db", "n1", "StatefulSet"), 2*time.Minute))
+	nodes.observePodDeleted("web-uid", SUCCESS, testStart.Add(3*time.Minute))
+
+	// the delete SLO of db is not saved yet
+	resolveRemotePods()
+	assert.Equal(t, []string{"db-uid"}, looked)
+	assert.Equal(t, 1, len(saved))
+
+	record, err := json.Marshal(&xsearch.PodDeleteMileStone{
+		Type: DeleteMileStoneType, PodUID: "db-uid", PodName: "db", DeleteResult: SUCCESS, DeleteEndTime: testStart.Add(4 * time.Minute),
+	})
+	assert.Nil(t, err)
+	records = append(records, record)
+	resolveRemotePods()
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, NODE_DRAIN, saved[1].Phase)
+	assert.Equal(t, NODE_PHASE_SUCCESS, saved[1].Result)
+	assert.Equal(t, 3*time.Minute, saved[1].Duration)
+	assert.Equal(t, 2, saved[1].PodsDeleted)
+
+	// nothing is waited for
+	looked = looked[:0]
+	resolveRemotePods()
+	assert.Empty(t, looked)
+}
@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/alipay/container-observability-service/pkg/metas"
 	"github.com/alipay/container-observability-service/pkg/queue"
 	"github.com/olivere/elastic/v7"
 	"golang.org/x/sync/errgroup"
@@ -24,6 +25,23 @@ import (
 	v1 "k8s.io/api/core/v1"
 )
 
+// publishDeliveryResult sends the SLO record to the watchers of deliveryType, it is marshaled at once
+// as the record could still be changed
+func publishDeliveryResult(deliveryType string, record interface{}) {
+	publisher := metas.GetPubLister(deliveryType)
+	if publisher == nil {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		klog.Errorf("failed to marshal %s record: %s", deliveryType, err.Error())
+		return
+	}
+	if err := publisher.Publish(json.RawMessage(data)); err != nil {
+		klog.Errorf("failed to publish %s record: %s", deliveryType, err.Error())
+	}
+}
+
 const (
 	schedulingStrategyGPU        = "gpu"
 	schedulingStrategyColocation = "colocation"
@@ -48,6 +66,48 @@ type RequestAction struct {
 	Timestamp  string   `json:"timestamp,omitempty"`
 }
 
+// clusterTime is the StageTimestamp of an audit event of cluster
+type clusterTime struct {
+	cluster string
+	t       time.Time
+}
+
+// clusterAuditTime keeps the time of the latest audit event of each cluster, so that
+// milestones of a cluster are not timed out by the audit events of another cluster.
+type clusterAuditTime struct {
+	sync.RWMutex
+	times map[string]time.Time
+}
+
+func newClusterAuditTime() *clusterAuditTime {
+	return &clusterAuditTime{times: make(map[string]time.Time)}
+}
+
+func (c *clusterAuditTime) Set(cluster string, t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.times[cluster] = t
+}
+
+func (c *clusterAuditTime) Get(cluster string) time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.times[cluster]
+}
+
+// Oldest returns the earliest time of all clusters
+func (c *clusterAuditTime) Oldest() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	var oldest time.Time
+	for _, t := range c.times {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
 func getOwnerRefStr(pod *v1.Pod) string {
 	if pod == nil || len(pod.OwnerReferences) == 0 {
 		return ""
@@ -9,8 +9,7 @@ import (
 )
 
 var (
-	Queue       *queue.BoundedQueue
-	ClusterName string
+	Queue *queue.BoundedQueue
 )
 
 const (
@@ -18,9 +17,14 @@ const (
 	POD_DELETE  = "pod_delete"
 	POD_CREATE  = "pod_create"
 	PVC_CREATE  = "pvc_create"
+
+	WORKLOAD_ROLLOUT = "workload_rollout"
 )
 
 func init() {
+	// SLOs are computed after the spans of the event
+	shares.RegisterDAGNode(shares.SLOProcessNode, shares.SpanProcessNode)
+
 	Queue = queue.NewBoundedQueue("slo-watcher", 200000, nil)
 	Queue.StartLengthReporting(10 * time.Second)
 	Queue.IsDropEventOnFull = false
@@ -34,6 +38,16 @@ func init() {
 		}
 
 		event.CanProcess(shares.SLOProcessNode)
+		//deployment and statefulset rollout, in order before the create and delete SLOs of the pods which end it
+		processRolloutEvent(event)
+		//job completion, likewise before the create SLOs
+		processJobEvent(event)
+		//node join, drain and NotReady, likewise before the delete SLOs
+		processNodeEvent(event)
+		if event.Observed {
+			// the SLOs of the pod are followed by its shard
+			return
+		}
 		//删除Pod SLO
 		deleteQueue.Produce(event)
 		//Upgrade SLO
@@ -46,3 +60,38 @@ func init() {
 		event.FinishProcess(shares.SLOProcessNode)
 	})
 }
+
+// StageQueue returns the queue consuming the events failed in stage, e.g. a dead letter of slo_create,
+// nil if stage is not one of SLOs
+func StageQueue(stage string) *queue.BoundedQueue {
+	switch stage {
+	case "slo_create":
+		return createQueue
+	case "slo_upgrade":
+		return upgradeQueue
+	case "slo_delete":
+		return deleteQueue
+	case "slo_rollout":
+		return rolloutQueue
+	case "slo_job":
+		return jobQueue
+	case "slo_node":
+		return nodeQueue
+	}
+	return nil
+}
+
+// newTrackerQueue returns the queue of the dead letters of a tracker, which follows the other events in the watcher
+func newTrackerQueue(name string, process func(event *shares.AuditEvent)) *queue.BoundedQueue {
+	q := queue.NewBoundedQueue(name, 10000, nil)
+	q.IsDropEventOnFull = false
+	q.StartConsumers(1, func(v interface{}) {
+		event, ok := v.(*shares.AuditEvent)
+		if !ok || event == nil {
+			return
+		}
+		event.Wait()
+		process(event)
+	})
+	return q
+}
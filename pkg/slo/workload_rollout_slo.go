@@ -0,0 +1,619 @@
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metas"
+	"github.com/alipay/container-observability-service/pkg/shares"
+	"github.com/alipay/container-observability-service/pkg/xsearch"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// WorkloadRolloutMileStone is the rollout of a Deployment or StatefulSet to one revision
+type WorkloadRolloutMileStone struct {
+	Type      string
+	Cluster   string
+	Namespace string
+	Kind      string
+	Name      string
+	UID       string
+	// deployment.kubernetes.io/revision of the ReplicaSet, or the revision of the ControllerRevision
+	Revision string
+	// pod-template-hash of the ReplicaSet, or the name of the ControllerRevision
+	RevisionHash    string
+	Replicas        int32
+	TriggerAuditLog string
+	RolloutResult   string
+	StartTime       time.Time
+	EndTime         time.Time
+	Duration        time.Duration
+	// time with more pods than replicas
+	SurgeDuration time.Duration
+	// time with unavailable replicas
+	UnavailableDuration    time.Duration
+	MaxUnavailableReplicas int32
+	PodsCreated            int
+	PodsDeleted            int
+	SlowestPod             string
+	SlowestPodDuration     time.Duration
+	// pods of the revision not ready, or old pods not deleted, when the rollout ends
+	StuckPods []string
+	//内部变量
+	key string
+	// the pods created by the rollout whose create SLO has not finished
+	pendingPods map[string]string // uid -> name
+	// the phase observed by the last status update
+	surgeSince       *time.Time
+	unavailableSince *time.Time
+}
+
+const (
+	ROLLOUT_SUCCESS           = "success"
+	ROLLOUT_TIMEOUT           = "timeout"
+	ROLLOUT_SUPERSEDED        = "superseded"
+	ROLLOUT_DELETED           = "deleted"
+	ROLLOUT_DEADLINE_EXCEEDED = "deadline_exceeded"
+
+	kindDeployment  = "Deployment"
+	kindStatefulSet = "StatefulSet"
+
+	deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+	// reason of the Progressing condition of a Deployment which exceeded its progressDeadlineSeconds
+	progressDeadlineExceededReason = "ProgressDeadlineExceeded"
+
+	// a rollout not finished in time is saved as timeout
+	rolloutTimeout = 60 * time.Minute
+	// after a rollout ends, the create SLOs of its pods are waited for so long
+	rolloutSettleTime = time.Minute
+)
+
+var (
+	workloadRolloutResult = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_workload_rollout_result_count",
+			Help: "rollouts of deployments and statefulsets by result",
+		},
+		[]string{"cluster", "namespace", "kind", "result"},
+	)
+	workloadRolloutDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "slo_workload_rollout_duration_seconds",
+			Help:    "time from the new revision to the rollout finished",
+			Buckets: []float64{30, 60, 120, 300, 600, 900, 1800, 3600},
+		},
+		[]string{"cluster", "kind", "result"},
+	)
+
+	rollouts = newRolloutTracker(saveRolloutMileStone)
+	// the dead letters of the rollouts re-processed
+	rolloutQueue = newTrackerQueue("slo-watcher-rollout", processRolloutEvent)
+)
+
+func init() {
+	prometheus.MustRegister(workloadRolloutResult)
+	prometheus.MustRegister(workloadRolloutDuration)
+
+	metas.RegisterPublisher(metas.WorkloadRolloutSLO)
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			sloOngoingSize.WithLabelValues("workloadRollout").Set(float64(rollouts.size()))
+			rollouts.checkTimeout()
+		}
+	}()
+}
+
+// processRolloutEvent is called by the SLO watcher before the event is handed to the create and delete SLOs,
+// so the pods of a rollout are known to it before their SLOs finish
+func processRolloutEvent(event *shares.AuditEvent) {
+	defer event.IgnorePanic("slo_rollout", "processRolloutEvent")
+
+	if event.ObjectRef == nil {
+		return
+	}
+	rollouts.processEvent(event)
+}
+
+func saveRolloutMileStone(ms *WorkloadRolloutMileStone) {
+	workloadRolloutResult.WithLabelValues(ms.Cluster, ms.Namespace, ms.Kind, ms.RolloutResult).Inc()
+	workloadRolloutDuration.WithLabelValues(ms.Cluster, ms.Kind, ms.RolloutResult).Observe(ms.Duration.Seconds())
+
+	data, err := json.Marshal(ms)
+	if err != nil {
+		klog.Errorf("failed to marshal rollout of %s: %s", ms.key, err.Error())
+		return
+	}
+	// one record per revision
+	if err := xsearch.SaveSloTraceData(ms.Cluster, ms.Namespace, ms.Name, ms.UID+"-"+ms.Revision, "rollout", data); err != nil {
+		klog.Errorf("failed to save rollout of %s: %s", ms.key, err.Error())
+	}
+	publishDeliveryResult(metas.WorkloadRolloutSLO, json.RawMessage(data))
+	klog.V(6).Infof("rollout of %s to revision %s finished: %s", ms.key, ms.Revision, ms.RolloutResult)
+}
+
+// rolloutTracker follows the rollouts by the revisions of the workloads, and the create and delete SLOs of their pods
+type rolloutTracker struct {
+	mutex sync.Mutex
+	// workload key -> rollout in progress
+	active map[string]*WorkloadRolloutMileStone
+	// the rollouts ended, waiting for the create and delete SLOs of their pods
+	settler *podSettler
+	// workload key -> time and audit id of the last spec change, the start of the next rollout
+	specChanges map[string]specChange
+	// workload key -> the latest revision seen
+	revisions map[string]int64
+	save      func(*WorkloadRolloutMileStone)
+}
+
+type specChange struct {
+	time    time.Time
+	auditID string
+}
+
+func newRolloutTracker(save func(*WorkloadRolloutMileStone)) *rolloutTracker {
+	return &rolloutTracker{
+		active:      make(map[string]*WorkloadRolloutMileStone),
+		settler:     newPodSettler(rolloutSettleTime),
+		specChanges: make(map[string]specChange),
+		revisions:   make(map[string]int64),
+		save:        save,
+	}
+}
+
+func workloadKey(cluster, namespace, kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", cluster, namespace, kind, name)
+}
+
+func (t *rolloutTracker) size() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.active) + len(t.settler.ending)
+}
+
+func (ms *WorkloadRolloutMileStone) settling() (string, time.Time, map[string]string) {
+	return ms.Cluster, ms.EndTime, ms.pendingPods
+}
+
+func (t *rolloutTracker) processEvent(event *shares.AuditEvent) {
+	if event.ResponseStatus == nil || event.ResponseStatus.Code >= 300 || event.ResponseObject == nil {
+		return
+	}
+	cluster := event.Annotations["cluster"]
+	now := event.StageTimestamp.Time
+
+	t.mutex.Lock()
+	// saved after unlocked
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	t.settler.observeAuditTime(cluster, now)
+
+	ref := event.ObjectRef
+	switch {
+	case ref.Resource == "deployments" || ref.Resource == "statefulsets":
+		t.processWorkload(event, cluster, now)
+	case ref.Resource == "replicasets" && ref.Subresource == "" && (event.Verb == "create" || event.Verb == "update" || event.Verb == "patch"):
+		rs := &appsv1.ReplicaSet{}
+		if err := json.Unmarshal(event.ResponseObject.Raw, rs); err != nil {
+			return
+		}
+		owner := metav1.GetControllerOf(rs)
+		if owner == nil || owner.Kind != kindDeployment {
+			return
+		}
+		// a rollback reuses the old ReplicaSet with a new revision
+		t.startRollout(event.Verb == "create", workloadKey(cluster, rs.Namespace, kindDeployment, owner.Name), &WorkloadRolloutMileStone{
+			Cluster:      cluster,
+			Namespace:    rs.Namespace,
+			Kind:         kindDeployment,
+			Name:         owner.Name,
+			UID:          string(owner.UID),
+			Revision:     rs.Annotations[deploymentRevisionAnnotation],
+			RevisionHash: rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey],
+		}, string(event.AuditID), now)
+	case ref.Resource == "controllerrevisions" && ref.Subresource == "" && (event.Verb == "create" || event.Verb == "update" || event.Verb == "patch"):
+		cr := &appsv1.ControllerRevision{}
+		if err := json.Unmarshal(event.ResponseObject.Raw, cr); err != nil {
+			return
+		}
+		owner := metav1.GetControllerOf(cr)
+		if owner == nil || owner.Kind != kindStatefulSet {
+			return
+		}
+		// a rollback updates the revision of the old ControllerRevision
+		t.startRollout(event.Verb == "create", workloadKey(cluster, cr.Namespace, kindStatefulSet, owner.Name), &WorkloadRolloutMileStone{
+			Cluster:      cluster,
+			Namespace:    cr.Namespace,
+			Kind:         kindStatefulSet,
+			Name:         owner.Name,
+			UID:          string(owner.UID),
+			Revision:     strconv.FormatInt(cr.Revision, 10),
+			RevisionHash: cr.Name,
+		}, string(event.AuditID), now)
+	case ref.Resource == "pods" && ref.Subresource == "" && (event.Verb == "create" || event.Verb == "delete"):
+		pod, ok := event.ResponseRuntimeObj.(*v1.Pod)
+		if !ok || pod == nil {
+			return
+		}
+		t.processPod(cluster, pod, event.Verb == "create")
+	}
+}
+
+// processWorkload records the spec changes, and follows the status of the workload in rollout
+func (t *rolloutTracker) processWorkload(event *shares.AuditEvent, cluster string, now time.Time) {
+	ref := event.ObjectRef
+	kind := kindDeployment
+	if ref.Resource == "statefulsets" {
+		kind = kindStatefulSet
+	}
+	key := workloadKey(cluster, ref.Namespace, kind, ref.Name)
+
+	if event.Verb == "delete" && ref.Subresource == "" {
+		if ms, ok := t.active[key]; ok {
+			t.endRollout(ms, ROLLOUT_DELETED, now)
+		}
+		delete(t.specChanges, key)
+		delete(t.revisions, key)
+		return
+	}
+	if event.Verb != "create" && event.Verb != "update" && event.Verb != "patch" {
+		return
+	}
+	if ref.Subresource == "" {
+		// the new revision is created by the controller right after
+		t.specChanges[key] = specChange{time: now, auditID: string(event.AuditID)}
+	}
+
+	ms, ok := t.active[key]
+	if !ok {
+		return
+	}
+	var status workloadStatus
+	if kind == kindDeployment {
+		d := &appsv1.Deployment{}
+		if err := json.Unmarshal(event.ResponseObject.Raw, d); err != nil {
+			return
+		}
+		status = deploymentStatus(d)
+	} else {
+		s := &appsv1.StatefulSet{}
+		if err := json.Unmarshal(event.ResponseObject.Raw, s); err != nil {
+			return
+		}
+		status = statefulSetStatus(s, ms.RevisionHash)
+	}
+	t.updateRollout(ms, status, now)
+}
+
+// workloadStatus is what a rollout needs from the status of a workload
+type workloadStatus struct {
+	replicas    int32
+	surge       bool
+	unavailable int32
+	complete    bool
+	// result of the rollout failed, e.g. deadline_exceeded
+	failed string
+}
+
+func deploymentStatus(d *appsv1.Deployment) workloadStatus {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	s := workloadStatus{
+		replicas:    replicas,
+		surge:       d.Status.Replicas > replicas,
+		unavailable: d.Status.UnavailableReplicas,
+	}
+	// same as kubectl rollout status
+	s.complete = d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+		d.Status.Replicas == d.Status.UpdatedReplicas && d.Status.AvailableReplicas == d.Status.UpdatedReplicas
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == v1.ConditionFalse && c.Reason == progressDeadlineExceededReason {
+			s.failed = ROLLOUT_DEADLINE_EXCEEDED
+		}
+	}
+	return s
+}
+
+func statefulSetStatus(sts *appsv1.StatefulSet, revision string) workloadStatus {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	s := workloadStatus{replicas: replicas}
+	if sts.Status.ReadyReplicas < replicas {
+		s.unavailable = replicas - sts.Status.ReadyReplicas
+	}
+	s.complete = sts.Status.ObservedGeneration >= sts.Generation && sts.Status.UpdateRevision == revision &&
+		sts.Status.CurrentRevision == revision && sts.Status.UpdatedReplicas >= replicas && sts.Status.ReadyReplicas >= replicas
+	return s
+}
+
+// startRollout starts the rollout to a revision newer than the ones seen before. The revisions updated are only
+// learnt until one is created, the updates of the old revisions seen after restart are not rollouts.
+func (t *rolloutTracker) startRollout(created bool, key string, ms *WorkloadRolloutMileStone, auditID string, now time.Time) {
+	revision, err := strconv.ParseInt(ms.Revision, 10, 64)
+	if err != nil || ms.RevisionHash == "" {
+		return
+	}
+	last, known := t.revisions[key]
+	if known && revision <= last {
+		return
+	}
+	t.revisions[key] = revision
+	if !created && !known {
+		return
+	}
+	if old, ok := t.active[key]; ok {
+		t.endRollout(old, ROLLOUT_SUPERSEDED, now)
+	}
+
+	ms.Type = WORKLOAD_ROLLOUT
+	ms.StartTime = now
+	ms.TriggerAuditLog = auditID
+	// started by the spec change just before
+	if change, ok := t.specChanges[key]; ok && !change.time.After(now) && now.Sub(change.time) < time.Minute {
+		ms.StartTime = change.time
+		ms.TriggerAuditLog = change.auditID
+	}
+	ms.key = key
+	ms.pendingPods = make(map[string]string)
+	t.active[key] = ms
+	klog.V(6).Infof("rollout of %s to revision %s started", key, ms.Revision)
+}
+
+func (t *rolloutTracker) updateRollout(ms *WorkloadRolloutMileStone, status workloadStatus, now time.Time) {
+	ms.Replicas = status.replicas
+	if status.unavailable > ms.MaxUnavailableReplicas {
+		ms.MaxUnavailableReplicas = status.unavailable
+	}
+	ms.surgeSince = updatePhase(&ms.SurgeDuration, ms.surgeSince, status.surge, now)
+	ms.unavailableSince = updatePhase(&ms.UnavailableDuration, ms.unavailableSince, status.unavailable > 0, now)
+
+	if status.complete {
+		t.endRollout(ms, ROLLOUT_SUCCESS, now)
+	} else if status.failed != "" {
+		t.endRollout(ms, status.failed, now)
+	}
+}
+
+// updatePhase adds the time of a phase to total when it is left, and returns when the phase was entered
+func updatePhase(total *time.Duration, since *time.Time, in bool, now time.Time) *time.Time {
+	if in && since == nil {
+		return &now
+	}
+	if !in && since != nil {
+		*total += now.Sub(*since)
+		return nil
+	}
+	return since
+}
+
+// processPod attributes the pods created or deleted during a rollout to it
+func (t *rolloutTracker) processPod(cluster string, pod *v1.Pod, created bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return
+	}
+	var key, hash string
+	switch owner.Kind {
+	case "ReplicaSet":
+		hash = pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+		if hash == "" {
+			return
+		}
+		key = workloadKey(cluster, pod.Namespace, kindDeployment, strings.TrimSuffix(owner.Name, "-"+hash))
+	case kindStatefulSet:
+		hash = pod.Labels[appsv1.StatefulSetRevisionLabel]
+		key = workloadKey(cluster, pod.Namespace, kindStatefulSet, owner.Name)
+	default:
+		return
+	}
+	ms, ok := t.active[key]
+	if !ok {
+		return
+	}
+	if created && hash == ms.RevisionHash {
+		ms.PodsCreated++
+		t.settler.addPod(ms, string(pod.UID), pod.Name, POD_CREATE)
+	} else if !created && hash != ms.RevisionHash {
+		// the delete SLO tells when it is done
+		t.settler.addPod(ms, string(pod.UID), pod.Name, POD_DELETE)
+	}
+}
+
+// observePodCreated is called when the create SLO of a pod finishes
+func (t *rolloutTracker) observePodCreated(uid, name, result string, duration time.Duration) {
+	t.mutex.Lock()
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	ms := t.popPod(uid, POD_CREATE)
+	if ms == nil {
+		return
+	}
+	if result != CREATE_RESULT_SUCCESS {
+		ms.StuckPods = append(ms.StuckPods, fmt.Sprintf("%s: %s", name, result))
+	} else if duration > ms.SlowestPodDuration {
+		ms.SlowestPod = name
+		ms.SlowestPodDuration = duration
+	}
+	t.settle()
+}
+
+// observePodDeleted is called when the delete SLO of a pod finishes
+func (t *rolloutTracker) observePodDeleted(uid, name, result string) {
+	t.mutex.Lock()
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	ms := t.popPod(uid, POD_DELETE)
+	if ms == nil {
+		return
+	}
+	if result == SUCCESS {
+		ms.PodsDeleted++
+	} else {
+		ms.StuckPods = append(ms.StuckPods, fmt.Sprintf("%s: delete %s", name, result))
+	}
+	t.settle()
+}
+
+func (t *rolloutTracker) popPod(uid, slo string) *WorkloadRolloutMileStone {
+	ms, _ := t.settler.popPod(uid, slo).(*WorkloadRolloutMileStone)
+	return ms
+}
+
+func (t *rolloutTracker) endRollout(ms *WorkloadRolloutMileStone, result string, now time.Time) {
+	delete(t.active, ms.key)
+	ms.RolloutResult = result
+	ms.EndTime = now
+	ms.Duration = now.Sub(ms.StartTime)
+	updatePhase(&ms.SurgeDuration, ms.surgeSince, false, now)
+	updatePhase(&ms.UnavailableDuration, ms.unavailableSince, false, now)
+	ms.surgeSince, ms.unavailableSince = nil, nil
+	t.settler.end(ms)
+	t.settle()
+}
+
+// settle finishes the ended rollouts whose pods have all finished, or which have waited long enough
+func (t *rolloutTracker) settle() {
+	t.settler.settle(func(settled settlingMilestone, stuckPods []string) {
+		ms := settled.(*WorkloadRolloutMileStone)
+		ms.StuckPods = append(ms.StuckPods, stuckPods...)
+	})
+}
+
+// saveSettled saves the rollouts settled out of the lock, as saving them may block
+func (t *rolloutTracker) saveSettled() {
+	t.mutex.Lock()
+	settled := t.settler.takeSettled()
+	t.mutex.Unlock()
+	for _, ms := range settled {
+		t.save(ms.(*WorkloadRolloutMileStone))
+	}
+}
+
+// podsWaited adds the pods whose SLOs are waited for and are not followed here, as tells local, to pods by cluster
+func (t *rolloutTracker) podsWaited(local func(uid string) bool, pods remotePods) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.settler.podsWaited(local, pods)
+}
+
+// checkTimeout ends the rollouts not finished in rolloutTimeout by the audit time
+func (t *rolloutTracker) checkTimeout() {
+	t.mutex.Lock()
+	defer t.saveSettled()
+	defer t.mutex.Unlock()
+	for _, ms := range t.active {
+		if now, timedOut := t.settler.timedOut(ms.Cluster, ms.StartTime, rolloutTimeout); timedOut {
+			t.endRollout(ms, ROLLOUT_TIMEOUT, now)
+		}
+	}
+	t.settle()
+}
+
+// rolloutSnapshot keeps the internal state of a rollout needed to go on after restart
+type rolloutSnapshot struct {
+	Key              string
+	Milestone        *WorkloadRolloutMileStone
+	PendingPods      map[string]string
+	SurgeSince       *time.Time
+	UnavailableSince *time.Time
+	// the pending pods waited for to be deleted, the others to be created
+	DeletingPods []string
+}
+
+// snapshot returns the rollouts of cluster in progress or waiting for their pods
+func (t *rolloutTracker) snapshot(cluster string) []*rolloutSnapshot {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	snapshots := make([]*rolloutSnapshot, 0)
+	add := func(ms *WorkloadRolloutMileStone) {
+		if ms.Cluster != cluster {
+			return
+		}
+		pendingPods := make(map[string]string, len(ms.pendingPods))
+		var deletingPods []string
+		for uid, name := range ms.pendingPods {
+			pendingPods[uid] = name
+			if t.settler.slos[uid] == POD_DELETE {
+				deletingPods = append(deletingPods, uid)
+			}
+		}
+		sort.Strings(deletingPods)
+		copied := *ms
+		snapshots = append(snapshots, &rolloutSnapshot{
+			Key:              ms.key,
+			Milestone:        &copied,
+			PendingPods:      pendingPods,
+			DeletingPods:     deletingPods,
+			SurgeSince:       ms.surgeSince,
+			UnavailableSince: ms.unavailableSince,
+		})
+	}
+	for _, ms := range t.active {
+		add(ms)
+	}
+	for _, ms := range t.settler.ending {
+		add(ms.(*WorkloadRolloutMileStone))
+	}
+	return snapshots
+}
+
+// restore rehydrates a rollout, a rollout of the workload being tracked already is kept
+func (t *rolloutTracker) restore(snapshot *rolloutSnapshot) bool {
+	key, ms := snapshot.Key, snapshot.Milestone
+	if ms == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.active[key]; ok {
+		return false
+	}
+	for _, settling := range t.settler.ending {
+		if ending := settling.(*WorkloadRolloutMileStone); ending.key == key && ending.Revision == ms.Revision {
+			return false
+		}
+	}
+
+	ms.key = key
+	ms.pendingPods = snapshot.PendingPods
+	if ms.pendingPods == nil {
+		ms.pendingPods = make(map[string]string)
+	}
+	ms.surgeSince = snapshot.SurgeSince
+	ms.unavailableSince = snapshot.UnavailableSince
+	deleting := make(map[string]bool, len(snapshot.DeletingPods))
+	for _, uid := range snapshot.DeletingPods {
+		deleting[uid] = true
+	}
+	for uid, name := range ms.pendingPods {
+		slo := POD_CREATE
+		if deleting[uid] {
+			slo = POD_DELETE
+		}
+		t.settler.addPod(ms, uid, name, slo)
+	}
+	if ms.RolloutResult != "" {
+		t.settler.end(ms)
+		return true
+	}
+	t.active[key] = ms
+	if revision, err := strconv.ParseInt(ms.Revision, 10, 64); err == nil && revision > t.revisions[key] {
+		t.revisions[key] = revision
+	}
+	return true
+}
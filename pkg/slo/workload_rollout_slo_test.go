@@ -0,0 +1,213 @@
+package slo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func newTestDeployment(generation int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web", UID: "d-uid", Generation: generation},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(2)},
+		Status:     status,
+	}
+}
+
+func newTestReplicaSet(hash, revision string) *appsv1.ReplicaSet {
+	controller := true
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "ns",
+			Name:            "web-" + hash,
+			Labels:          map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: hash},
+			Annotations:     map[string]string{deploymentRevisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{{Kind: kindDeployment, Name: "web", UID: "d-uid", Controller: &controller}},
+		},
+	}
+}
+
+func newRolloutPod(name, hash string) *v1.Pod {
+	pod := generatePod(name, "ReplicaSet", "web-"+hash)
+	pod.Labels = map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: hash}
+	return pod
+}
+
+func TestDeploymentRollout(t *testing.T) {
+	saved := make([]*WorkloadRolloutMileStone, 0)
+	var tracker *rolloutTracker
+	tracker = newRolloutTracker(func(ms *WorkloadRolloutMileStone) {
+		// saved out of the lock
+		tracker.size()
+		saved = append(saved, ms)
+	})
+
+	tracker.processEvent(generateAuditEvent(t, "patch", "deployments", "", newTestDeployment(2, appsv1.DeploymentStatus{}), 0))
+	tracker.processEvent(generateAuditEvent(t, "create", "replicasets", "", newTestReplicaSet("new", "2"), time.Second))
+	// scaling down the old ReplicaSet is not a rollout
+	tracker.processEvent(generateAuditEvent(t, "update", "replicasets", "", newTestReplicaSet("old", "1"), 2*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", newRolloutPod("web-new-a", "new"), 2*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", newRolloutPod("web-new-b", "new"), 2*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "delete", "pods", "", newRolloutPod("web-old-a", "old"), 3*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(2, appsv1.DeploymentStatus{
+		ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 1, UnavailableReplicas: 2,
+	}), 10*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(2, appsv1.DeploymentStatus{
+		ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1, UnavailableReplicas: 1,
+	}), 20*time.Second))
+	tracker.observePodCreated("web-new-a-uid", "web-new-a", CREATE_RESULT_SUCCESS, 15*time.Second)
+	tracker.observePodDeleted("web-old-a-uid", "web-old-a", SUCCESS)
+	tracker.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(2, appsv1.DeploymentStatus{
+		ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2,
+	}), 30*time.Second))
+
+	// waiting for the create SLO of web-new-b
+	assert.Equal(t, 0, len(saved))
+	tracker.observePodCreated("web-new-b-uid", "web-new-b", CREATE_RESULT_SUCCESS, 25*time.Second)
+	assert.Equal(t, 1, len(saved))
+
+	ms := saved[0]
+	assert.Equal(t, ROLLOUT_SUCCESS, ms.RolloutResult)
+	assert.Equal(t, "2", ms.Revision)
+	assert.Equal(t, "new", ms.RevisionHash)
+	assert.Equal(t, "patch-deployments-web", ms.TriggerAuditLog)
+	assert.Equal(t, testStart, ms.StartTime)
+	assert.Equal(t, 30*time.Second, ms.Duration)
+	assert.Equal(t, 10*time.Second, ms.SurgeDuration)
+	assert.Equal(t, 20*time.Second, ms.UnavailableDuration)
+	assert.Equal(t, int32(2), ms.MaxUnavailableReplicas)
+	assert.Equal(t, 2, ms.PodsCreated)
+	assert.Equal(t, 1, ms.PodsDeleted)
+	assert.Equal(t, "web-new-b", ms.SlowestPod)
+	assert.Empty(t, ms.StuckPods)
+	assert.Equal(t, 0, tracker.size())
+}
+
+func TestDeploymentRolloutSupersededAndStuck(t *testing.T) {
+	saved := make([]*WorkloadRolloutMileStone, 0)
+	tracker := newRolloutTracker(func(ms *WorkloadRolloutMileStone) {
+		saved = append(saved, ms)
+	})
+
+	// the ReplicaSet updated after restart is not a rollout
+	tracker.processEvent(generateAuditEvent(t, "update", "replicasets", "", newTestReplicaSet("v1", "1"), 0))
+	assert.Equal(t, 0, tracker.size())
+
+	tracker.processEvent(generateAuditEvent(t, "create", "replicasets", "", newTestReplicaSet("v2", "2"), time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", newRolloutPod("web-v2-a", "v2"), time.Minute))
+	// rolled back
+	tracker.processEvent(generateAuditEvent(t, "update", "replicasets", "", newTestReplicaSet("v1", "3"), 2*time.Minute))
+	// the superseded rollout waits for the create SLO of web-v2-a
+	assert.Equal(t, 2, tracker.size())
+	assert.Equal(t, 0, len(saved))
+	tracker.observePodCreated("web-v2-a-uid", "web-v2-a", "ImagePullFailed", time.Minute)
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, ROLLOUT_SUPERSEDED, saved[0].RolloutResult)
+	assert.Equal(t, []string{"web-v2-a: ImagePullFailed"}, saved[0].StuckPods)
+
+	// pods never ready are stuck after the settle time
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", newRolloutPod("web-v1-a", "v1"), 2*time.Minute))
+	tracker.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(3, appsv1.DeploymentStatus{
+		ObservedGeneration: 3, Replicas: 2, UpdatedReplicas: 1,
+		Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: v1.ConditionFalse, Reason: progressDeadlineExceededReason}},
+	}), 12*time.Minute))
+	assert.Equal(t, 1, len(saved))
+	tracker.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(3, appsv1.DeploymentStatus{}), 14*time.Minute))
+	tracker.checkTimeout()
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, ROLLOUT_DEADLINE_EXCEEDED, saved[1].RolloutResult)
+	assert.Equal(t, "3", saved[1].Revision)
+	assert.Equal(t, []string{"web-v1-a"}, saved[1].StuckPods)
+	assert.Equal(t, 0, tracker.size())
+}
+
+func TestStatefulSetRollout(t *testing.T) {
+	saved := make([]*WorkloadRolloutMileStone, 0)
+	tracker := newRolloutTracker(func(ms *WorkloadRolloutMileStone) {
+		saved = append(saved, ms)
+	})
+
+	controller := true
+	cr := &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "ns",
+			Name:            "db-abc",
+			OwnerReferences: []metav1.OwnerReference{{Kind: kindStatefulSet, Name: "db", UID: "s-uid", Controller: &controller}},
+		},
+		Revision: 4,
+	}
+	sts := func(ready int32, current string) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db", Generation: 4},
+			Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(3)},
+			Status: appsv1.StatefulSetStatus{ObservedGeneration: 4, ReadyReplicas: ready, UpdatedReplicas: 3,
+				UpdateRevision: "db-abc", CurrentRevision: current},
+		}
+	}
+	tracker.processEvent(generateAuditEvent(t, "create", "controllerrevisions", "", cr, 0))
+	tracker.processEvent(generateAuditEvent(t, "update", "statefulsets", "status", sts(2, "db-old"), 10*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "update", "statefulsets", "status", sts(3, "db-abc"), 40*time.Second))
+
+	assert.Equal(t, 1, len(saved))
+	assert.Equal(t, ROLLOUT_SUCCESS, saved[0].RolloutResult)
+	assert.Equal(t, kindStatefulSet, saved[0].Kind)
+	assert.Equal(t, "4", saved[0].Revision)
+	assert.Equal(t, 30*time.Second, saved[0].UnavailableDuration)
+	assert.Equal(t, 40*time.Second, saved[0].Duration)
+}
+
+func TestRolloutSnapshot(t *testing.T) {
+	tracker := newRolloutTracker(func(ms *WorkloadRolloutMileStone) {})
+	tracker.processEvent(generateAuditEvent(t, "create", "replicasets", "", newTestReplicaSet("new", "2"), 0))
+	tracker.processEvent(generateAuditEvent(t, "create", "pods", "", newRolloutPod("web-new-a", "new"), time.Second))
+	tracker.processEvent(generateAuditEvent(t, "delete", "pods", "", newRolloutPod("web-old-a", "old"), 2*time.Second))
+	tracker.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(2, appsv1.DeploymentStatus{
+		ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, UnavailableReplicas: 1,
+	}), 10*time.Second))
+
+	snapshots := tracker.snapshot("c1")
+	assert.Equal(t, 1, len(snapshots))
+	assert.Empty(t, tracker.snapshot("c2"))
+	data, err := json.Marshal(snapshots[0])
+	assert.Nil(t, err)
+
+	// restarted
+	saved := make([]*WorkloadRolloutMileStone, 0)
+	restarted := newRolloutTracker(func(ms *WorkloadRolloutMileStone) {
+		saved = append(saved, ms)
+	})
+	snapshot := &rolloutSnapshot{}
+	assert.Nil(t, json.Unmarshal(data, snapshot))
+	assert.True(t, restarted.restore(snapshot))
+	assert.False(t, restarted.restore(snapshot))
+	// the revision is known, its update is not another rollout
+	restarted.processEvent(generateAuditEvent(t, "update", "replicasets", "", newTestReplicaSet("new", "2"), 15*time.Second))
+	restarted.processEvent(generateAuditEvent(t, "update", "deployments", "status", newTestDeployment(2, appsv1.DeploymentStatus{
+		ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2,
+	}), 20*time.Second))
+	// the new pod is waited for to be created, the old one to be deleted
+	restarted.observePodDeleted("web-new-a-uid", "web-new-a", SUCCESS)
+	restarted.observePodCreated("web-old-a-uid", "web-old-a", CREATE_RESULT_SUCCESS, time.Second)
+	restarted.observePodCreated("web-new-a-uid", "web-new-a", CREATE_RESULT_SUCCESS, 5*time.Second)
+	assert.Equal(t, 0, len(saved))
+	restarted.observePodDeleted("web-old-a-uid", "web-old-a", SUCCESS)
+	assert.Equal(t, 1, len(saved))
+
+	ms := saved[0]
+	assert.Equal(t, ROLLOUT_SUCCESS, ms.RolloutResult)
+	assert.Equal(t, testStart, ms.StartTime)
+	assert.Equal(t, 10*time.Second, ms.SurgeDuration)
+	assert.Equal(t, 10*time.Second, ms.UnavailableDuration)
+	assert.Equal(t, 1, ms.PodsCreated)
+	assert.Equal(t, 1, ms.PodsDeleted)
+	assert.Equal(t, 0, restarted.size())
+}
@@ -0,0 +1,328 @@
+package slopolicy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/alipay/container-observability-service/pkg/metas"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// Policy is a valid DeliverySLOPolicy compiled to match pods
+type Policy struct {
+	Name string
+	// SLO time by delivery, metas.SloSpecPodCreate, metas.SloSpecPodUpgrade or metas.SloSpecPodDelete
+	SloTimes map[string]time.Duration
+
+	priority   int32
+	generation int64
+	namespaces map[string]bool
+	// nil matches all the pods
+	selector   labels.Selector
+	ownerKinds map[string]bool
+	objectives []config.SLOObjective
+	// shared by the policies compiled from the same generation
+	counter *matchCounter
+}
+
+// matchCounter counts the pods created which a policy is applied to and not added to its status yet
+type matchCounter struct {
+	mutex       sync.Mutex
+	pending     int64
+	lastMatched time.Time
+}
+
+type namedHandler struct {
+	name string
+	fn   func()
+}
+
+var (
+	policiesValue atomic.Value
+
+	// guards the changes of the policies and the handlers
+	lock     sync.Mutex
+	byName   = make(map[string]*Policy)
+	invalid  = make(map[string]string)
+	handlers []namedHandler
+
+	policyMatchedPods = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lunettes_slo_policy_matched_pods_count",
+			Help: "pods created which the delivery slo policy is applied to",
+		},
+		[]string{"policy"},
+	)
+	policyInvalid = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lunettes_slo_policy_invalid",
+			Help: "1 if the delivery slo policy is invalid and not applied",
+		},
+		[]string{"policy"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(policyMatchedPods)
+	prometheus.MustRegister(policyInvalid)
+}
+
+func currentPolicies() []*Policy {
+	if policies, ok := policiesValue.Load().([]*Policy); ok {
+		return policies
+	}
+	return nil
+}
+
+// Match returns the policy applied to the pod, nil if none matches it
+func Match(pod *v1.Pod) *Policy {
+	if pod == nil {
+		return nil
+	}
+	for _, p := range currentPolicies() {
+		if p.matches(pod) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Objectives returns the SLO objectives of the policies
+func Objectives() []config.SLOObjective {
+	objectives := make([]config.SLOObjective, 0)
+	for _, p := range currentPolicies() {
+		objectives = append(objectives, p.objectives...)
+	}
+	return objectives
+}
+
+// OnChange registers fn to be called each time the policies change, and at once
+func OnChange(name string, fn func()) {
+	lock.Lock()
+	defer lock.Unlock()
+	handlers = append(handlers, namedHandler{name: name, fn: fn})
+	callHandler(handlers[len(handlers)-1])
+}
+
+func callHandler(h namedHandler) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("slo policy handler %s panics: %v", h.name, r)
+		}
+	}()
+	h.fn()
+}
+
+func (p *Policy) matches(pod *v1.Pod) bool {
+	if p.namespaces != nil && !p.namespaces[pod.Namespace] {
+		return false
+	}
+	if p.selector != nil && !p.selector.Matches(labels.Set(pod.Labels)) {
+		return false
+	}
+	if p.ownerKinds != nil {
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || !p.ownerKinds[owner.Kind] {
+			return false
+		}
+	}
+	return true
+}
+
+// PodMatched counts a pod created at t which the policy is applied to
+func (p *Policy) PodMatched(t time.Time) {
+	c := p.counter
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending++
+	if t.After(c.lastMatched) {
+		c.lastMatched = t
+	}
+	policyMatchedPods.WithLabelValues(p.Name).Inc()
+}
+
+// compile checks the policy, previous is the one of the same name in use
+func compile(policy *DeliverySLOPolicy, previous *Policy) (*Policy, error) {
+	p := &Policy{
+		Name:       policy.Name,
+		SloTimes:   make(map[string]time.Duration),
+		priority:   policy.Spec.Priority,
+		generation: policy.Generation,
+		objectives: make([]config.SLOObjective, 0),
+	}
+	errs := make([]error, 0)
+
+	if len(policy.Spec.Namespaces) > 0 {
+		p.namespaces = make(map[string]bool, len(policy.Spec.Namespaces))
+		for _, ns := range policy.Spec.Namespaces {
+			p.namespaces[ns] = true
+		}
+	}
+	if policy.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.Selector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("selector: %v", err))
+		}
+		p.selector = selector
+	}
+	if len(policy.Spec.OwnerKinds) > 0 {
+		p.ownerKinds = make(map[string]bool, len(policy.Spec.OwnerKinds))
+		for _, kind := range policy.Spec.OwnerKinds {
+			p.ownerKinds[kind] = true
+		}
+	}
+
+	for _, delivery := range []struct {
+		field         string
+		sloSpec       string
+		objectiveType string
+		slo           *DeliverySLO
+	}{
+		{"podCreate", metas.SloSpecPodCreate, config.SLOObjectivePodCreate, policy.Spec.PodCreate},
+		{"podUpgrade", metas.SloSpecPodUpgrade, "", policy.Spec.PodUpgrade},
+		{"podDelete", metas.SloSpecPodDelete, config.SLOObjectivePodDelete, policy.Spec.PodDelete},
+	} {
+		if delivery.slo == nil {
+			continue
+		}
+		if timeout := delivery.slo.Timeout; timeout != nil {
+			if timeout.Duration <= 0 {
+				errs = append(errs, fmt.Errorf("%s.timeout: %s must be positive", delivery.field, timeout.Duration))
+			}
+			p.SloTimes[delivery.sloSpec] = timeout.Duration
+		}
+		if objective := delivery.slo.Objective; objective != nil {
+			if delivery.objectiveType == "" {
+				errs = append(errs, fmt.Errorf("%s.objective: not supported", delivery.field))
+				continue
+			}
+			o := config.SLOObjective{
+				Name:    fmt.Sprintf("%s/%s", policy.Name, delivery.objectiveType),
+				Type:    delivery.objectiveType,
+				Target:  objective.Target,
+				Window:  objective.Window,
+				GroupBy: objective.GroupBy,
+				Policy:  policy.Name,
+			}
+			if err := o.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s.objective: %v", delivery.field, err))
+			}
+			p.objectives = append(p.objectives, o)
+		}
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return nil, err
+	}
+
+	// the pods matched by the same spec are counted on
+	if previous != nil && previous.generation == p.generation {
+		p.counter = previous.counter
+	} else {
+		p.counter = &matchCounter{}
+	}
+	return p, nil
+}
+
+// setPolicies replaces the policies in use, the invalid ones are not applied
+func setPolicies(policies []*DeliverySLOPolicy) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	compiled := make([]*Policy, 0, len(policies))
+	names := make(map[string]*Policy, len(policies))
+	messages := make(map[string]string)
+	for _, policy := range policies {
+		p, err := compile(policy, byName[policy.Name])
+		if err != nil {
+			if invalid[policy.Name] != err.Error() {
+				klog.Errorf("delivery slo policy %s is invalid and not applied: %v", policy.Name, err)
+			}
+			messages[policy.Name] = err.Error()
+			policyInvalid.WithLabelValues(policy.Name).Set(1)
+			continue
+		}
+		compiled = append(compiled, p)
+		names[p.Name] = p
+		policyInvalid.WithLabelValues(policy.Name).Set(0)
+	}
+	// the policies deleted
+	deleted := func(name string) bool {
+		_, ok := messages[name]
+		return !ok && names[name] == nil
+	}
+	for name := range byName {
+		if deleted(name) {
+			policyInvalid.DeleteLabelValues(name)
+		}
+	}
+	for name := range invalid {
+		if deleted(name) {
+			policyInvalid.DeleteLabelValues(name)
+		}
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		if compiled[i].priority != compiled[j].priority {
+			return compiled[i].priority > compiled[j].priority
+		}
+		return compiled[i].Name < compiled[j].Name
+	})
+
+	byName, invalid = names, messages
+	policiesValue.Store(compiled)
+	for _, h := range handlers {
+		callHandler(h)
+	}
+}
+
+// desiredStatus returns the status the policy should have, and the pods matched it adds to the current status.
+// The pods are added to the ones of the status, which are counted by the other aggregators and before restart too,
+// or of a new generation if the spec is changed.
+func desiredStatus(policy *DeliverySLOPolicy) (DeliverySLOPolicyStatus, int64) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if message, ok := invalid[policy.Name]; ok {
+		return DeliverySLOPolicyStatus{ObservedGeneration: policy.Generation, Message: message}, 0
+	}
+	p := byName[policy.Name]
+	if p == nil || p.generation != policy.Generation {
+		return policy.Status, 0
+	}
+
+	status := DeliverySLOPolicyStatus{ObservedGeneration: p.generation}
+	if policy.Status.ObservedGeneration == p.generation {
+		status.MatchedPods = policy.Status.MatchedPods
+		status.LastMatchedTime = policy.Status.LastMatchedTime
+	}
+	c := p.counter
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	status.MatchedPods += c.pending
+	if !c.lastMatched.IsZero() && (status.LastMatchedTime == nil || c.lastMatched.After(status.LastMatchedTime.Time)) {
+		t := metav1.NewTime(c.lastMatched)
+		status.LastMatchedTime = &t
+	}
+	return status, c.pending
+}
+
+// statusUpdated removes the pods added to the status of policy from the ones to add
+func statusUpdated(policy *DeliverySLOPolicy, added int64) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if p := byName[policy.Name]; p != nil && p.generation == policy.Generation {
+		p.counter.mutex.Lock()
+		p.counter.pending -= added
+		p.counter.mutex.Unlock()
+	}
+}
@@ -0,0 +1,175 @@
+package slopolicy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/config"
+	"github.com/alipay/container-observability-service/pkg/metas"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestPolicy(name string, generation int64, spec DeliverySLOPolicySpec) *DeliverySLOPolicy {
+	return &DeliverySLOPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Generation: generation},
+		Spec:       spec,
+	}
+}
+
+func generateMatchPod(namespace string, podLabels map[string]string, ownerKind string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "pod", Labels: podLabels}}
+	if ownerKind != "" {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: "owner", Controller: &controller}}
+	}
+	return pod
+}
+
+func resetPolicies(t *testing.T) {
+	t.Cleanup(func() {
+		handlers = nil
+		setPolicies(nil)
+	})
+}
+
+func TestMatch(t *testing.T) {
+	resetPolicies(t)
+	changes := 0
+	OnChange("test", func() {
+		changes++
+	})
+
+	setPolicies([]*DeliverySLOPolicy{
+		newTestPolicy("web", 1, DeliverySLOPolicySpec{
+			Namespaces: []string{"ns1"},
+			Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			PodCreate: &DeliverySLO{
+				Timeout:   &metav1.Duration{Duration: 2 * time.Minute},
+				Objective: &Objective{Target: 99.5, GroupBy: []string{config.SLOGroupByOwnerRef}},
+			},
+			PodDelete: &DeliverySLO{Timeout: &metav1.Duration{Duration: 5 * time.Minute}},
+		}),
+		newTestPolicy("jobs", 1, DeliverySLOPolicySpec{
+			OwnerKinds: []string{"Job"},
+			PodCreate:  &DeliverySLO{Timeout: &metav1.Duration{Duration: 30 * time.Minute}},
+		}),
+		newTestPolicy("ns1-jobs", 1, DeliverySLOPolicySpec{
+			Namespaces: []string{"ns1"},
+			OwnerKinds: []string{"Job"},
+			Priority:   1,
+			PodUpgrade: &DeliverySLO{Timeout: &metav1.Duration{Duration: 3 * time.Minute}},
+		}),
+	})
+	assert.Equal(t, 2, changes)
+
+	assert.Equal(t, "web", Match(generateMatchPod("ns1", map[string]string{"app": "web"}, "ReplicaSet")).Name)
+	assert.Nil(t, Match(generateMatchPod("ns2", map[string]string{"app": "web"}, "ReplicaSet")))
+	assert.Nil(t, Match(generateMatchPod("ns1", nil, "")))
+	assert.Equal(t, "jobs", Match(generateMatchPod("ns2", nil, "Job")).Name)
+	// by priority
+	p := Match(generateMatchPod("ns1", map[string]string{"app": "web"}, "Job"))
+	assert.Equal(t, "ns1-jobs", p.Name)
+	assert.Equal(t, map[string]time.Duration{metas.SloSpecPodUpgrade: 3 * time.Minute}, p.SloTimes)
+
+	objectives := Objectives()
+	assert.Equal(t, 1, len(objectives))
+	assert.Equal(t, "web/pod_create", objectives[0].Name)
+	assert.Equal(t, "web", objectives[0].Policy)
+	assert.Equal(t, 99.5, objectives[0].Target)
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	resetPolicies(t)
+
+	policy := newTestPolicy("invalid", 2, DeliverySLOPolicySpec{
+		Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Near"}}},
+		PodCreate: &DeliverySLO{
+			Timeout:   &metav1.Duration{Duration: -time.Minute},
+			Objective: &Objective{Target: 100},
+		},
+		PodUpgrade: &DeliverySLO{Objective: &Objective{Target: 99}},
+	})
+	setPolicies([]*DeliverySLOPolicy{policy})
+
+	assert.Nil(t, Match(generateMatchPod("ns1", map[string]string{"app": "web"}, "")))
+	status, _ := desiredStatus(policy)
+	assert.Equal(t, int64(2), status.ObservedGeneration)
+	assert.Contains(t, status.Message, "selector: ")
+	assert.Contains(t, status.Message, "podCreate.timeout: -1m0s must be positive")
+	assert.Contains(t, status.Message, "podCreate.objective: SLOObjectives[invalid/pod_create]: target 100 must be between 0 and 100")
+	assert.Contains(t, status.Message, "podUpgrade.objective: not supported")
+}
+
+func TestUpdateStatus(t *testing.T) {
+	resetPolicies(t)
+
+	policy := newTestPolicy("web", 3, DeliverySLOPolicySpec{
+		PodCreate: &DeliverySLO{Timeout: &metav1.Duration{Duration: 2 * time.Minute}},
+	})
+	// applied before the spec changed
+	policy.Status = DeliverySLOPolicyStatus{ObservedGeneration: 2}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	assert.Nil(t, err)
+	obj := &unstructured.Unstructured{Object: content}
+
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), obj)
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	assert.Nil(t, store.Add(obj))
+	policies := listPolicies(store)
+	assert.Equal(t, 2*time.Minute, policies[0].Spec.PodCreate.Timeout.Duration)
+	setPolicies(policies)
+
+	updateStatus(client, policies)
+	assert.Equal(t, 1, len(client.Actions()))
+	assert.Equal(t, "status", client.Actions()[0].GetSubresource())
+
+	updated, err := client.Resource(GroupVersionResource).Get(context.Background(), "web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	generation, _, _ := unstructured.NestedInt64(updated.Object, "status", "observedGeneration")
+	assert.Equal(t, int64(3), generation)
+
+	// nothing changed
+	client.ClearActions()
+	assert.Nil(t, store.Update(updated))
+	policies = listPolicies(store)
+	updateStatus(client, policies)
+	assert.Empty(t, client.Actions())
+
+	// the pods matched are added to the status and the metric
+	Match(generateMatchPod("ns1", nil, "")).PodMatched(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, float64(1), testutil.ToFloat64(policyMatchedPods.WithLabelValues("web")))
+	updateStatus(client, policies)
+	assert.Equal(t, 1, len(client.Actions()))
+	updated, err = client.Resource(GroupVersionResource).Get(context.Background(), "web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	matched, _, _ := unstructured.NestedInt64(updated.Object, "status", "matchedPods")
+	assert.Equal(t, int64(1), matched)
+	lastMatched, _, _ := unstructured.NestedString(updated.Object, "status", "lastMatchedTime")
+	assert.Equal(t, "2023-06-01T00:00:00Z", lastMatched)
+
+	// counted on top of the pods added by another aggregator, and not added twice
+	policies[0].Status = DeliverySLOPolicyStatus{ObservedGeneration: 3, MatchedPods: 10}
+	Match(generateMatchPod("ns1", nil, "")).PodMatched(time.Date(2023, 6, 1, 0, 1, 0, 0, time.UTC))
+	status, added := desiredStatus(policies[0])
+	assert.Equal(t, int64(11), status.MatchedPods)
+	assert.Equal(t, int64(1), added)
+	statusUpdated(policies[0], added)
+	status, added = desiredStatus(policies[0])
+	assert.Equal(t, int64(10), status.MatchedPods)
+	assert.Equal(t, int64(0), added)
+
+	// a new generation is counted from zero
+	policies[0].Generation = 4
+	setPolicies(policies)
+	status, _ = desiredStatus(policies[0])
+	assert.Equal(t, DeliverySLOPolicyStatus{ObservedGeneration: 4}, status)
+}
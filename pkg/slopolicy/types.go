@@ -0,0 +1,71 @@
+package slopolicy
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "lunettes.alipay.com"
+	Version  = "v1alpha1"
+	Kind     = "DeliverySLOPolicy"
+	Resource = "deliveryslopolicies"
+)
+
+// GroupVersionResource of DeliverySLOPolicy
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// DeliverySLOPolicy 选择 Pod 并指定其创建、升级、删除的 SLO 时间和目标，cluster 级别
+type DeliverySLOPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DeliverySLOPolicySpec   `json:"spec"`
+	Status DeliverySLOPolicyStatus `json:"status,omitempty"`
+}
+
+// DeliverySLOPolicySpec selects the pods, a pod matches if all the selectors set match it
+type DeliverySLOPolicySpec struct {
+	// all the namespaces if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// labels of the pods, all the pods if nil
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// kinds of the controllers of the pods, e.g. ReplicaSet or Job, all the pods if empty
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// of the policies matching a pod, the one with the highest priority is applied, then the first by name
+	Priority int32 `json:"priority,omitempty"`
+
+	PodCreate  *DeliverySLO `json:"podCreate,omitempty"`
+	PodUpgrade *DeliverySLO `json:"podUpgrade,omitempty"`
+	PodDelete  *DeliverySLO `json:"podDelete,omitempty"`
+}
+
+// DeliverySLO is the SLO of one kind of delivery
+type DeliverySLO struct {
+	// the default of the delivery if not set
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// error budget of the deliveries of the pods matched, not supported by podUpgrade
+	Objective *Objective `json:"objective,omitempty"`
+}
+
+// Objective is a config.SLOObjective of the pods matched
+type Objective struct {
+	// percentage of the good deliveries, e.g. 99.5
+	Target float64 `json:"target"`
+	// e.g. 28d or 12h, 28d if empty
+	Window string `json:"window,omitempty"`
+	// cluster, namespace or ownerref
+	GroupBy []string `json:"groupBy,omitempty"`
+}
+
+// DeliverySLOPolicyStatus is updated by the aggregator
+type DeliverySLOPolicyStatus struct {
+	// the generation of the spec applied
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// pods created which the generation is applied to
+	MatchedPods int64 `json:"matchedPods"`
+	// time of the last pod matched
+	LastMatchedTime *metav1.Time `json:"lastMatchedTime,omitempty"`
+	// why the policy is not applied, empty if it is valid
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,128 @@
+package slopolicy
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the policies are listed again to repair a missed event
+	policyResync = 10 * time.Minute
+	// how often the status of the policies is updated
+	statusUpdateInterval = 30 * time.Second
+)
+
+// Watch applies the DeliverySLOPolicies of the cluster and updates their status until stop is closed
+func Watch(stop <-chan struct{}) error {
+	cfg, err := restclient.InClusterConfig()
+	if err != nil {
+		klog.Errorf("failed to build config, err is %v", err)
+		return err
+	}
+
+	cfg.UserAgent = "lunettes"
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		klog.Errorf("failed to create dynamic client: %v", err)
+		return err
+	}
+	watch(client, stop)
+	return nil
+}
+
+func watch(client dynamic.Interface, stop <-chan struct{}) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, policyResync)
+	informer := factory.ForResource(GroupVersionResource).Informer()
+
+	onChange := func() {
+		setPolicies(listPolicies(informer.GetStore()))
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			onChange()
+		},
+		UpdateFunc: func(_, _ interface{}) {
+			onChange()
+		},
+		DeleteFunc: func(interface{}) {
+			onChange()
+		},
+	})
+
+	factory.Start(stop)
+	go func() {
+		if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+			return
+		}
+		wait.Until(func() {
+			updateStatus(client, listPolicies(informer.GetStore()))
+		}, statusUpdateInterval, stop)
+	}()
+}
+
+func listPolicies(store cache.Store) []*DeliverySLOPolicy {
+	policies := make([]*DeliverySLOPolicy, 0)
+	for _, obj := range store.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		policy := &DeliverySLOPolicy{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), policy); err != nil {
+			klog.Errorf("failed to convert delivery slo policy %s: %v", u.GetName(), err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// updateStatus patches the status of the policies changed, a failed one is patched next time.
+// The patch is rejected if the policy is changed since it is listed, e.g. the pods matched are added by another
+// aggregator, then the pods are added to the latest status next time.
+func updateStatus(client dynamic.Interface, policies []*DeliverySLOPolicy) {
+	for _, policy := range policies {
+		status, added := desiredStatus(policy)
+		if statusEqual(&status, &policy.Status) {
+			continue
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": policy.ResourceVersion},
+			"status":   status,
+		})
+		if err != nil {
+			klog.Errorf("failed to marshal status of delivery slo policy %s: %v", policy.Name, err)
+			continue
+		}
+		_, err = client.Resource(GroupVersionResource).Patch(context.Background(), policy.Name,
+			types.MergePatchType, data, metav1.PatchOptions{}, "status")
+		if err != nil {
+			klog.Errorf("failed to update status of delivery slo policy %s: %v", policy.Name, err)
+			continue
+		}
+		statusUpdated(policy, added)
+	}
+}
+
+// the times are compared in seconds as they are stored
+func statusEqual(a, b *DeliverySLOPolicyStatus) bool {
+	if a.ObservedGeneration != b.ObservedGeneration || a.MatchedPods != b.MatchedPods || a.Message != b.Message {
+		return false
+	}
+	if a.LastMatchedTime == nil || b.LastMatchedTime == nil {
+		return a.LastMatchedTime == b.LastMatchedTime
+	}
+	return a.LastMatchedTime.Unix() == b.LastMatchedTime.Unix()
+}
@@ -1,5 +1,7 @@
 package spans
 
+import "github.com/alipay/container-observability-service/pkg/featuregates"
+
 const (
 	SpanAnalysisFeature = "SpanAnalysisFeature"
 	SpanIndex           = "spans_consuming"
@@ -7,3 +9,9 @@ const (
 
 	JaegerFeature = "JaegerFeature"
 )
+
+func init() {
+	// the watchers and writers are created at startup
+	featuregates.Register(SpanAnalysisFeature, featuregates.FeatureSpec{Default: false, PreRelease: featuregates.Beta})
+	featuregates.Register(JaegerFeature, featuregates.FeatureSpec{Default: false, PreRelease: featuregates.Alpha})
+}
@@ -28,7 +28,7 @@ var (
 type SpanProcessor struct {
 	Cluster   string
 	writer    Writer
-	config    atomic.Value
+	config    *atomic.Value
 	SpanMetas *sync.Map
 	now       time.Time
 }
@@ -67,10 +67,8 @@ func (p *SpanProcessor) Compact() {
 
 // this function should be thread safe
 func (p *SpanProcessor) ProcessEvent(ev *shares.AuditEvent) {
-	defer func() {
-		HandleCrash()
-		ev.FinishProcess(shares.SpanProcessNode)
-	}()
+	defer ev.FinishProcess(shares.SpanProcessNode)
+	defer ev.IgnorePanic("spans", "SpanProcessor.ProcessEvent")
 	p.now = ev.RequestReceivedTimestamp.Time
 	if ev.ObjectRef == nil {
 		return
@@ -109,6 +107,7 @@ func (p *SpanProcessor) ProcessEvent(ev *shares.AuditEvent) {
 
 	go func() {
 		defer ev.FinishProcess(shares.SpanProcessNode)
+		defer ev.IgnorePanic("spans", "SpanProcessor.TrackSpan")
 
 		uid, err := ev.GetObjectUID()
 		if err != nil {
@@ -226,6 +225,7 @@ func NewSpanProcessor(cluster string) *SpanProcessor {
 		SpanMetas: &sync.Map{},
 		Cluster:   cluster,
 		writer:    w,
+		config:    &atomic.Value{},
 	}
 
 	p.RefreshConfig()
@@ -237,6 +237,16 @@ func NewSpanProcessor(cluster string) *SpanProcessor {
 	return p
 }
 
+// forCluster returns a processor of another cluster, which shares the writer and config with p
+func (p *SpanProcessor) forCluster(cluster string) *SpanProcessor {
+	return &SpanProcessor{
+		SpanMetas: &sync.Map{},
+		Cluster:   cluster,
+		writer:    p.writer,
+		config:    p.config,
+	}
+}
+
 func HandleCrash() {
 	if r := recover(); r != nil {
 		logPanic(r)
@@ -13,12 +13,19 @@ import (
 )
 
 var (
-	WatcherQueue          *queue.BoundedQueue
+	WatcherQueue *queue.BoundedQueue
+	// DeliverySpanProcessor is the processor of the first cluster, events of unknown clusters go to it
 	DeliverySpanProcessor *SpanProcessor
+	// cluster -> processor, the in-memory spans of each cluster are isolated
+	spanProcessors = make(map[string]*SpanProcessor)
 )
 
-// actually cluster is not necessary
-func InitKubeSpanWatcher(cluster string, otlpAddr string) error {
+func init() {
+	shares.RegisterDAGNode(shares.SpanProcessNode)
+}
+
+// InitKubeSpanWatcher starts a span processor for each cluster
+func InitKubeSpanWatcher(clusters []string, otlpAddr string) error {
 	prometheus.MustRegister(metrics.SpansProcessedPods)
 	prometheus.MustRegister(metrics.SpansInMemPodsCount)
 	metrics.ClearRequestResourceMetric()
@@ -33,9 +40,17 @@ func InitKubeSpanWatcher(cluster string, otlpAddr string) error {
 		}
 	}
 
-	DeliverySpanProcessor = NewSpanProcessor(cluster)
-
-	go DeliverySpanProcessor.Compact()
+	for _, cluster := range clusters {
+		var processor *SpanProcessor
+		if DeliverySpanProcessor == nil {
+			DeliverySpanProcessor = NewSpanProcessor(cluster)
+			processor = DeliverySpanProcessor
+		} else {
+			processor = DeliverySpanProcessor.forCluster(cluster)
+		}
+		spanProcessors[cluster] = processor
+		go processor.Compact()
+	}
 	WatcherQueue = queue.NewBoundedQueue("spans-watcher", 200000, nil)
 	WatcherQueue.StartLengthReporting(10 * time.Second)
 	WatcherQueue.IsDropEventOnFull = false
@@ -50,7 +65,25 @@ func InitKubeSpanWatcher(cluster string, otlpAddr string) error {
 		}
 
 		auditEvent.CanProcess(shares.SpanProcessNode)
-		DeliverySpanProcessor.ProcessEvent(auditEvent)
+		GetSpanProcessor(auditEvent.Annotations["cluster"]).ProcessEvent(auditEvent)
 	})
 	return nil
 }
+
+// GetSpanProcessor returns the span processor of cluster
+func GetSpanProcessor(cluster string) *SpanProcessor {
+	if processor, ok := spanProcessors[cluster]; ok {
+		return processor
+	}
+	return DeliverySpanProcessor
+}
+
+// LoadSpanMetas returns the span metas of the object in any cluster, uid is unique among clusters
+func LoadSpanMetas(uid string) (interface{}, bool) {
+	for _, processor := range spanProcessors {
+		if spanMetas, ok := processor.SpanMetas.Load(uid); ok {
+			return spanMetas, true
+		}
+	}
+	return nil, false
+}
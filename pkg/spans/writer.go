@@ -27,7 +27,7 @@ var spanBuffer *utils.BufferUtils = nil
 
 func NewXSearchWriter() (*XSearchWriter, error) {
 	client := xsearch.GetXSearchClient()
-	if client == nil {
+	if client == nil && xsearch.GetDocWriter() == nil {
 		return nil, fmt.Errorf("xsearch client is not initialized")
 	}
 	return &XSearchWriter{
@@ -123,6 +123,9 @@ func (x *XSearchWriter) writePart(obj interface{}, docID string) error {
 			if datas == nil {
 				return nil
 			}
+			if w := xsearch.GetDocWriter(); w != nil {
+				return w.Write(SpanIndex, datas)
+			}
 
 			klog.V(6).Infof("do spans bulk, data size: %d", len(datas))
 			err := utils.ReTry(func() error {
@@ -1,10 +1,16 @@
 package trace
 
+import "github.com/alipay/container-observability-service/pkg/featuregates"
+
 const (
 	TraceFeature           = "TraceFeature"
 	TraceContextAnnotation = "meta.lunettes.com/trace-context"
 )
 
+func init() {
+	featuregates.Register(TraceFeature, featuregates.FeatureSpec{Default: false, PreRelease: featuregates.Alpha})
+}
+
 type TraceService struct {
 	Component string `json:"component"`
 	SpanID    string `json:"span_id"`
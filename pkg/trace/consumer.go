@@ -26,7 +26,7 @@ var (
 
 type SpanProcessor struct {
 	Cluster   string
-	config    atomic.Value
+	config    *atomic.Value
 	SpanMetas *sync.Map
 	now       time.Time
 }
@@ -65,7 +65,7 @@ func (p *SpanProcessor) Compact() {
 
 // this function should be thread safe
 func (p *SpanProcessor) ProcessEvent(ev *shares.AuditEvent) {
-	defer HandleCrash()
+	defer ev.IgnorePanic("trace", "SpanProcessor.ProcessEvent")
 	p.now = ev.RequestReceivedTimestamp.Time
 	if ev.ObjectRef == nil {
 		return
@@ -205,12 +205,22 @@ func NewSpanProcessor(cluster string) *SpanProcessor {
 	p := &SpanProcessor{
 		SpanMetas: &sync.Map{},
 		Cluster:   cluster,
+		config:    &atomic.Value{},
 	}
 
 	p.RefreshConfig()
 	return p
 }
 
+// forCluster returns a processor of another cluster, which shares the config with p
+func (p *SpanProcessor) forCluster(cluster string) *SpanProcessor {
+	return &SpanProcessor{
+		SpanMetas: &sync.Map{},
+		Cluster:   cluster,
+		config:    p.config,
+	}
+}
+
 func HandleCrash() {
 	if r := recover(); r != nil {
 		logPanic(r)
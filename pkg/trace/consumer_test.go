@@ -0,0 +1,24 @@
+package trace
+
+import (
+	"testing"
+
+	"github.com/alipay/container-observability-service/pkg/deadletter"
+	"github.com/alipay/container-observability-service/pkg/shares"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	k8s_audit "k8s.io/apiserver/pkg/apis/audit"
+)
+
+func TestProcessEventPanic(t *testing.T) {
+	deadletter.SetStore(deadletter.NewMemoryStore(10))
+	// panics without the response status
+	ev := shares.NewAuditEvent(&k8s_audit.Event{ObjectRef: &k8s_audit.ObjectReference{}})
+	ev.ResponseRuntimeObj = &v1.Pod{}
+	(&SpanProcessor{}).ProcessEvent(ev)
+
+	letters, err := deadletter.List(&deadletter.Filter{Stage: "trace"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(letters))
+}
@@ -228,7 +228,11 @@ func NewSpanMeta(config *ResourceSpanConfig, cluster string, event *shares.Audit
 		Begin:             event.RequestReceivedTimestamp.Time,
 	}
 	spanMeta.ObjectRef.UID, _ = event.GetObjectUID()
-	sloSpec := metas.FetchSloSpec(event.ResponseRuntimeObj)
+	// the pods have theirs set by SLOTimeComputer
+	sloSpec := event.SloSpec
+	if sloSpec == nil {
+		sloSpec = metas.FetchSloSpec(event.ResponseRuntimeObj)
+	}
 	if sloSpec[config.ActionType] != nil {
 		sloTime, err := time.ParseDuration(sloSpec[config.ActionType].SloTime)
 		if err == nil {
@@ -361,7 +365,7 @@ func (s *SpanMeta) Finish(ev *shares.AuditEvent) {
 	if ev != nil {
 		s.End = ev.StageTimestamp.Time
 	} else {
-		s.End = time.Now()
+		s.End = utils.Now()
 	}
 
 	for _, span := range s.Spans {
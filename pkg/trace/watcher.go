@@ -9,11 +9,19 @@ import (
 	"k8s.io/klog"
 )
 
+// DAGNode is the node of the trace watcher in the processing DAG
+const DAGNode = "TraceNode"
+
 var (
 	WatcherQueue *queue.BoundedQueue
 )
 
-func InitKubeTraceWatcher(cluster string, otlpAddr string) error {
+func init() {
+	shares.RegisterDAGNode(DAGNode)
+}
+
+// InitKubeTraceWatcher starts a trace processor for each cluster
+func InitKubeTraceWatcher(clusters []string, otlpAddr string) error {
 	var err error
 	//spanExporter, err = setupOTLP(ctx, otlpAddr, "", false)
 	err = initOtlpProcessor(otlpAddr)
@@ -22,9 +30,20 @@ func InitKubeTraceWatcher(cluster string, otlpAddr string) error {
 		os.Exit(1)
 	}
 
-	processor := NewSpanProcessor(cluster)
-
-	go processor.Compact()
+	// cluster -> processor, the first one also processes events of unknown clusters
+	processors := make(map[string]*SpanProcessor)
+	var defaultProcessor *SpanProcessor
+	for _, cluster := range clusters {
+		var processor *SpanProcessor
+		if defaultProcessor == nil {
+			defaultProcessor = NewSpanProcessor(cluster)
+			processor = defaultProcessor
+		} else {
+			processor = defaultProcessor.forCluster(cluster)
+		}
+		processors[cluster] = processor
+		go processor.Compact()
+	}
 	WatcherQueue = queue.NewBoundedQueue("trace-watcher", 200000, nil)
 	WatcherQueue.StartLengthReporting(10 * time.Second)
 	WatcherQueue.IsDropEventOnFull = false
@@ -38,7 +57,12 @@ func InitKubeTraceWatcher(cluster string, otlpAddr string) error {
 			return
 		}
 
-		auditEvent.Wait()
+		auditEvent.CanProcess(DAGNode)
+		defer auditEvent.FinishProcess(DAGNode)
+		processor, ok := processors[auditEvent.Annotations["cluster"]]
+		if !ok {
+			processor = defaultProcessor
+		}
 		processor.ProcessEvent(auditEvent)
 	})
 	return nil
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+)
+
+// Shard is the Index-th of Count aggregators, each owning a range of the fnv32 hash of pod uids, the same hash
+// ConcurrentMap splits the keys by, so the SLOs of a pod are followed by one aggregator. The objects other than pods,
+// e.g. nodes and workloads, and the state made of many pods, e.g. rollouts and node drains, belong to the first shard,
+// the cluster shard.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// Validate checks the shard is one of Count shards
+func (s Shard) Validate() error {
+	if s.Count < 1 {
+		return fmt.Errorf("shard count %d should be at least 1", s.Count)
+	}
+	if s.Index < 0 || s.Index >= s.Count {
+		return fmt.Errorf("shard index %d should be in [0, %d)", s.Index, s.Count)
+	}
+	return nil
+}
+
+// Enabled returns false if all objects are processed by one aggregator
+func (s Shard) Enabled() bool {
+	return s.Count > 1
+}
+
+// Owns returns true if the pod of uid belongs to the shard
+func (s Shard) Owns(uid string) bool {
+	if !s.Enabled() {
+		return true
+	}
+	return s.Of(uid) == s.Index
+}
+
+// Of returns the index of the shard whose hash range holds uid, the uint32 hashes are split into Count even ranges.
+// The uids are random, so are the high bits of their hashes.
+func (s Shard) Of(uid string) int {
+	if !s.Enabled() {
+		return 0
+	}
+	return int(uint64(fnv32(uid)) * uint64(s.Count) >> 32)
+}
+
+// OwnsCluster returns true if the shard follows the objects other than pods, and the state of the nodes and workloads
+// made of the pods of all shards
+func (s Shard) OwnsCluster() bool {
+	return s.Index == 0
+}
+
+// Name returns the name of things kept by each shard, such as checkpoint, name itself if not sharded.
+func (s Shard) Name(name string) string {
+	if !s.Enabled() {
+		return name
+	}
+	return fmt.Sprintf("%s-shard-%d", name, s.Index)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+func TestShard(t *testing.T) {
+	assert.NotNil(t, Shard{Index: 0, Count: 0}.Validate())
+	assert.NotNil(t, Shard{Index: 4, Count: 4}.Validate())
+	assert.Nil(t, Shard{Index: 3, Count: 4}.Validate())
+
+	single := Shard{Index: 0, Count: 1}
+	assert.True(t, single.Owns("any"))
+	assert.True(t, single.OwnsCluster())
+	assert.Equal(t, "cluster", single.Name("cluster"))
+	assert.Equal(t, "cluster-shard-2", Shard{Index: 2, Count: 4}.Name("cluster"))
+
+	// every uid is owned by exactly one shard
+	counts := make([]int, 4)
+	for i := 0; i < 10000; i++ {
+		uid := string(uuid.NewUUID())
+		owners := 0
+		for index := 0; index < 4; index++ {
+			if (Shard{Index: index, Count: 4}).Owns(uid) {
+				owners++
+				counts[index]++
+			}
+		}
+		assert.Equal(t, 1, owners)
+	}
+	for _, count := range counts {
+		assert.True(t, count > 2000, "uids are not spread: %v", counts)
+	}
+
+	// the hash of "" is 0x811c9dc5, in the third quarter
+	assert.Equal(t, 2, Shard{Count: 4}.Of(""))
+	assert.Equal(t, 1, Shard{Count: 2}.Of(""))
+	assert.True(t, Shard{Index: 0, Count: 4}.OwnsCluster())
+	assert.False(t, Shard{Index: 1, Count: 4}.OwnsCluster())
+}
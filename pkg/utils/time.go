@@ -1,11 +1,24 @@
 package utils
 
-import "time"
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
 
 const (
 	layout = "2006-01-02T15:04:05.000Z"
 )
 
+// Clock is the clock used when the result of audit processing depends on current time.
+// It is the wall clock in aggregator, and is driven by audit event time in replay.
+var Clock clock.PassiveClock = clock.RealClock{}
+
+// Now returns current time of Clock
+func Now() time.Time {
+	return Clock.Now()
+}
+
 // ParseTime parse string into time using layout "2006-01-02T15:04:05.000Z"
 func ParseTime(s string) (time.Time, error) {
 	return time.Parse(layout, s)
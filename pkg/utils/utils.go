@@ -214,11 +214,16 @@ func ShouldIgnoreNamespace(ns string) bool {
 
 func IgnorePanic(desc string) {
 	if err := recover(); err != nil {
-		klog.Error(desc, err)
-		logPanic(err)
+		LogPanic(desc, err)
 	}
 }
 
+// LogPanic logs the panic recovered in desc
+func LogPanic(desc string, err interface{}) {
+	klog.Error(desc, err)
+	logPanic(err)
+}
+
 func StringHashcode(s string) int {
 	v := int(crc32.ChecksumIEEE([]byte(s)))
 	if v >= 0 {
@@ -0,0 +1,187 @@
+package xsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/utils"
+
+	"github.com/olivere/elastic/v7"
+	"k8s.io/klog/v2"
+)
+
+// DocWriter receives the documents flushed by the Save* functions instead of elasticsearch.
+// docs is keyed by document id, a later write of the same id replaces the former one.
+// A doc is either an object to be encoded, or []byte which is already encoded json.
+type DocWriter interface {
+	Write(index string, docs map[string]interface{}) error
+	Close() error
+}
+
+var docWriter DocWriter
+
+// SetDocWriter redirects all documents to w, it should be called before anything is saved.
+func SetDocWriter(w DocWriter) {
+	docWriter = w
+}
+
+// GetDocWriter returns the writer set by SetDocWriter, nil means documents are written to elasticsearch.
+func GetDocWriter() DocWriter {
+	return docWriter
+}
+
+// JSONDocWriter keeps documents in memory and writes them to <dir>/<index>.json on Close,
+// one {"_id": ..., "_source": ...} per line and sorted by id.
+type JSONDocWriter struct {
+	dir  string
+	docs map[string]map[string]json.RawMessage
+	sync.Mutex
+}
+
+func NewJSONDocWriter(dir string) (*JSONDocWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &JSONDocWriter{
+		dir:  dir,
+		docs: make(map[string]map[string]json.RawMessage),
+	}, nil
+}
+
+func (w *JSONDocWriter) Write(index string, docs map[string]interface{}) error {
+	encoded := make(map[string]json.RawMessage, len(docs))
+	for id, doc := range docs {
+		if data, ok := doc.([]byte); ok {
+			encoded[id] = data
+			continue
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			klog.Errorf("failed to marshal doc %s of %s: %s", id, index, err.Error())
+			continue
+		}
+		encoded[id] = data
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.docs[index] == nil {
+		w.docs[index] = make(map[string]json.RawMessage)
+	}
+	for id, data := range encoded {
+		w.docs[index][id] = data
+	}
+	return nil
+}
+
+func (w *JSONDocWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	for index, docs := range w.docs {
+		if err := writeJSONDocs(filepath.Join(w.dir, index+".json"), docs); err != nil {
+			return fmt.Errorf("failed to write %s: %w", index, err)
+		}
+		klog.Infof("%d docs written to %s", len(docs), filepath.Join(w.dir, index+".json"))
+	}
+	return nil
+}
+
+func writeJSONDocs(path string, docs map[string]json.RawMessage) error {
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, id := range ids {
+		err := encoder.Encode(struct {
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+		}{id, docs[id]})
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// SLODocWriter writes the SLO results to elasticsearch and drops the other documents, so that a backfill
+// recomputing SLOs leaves the rest of the live indexes alone. With a version, the results are written to
+// "<index>_<version>" and the former ones are kept, otherwise they are overwritten.
+type SLODocWriter struct {
+	client *elastic.Client
+	// index of the Save* functions -> index to write
+	indexes map[string]string
+}
+
+func NewSLODocWriter(conf *ElasticSearchConf, version string) (*SLODocWriter, error) {
+	client, err := elastic.NewClient(elastic.SetURL(conf.Endpoint),
+		elastic.SetBasicAuth(conf.User, conf.Password),
+		elastic.SetSniff(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := map[string]string{
+		sloDataIndexName:      "",
+		sloTraceDataIndexName: sloTraceDataMapping,
+	}
+	w := &SLODocWriter{client: client, indexes: make(map[string]string)}
+	for index, mapping := range mappings {
+		target := index
+		if version != "" {
+			target = index + "_" + version
+		}
+		if err := EnsureIndex(client, target, mapping); err != nil {
+			return nil, fmt.Errorf("failed to create index %s: %w", target, err)
+		}
+		w.indexes[index] = target
+	}
+	return w, nil
+}
+
+func (w *SLODocWriter) Write(index string, docs map[string]interface{}) error {
+	target, ok := w.indexes[index]
+	if !ok || len(docs) == 0 {
+		return nil
+	}
+	return utils.ReTry(func() error {
+		bulkService := w.client.Bulk()
+		for id, doc := range docs {
+			if data, ok := doc.([]byte); ok {
+				doc = json.RawMessage(data)
+			}
+			bulkService = bulkService.Add(elastic.NewBulkIndexRequest().Index(target).Id(id).Doc(doc))
+		}
+		resp, err := bulkService.Do(context.Background())
+		if err != nil {
+			return err
+		}
+		if resp.Errors {
+			return fmt.Errorf("failed to write %d docs to %s", len(resp.Failed()), target)
+		}
+		return nil
+	}, 1*time.Second, 10)
+}
+
+func (w *SLODocWriter) Close() error {
+	w.client.Stop()
+	return nil
+}
@@ -6,6 +6,23 @@ type ElasticSearchConf struct {
 	Password string
 	Index    string
 	Type     string
+
+	// IndexPattern names the indexes, data streams or aliases holding the audit events of a day,
+	// "{index}", "{cluster}" and "{date}" ("{date:<go time layout>}") are replaced, e.g. "append_only.{index}.{date}".
+	// Index is read if it is empty or none of the named indexes exists.
+	IndexPattern string
+	// TimestampField is the time field of audit events, "stageTimestamp" if empty
+	TimestampField string
+	// ClusterField is the keyword field of audit events telling the cluster, events are not filtered by cluster if empty
+	ClusterField string
+}
+
+// AuditTimestampField returns the field to query audit events by time
+func (c *ElasticSearchConf) AuditTimestampField() string {
+	if c.TimestampField == "" {
+		return "stageTimestamp"
+	}
+	return c.TimestampField
 }
 
 var (
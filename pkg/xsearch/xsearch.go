@@ -654,7 +654,9 @@ const (
 
 var esClient *elastic.Client
 var EsConfig *ElasticSearchConf
-var cluster string
+
+// clusters served by this process
+var clusters []string
 
 func InitZsearch(zsearchEndPoint, username, password string, extraInfo interface{}) {
 	if esClient == nil {
@@ -669,7 +671,12 @@ func InitZsearch(zsearchEndPoint, username, password string, extraInfo interface
 		}
 
 		esClient = client
-		cluster = extraInfo.(string)
+		switch info := extraInfo.(type) {
+		case string:
+			clusters = []string{info}
+		case []string:
+			clusters = info
+		}
 	}
 
 	//node yaml
@@ -684,6 +691,11 @@ func InitZsearch(zsearchEndPoint, username, password string, extraInfo interface
 		log.Printf("index: %s, mammping: %s\n", podLifePhaseIndexName, podLifePhaseMapping)
 		panic(err)
 	}
+	err = EnsureIndex(esClient, nodeLifePhaseIndexName, nodeLifePhaseMapping)
+	if err != nil {
+		log.Printf("index: %s, mammping: %s\n", nodeLifePhaseIndexName, nodeLifePhaseMapping)
+		panic(err)
+	}
 	err = EnsureIndex(esClient, podYamlIndexName, podYamlmap)
 	if err != nil {
 		log.Printf("index: %s, mammping: %s\n", podYamlIndexName, podYamlmap)
@@ -733,6 +745,9 @@ func SavePodLifePhase(clusterName string, namespace string, podUID string, podNa
 			if datas == nil {
 				return nil
 			}
+			if docWriter != nil {
+				return docWriter.Write(podLifePhaseIndexName, datas)
+			}
 
 			klog.V(6).Infof("do bulk, data size: %d", len(datas))
 			err := utils.ReTry(func() error {
@@ -793,9 +808,15 @@ func SavePodLifePhase(clusterName string, namespace string, podUID string, podNa
 	return nil
 }
 
+// docs of an object are versioned by the stageTimestamp of audit event,
+// so re-processed (older or the same) events never overwrite a newer doc
+const docVersionType = "external_gte"
+
 // SavePodYaml save pod yaml
 type PodYamlDic struct {
 	data []byte
+	// stageTimestamp of the audit event, used as the external version of the doc
+	version int64
 	sync.WaitGroup
 }
 
@@ -843,6 +864,16 @@ func SavePodYaml(cluster string, pod *corev1.Pod, t time.Time, auditID string, i
 			if datas == nil {
 				return nil
 			}
+			if docWriter != nil {
+				docs := make(map[string]interface{}, len(datas))
+				for id, data := range datas {
+					if podYamlDic, ok := data.(*PodYamlDic); ok {
+						podYamlDic.Wait()
+						docs[id] = podYamlDic.data
+					}
+				}
+				return docWriter.Write(podYamlIndexName, docs)
+			}
 
 			klog.V(6).Infof("do bulk for %s, data size: %d ", podYamlIndexName, len(datas))
 			err := utils.ReTry(func() error {
@@ -854,7 +885,8 @@ func SavePodYaml(cluster string, pod *corev1.Pod, t time.Time, auditID string, i
 					}
 					podYamlDic.Wait()
 
-					doc := elastic.NewBulkIndexRequest().Index(podYamlIndexName).Type(podYamlTypeName).Id(id).Doc(json.RawMessage(podYamlDic.data)).UseEasyJSON(true)
+					doc := elastic.NewBulkIndexRequest().Index(podYamlIndexName).Type(podYamlTypeName).Id(id).Doc(json.RawMessage(podYamlDic.data)).UseEasyJSON(true).
+						Version(podYamlDic.version).VersionType(docVersionType)
 					bulkService = bulkService.Add(doc)
 
 					//释放内存
@@ -890,7 +922,7 @@ func SavePodYaml(cluster string, pod *corev1.Pod, t time.Time, auditID string, i
 	}()
 
 	docID := fmt.Sprintf("%s_%s", cluster, pod.UID)
-	podYamlDic := &PodYamlDic{}
+	podYamlDic := &PodYamlDic{version: t.UnixNano()}
 	go podYamlDic.conStruct(cluster, pod, t, auditID, isBeginDelete, isDeleted)
 
 	//insert to es with retry
@@ -915,6 +947,9 @@ func SaveSloData(cluster string, namespace string, podName string, podUID string
 			if datas == nil {
 				return nil
 			}
+			if docWriter != nil {
+				return docWriter.Write(sloDataIndexName, datas)
+			}
 
 			klog.V(6).Infof("do bulk, data size: %d", len(datas))
 			err := utils.ReTry(func() error {
@@ -973,6 +1008,9 @@ func SaveSloTraceData(cluster string, namespace string, name string, uid string,
 			if datas == nil {
 				return nil
 			}
+			if docWriter != nil {
+				return docWriter.Write(sloTraceDataIndexName, datas)
+			}
 
 			klog.V(6).Infof("do bulk, data size: %d", len(datas))
 			err := utils.ReTry(func() error {
@@ -1041,13 +1079,21 @@ func SaveNodeYaml(cluster string, node *corev1.Node, t time.Time, auditID string
 			if data == nil {
 				return nil
 			}
+			if docWriter != nil {
+				return docWriter.Write(nodeYamlIndexName, data)
+			}
 
 			klog.V(6).Infof("do bulk, data size: %d", len(data))
 			err := utils.ReTry(func() error {
 				bulkService := esClient.Bulk()
 				for id, doc := range data {
-					doc := elastic.NewBulkIndexRequest().Index(nodeYamlIndexName).Type(defaultTypeName).Id(id).Doc(doc).UseEasyJSON(true)
-					bulkService = bulkService.Add(doc)
+					req := elastic.NewBulkIndexRequest().Index(nodeYamlIndexName).Type(defaultTypeName).Id(id).Doc(doc).UseEasyJSON(true)
+					if dic, ok := doc.(map[string]interface{}); ok {
+						if t, ok := dic["stageTimestamp"].(time.Time); ok {
+							req = req.Version(t.UnixNano()).VersionType(docVersionType)
+						}
+					}
+					bulkService = bulkService.Add(req)
 				}
 				_, err := bulkService.Do(context.Background())
 				if err != nil {
@@ -0,0 +1,128 @@
+package xsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/olivere/elastic/v7"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DeadLetterIndex   = "lunettes_dead_letter"
+	deadLetterMapping = `
+	{
+		"mappings": {
+			"properties": {
+				"ID": {"type": "keyword"},
+				"Stage": {"type": "keyword"},
+				"Cluster": {"type": "keyword"},
+				"AuditID": {"type": "keyword"},
+				"Error": {"type": "text"},
+				"Time": {"type": "date"},
+				"Event": {"type": "object", "enabled": false}
+			}
+		}
+	}`
+)
+
+// DeadLetter is an audit event failed to be processed, kept to be re-injected after a fix
+type DeadLetter struct {
+	// <stage>/<audit id>, the same failure of an event is kept once
+	ID      string
+	Stage   string
+	Cluster string
+	AuditID string
+	Error   string
+	Time    time.Time
+	// the audit event, or the raw document if it could not be decoded
+	Event json.RawMessage
+}
+
+// SaveDeadLetters indexes the dead letters
+func SaveDeadLetters(letters []*DeadLetter) error {
+	if docWriter != nil || len(letters) == 0 {
+		return nil
+	}
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("SaveDeadLetters", begin)
+	}()
+
+	if err := EnsureIndex(esClient, DeadLetterIndex, deadLetterMapping); err != nil {
+		return err
+	}
+	bulkService := esClient.Bulk()
+	for _, letter := range letters {
+		bulkService.Add(elastic.NewBulkIndexRequest().Index(DeadLetterIndex).Id(letter.ID).Doc(letter))
+	}
+	resp, err := bulkService.Do(context.Background())
+	if err != nil {
+		return err
+	}
+	if resp.Errors {
+		return fmt.Errorf("failed to save %d dead letters", len(resp.Failed()))
+	}
+	return nil
+}
+
+// GetDeadLetters returns the latest dead letters matching the non-empty conditions
+func GetDeadLetters(stage, cluster string, ids []string, size int) ([]*DeadLetter, error) {
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("GetDeadLetters", begin)
+	}()
+
+	result := make([]*DeadLetter, 0)
+	ctx := context.Background()
+	exists, err := esClient.IndexExists(DeadLetterIndex).Do(ctx)
+	if err != nil || !exists {
+		return result, err
+	}
+
+	query := elastic.NewBoolQuery()
+	if stage != "" {
+		query = query.Must(elastic.NewTermQuery("Stage", stage))
+	}
+	if cluster != "" {
+		query = query.Must(elastic.NewTermQuery("Cluster", cluster))
+	}
+	if len(ids) > 0 {
+		query = query.Must(elastic.NewIdsQuery().Ids(ids...))
+	}
+	searchResult, err := esClient.Search(DeadLetterIndex).Query(query).
+		Sort("Time", false).Size(size).Do(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, hit := range searchResult.Hits.Hits {
+		letter := &DeadLetter{}
+		if err := json.Unmarshal(hit.Source, letter); err != nil {
+			klog.Errorf("failed to unmarshal dead letter %s: %s", hit.Id, err.Error())
+			continue
+		}
+		result = append(result, letter)
+	}
+	return result, nil
+}
+
+// DeleteDeadLetters removes the dead letters, the deletion is visible to the next search
+func DeleteDeadLetters(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("DeleteDeadLetters", begin)
+	}()
+
+	bulkService := esClient.Bulk().Refresh("true")
+	for _, id := range ids {
+		bulkService.Add(elastic.NewBulkDeleteRequest().Index(DeadLetterIndex).Id(id))
+	}
+	_, err := bulkService.Do(context.Background())
+	return err
+}
@@ -0,0 +1,151 @@
+package xsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/olivere/elastic/v7"
+	"k8s.io/klog/v2"
+)
+
+const (
+	MilestoneSnapshotIndex   = "lunettes_milestone_snapshot"
+	milestoneSnapshotMapping = `
+	{
+		"mappings": {
+			"properties": {
+				"Owner": {"type": "keyword"},
+				"Kind": {"type": "keyword"},
+				"Generation": {"type": "keyword"},
+				"SnapshotTime": {"type": "date"},
+				"Data": {"type": "object", "enabled": false}
+			}
+		}
+	}`
+)
+
+// MilestoneSnapshot is an in-flight create/upgrade/pvc milestone, saved periodically so that it is
+// rehydrated by the aggregator taking over the cluster.
+type MilestoneSnapshot struct {
+	// checkpoint name of the reader, cluster or cluster-shard-i
+	Owner string
+	// snapshots saved together share a generation, the read checkpoint tells which one to restore
+	Generation   string
+	Kind         string
+	Key          string
+	UID          string
+	Data         json.RawMessage
+	SnapshotTime time.Time
+}
+
+// SaveMilestoneSnapshots saves the snapshots of owner as generation, the older generations are kept
+// until DeleteMilestoneSnapshots, so the ones the read checkpoint refers to are never lost.
+func SaveMilestoneSnapshots(owner, generation string, snapshots []*MilestoneSnapshot) error {
+	if docWriter != nil {
+		// nothing to recover from a doc writer, same as the delete milestones
+		return nil
+	}
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("SaveMilestoneSnapshots", begin)
+	}()
+
+	if err := EnsureIndex(esClient, MilestoneSnapshotIndex, milestoneSnapshotMapping); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	const batchSize = 500
+	for start := 0; start < len(snapshots); start += batchSize {
+		end := start + batchSize
+		if end > len(snapshots) {
+			end = len(snapshots)
+		}
+		bulkService := esClient.Bulk()
+		for _, snapshot := range snapshots[start:end] {
+			snapshot.Owner = owner
+			snapshot.Generation = generation
+			snapshot.SnapshotTime = begin
+			bulkService.Add(elastic.NewBulkIndexRequest().Index(MilestoneSnapshotIndex).
+				Id(owner + "/" + generation + "/" + snapshot.Kind + "/" + snapshot.Key).Doc(snapshot))
+		}
+		resp, err := bulkService.Do(ctx)
+		if err != nil {
+			return err
+		}
+		if resp.Errors {
+			// the half saved generation is not referred to, it is removed next time
+			return fmt.Errorf("failed to save %d milestone snapshots", len(resp.Failed()))
+		}
+	}
+	klog.V(5).Infof("[%s]saved %d milestone snapshots of generation %s", owner, len(snapshots), generation)
+	return nil
+}
+
+// DeleteMilestoneSnapshots removes the snapshots of owner except the ones of generation
+func DeleteMilestoneSnapshots(owner, generation string) error {
+	if docWriter != nil {
+		return nil
+	}
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("DeleteMilestoneSnapshots", begin)
+	}()
+
+	query := elastic.NewBoolQuery().
+		Must(elastic.NewTermQuery("Owner", owner)).
+		MustNot(elastic.NewTermQuery("Generation", generation))
+	result, err := esClient.DeleteByQuery(MilestoneSnapshotIndex).Query(query).Do(context.Background())
+	if err != nil {
+		return err
+	}
+	klog.V(5).Infof("[%s]%d milestone snapshots older than generation %s are removed", owner, result.Deleted, generation)
+	return nil
+}
+
+// GetMilestoneSnapshots returns the snapshots saved by owner as generation,
+// all of them if generation is empty, e.g. the checkpoint was saved by an older version.
+func GetMilestoneSnapshots(owner, generation string) ([]*MilestoneSnapshot, error) {
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("GetMilestoneSnapshots", begin)
+	}()
+
+	result := make([]*MilestoneSnapshot, 0)
+	ctx := context.Background()
+	exists, err := esClient.IndexExists(MilestoneSnapshotIndex).Do(ctx)
+	if err != nil || !exists {
+		return result, err
+	}
+
+	query := elastic.NewBoolQuery().Must(elastic.NewTermQuery("Owner", owner))
+	if generation != "" {
+		query = query.Must(elastic.NewTermQuery("Generation", generation))
+	}
+	scroller := esClient.Scroll().
+		Index(MilestoneSnapshotIndex).
+		Query(query).
+		Size(100)
+	defer scroller.Clear(ctx)
+	for {
+		searchResult, err := scroller.Do(ctx)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		for _, hit := range searchResult.Hits.Hits {
+			snapshot := &MilestoneSnapshot{}
+			if err := json.Unmarshal(hit.Source, snapshot); err != nil {
+				klog.Errorf("failed to unmarshal milestone snapshot %s: %s", hit.Id, err.Error())
+				continue
+			}
+			result = append(result, snapshot)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package xsearch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/alipay/container-observability-service/pkg/utils"
+	"github.com/olivere/elastic/v7"
+	"k8s.io/klog/v2"
+)
+
+const (
+	//node 生命周期中各个阶段
+	nodeLifePhaseIndexName = "node_life_phase"
+	nodeLifePhaseTypeName  = "_doc"
+	nodeLifePhaseMapping   = `{
+		"mappings": {
+			"dynamic": "false",
+			"properties": {
+				"clusterName": {
+					"type": "keyword"
+				},
+				"dataSourceId": {
+					"type": "keyword"
+				},
+				"nodeName": {
+					"type": "keyword"
+				},
+				"uid": {
+					"type": "keyword"
+				},
+				"operationName": {
+					"type": "keyword"
+				},
+				"hasErr": {
+					"type": "boolean"
+				},
+				"startTime": {
+					"type": "date"
+				},
+				"endTime": {
+					"type": "date"
+				}
+			}
+		}
+	}`
+)
+
+var nodeLifeBuffer *utils.BufferUtils = nil
+
+// SaveNodeLifePhase 存储 Node Phase 到 zsearch node_life_phase 这个索引
+func SaveNodeLifePhase(clusterName string, nodeName string, nodeUID string, operationName string,
+	hasErr bool, startTime, endTime time.Time, extraInfo map[string]interface{}, dataSourceID string) error {
+	if nodeLifeBuffer == nil {
+		nodeLifeBuffer = utils.NewBufferUtils(nodeLifePhaseIndexName, 1000, 10*time.Second, false, func(datas map[string]interface{}) error {
+			if datas == nil {
+				return nil
+			}
+			if docWriter != nil {
+				return docWriter.Write(nodeLifePhaseIndexName, datas)
+			}
+
+			return utils.ReTry(func() error {
+				bulkService := esClient.Bulk()
+				for id, data := range datas {
+					doc := elastic.NewBulkIndexRequest().Index(nodeLifePhaseIndexName).Type(nodeLifePhaseTypeName).Id(id).Doc(data)
+					bulkService = bulkService.Add(doc)
+				}
+				_, err := bulkService.Do(context.Background())
+				return err
+			}, 1*time.Second, 5)
+		})
+
+		nodeLifeBuffer.DoClearData()
+		//add graceful clear
+		XSearchClear.AddCleanWork(func() {
+			nodeLifeBuffer.Stop()
+		})
+	}
+
+	begin := time.Now()
+	defer func() {
+		cost := utils.TimeSinceInMilliSeconds(begin)
+		metrics.DebugMethodDurationMilliSeconds.WithLabelValues("SaveNodeLifePhase").Observe(cost)
+	}()
+
+	docMap := make(map[string]interface{})
+	docMap["clusterName"] = clusterName
+	docMap["nodeName"] = nodeName
+	docMap["uid"] = nodeUID
+	docMap["operationName"] = operationName
+	docMap["hasErr"] = hasErr
+	docMap["startTime"] = startTime
+	docMap["endTime"] = endTime
+	docMap["extraInfo"] = extraInfo
+	docMap["dataSourceId"] = dataSourceID
+
+	// a node has many phases of the same operation, e.g. NotReady
+	docID := fmt.Sprintf("%s_%s_%s_%d", clusterName, nodeUID, operationName, startTime.UnixNano())
+	if err := nodeLifeBuffer.SaveData(docID, docMap); err != nil {
+		klog.Error("Do save data error", err)
+		return err
+	}
+
+	return nil
+}
@@ -44,11 +44,16 @@ type PodDeleteMileStone struct {
 	RemainingFinalizers []string
 	DeleteTimeoutTime   time.Time
 	IsJob               bool
+	SLOPolicy           string // 匹配 Pod 的 DeliverySLOPolicy
 	Key                 string
 	Mutex               sync.Mutex
 }
 
 func SaveDeleteSloMilestoneMapToZsearch(podDeleteMileStoneMap *utils.SafeMap) {
+	if docWriter != nil {
+		// the milestones are only used to recover aggregator, there is nothing to recover from a doc writer
+		return
+	}
 	klog.Infof("Saving podDeleteMileStoneMap to zsearch, map size is %d", podDeleteMileStoneMap.Size())
 	podDeleteMileStoneMap.IterateWithFunc(func(i interface{}) {
 		deleteMS, ok := i.(*PodDeleteMileStone)
@@ -180,3 +185,25 @@ func DeleteAllPodDeleteMilestone(cluster string) *utils.SafeMap {
 	klog.Infof("Successfully deleted %d podDeleteMileStone from zsearch for recovery.", searchResult.Deleted)
 	return result
 }
+
+// DeletePodDeleteMilestones 删除指定 key 的 podDeleteMileStone, used by sharded aggregators
+func DeletePodDeleteMilestones(cluster string, keys []string) {
+	indexName := GetIndexNameForPodDeleteMileStone(cluster)
+
+	const batchSize = 1000
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		query := elastic.NewIdsQuery().Ids(keys[start:end]...)
+		searchResult, err := esClient.DeleteByQuery(indexName).Type(PodDeleteMileStoneType).
+			Query(query).
+			Do(context.Background())
+		if err != nil {
+			klog.Errorf("DeletePodDeleteMilestones failed to delete from zsearch %v", err)
+			return
+		}
+		klog.Infof("Successfully deleted %d podDeleteMileStone from zsearch for recovery.", searchResult.Deleted)
+	}
+}
@@ -0,0 +1,58 @@
+package xsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/olivere/elastic/v7"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// GetNodePods returns the latest yamls of the pods of cluster on the node of hostIP not deleted yet, e.g. the pods
+// to be deleted by a drain of the node. The yamls are the latest ones, so the pods are looked up only as the node
+// is being drained, not in a replay.
+func GetNodePods(cluster, hostIP string) ([]*corev1.Pod, error) {
+	if docWriter != nil || esClient == nil {
+		return nil, fmt.Errorf("no pod yaml to look up")
+	}
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("GetNodePods", begin)
+	}()
+
+	result := make([]*corev1.Pod, 0)
+	ctx := context.Background()
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("clusterName.keyword", cluster)).
+		Filter(elastic.NewTermQuery("hostIP.keyword", hostIP)).
+		Filter(elastic.NewTermQuery("isDeleted.keyword", "false"))
+	scroller := esClient.Scroll().
+		Index(podYamlIndexName).
+		Query(query).
+		Size(500)
+	defer scroller.Clear(ctx)
+	for {
+		searchResult, err := scroller.Do(ctx)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		for _, hit := range searchResult.Hits.Hits {
+			doc := struct {
+				Pod *corev1.Pod `json:"pod"`
+			}{}
+			if err := json.Unmarshal(hit.Source, &doc); err != nil || doc.Pod == nil {
+				klog.Errorf("failed to unmarshal pod yaml %s: %v", hit.Id, err)
+				continue
+			}
+			result = append(result, doc.Pod)
+		}
+	}
+}
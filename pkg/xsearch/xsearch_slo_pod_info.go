@@ -105,7 +105,9 @@ func getPodInfoCacheForUID() utils.LRU {
 		if podInfoCache == nil {
 			klog.V(7).Infof("pod_info_cache_size: %d", podInfoCacheSize)
 			podInfoCache = utils.LRUCache("pod_info_uid", podInfoCacheSize)
-			InitSloPodInfo(cluster, podInfoCache)
+			for _, cluster := range clusters {
+				InitSloPodInfo(cluster, podInfoCache)
+			}
 		}
 		cacheLock.Unlock()
 	}
@@ -159,6 +161,9 @@ func SavePodInfoToZSearch(cluster string, pod *corev1.Pod, deliveryStatus string
 			if datas == nil {
 				return nil
 			}
+			if docWriter != nil {
+				return docWriter.Write(podInfoIndexName, datas)
+			}
 
 			klog.V(6).Infof("do bulk, data size: %d", len(datas))
 			err := utils.ReTry(func() error {
@@ -0,0 +1,60 @@
+package xsearch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/alipay/container-observability-service/pkg/metrics"
+	"github.com/olivere/elastic/v7"
+)
+
+// maxPodUIDsPerQuery is the most pod uids looked up by one query
+const maxPodUIDsPerQuery = 1000
+
+// GetPodSloTraceData returns the SLO records of the pods of cluster by uid, e.g. the create and delete SLOs of the pods
+// followed by other shards. The records are saved in bulk, so the ones finished just before are not found yet.
+func GetPodSloTraceData(cluster string, uids []string) ([]json.RawMessage, error) {
+	begin := time.Now()
+	defer func() {
+		metrics.ObserveQueryMethodDuration("GetPodSloTraceData", begin)
+	}()
+
+	result := make([]json.RawMessage, 0)
+	ctx := context.Background()
+	for len(uids) > 0 {
+		batch := uids
+		if len(batch) > maxPodUIDsPerQuery {
+			batch = batch[:maxPodUIDsPerQuery]
+		}
+		uids = uids[len(batch):]
+
+		values := make([]interface{}, 0, len(batch))
+		for _, uid := range batch {
+			values = append(values, uid)
+		}
+		query := elastic.NewBoolQuery().
+			Filter(elastic.NewTermQuery("Cluster.keyword", cluster)).
+			Filter(elastic.NewTermsQuery("PodUID.keyword", values...))
+		scroller := esClient.Scroll().
+			Index(sloTraceDataIndexName).
+			Query(query).
+			Size(500)
+		for {
+			searchResult, err := scroller.Do(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				scroller.Clear(ctx)
+				return result, err
+			}
+			for _, hit := range searchResult.Hits.Hits {
+				result = append(result, hit.Source)
+			}
+		}
+		scroller.Clear(ctx)
+	}
+	return result, nil
+}